@@ -0,0 +1,145 @@
+package prompt
+
+import "sort"
+
+// Annotation is transient text displayed after the input text, such as "3 rows
+// will be affected" or a lint warning. Annotations are not part of the input
+// text: the cursor cannot be moved into them and they are removed when the
+// input is accepted or cancelled. See Prompt.SetAnnotation.
+type Annotation struct {
+	// Text is the text to display. The text is displayed immediately after the
+	// input text, so it usually begins with a newline in order to be displayed
+	// on its own line.
+	Text string
+	// Style is the style of the text. Empty for unstyled text.
+	Style Style
+	// Priority determines the order of annotations. Annotations are displayed
+	// in order of decreasing priority, and in the order they were added for
+	// equal priorities.
+	Priority int
+}
+
+// The priorities of the annotations displayed by Prompt itself. Application
+// annotations with lower priorities (such as the default of 0) are displayed
+// after them.
+const (
+	// AnnotationPriorityDraft is the priority of the notice displayed when
+	// input is restored from the draft file (see WithDraftFile).
+	AnnotationPriorityDraft = 25
+	// AnnotationPriorityCompletions is the priority of the listing of
+	// completions displayed by the complete command.
+	AnnotationPriorityCompletions = 50
+	// AnnotationPriorityDiagnostic is the priority of the message of the
+	// diagnostic at the cursor position (see WithLinter), and of the error
+	// which prevented the input from being accepted (see WithAcceptCheck and
+	// WithHistoryExpansion).
+	AnnotationPriorityDiagnostic = 75
+	// AnnotationPrioritySearch is the priority of the incremental history
+	// search prompt (see WithSearchPrompt), and of the code read by the
+	// insert-unicode command.
+	AnnotationPrioritySearch = 100
+	// AnnotationPriorityHistoryMeta is the priority of the metadata attached
+	// to the displayed history entry (see Prompt.AnnotateLastHistory).
+	AnnotationPriorityHistoryMeta = 125
+	// AnnotationPriorityHelp is the priority of the key bindings displayed by
+	// the help command.
+	AnnotationPriorityHelp = 150
+	// AnnotationPriorityOverwrite is the priority of the indicator displayed
+	// while overwrite mode is enabled by the overwrite-mode command.
+	AnnotationPriorityOverwrite = 175
+	// AnnotationPriorityCollapsed is the priority of the indication of the
+	// number of hidden lines of a collapsed history entry (see
+	// WithCollapsedHistory), which is displayed on the same line as the input.
+	AnnotationPriorityCollapsed = 200
+)
+
+// annotationKey identifies an annotation. Annotations set by Prompt itself are
+// internal so that their names do not conflict with those of the application.
+type annotationKey struct {
+	internal bool
+	name     string
+}
+
+var (
+	annotationSearch      = annotationKey{internal: true, name: "search"}
+	annotationCollapsed   = annotationKey{internal: true, name: "collapsed"}
+	annotationCompletions = annotationKey{internal: true, name: "completions"}
+	annotationHelp        = annotationKey{internal: true, name: "help"}
+	annotationDraft       = annotationKey{internal: true, name: "draft"}
+	annotationRender      = annotationKey{internal: true, name: "render"}
+	annotationOverwrite   = annotationKey{internal: true, name: "overwrite"}
+	annotationDiagnostic  = annotationKey{internal: true, name: "diagnostic"}
+	annotationHistoryMeta = annotationKey{internal: true, name: "history-meta"}
+	annotationUnicode     = annotationKey{internal: true, name: "unicode"}
+	annotationAcceptError = annotationKey{internal: true, name: "accept-error"}
+)
+
+type annotationEntry struct {
+	key annotationKey
+	Annotation
+}
+
+// annotations holds the annotations displayed after the input text as the
+// screen suffix.
+type annotations struct {
+	entries []annotationEntry
+}
+
+// Set sets the annotation for key, replacing any existing annotation for key.
+// An annotation with empty text removes the annotation. The screen suffix is
+// updated to display the annotations.
+func (a *annotations) Set(s *screen, key annotationKey, ann Annotation) {
+	i := 0
+	for ; i < len(a.entries); i++ {
+		if a.entries[i].key == key {
+			break
+		}
+	}
+	switch {
+	case i < len(a.entries) && ann.Text == "":
+		a.entries = append(a.entries[:i], a.entries[i+1:]...)
+	case i < len(a.entries):
+		if a.entries[i].Annotation == ann {
+			return
+		}
+		a.entries[i].Annotation = ann
+	case ann.Text == "":
+		return
+	default:
+		a.entries = append(a.entries, annotationEntry{key: key, Annotation: ann})
+	}
+	a.render(s)
+}
+
+// Clear removes all of the annotations.
+func (a *annotations) Clear(s *screen) {
+	if len(a.entries) == 0 {
+		return
+	}
+	a.entries = a.entries[:0]
+	a.render(s)
+}
+
+// Reset removes all of the annotations without updating the screen, which is
+// expected to have been reset.
+func (a *annotations) Reset() {
+	a.entries = a.entries[:0]
+}
+
+// render sets the screen suffix to the annotations in priority order.
+func (a *annotations) render(s *screen) {
+	sort.SliceStable(a.entries, func(i, j int) bool {
+		return a.entries[i].Priority > a.entries[j].Priority
+	})
+	var suffix []rune
+	var attrs []attrInfo
+	for i := range a.entries {
+		e := &a.entries[i]
+		start := len(suffix)
+		suffix = append(suffix, []rune(e.Text)...)
+		if e.Style != "" {
+			attrs = append(attrs, attrInfo{startPos: start, endPos: len(suffix), value: string(e.Style)})
+		}
+	}
+	s.SetSuffix(suffix, attrs)
+}
@@ -0,0 +1,38 @@
+package prompt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotationStyle(t *testing.T) {
+	var s screen
+	s.Init()
+	s.SetSize(80, 24, nil)
+	s.Reset([]rune("> "))
+	s.Insert([]rune("abc")...)
+
+	var a annotations
+	a.Set(&s, annotationKey{name: "warn"}, Annotation{Text: " !", Style: attrBold})
+	a.Set(&s, annotationKey{name: "info"}, Annotation{Text: " ?"})
+
+	// Input inserted at the end of the input precedes the styled suffix.
+	s.Insert('d')
+	require.Equal(t, "abcd", string(s.Text()))
+	require.Equal(t, "> abcd ! ?", string(s.text))
+	require.Equal(t, []attrInfo{{startPos: 6, endPos: 8, value: attrBold}}, s.attrs.All())
+
+	s.outbuf.Reset()
+	s.Redraw()
+	require.Equal(t, "\r\x1b[J> abcd\x1b[1m !\x1b[0m ?\x1b[4D", s.outbuf.String())
+
+	// Reordering the annotations moves the style with the text.
+	a.Set(&s, annotationKey{name: "info"}, Annotation{Text: " ?", Priority: 1})
+	require.Equal(t, "> abcd ? !", string(s.text))
+	require.Equal(t, []attrInfo{{startPos: 8, endPos: 10, value: attrBold}}, s.attrs.All())
+
+	a.Clear(&s)
+	require.Equal(t, "> abcd", string(s.text))
+	require.Empty(t, s.attrs.All())
+}
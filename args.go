@@ -0,0 +1,96 @@
+package prompt
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// ErrUnterminatedQuote is returned by SplitArgs if a quoted argument is not
+// terminated by a closing quote.
+var ErrUnterminatedQuote = errors.New("unterminated quoted string")
+
+// SplitArgs splits a line into arguments in the manner of a POSIX shell, which
+// is useful for implementing client-side commands such as `\connect host port`.
+// Arguments are separated by unquoted whitespace. Within single quotes all
+// characters are taken literally. Within double quotes a backslash escapes
+// only '"', '\', '$', '`', and newline. Outside of quotes a backslash escapes
+// whitespace, quotes, and backslash, and is otherwise taken literally so that
+// commands such as `\connect` are preserved. A backslash-newline pair outside
+// of single quotes is removed entirely. An empty pair of quotes specifies an
+// empty argument. Variable expansion, globbing, and other shell features are
+// not performed.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	var buf strings.Builder
+	// inArg is true if an argument has been started, which is distinct from buf
+	// being non-empty due to empty quoted arguments.
+	inArg := false
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && i+1 < len(runes) && isArgEscape(runes[i+1]):
+			i++
+			if runes[i] != '\n' {
+				buf.WriteRune(runes[i])
+				inArg = true
+			}
+
+		case r == '\'':
+			inArg = true
+			for i++; ; i++ {
+				if i == len(runes) {
+					return nil, ErrUnterminatedQuote
+				}
+				if runes[i] == '\'' {
+					break
+				}
+				buf.WriteRune(runes[i])
+			}
+
+		case r == '"':
+			inArg = true
+			for i++; ; i++ {
+				if i == len(runes) {
+					return nil, ErrUnterminatedQuote
+				}
+				if runes[i] == '"' {
+					break
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					switch runes[i+1] {
+					case '\n':
+						i++
+						continue
+					case '"', '\\', '$', '`':
+						i++
+					}
+				}
+				buf.WriteRune(runes[i])
+			}
+
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, buf.String())
+				buf.Reset()
+				inArg = false
+			}
+
+		default:
+			buf.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, buf.String())
+	}
+	return args, nil
+}
+
+// isArgEscape returns true if r is escaped by a preceding backslash outside of
+// quotes.
+func isArgEscape(r rune) bool {
+	return unicode.IsSpace(r) || r == '\'' || r == '"' || r == '\\'
+}
@@ -0,0 +1,42 @@
+package prompt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitArgs(t *testing.T) {
+	testCases := []struct {
+		line     string
+		expected []string
+		err      error
+	}{
+		{``, nil, nil},
+		{`   `, nil, nil},
+		{`\connect host 26257`, []string{`\connect`, `host`, `26257`}, nil},
+		{`\\connect host`, []string{`\connect`, `host`}, nil},
+		{`\d \'a\"`, []string{`\d`, `'a"`}, nil},
+		{"  a\tb\n c  ", []string{`a`, `b`, `c`}, nil},
+		{`a\ b c`, []string{`a b`, `c`}, nil},
+		{`'a b' "c d"`, []string{`a b`, `c d`}, nil},
+		{`'' ""`, []string{``, ``}, nil},
+		{`a'b c'd`, []string{`ab cd`}, nil},
+		{`'a\b'`, []string{`a\b`}, nil},
+		{`"a\"b\\c\d\$"`, []string{`a"b\c\d$`}, nil},
+		{`"it's"`, []string{`it's`}, nil},
+		{"a\\\nb", []string{`ab`}, nil},
+		{"\"a\\\nb\"", []string{`ab`}, nil},
+		{`héllo wörld`, []string{`héllo`, `wörld`}, nil},
+		{`'abc`, nil, ErrUnterminatedQuote},
+		{`"abc\"`, nil, ErrUnterminatedQuote},
+		{`abc\`, []string{`abc\`}, nil},
+	}
+	for _, c := range testCases {
+		t.Run(c.line, func(t *testing.T) {
+			args, err := SplitArgs(c.line)
+			require.Equal(t, c.err, err)
+			require.Equal(t, c.expected, args)
+		})
+	}
+}
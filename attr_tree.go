@@ -0,0 +1,267 @@
+package prompt
+
+// attrTree holds a set of attribute spans ordered by start position. The spans
+// are stored in a treap (a randomized balanced binary search tree) keyed by
+// start position and augmented with the maximum end position within each
+// subtree, which allows efficiently finding the spans overlapping a position.
+// Shifting the positions of all of the spans after a position (as happens when
+// text is inserted or erased) is performed lazily in O(log n) time.
+type attrTree struct {
+	root *attrNode
+	// seed is the state of the pseudo-random number generator used to assign
+	// node priorities.
+	seed uint64
+}
+
+type attrNode struct {
+	attr        attrInfo
+	priority    uint64
+	left, right *attrNode
+	// maxEnd is the maximum endPos of the spans within the subtree rooted at the
+	// node.
+	maxEnd int
+	// delta is a pending shift to apply to the positions of the spans in the
+	// children of the node. The node's own span and maxEnd already reflect the
+	// shift.
+	delta int
+}
+
+// Reset removes all of the spans from the tree.
+func (t *attrTree) Reset() {
+	t.root = nil
+}
+
+// Add adds a span to the tree. Spans with the same start position are ordered
+// by the order in which they were added.
+func (t *attrTree) Add(attr attrInfo) {
+	t.seed = t.seed*6364136223846793005 + 1442695040888963407
+	n := &attrNode{attr: attr, priority: t.seed, maxEnd: attr.endPos}
+	l, r := splitAttrs(t.root, attr.startPos, true)
+	t.root = mergeAttrs(mergeAttrs(l, n), r)
+}
+
+// Shift adjusts the spans to account for n characters being inserted at pos.
+// Spans which start after pos are shifted by n, and spans which contain pos
+// are extended by n.
+func (t *attrTree) Shift(pos, n int) {
+	l, r := splitAttrs(t.root, pos, true)
+	r.shift(n)
+	extendAttrs(l, pos, n)
+	t.root = mergeAttrs(l, r)
+}
+
+// ShiftAll shifts the positions of all of the spans by delta.
+func (t *attrTree) ShiftAll(delta int) {
+	t.root.shift(delta)
+}
+
+// Erase adjusts the spans to account for the text in the range [start,end)
+// being erased. Spans which are entirely within the erased range are removed.
+func (t *attrTree) Erase(start, end int) {
+	l, r := splitAttrs(t.root, end, false)
+	r.shift(-(end - start))
+	l, overlapping := extractAttrs(l, start, end, nil)
+	t.root = mergeAttrs(l, r)
+	for _, attr := range overlapping {
+		if attr, ok := eraseAttr(attr, start, end); ok {
+			t.Add(attr)
+		}
+	}
+}
+
+// Extract removes and returns the spans which overlap the range [start,end), in
+// order of start position.
+func (t *attrTree) Extract(start, end int) []attrInfo {
+	l, r := splitAttrs(t.root, end, false)
+	l, overlapping := extractAttrs(l, start, end, nil)
+	t.root = mergeAttrs(l, r)
+	return overlapping
+}
+
+// Overlapping appends to buf the spans which overlap the range [start,end), in
+// order of start position.
+func (t *attrTree) Overlapping(buf []attrInfo, start, end int) []attrInfo {
+	var walk func(n *attrNode, delta int)
+	walk = func(n *attrNode, delta int) {
+		if n == nil || n.maxEnd+delta <= start {
+			return
+		}
+		walk(n.left, delta+n.delta)
+		attr := n.attr
+		attr.startPos += delta
+		attr.endPos += delta
+		if attr.startPos >= end {
+			return
+		}
+		if attr.endPos > start {
+			buf = append(buf, attr)
+		}
+		walk(n.right, delta+n.delta)
+	}
+	walk(t.root, 0)
+	return buf
+}
+
+// All returns all of the spans in order of start position.
+func (t *attrTree) All() []attrInfo {
+	var buf []attrInfo
+	var walk func(n *attrNode, delta int)
+	walk = func(n *attrNode, delta int) {
+		if n == nil {
+			return
+		}
+		walk(n.left, delta+n.delta)
+		attr := n.attr
+		attr.startPos += delta
+		attr.endPos += delta
+		buf = append(buf, attr)
+		walk(n.right, delta+n.delta)
+	}
+	walk(t.root, 0)
+	return buf
+}
+
+// eraseAttr returns the span which results from erasing the text in the range
+// [start,end), or false if the span is entirely erased.
+func eraseAttr(attr attrInfo, start, end int) (attrInfo, bool) {
+	if start >= attr.endPos {
+		// Attribute info is fully before erased span.
+		//     attr: +-------+
+		//     span:         +-------+
+		//           0 1 2 3 4 5 6 7 8
+		return attr, true
+	}
+	if end <= attr.startPos {
+		// Attribute info is fully after erased span.
+		//     attr:         +-------+
+		//     span: +-------+
+		//           0 1 2 3 4 5 6 7 8
+		attr.startPos -= end - start
+		attr.endPos -= end - start
+		return attr, true
+	}
+	overlapStart := attr.startPos
+	if overlapStart < start {
+		overlapStart = start
+	}
+	overlapEnd := attr.endPos
+	if overlapEnd > end {
+		overlapEnd = end
+	}
+	attr.endPos -= overlapEnd - overlapStart
+	if attr.startPos >= attr.endPos {
+		return attr, false
+	}
+	if start < attr.startPos {
+		attr.endPos -= attr.startPos - start
+		attr.startPos = start
+	}
+	return attr, true
+}
+
+// shift shifts the positions of the spans in the subtree rooted at n by delta.
+func (n *attrNode) shift(delta int) {
+	if n == nil {
+		return
+	}
+	n.attr.startPos += delta
+	n.attr.endPos += delta
+	n.maxEnd += delta
+	n.delta += delta
+}
+
+// push applies the pending shift to the children of n.
+func (n *attrNode) push() {
+	if n.delta != 0 {
+		n.left.shift(n.delta)
+		n.right.shift(n.delta)
+		n.delta = 0
+	}
+}
+
+// update recomputes the maxEnd of n from its span and children.
+func (n *attrNode) update() {
+	n.maxEnd = n.attr.endPos
+	if n.left != nil && n.left.maxEnd > n.maxEnd {
+		n.maxEnd = n.left.maxEnd
+	}
+	if n.right != nil && n.right.maxEnd > n.maxEnd {
+		n.maxEnd = n.right.maxEnd
+	}
+}
+
+// splitAttrs splits the subtree rooted at n into the spans which start before
+// pos (or at pos if inclusive is true), and the remaining spans.
+func splitAttrs(n *attrNode, pos int, inclusive bool) (l, r *attrNode) {
+	if n == nil {
+		return nil, nil
+	}
+	n.push()
+	if n.attr.startPos < pos || (inclusive && n.attr.startPos == pos) {
+		n.right, r = splitAttrs(n.right, pos, inclusive)
+		n.update()
+		return n, r
+	}
+	l, n.left = splitAttrs(n.left, pos, inclusive)
+	n.update()
+	return l, n
+}
+
+// mergeAttrs merges two subtrees where all of the spans in l are ordered before
+// the spans in r.
+func mergeAttrs(l, r *attrNode) *attrNode {
+	if l == nil {
+		return r
+	}
+	if r == nil {
+		return l
+	}
+	if l.priority > r.priority {
+		l.push()
+		l.right = mergeAttrs(l.right, r)
+		l.update()
+		return l
+	}
+	r.push()
+	r.left = mergeAttrs(l, r.left)
+	r.update()
+	return r
+}
+
+// extendAttrs extends the spans in the subtree rooted at n which end after pos
+// by delta.
+func extendAttrs(n *attrNode, pos, delta int) {
+	if n == nil || n.maxEnd <= pos {
+		return
+	}
+	n.push()
+	if n.attr.endPos > pos {
+		n.attr.endPos += delta
+	}
+	extendAttrs(n.left, pos, delta)
+	extendAttrs(n.right, pos, delta)
+	n.update()
+}
+
+// extractAttrs removes the spans in the subtree rooted at n which end after
+// start, appending them to buf. All of the spans in the subtree are required to
+// start before end. Returns the new root of the subtree.
+func extractAttrs(n *attrNode, start, end int, buf []attrInfo) (*attrNode, []attrInfo) {
+	if n == nil || n.maxEnd <= start {
+		return n, buf
+	}
+	n.push()
+	var left, right *attrNode
+	left, buf = extractAttrs(n.left, start, end, buf)
+	extract := n.attr.endPos > start
+	if extract {
+		buf = append(buf, n.attr)
+	}
+	right, buf = extractAttrs(n.right, start, end, buf)
+	if extract {
+		return mergeAttrs(left, right), buf
+	}
+	n.left, n.right = left, right
+	n.update()
+	return n, buf
+}
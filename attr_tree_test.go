@@ -0,0 +1,109 @@
+package prompt
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// naiveAttrs is a simple slice based implementation of attrTree used to verify
+// the behavior of attrTree.
+type naiveAttrs []attrInfo
+
+func (a *naiveAttrs) Add(attr attrInfo) {
+	i := sort.Search(len(*a), func(i int) bool {
+		return (*a)[i].startPos > attr.startPos
+	})
+	*a = append(*a, attrInfo{})
+	copy((*a)[i+1:], (*a)[i:])
+	(*a)[i] = attr
+}
+
+func (a *naiveAttrs) Shift(pos, n int) {
+	for i := range *a {
+		attr := &(*a)[i]
+		if attr.endPos <= pos {
+			continue
+		}
+		if attr.startPos > pos {
+			attr.startPos += n
+		}
+		attr.endPos += n
+	}
+}
+
+func (a *naiveAttrs) Erase(start, end int) {
+	var kept, overlapping naiveAttrs
+	for _, attr := range *a {
+		if attr.startPos < end && attr.endPos > start {
+			overlapping = append(overlapping, attr)
+			continue
+		}
+		attr, _ = eraseAttr(attr, start, end)
+		kept = append(kept, attr)
+	}
+	*a = kept
+	for _, attr := range overlapping {
+		if attr, ok := eraseAttr(attr, start, end); ok {
+			a.Add(attr)
+		}
+	}
+}
+
+func (a *naiveAttrs) Extract(start, end int) []attrInfo {
+	var kept naiveAttrs
+	var extracted []attrInfo
+	for _, attr := range *a {
+		if attr.startPos < end && attr.endPos > start {
+			extracted = append(extracted, attr)
+		} else {
+			kept = append(kept, attr)
+		}
+	}
+	*a = kept
+	return extracted
+}
+
+func (a naiveAttrs) Overlapping(start, end int) []attrInfo {
+	var buf []attrInfo
+	for _, attr := range a {
+		if attr.startPos < end && attr.endPos > start {
+			buf = append(buf, attr)
+		}
+	}
+	return buf
+}
+
+func TestAttrTreeRandomized(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for iter := 0; iter < 100; iter++ {
+		var tree attrTree
+		var naive naiveAttrs
+		const maxPos = 100
+		for op := 0; op < 200; op++ {
+			start := rng.Intn(maxPos)
+			end := start + 1 + rng.Intn(10)
+			switch rng.Intn(5) {
+			case 0, 1:
+				attr := attrInfo{startPos: start, endPos: end, value: string(rune('a' + op%26))}
+				tree.Add(attr)
+				naive.Add(attr)
+			case 2:
+				n := 1 + rng.Intn(5)
+				tree.Shift(start, n)
+				naive.Shift(start, n)
+			case 3:
+				tree.Erase(start, end)
+				naive.Erase(start, end)
+			case 4:
+				if rng.Intn(4) == 0 {
+					require.Equal(t, []attrInfo(naive.Extract(start, end)), tree.Extract(start, end))
+				}
+			}
+			require.Equal(t, []attrInfo(naive), tree.All())
+			require.Equal(t, naive.Overlapping(start, end), tree.Overlapping(nil, start, end))
+		}
+	}
+}
@@ -3,9 +3,14 @@ package prompt
 import (
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/petermattis/prompt/keys"
 )
 
 // TODO(peter): Support the "\C-<char>" and "\M-<char>" syntax?
@@ -15,6 +20,7 @@ type command string
 
 const (
 	cmdAbort                 command = "abort"
+	cmdAddCursorNextMatch            = "add-cursor-next-match"
 	cmdBackwardChar                  = "backward-char"
 	cmdBackwardDeleteChar            = "backward-delete-char"
 	cmdBackwardKillLine              = "backward-kill-line"
@@ -24,27 +30,43 @@ const (
 	cmdCancel                        = "cancel"
 	cmdClearScreen                   = "clear-screen"
 	cmdComplete                      = "complete"
+	cmdCompleteNth                   = "complete-nth"
+	cmdCopyBuffer                    = "copy-buffer"
 	cmdDeleteChar                    = "delete-char"
 	cmdDeleteHorizontalSpace         = "delete-horizontal-space"
 	cmdEndOfLine                     = "end-of-line"
 	cmdEnter                         = "enter"
 	cmdExitOrDeleteChar              = "exit-or-delete-char"
+	cmdExpandHistory                 = "expand-history"
 	cmdFinishOrEnter                 = "finish-or-enter"
 	cmdForwardChar                   = "forward-char"
 	cmdForwardSearchHistory          = "forward-search-history"
 	cmdForwardWord                   = "forward-word"
+	cmdHelp                          = "help"
+	cmdHistoryExpandLine             = "history-expand-line"
 	cmdInsertChar                    = "insert-char"
+	cmdInsertUnicode                 = "insert-unicode"
 	cmdKillLine                      = "kill-line"
+	cmdKillRectangle                 = "kill-rectangle"
 	cmdKillWord                      = "kill-word"
 	cmdNextHistory                   = "next-history"
+	cmdOverwriteMode                 = "overwrite-mode"
 	cmdPreviousHistory               = "previous-history"
 	cmdReverseSearchHistory          = "reverse-search-history"
+	cmdSearchNextOccurrence          = "search-next-occurrence"
+	cmdSearchPrevOccurrence          = "search-previous-occurrence"
 	cmdSetMark                       = "set-mark"
+	cmdPatternSearchBackward         = "history-search-pattern-backward"
+	cmdPatternSearchForward          = "history-search-pattern-forward"
+	cmdSubstrSearchBackward          = "history-substring-search-backward"
+	cmdSubstrSearchForward           = "history-substring-search-forward"
+	cmdToggleSearchRegexp            = "toggle-search-regexp"
 	cmdTransposeChars                = "transpose-chars"
 	cmdTransposeWords                = "transpose-words"
 	cmdUndo                          = "undo"
 	cmdYank                          = "yank"
 	cmdYankPop                       = "yank-pop"
+	cmdYankRectangle                 = "yank-rectangle"
 )
 
 const defaultBindings = string(`
@@ -54,6 +76,7 @@ bind Down            ` + cmdNextHistory + `
 bind End             ` + cmdEndOfLine + `
 bind Enter           ` + cmdFinishOrEnter + `
 bind Home            ` + cmdBeginningOfLine + `
+bind Insert          ` + cmdOverwriteMode + `
 bind Left            ` + cmdBackwardChar + `
 bind Right           ` + cmdForwardChar + `
 bind Tab             ` + cmdComplete + `
@@ -86,9 +109,24 @@ bind Meta-Enter      ` + cmdEnter + `
 bind Meta-Left       ` + cmdBackwardWord + `
 bind Meta-Right      ` + cmdForwardWord + `
 bind Meta-\          ` + cmdDeleteHorizontalSpace + `
+bind Meta-1          ` + cmdCompleteNth + `
+bind Meta-2          ` + cmdCompleteNth + `
+bind Meta-3          ` + cmdCompleteNth + `
+bind Meta-4          ` + cmdCompleteNth + `
+bind Meta-5          ` + cmdCompleteNth + `
+bind Meta-6          ` + cmdCompleteNth + `
+bind Meta-7          ` + cmdCompleteNth + `
+bind Meta-8          ` + cmdCompleteNth + `
+bind Meta-9          ` + cmdCompleteNth + `
 bind Meta-b          ` + cmdBackwardWord + `
 bind Meta-d          ` + cmdKillWord + `
+bind Meta-e          ` + cmdExpandHistory + `
 bind Meta-f          ` + cmdForwardWord + `
+bind Meta-h          ` + cmdHelp + `
+bind Meta-m          ` + cmdAddCursorNextMatch + `
+bind Meta-n          ` + cmdSearchNextOccurrence + `
+bind Meta-p          ` + cmdSearchPrevOccurrence + `
+bind Meta-r          ` + cmdToggleSearchRegexp + `
 bind Meta-t          ` + cmdTransposeWords + `
 bind Meta-y          ` + cmdYankPop + `
 `)
@@ -104,6 +142,7 @@ var namedKeys = map[string]rune{
 	"end":       keyEnd,
 	"enter":     keyEnter,
 	"home":      keyHome,
+	"insert":    keyInsert,
 	"left":      keyLeft,
 	"page-down": keyPageDown,
 	"page-up":   keyPageUp,
@@ -122,8 +161,21 @@ var baseCommands = map[command]commandFunc{
 		return true, nil
 	},
 	cmdBackwardDeleteChar: func(s *state, key rune) (bool, error) {
-		// Erase to the beginning of the previous grapheme.
-		s.screen.EraseTo(s.screen.PrevGraphemeStart())
+		// Erase to the beginning of the previous grapheme. In overwrite mode, the
+		// grapheme is replaced by spaces unless the cursor is at the end of a
+		// line, as in readline.
+		erased := s.screen.EraseTo(s.screen.PrevGraphemeStart())
+		if s.overwrite && erased != "" && erased != "\n" {
+			pos, text := s.screen.Position(), s.screen.Text()
+			if pos < len(text) && text[pos] != '\n' {
+				var width int
+				for _, r := range erased {
+					width += s.screen.runeWidth(r)
+				}
+				s.screen.Insert([]rune(strings.Repeat(" ", width))...)
+				s.screen.MoveTo(pos)
+			}
+		}
 		s.completer.Try(s)
 		return true, nil
 	},
@@ -138,11 +190,15 @@ var baseCommands = map[command]commandFunc{
 		return true, nil
 	},
 	cmdCancel: func(s *state, key rune) (bool, error) {
+		s.annotations.Clear(&s.screen)
 		if len(s.screen.Text()) == 0 {
+			s.mirrorLine("", s.echoInterrupt)
+			echoExit(s, s.echoInterrupt)
 			return true, io.EOF
 		}
 		// Cancel the current input, but leave it on screen.
-		s.screen.Cancel()
+		s.mirrorLine(string(undoSnapshot(s).text), s.echoInterrupt)
+		s.screen.Cancel(s.echoInterrupt)
 		return true, nil
 	},
 	cmdClearScreen: func(s *state, key rune) (bool, error) {
@@ -184,11 +240,14 @@ var baseCommands = map[command]commandFunc{
 		return true, nil
 	},
 	cmdEnter: func(s *state, key rune) (bool, error) {
-		s.screen.Insert('\n')
+		insertNewline(s)
 		return true, nil
 	},
 	cmdExitOrDeleteChar: func(s *state, key rune) (bool, error) {
 		if len(s.screen.Text()) == 0 {
+			s.annotations.Clear(&s.screen)
+			s.mirrorLine("", s.echoEOF)
+			echoExit(s, s.echoEOF)
 			return true, io.EOF
 		}
 		// Delete the next grapheme.
@@ -196,11 +255,24 @@ var baseCommands = map[command]commandFunc{
 		return true, nil
 	},
 	cmdFinishOrEnter: func(s *state, key rune) (bool, error) {
-		if s.inputFinished == nil || s.inputFinished(string(s.screen.Text())) {
+		if s.history.expandOnAccept && !s.history.ExpandLine(s) {
+			return true, nil
+		}
+		if s.inputFinished == nil || isInputFinished(s) {
+			if !checkAccept(s) {
+				return true, nil
+			}
+			s.annotations.Clear(&s.screen)
+			s.mirrorLine(string(s.screen.Text()), "")
 			s.screen.outbuf.WriteString("\r\n")
+			if s.screen.semanticMarks && !s.nested {
+				// The output of the command follows.
+				s.screen.outbuf.WriteString(oscOutputStart)
+				s.commandRunning = true
+			}
 			return true, io.EOF
 		}
-		s.screen.Insert('\n')
+		insertNewline(s)
 		return true, nil
 	},
 	cmdForwardChar: func(s *state, key rune) (bool, error) {
@@ -214,15 +286,41 @@ var baseCommands = map[command]commandFunc{
 		return true, nil
 	},
 	cmdInsertChar: func(s *state, key rune) (bool, error) {
-		// Insert the character at the current cursor position.
+		// Insert the character at the current cursor position, unless the
+		// self-insert hook replaces the text before the cursor instead. In
+		// overwrite mode, the character replaces the grapheme at the cursor,
+		// unless the cursor is at the end of a line.
+		if selfInsert(s, key) {
+			s.completer.Try(s)
+			return true, nil
+		}
+		if s.overwrite && key != '\n' {
+			if text := s.screen.Text(); s.screen.Position() < len(text) && text[s.screen.Position()] != '\n' {
+				s.screen.EraseTo(s.screen.NextGraphemeEnd())
+			}
+		}
 		s.screen.Insert(key)
 		s.completer.Try(s)
 		return true, nil
 	},
+	cmdOverwriteMode: func(s *state, key rune) (bool, error) {
+		// Toggle between inserting and overwriting typed characters.
+		s.overwrite = !s.overwrite
+		var indicator Annotation
+		if s.overwrite {
+			indicator = Annotation{Text: " [overwrite]", Priority: AnnotationPriorityOverwrite}
+		}
+		s.annotations.Set(&s.screen, annotationOverwrite, indicator)
+		return true, nil
+	},
+	cmdKillRectangle: func(s *state, key rune) (bool, error) {
+		killRectangle(s)
+		return true, nil
+	},
 	cmdSetMark: func(s *state, key rune) (bool, error) {
-		// TODO(peter): set-mark
-		// - The mark is a logical position in the text. If text is inserted or erased
-		//   before the mark, the mark's position is adjusted.
+		// The mark is a logical position in the text. If text is inserted or
+		// erased before the mark, the mark's position is adjusted.
+		s.mark = s.screen.Position()
 		return true, nil
 	},
 	cmdTransposeChars: func(s *state, key rune) (bool, error) {
@@ -251,58 +349,223 @@ var baseCommands = map[command]commandFunc{
 		return true, nil
 	},
 	cmdUndo: func(s *state, key rune) (bool, error) {
-		// TODO(peter): Undo. Each input buffer needs to maintain a series of edits
-		// (insertions, and deletions). The history entries then become these input
-		// buffers rather than just strings. Undo only applies to the current line. A
-		// series of insertions creates a single edit.
+		undo(s)
+		return true, nil
+	},
+	cmdYankRectangle: func(s *state, key rune) (bool, error) {
+		yankRectangle(s)
 		return true, nil
 	},
 }
 
-func isValidCommand(cmd command) bool {
-	if _, ok := baseCommands[cmd]; ok {
-		return true
+// echoExit writes echo after the input text followed by a newline, if echo is
+// non-empty. See WithExitEcho.
+func echoExit(s *state, echo string) {
+	if echo == "" {
+		return
 	}
-	if _, ok := completionCommands[cmd]; ok {
-		return true
+	s.screen.MoveTo(s.screen.End())
+	s.screen.outbuf.WriteString(echo)
+	s.screen.outbuf.WriteString("\r\n")
+}
+
+// insertNewline inserts a newline at the current cursor position. If
+// auto-indent is enabled, the newline is followed by the leading whitespace of
+// the line containing the cursor.
+func insertNewline(s *state) {
+	if !s.autoIndent {
+		s.screen.Insert('\n')
+		return
 	}
-	if _, ok := killCommands[cmd]; ok {
-		return true
+	text := s.screen.Text()
+	start := s.screen.Position()
+	for start > 0 && text[start-1] != '\n' {
+		start--
 	}
-	if _, ok := yankCommands[cmd]; ok {
-		return true
+	end := start
+	for end < s.screen.Position() && (text[end] == ' ' || text[end] == '\t') {
+		end++
+	}
+	indent := append([]rune{'\n'}, text[start:end]...)
+	s.screen.Insert(indent...)
+}
+
+// SelfInsertFunc is invoked before a typed character is inserted into the
+// input, allowing an application to implement simple input method composition,
+// such as expanding "\alpha" to "α" when a space is typed. The doc holds the
+// input text and cursor position before the insertion, and r is the typed
+// character. If ok is true, the text between start and the cursor is replaced
+// by text, which should include r if it is to be inserted, and the cursor is
+// positioned after the replacement. Otherwise r is inserted as usual. See
+// WithSelfInsert.
+type SelfInsertFunc func(doc Document, r rune) (start int, text string, ok bool)
+
+// selfInsert invokes the self-insert hook for key, returning true if the hook
+// replaced the text before the cursor.
+func selfInsert(s *state, key rune) bool {
+	if s.selfInsert == nil {
+		return false
+	}
+	pos := s.screen.Position()
+	doc := Document{Text: append([]rune(nil), s.screen.Text()...), Cursor: pos}
+	start, text, ok := s.selfInsert(doc, key)
+	if !ok || start < 0 || start > pos {
+		return false
 	}
-	if _, ok := historyCommands[cmd]; ok {
+	s.screen.MoveTo(start)
+	s.screen.EraseTo(pos)
+	s.screen.Insert([]rune(text)...)
+	return true
+}
+
+// isInputFinished invokes the inputFinished callback, recording its latency.
+func isInputFinished(s *state) bool {
+	start := time.Now()
+	finished := s.inputFinished(string(s.screen.Text()), s.screen.Position())
+	s.metrics.AddCallback(callbackInputFinished, start)
+	return finished
+}
+
+// AcceptCheckFunc is invoked when the input is accepted, before ReadLine
+// returns it, to audit the input or enforce a policy such as refusing to drop
+// a database. If it returns an error, the input is not accepted: editing
+// continues and the error message is displayed after the input until the next
+// key is processed. The LockedPrompt may be used to read a confirmation with a
+// nested ReadLine. See WithAcceptCheck.
+type AcceptCheckFunc func(lp *LockedPrompt, line string) error
+
+// checkAccept invokes the acceptCheck callback, if any, returning false and
+// displaying the error if it vetoes accepting the input.
+func checkAccept(s *state) bool {
+	if s.acceptCheck == nil || s.nested {
 		return true
 	}
-	return false
+	if err := s.acceptCheck(string(s.screen.Text())); err != nil {
+		showAcceptError(s, err)
+		return false
+	}
+	return true
 }
 
-func parseBinding(binding string) (key rune, cmd command, err error) {
-	const (
-		controlPrefix = "Control-"
-		metaPrefix    = "Meta-"
-	)
+// showAcceptError displays the error which prevented the input from being
+// accepted, until clearAcceptError is called.
+func showAcceptError(s *state, err error) {
+	s.acceptErr = true
+	s.annotations.Set(&s.screen, annotationAcceptError, Annotation{
+		Text:     "\n" + err.Error(),
+		Priority: AnnotationPriorityDiagnostic,
+	})
+}
 
+// clearAcceptError removes the error displayed by showAcceptError.
+func clearAcceptError(s *state) {
+	if s.acceptErr {
+		s.acceptErr = false
+		s.annotations.Set(&s.screen, annotationAcceptError, Annotation{})
+	}
+}
+
+func parseBinding(binding string) (key rune, cmd command, err error) {
 	parts := strings.Fields(binding)
-	if len(parts) != 3 || parts[0] != "bind" {
-		return utf8.RuneError, "", fmt.Errorf("invalid binding: [%s]", binding)
+	if i, n := macroLiteral(binding); i >= 0 {
+		// The text of a macro is a single argument, even if it holds spaces.
+		parts = append(strings.Fields(binding[:i]), binding[i:i+n])
+		parts = append(parts, strings.Fields(binding[i+n:])...)
+	}
+	switch {
+	case len(parts) == 0 || parts[0] != "bind":
+		return utf8.RuneError, "", fmt.Errorf("invalid binding: [%s]: expected \"bind <key> <command>\"", binding)
+	case len(parts) < 3:
+		return utf8.RuneError, "", fmt.Errorf("invalid binding: [%s]: missing command", binding)
+	case len(parts) > 3:
+		return utf8.RuneError, "", fmt.Errorf("invalid binding: [%s]: unexpected %q after command", binding, parts[3])
+	}
+
+	if isMacro(command(parts[2])) {
+		var lit string
+		lit, _, err = parseMacro(parts[2])
+		cmd = command(lit)
+	} else {
+		cmd, err = lookupCommand(parts[2])
+	}
+	if err != nil {
+		return utf8.RuneError, "", err
+	}
+
+	key, err = parseKey(parts[1])
+	if err != nil {
+		return utf8.RuneError, "", err
+	}
+	return key, cmd, nil
+}
+
+// isMacro returns true if cmd is a macro, the quoted text of the keys a binding
+// types rather than the name of a command.
+func isMacro(cmd command) bool {
+	return strings.HasPrefix(string(cmd), `"`)
+}
+
+// macroLiteral locates the quoted text of a macro in a binding, returning the
+// index of its opening quote and its length through the closing quote, or
+// through the end of the binding if the text is unterminated. The index is -1
+// if the binding holds no macro.
+func macroLiteral(binding string) (i, n int) {
+	i = strings.IndexByte(binding, '"')
+	if i < 0 {
+		return -1, 0
 	}
+	for j := i + 1; j < len(binding); j++ {
+		switch binding[j] {
+		case '\\':
+			j++
+		case '"':
+			return i, j + 1 - i
+		}
+	}
+	return i, len(binding) - i
+}
 
-	cmd = command(parts[2])
-	if s, ok := commandAliases[string(cmd)]; ok {
-		cmd = s
+// parseMacro parses the text of a macro, a double-quoted Go string literal
+// holding keys in the syntax of ParseKeys, returning the text in canonical form
+// along with the keys it types.
+func parseMacro(lit string) (string, []rune, error) {
+	text, err := strconv.Unquote(lit)
+	if err != nil || lit[0] != '"' {
+		return "", nil, fmt.Errorf("invalid macro: %s", lit)
 	}
-	if !isValidCommand(cmd) {
-		return utf8.RuneError, "", fmt.Errorf("unknown command: %s", cmd)
+	input, err := ParseKeys(text)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid macro: %s: %v", lit, err)
 	}
+	// The input is complete, so a trailing escape is the Escape key rather
+	// than the start of a sequence.
+	parser := keys.NewParser(nil, false)
+	parser.Feed(input)
+	parser.Expire()
+	var macro []rune
+	for {
+		key, ok := parser.Next()
+		if !ok {
+			break
+		}
+		macro = append(macro, key)
+	}
+	return strconv.Quote(text), macro, nil
+}
+
+// parseKey parses the name of a key in the syntax used by bindings, such as
+// "a", "Control-a", or "Meta-Left".
+func parseKey(name string) (key rune, err error) {
+	const (
+		controlPrefix = "Control-"
+		metaPrefix    = "Meta-"
+	)
 
-	origKey := parts[1]
 	var mods rune
-	for s := parts[1]; len(s) > 0; {
+	for s := name; ; {
 		if strings.HasPrefix(s, controlPrefix) {
 			if (mods & keyCtrl) != 0 {
-				return utf8.RuneError, "", fmt.Errorf("invalid key: %q", origKey)
+				return utf8.RuneError, fmt.Errorf("invalid key: %q", name)
 			}
 			mods |= keyCtrl
 			s = s[len(controlPrefix):]
@@ -310,7 +573,7 @@ func parseBinding(binding string) (key rune, cmd command, err error) {
 		}
 		if strings.HasPrefix(s, metaPrefix) {
 			if (mods & keyAlt) != 0 {
-				return utf8.RuneError, "", fmt.Errorf("invalid key: %q", origKey)
+				return utf8.RuneError, fmt.Errorf("invalid key: %q", name)
 			}
 			mods |= keyAlt
 			s = s[len(metaPrefix):]
@@ -319,46 +582,367 @@ func parseBinding(binding string) (key rune, cmd command, err error) {
 		if key = namedKeys[strings.ToLower(s)]; key == 0 {
 			var l int
 			key, l = utf8.DecodeRuneInString(s)
-			if l != len(s) {
-				return utf8.RuneError, "", fmt.Errorf("invalid key: %q", origKey)
+			if l == 0 || l != len(s) {
+				return utf8.RuneError, fmt.Errorf("invalid key: %q", name)
 			}
 		}
 		break
 	}
 
-	// Translate C-[a-z] into keyCtrl[A-Z].
+	// Translate C-[a-z] into keyCtrl[A-Z], and C-Space, C-@, C-\, C-], C-^, and
+	// C-_ into the control characters the terminal sends for them.
 	if (mods & keyCtrl) != 0 {
-		if key >= 'a' && key <= ('a'+31) {
+		if key == ' ' || key == '@' {
+			key = 0
+			mods ^= keyCtrl
+		} else if key >= 'a' && key <= ('a'+31) {
 			key -= 0x60
 			mods ^= keyCtrl
+		} else if key >= '\\' && key <= '_' {
+			key -= 0x40
+			mods ^= keyCtrl
+		}
+	}
+
+	return key | mods, nil
+}
+
+// BindingConflict describes a key which is bound more than once to different
+// commands. Bindings are applied in order (the default bindings, followed by
+// the bindings specified by options), and the last binding of a key wins. See
+// Prompt.BindingConflicts.
+type BindingConflict struct {
+	// Key is the name of the key, such as "Control-a" or "Meta-Left".
+	Key string
+	// Condition is the condition of a conditional binding, or empty for an
+	// unconditional binding. Conditional bindings only conflict with bindings
+	// of the key with the same condition.
+	Condition string
+	// Command is the command the key is bound to.
+	Command string
+	// Source and Line identify the binding of the key to Command. Source is
+	// "default" for the default bindings, "WithBindings#N" for the bindings
+	// specified by the Nth WithBindings option, or the name of the option which
+	// bound the key. Line is the line number within the bindings, or 0 for
+	// bindings which are not specified textually.
+	Source string
+	Line   int
+	// PrevCommand, PrevSource, and PrevLine describe the overridden binding.
+	PrevCommand string
+	PrevSource  string
+	PrevLine    int
+}
+
+func (c BindingConflict) String() string {
+	loc := func(source string, line int) string {
+		if line == 0 {
+			return source
 		}
+		return fmt.Sprintf("%s:%d", source, line)
+	}
+	key := c.Key
+	if c.Condition != "" {
+		key += " if " + c.Condition
 	}
+	return fmt.Sprintf("%s: %s (%s) overrides %s (%s)",
+		key, c.Command, loc(c.Source, c.Line), c.PrevCommand, loc(c.PrevSource, c.PrevLine))
+}
+
+// bindingOrigin records where a key binding was specified.
+type bindingOrigin struct {
+	source string
+	line   int
+}
+
+// keyBindings maps keys to commands, recording the origin of each binding and
+// any conflicts between bindings. A key may also have conditional bindings,
+// which take precedence over its unconditional binding while their condition
+// holds.
+type keyBindings struct {
+	commands  map[rune]command
+	origins   map[rune]bindingOrigin
+	conflicts []BindingConflict
+	// conditional holds the conditional bindings of each key, in the order
+	// they were bound. conditions holds the conditions registered with
+	// WithBindingCondition.
+	conditional map[rune][]conditionalBinding
+	conditions  map[string]BindingCondition
+	// macros holds the keys typed by each macro bound to a key.
+	macros map[command][]rune
+}
 
-	return key | mods, cmd, nil
+// conditionalBinding is a binding which only applies while its condition
+// holds. The condition is the name of a built-in or registered condition,
+// negated if prefixed with "!".
+type conditionalBinding struct {
+	cond   string
+	cmd    command
+	origin bindingOrigin
 }
 
-func parseBindings(m map[rune]command, data string) error {
-	for _, line := range strings.Split(data, "\n") {
+// BindingCondition reports whether a conditional key binding applies, given the
+// input text and cursor position. See WithBindingCondition.
+type BindingCondition func(doc Document) bool
+
+// builtinConditions are the conditions which may guard a binding without
+// being registered with WithBindingCondition.
+var builtinConditions = map[string]func(s *state) bool{
+	// empty holds if there is no input text.
+	"empty": func(s *state) bool {
+		return len(s.screen.Text()) == 0
+	},
+	// multi-line holds if the input text contains more than one line.
+	"multi-line": func(s *state) bool {
+		for _, r := range s.screen.Text() {
+			if r == '\n' {
+				return true
+			}
+		}
+		return false
+	},
+	// searching holds during incremental history search.
+	"searching": func(s *state) bool {
+		return s.history.searchDir != 0
+	},
+	// completion-list holds while completions are listed below the input.
+	"completion-list": func(s *state) bool {
+		return s.completer.listed
+	},
+	// completion-hint holds while a completion hint is displayed.
+	"completion-hint": func(s *state) bool {
+		return len(s.completer.suffix) > 0
+	},
+}
+
+func makeKeyBindings() keyBindings {
+	return keyBindings{
+		commands:    make(map[rune]command),
+		origins:     make(map[rune]bindingOrigin),
+		conditional: make(map[rune][]conditionalBinding),
+		macros:      make(map[command][]rune),
+	}
+}
+
+// bind binds key to cmd, recording a conflict if the key is already bound to a
+// different command.
+func (b *keyBindings) bind(key rune, cmd command, origin bindingOrigin) {
+	if prev, ok := b.commands[key]; ok && prev != cmd {
+		prevOrigin := b.origins[key]
+		b.conflicts = append(b.conflicts, BindingConflict{
+			Key:         keyName(key),
+			Command:     string(cmd),
+			Source:      origin.source,
+			Line:        origin.line,
+			PrevCommand: string(prev),
+			PrevSource:  prevOrigin.source,
+			PrevLine:    prevOrigin.line,
+		})
+	}
+	b.commands[key] = cmd
+	b.origins[key] = origin
+}
+
+// bindIf binds key to cmd while cond holds, recording a conflict if the key is
+// already bound to a different command under the same condition.
+func (b *keyBindings) bindIf(key rune, cond string, cmd command, origin bindingOrigin) {
+	bindings := b.conditional[key]
+	for i := range bindings {
+		prev := &bindings[i]
+		if prev.cond != cond {
+			continue
+		}
+		if prev.cmd != cmd {
+			b.conflicts = append(b.conflicts, BindingConflict{
+				Key:         keyName(key),
+				Condition:   cond,
+				Command:     string(cmd),
+				Source:      origin.source,
+				Line:        origin.line,
+				PrevCommand: string(prev.cmd),
+				PrevSource:  prev.origin.source,
+				PrevLine:    prev.origin.line,
+			})
+		}
+		prev.cmd, prev.origin = cmd, origin
+		return
+	}
+	b.conditional[key] = append(bindings, conditionalBinding{cond: cond, cmd: cmd, origin: origin})
+}
+
+// listedBinding is a key binding listed by keyBindings.list.
+type listedBinding struct {
+	key  string
+	cmd  command
+	cond string
+}
+
+// list returns the key bindings, including the conditional bindings, ordered
+// by the category of the command, the command, the key, and the condition. The
+// implicit upper case variants of Meta bindings are omitted.
+func (b *keyBindings) list() []listedBinding {
+	bindings := make([]listedBinding, 0, len(b.commands))
+	for key, cmd := range b.commands {
+		if c := key &^ (keyAlt | keyCtrl); (key&keyAlt) != 0 && unicode.IsUpper(c) &&
+			b.commands[key&^c|unicode.ToLower(c)] == cmd {
+			// Omit the implicit upper case variant of a Meta binding.
+			continue
+		}
+		bindings = append(bindings, listedBinding{key: keyName(key), cmd: cmd})
+	}
+	for key, conditional := range b.conditional {
+		if c := key &^ (keyAlt | keyCtrl); (key&keyAlt) != 0 && unicode.IsUpper(c) {
+			// Omit the implicit upper case variant of a Meta binding.
+			if _, ok := b.conditional[key&^c|unicode.ToLower(c)]; ok {
+				continue
+			}
+		}
+		for _, cb := range conditional {
+			bindings = append(bindings, listedBinding{key: keyName(key), cmd: cb.cmd, cond: cb.cond})
+		}
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		bi, bj := bindings[i], bindings[j]
+		if ci, cj := describeCommand(bi.cmd).category, describeCommand(bj.cmd).category; ci != cj {
+			return ci < cj
+		}
+		if bi.cmd != bj.cmd {
+			return bi.cmd < bj.cmd
+		}
+		if bi.key != bj.key {
+			return bi.key < bj.key
+		}
+		return bi.cond < bj.cond
+	})
+	return bindings
+}
+
+// hasCondition returns true if cond names a built-in or registered condition,
+// optionally negated.
+func (b *keyBindings) hasCondition(cond string) bool {
+	name := strings.TrimPrefix(cond, "!")
+	if _, ok := b.conditions[name]; ok {
+		return true
+	}
+	_, ok := builtinConditions[name]
+	return ok
+}
+
+// eval returns true if cond holds. Registered conditions take precedence over
+// built-in conditions of the same name.
+func (b *keyBindings) eval(s *state, cond string) bool {
+	name := strings.TrimPrefix(cond, "!")
+	var holds bool
+	if fn, ok := b.conditions[name]; ok {
+		holds = fn(Document{
+			Text:       append([]rune(nil), s.screen.Text()...),
+			Cursor:     s.screen.Position(),
+			Completion: s.completer.State(),
+		})
+	} else {
+		holds = builtinConditions[name](s)
+	}
+	return holds != (name != cond)
+}
+
+// lookup returns the command key is bound to. The conditional bindings of the
+// key are considered first, most recently bound first, followed by the
+// unconditional binding.
+func (b *keyBindings) lookup(s *state, key rune) command {
+	bindings := b.conditional[key]
+	for i := len(bindings) - 1; i >= 0; i-- {
+		if b.eval(s, bindings[i].cond) {
+			return bindings[i].cmd
+		}
+	}
+	return b.commands[key]
+}
+
+// parse parses the bindings in data, which are attributed to source. A binding
+// may be followed by "if <condition>", in which case it only applies while the
+// condition holds. Lines beginning with '#' are comments.
+func (b *keyBindings) parse(data, source string) error {
+	for i, line := range strings.Split(data, "\n") {
 		line = strings.TrimSpace(line)
-		if len(line) == 0 {
+		if len(line) == 0 || line[0] == '#' {
 			continue
 		}
+		// The condition follows the text of a macro, which may itself hold
+		// "if".
+		var cond string
+		prefix, rest := "", line
+		if i, n := macroLiteral(line); i >= 0 {
+			prefix, rest = line[:i+n], line[i+n:]
+		}
+		if fields := strings.Fields(rest); len(fields) >= 2 && fields[len(fields)-2] == "if" &&
+			len(strings.Fields(prefix))+len(fields) > 2 {
+			cond = fields[len(fields)-1]
+			if !b.hasCondition(cond) {
+				return fmt.Errorf("unknown binding condition: %s", cond)
+			}
+			line = strings.TrimSpace(prefix + " " + strings.Join(fields[:len(fields)-2], " "))
+		}
 		key, cmd, err := parseBinding(line)
 		if err != nil {
 			return err
 		}
-		m[key] = cmd
+		if isMacro(cmd) {
+			_, b.macros[cmd], _ = parseMacro(string(cmd))
+		}
+		origin := bindingOrigin{source: source, line: i + 1}
+		bind := func(key rune) {
+			if cond == "" {
+				b.bind(key, cmd, origin)
+			} else {
+				b.bindIf(key, cond, cmd, origin)
+			}
+		}
+		bind(key)
 		if (key & keyAlt) != 0 {
-			b := key & ^(keyAlt | keyCtrl)
+			// Meta bindings are case-insensitive, as the Shift key is sometimes
+			// (inadvertently) held when the chord is typed.
+			c := key & ^(keyAlt | keyCtrl)
 			switch {
-			case unicode.IsLower(b):
-				b = unicode.ToUpper(b)
-			case unicode.IsUpper(b):
-				b = unicode.ToLower(b)
+			case unicode.IsLower(c):
+				c = unicode.ToUpper(c)
+			case unicode.IsUpper(c):
+				c = unicode.ToLower(c)
+			default:
+				continue
 			}
-			key = b | (key & (keyAlt | keyCtrl))
-			m[key] = cmd
+			bind(c | (key & (keyAlt | keyCtrl)))
 		}
 	}
 	return nil
 }
+
+// keyName returns the name of key in the syntax used by bindings.
+func keyName(key rune) string {
+	var prefix string
+	if (key & keyAlt) != 0 {
+		prefix += "Meta-"
+	}
+	if (key & keyCtrl) != 0 {
+		prefix += "Control-"
+	}
+	key &^= keyAlt | keyCtrl
+	for name, k := range namedKeys {
+		if k == key {
+			// Capitalize the name (and each hyphenated part), e.g. "Page-Down".
+			parts := strings.Split(name, "-")
+			for i := range parts {
+				parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+			}
+			return prefix + strings.Join(parts, "-")
+		}
+	}
+	if key == 0 {
+		return prefix + "Control-Space"
+	}
+	if key >= 0x1c && key < ' ' {
+		return prefix + "Control-" + string(key+0x40)
+	}
+	if key < ' ' {
+		return prefix + "Control-" + string(key+0x60)
+	}
+	return prefix + string(key)
+}
@@ -0,0 +1,249 @@
+package prompt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/petermattis/prompt/keys"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyName(t *testing.T) {
+	testCases := []struct {
+		binding string
+		name    string
+	}{
+		{"bind a enter", "a"},
+		{"bind Backspace enter", "Backspace"},
+		{"bind page-down enter", "Page-Down"},
+		{"bind insert overwrite-mode", "Insert"},
+		{"bind Control-a enter", "Control-a"},
+		{"bind Control-Space enter", "Control-Space"},
+		{"bind Control-@ enter", "Control-Space"},
+		{"bind Control-_ enter", "Control-_"},
+		{"bind Control-] enter", "Control-]"},
+		{"bind Control-Left enter", "Control-Left"},
+		{"bind Meta-b enter", "Meta-b"},
+		{"bind Meta-Control-h enter", "Meta-Control-h"},
+		{"bind Meta-Enter enter", "Meta-Enter"},
+	}
+	for _, c := range testCases {
+		t.Run(c.binding, func(t *testing.T) {
+			key, _, err := parseBinding(c.binding)
+			require.NoError(t, err)
+			require.Equal(t, c.name, keyName(key))
+
+			// The name round-trips through parseBinding.
+			key2, _, err := parseBinding("bind " + keyName(key) + " enter")
+			require.NoError(t, err)
+			require.Equal(t, key, key2)
+		})
+	}
+}
+
+func TestParseBindingErrors(t *testing.T) {
+	testCases := []struct {
+		binding string
+		err     string
+	}{
+		{"bnid Control-a yank", `invalid binding: [bnid Control-a yank]: expected "bind <key> <command>"`},
+		{"bind Control-a", "invalid binding: [bind Control-a]: missing command"},
+		{"bind Control-a yank pop", `invalid binding: [bind Control-a yank pop]: unexpected "pop" after command`},
+		{"bind Control-a yank-pp", "unknown command: yank-pp (did you mean yank-pop?)"},
+		{"bind Contrl-a yank", `invalid key: "Contrl-a"`},
+		{`bind Control-a "select`, `invalid macro: "select`},
+		{`bind Control-a "select" now`, `invalid binding: [bind Control-a "select" now]: unexpected "now" after command`},
+		{`bind Control-a "<Contrl-a>"`, `invalid macro: "<Contrl-a>": invalid key: "Contrl-a"`},
+	}
+	for _, c := range testCases {
+		_, _, err := parseBinding(c.binding)
+		require.EqualError(t, err, c.err, "%s", c.binding)
+	}
+}
+
+func TestDumpBindings(t *testing.T) {
+	p, err := New(WithInteractive(true),
+		WithBindings("bind Control-o enter\nbind Control-j cancel if empty\n"+
+			`bind Meta-s "select\t* from <lt>t> if x"`))
+	require.NoError(t, err)
+	var buf strings.Builder
+	require.NoError(t, p.DumpBindings(&buf))
+	dump := buf.String()
+	require.True(t, strings.HasPrefix(dump, "# Movement\n\n# Move back one character\nbind Control-b backward-char\n"))
+	require.Contains(t, dump, "\n# Insert a newline\nbind Control-o enter\nbind Meta-Enter enter\n")
+	require.Contains(t, dump, "\nbind Control-j cancel if empty\n")
+	require.True(t, strings.HasSuffix(dump,
+		"# Macros\n\n# Type the macro\n"+`bind Meta-s "select\t* from <lt>t> if x"`+"\n"))
+
+	// The dumped bindings can be passed back to WithBindings, resulting in the
+	// same bindings without conflicts.
+	q, err := New(WithInteractive(true), WithBindings(dump))
+	require.NoError(t, err)
+	require.Empty(t, q.BindingConflicts())
+	require.Equal(t, p.bindings.list(), q.bindings.list())
+}
+
+func TestMacros(t *testing.T) {
+	p, err := New(WithOutput(ioutil.Discard), WithInteractive(true),
+		WithBindings(`bind Control-j "SELECT * FROM " if empty
+bind Control-j "<Control-a>-- " if !empty
+bind Meta-g "git status<Enter>ignored"
+bind Meta-x "x<Meta-x>"`))
+	require.NoError(t, err)
+	require.Empty(t, p.BindingConflicts())
+
+	type result struct {
+		text string
+		pos  int
+		err  error
+	}
+	process := func(input string) result {
+		p.parser.Feed([]byte(input))
+		p.mu.Lock()
+		text, err := p.processInputLocked()
+		p.mu.Unlock()
+		if text == "" {
+			text = p.CurrentText()
+		}
+		return result{text, p.CursorPosition(), err}
+	}
+	p.mu.state.Reset([]rune("> "))
+	p.mu.state.active = true
+
+	// A conditional macro types text, including keys bound to commands.
+	require.Equal(t, result{"SELECT * FROM ", 14, nil}, process("\n"))
+	require.Equal(t, result{"-- SELECT * FROM t", 3, nil}, process("t\n"))
+
+	// A macro typing its own key terminates.
+	require.Equal(t, result{"-- xxxxxxxxSELECT * FROM t", 11, nil}, process("\x1bx"))
+
+	// The keys following one which accepts the input are discarded.
+	p.mu.state.Reset([]rune("> "))
+	require.Equal(t, result{"git status", 10, nil}, process("\x1bg"))
+}
+
+func TestBindingConflicts(t *testing.T) {
+	newPrompt := func(options ...Option) *Prompt {
+		options = append(options, WithInteractive(true))
+		p, err := New(options...)
+		require.NoError(t, err)
+		return p
+	}
+
+	// The default bindings don't conflict.
+	require.Empty(t, newPrompt().BindingConflicts())
+
+	// Rebinding a key to the same command is not a conflict.
+	require.Empty(t, newPrompt(WithBindings("bind Control-a beginning-of-line")).BindingConflicts())
+
+	// defaultLine returns the line number of the default binding of key.
+	defaultLine := func(key string) int {
+		for i, line := range strings.Split(defaultBindings, "\n") {
+			if f := strings.Fields(line); len(f) == 3 && f[1] == key {
+				return i + 1
+			}
+		}
+		t.Fatalf("no default binding for %s", key)
+		return 0
+	}
+
+	p := newPrompt(
+		WithHistorySubstringSearch(true),
+		WithBindings(`
+bind Control-o enter
+bind Meta-B kill-word
+`),
+		WithBindings(`bind Control-o yank`),
+	)
+	require.Equal(t, []BindingConflict{
+		{
+			Key:         "Meta-B",
+			Command:     "kill-word",
+			Source:      "WithBindings#1",
+			Line:        3,
+			PrevCommand: "backward-word",
+			PrevSource:  "default",
+			PrevLine:    defaultLine("Meta-b"),
+		},
+		{
+			Key:         "Meta-b",
+			Command:     "kill-word",
+			Source:      "WithBindings#1",
+			Line:        3,
+			PrevCommand: "backward-word",
+			PrevSource:  "default",
+			PrevLine:    defaultLine("Meta-b"),
+		},
+		{
+			Key:         "Control-o",
+			Command:     "yank",
+			Source:      "WithBindings#2",
+			Line:        1,
+			PrevCommand: "enter",
+			PrevSource:  "WithBindings#1",
+			PrevLine:    2,
+		},
+	}, p.BindingConflicts()[2:])
+
+	// The first two conflicts are the Up and Down keys being rebound by the
+	// substring search option.
+	conflicts := p.BindingConflicts()[:2]
+	require.Equal(t, "Up: history-substring-search-backward (WithHistorySubstringSearch) "+
+		fmt.Sprintf("overrides previous-history (default:%d)", defaultLine("Up")), conflicts[0].String())
+	require.Equal(t, "Down", conflicts[1].Key)
+
+	// The last binding wins.
+	require.Equal(t, command(cmdYank), p.bindings.commands[keys.CtrlO])
+	require.Equal(t, command(cmdKillWord), p.bindings.commands['b'|keyAlt])
+}
+
+func TestConditionalBindings(t *testing.T) {
+	var calls int
+	p, err := New(
+		WithInteractive(true),
+		WithBindingCondition("odd", func(doc Document) bool {
+			calls++
+			return len(doc.Text)%2 == 1
+		}),
+		WithBindings(`
+bind Tab insert-char if empty
+bind Tab help if !empty
+bind Tab complete if odd
+bind Meta-x kill-line if odd
+`),
+		WithBindings(`bind Tab transpose-chars if odd`),
+	)
+	require.NoError(t, err)
+
+	lookup := func(text string) command {
+		s := &p.mu.state
+		s.screen.Reset(nil)
+		s.screen.Insert([]rune(text)...)
+		return p.bindings.lookup(s, keyTab)
+	}
+	require.Equal(t, command(cmdInsertChar), lookup(""))
+	require.Equal(t, command(cmdHelp), lookup("ab"))
+	// The most recently bound condition which holds wins.
+	require.Equal(t, command(cmdTransposeChars), lookup("abc"))
+	require.Equal(t, 3, calls)
+	// Keys without conditional bindings use their unconditional binding.
+	require.Equal(t, command(cmdFinishOrEnter), p.bindings.lookup(&p.mu.state, keyEnter))
+	// Meta bindings are case-insensitive.
+	require.Len(t, p.bindings.conditional['X'|keyAlt], 1)
+
+	// Rebinding a key under the same condition is a conflict.
+	require.Equal(t, []string{
+		"Tab if odd: transpose-chars (WithBindings#2:1) overrides complete (WithBindings#1:4)",
+	}, func() []string {
+		var res []string
+		for _, c := range p.BindingConflicts() {
+			res = append(res, c.String())
+		}
+		return res
+	}())
+
+	_, err = New(WithBindings("bind Tab complete if unknown"))
+	require.EqualError(t, err, "unknown binding condition: unknown")
+}
@@ -0,0 +1,164 @@
+package prompt
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CommandSpec declares a command for completion by CommandCompleter. A command
+// may have subcommands, flags, and positional arguments.
+type CommandSpec struct {
+	// Name is the name of the command as typed, such as "show" or `\connect`.
+	Name string
+	// Subcommands are the commands which may follow the command name.
+	Subcommands []CommandSpec
+	// Flags are the flags accepted by the command.
+	Flags []FlagSpec
+	// Args, if non-nil, returns the candidates for the next positional argument
+	// given the positional arguments preceding it.
+	Args func(args []string) []string
+}
+
+// FlagSpec declares a flag for completion by CommandCompleter.
+type FlagSpec struct {
+	// Name is the long name of the flag without the leading dashes, such as
+	// "format" for --format.
+	Name string
+	// Short is the optional single character short name of the flag without the
+	// leading dash, such as "f" for -f.
+	Short string
+	// HasValue is true if the flag takes a value, either as the following
+	// argument or following an '=' (e.g. --format=json).
+	HasValue bool
+	// Values, if non-nil, returns the candidate values of the flag. Implies
+	// HasValue.
+	Values func() []string
+}
+
+func (f *FlagSpec) hasValue() bool {
+	return f.HasValue || f.Values != nil
+}
+
+// CommandCompleter returns a CompletionFunc which completes the commands
+// described by a tree of CommandSpecs. The arguments preceding the word being
+// completed are split on whitespace and walked through the tree to determine the
+// current command. The word is then completed as a subcommand, a flag, a flag
+// value, or a positional argument, depending on its position and its leading
+// dashes. Subcommands are only completed before the first positional argument.
+func CommandCompleter(commands ...CommandSpec) CompletionFunc {
+	return func(text []rune, wordStart, wordEnd int) []string {
+		// The token being completed starts at the preceding whitespace and may
+		// include non-word characters before the word (e.g. "--").
+		tokenStart := wordStart
+		for tokenStart > 0 && !unicode.IsSpace(text[tokenStart-1]) {
+			tokenStart--
+		}
+		token := string(text[tokenStart:wordEnd])
+		tokenPrefix := string(text[tokenStart:wordStart])
+
+		var cur *CommandSpec
+		subcommands := commands
+		var args []string
+		var pendingFlag *FlagSpec
+		for _, arg := range strings.Fields(string(text[:tokenStart])) {
+			if pendingFlag != nil {
+				pendingFlag = nil
+				continue
+			}
+			if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+				if f := findFlag(cur, arg); f != nil && f.hasValue() && !strings.Contains(arg, "=") {
+					pendingFlag = f
+				}
+				continue
+			}
+			if len(args) == 0 {
+				if c := findCommand(subcommands, arg); c != nil {
+					cur = c
+					subcommands = c.Subcommands
+					continue
+				}
+			}
+			args = append(args, arg)
+		}
+
+		var candidates []string
+		switch {
+		case pendingFlag != nil:
+			if pendingFlag.Values != nil {
+				candidates = pendingFlag.Values()
+			}
+
+		case strings.HasPrefix(token, "-"):
+			if i := strings.IndexByte(token, '='); i >= 0 {
+				if f := findFlag(cur, token[:i]); f != nil && f.Values != nil {
+					for _, v := range f.Values() {
+						candidates = append(candidates, token[:i+1]+v)
+					}
+				}
+				break
+			}
+			if cur == nil {
+				break
+			}
+			for i := range cur.Flags {
+				f := &cur.Flags[i]
+				if f.Name != "" {
+					candidates = append(candidates, "--"+f.Name)
+				}
+				if f.Short != "" {
+					candidates = append(candidates, "-"+f.Short)
+				}
+			}
+
+		default:
+			if len(args) == 0 {
+				for i := range subcommands {
+					candidates = append(candidates, subcommands[i].Name)
+				}
+			}
+			if cur != nil && cur.Args != nil {
+				candidates = append(candidates, cur.Args(args)...)
+			}
+		}
+
+		// Candidates are matched against the entire token, and the completion
+		// is the portion of the candidate starting at the word.
+		var completions []string
+		for _, c := range candidates {
+			if strings.HasPrefix(c, token) {
+				completions = append(completions, c[len(tokenPrefix):])
+			}
+		}
+		return completions
+	}
+}
+
+// findCommand returns the command with the specified name, or nil if there is
+// no such command.
+func findCommand(commands []CommandSpec, name string) *CommandSpec {
+	for i := range commands {
+		if commands[i].Name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// findFlag returns the flag of cmd specified by arg, which is of the form
+// --name, -short, or either followed by "=value". Returns nil if cmd is nil or
+// there is no such flag.
+func findFlag(cmd *CommandSpec, arg string) *FlagSpec {
+	if cmd == nil {
+		return nil
+	}
+	if i := strings.IndexByte(arg, '='); i >= 0 {
+		arg = arg[:i]
+	}
+	for i := range cmd.Flags {
+		f := &cmd.Flags[i]
+		if (f.Name != "" && arg == "--"+f.Name) || (f.Short != "" && arg == "-"+f.Short) {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package prompt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandCompleter(t *testing.T) {
+	formats := func() []string { return []string{"csv", "json", "table"} }
+	completer := CommandCompleter(
+		CommandSpec{
+			Name: `\connect`,
+			Flags: []FlagSpec{
+				{Name: "insecure", Short: "k"},
+				{Name: "port", Short: "p", HasValue: true},
+			},
+			Args: func(args []string) []string {
+				if len(args) == 0 {
+					return []string{"localhost", "remote"}
+				}
+				return nil
+			},
+		},
+		CommandSpec{
+			Name: "show",
+			Subcommands: []CommandSpec{
+				{Name: "tables", Flags: []FlagSpec{{Name: "format", Values: formats}}},
+				{Name: "table-stats"},
+				{Name: "users"},
+			},
+		},
+		CommandSpec{Name: "select"},
+	)
+
+	testCases := []struct {
+		text     string
+		expected []string
+	}{
+		{"s", []string{"show", "select"}},
+		{"sh", []string{"show"}},
+		{`\co`, []string{"connect"}},
+		{`\connect lo`, []string{"localhost"}},
+		{`\connect localhost re`, nil},
+		{`\connect --in`, []string{"insecure"}},
+		{`\connect --port 26257 lo`, []string{"localhost"}},
+		{`\connect -p 26257 --insecure r`, []string{"remote"}},
+		{`\connect -p`, []string{"p"}},
+		{"show t", []string{"tables", "table-stats"}},
+		{"show table-s", []string{"stats"}},
+		{"show tables --f", []string{"format"}},
+		{"show tables --format j", []string{"json"}},
+		{"show tables --format=t", []string{"table"}},
+		{"show users --f", nil},
+		{"show tables json t", nil},
+		{"foo s", nil},
+	}
+	for _, c := range testCases {
+		t.Run(c.text, func(t *testing.T) {
+			text := []rune(c.text)
+			wordEnd := len(text)
+			wordStart := wordEnd
+			for wordStart > 0 && isWord(text[wordStart-1]) {
+				wordStart--
+			}
+			require.Equal(t, c.expected, completer(text, wordStart, wordEnd))
+		})
+	}
+}
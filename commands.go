@@ -0,0 +1,164 @@
+package prompt
+
+import "fmt"
+
+// commandCategory groups related commands in the help overlay and in the
+// output of DumpBindings.
+type commandCategory int
+
+const (
+	categoryMovement commandCategory = iota
+	categoryEditing
+	categoryKill
+	categoryHistory
+	categoryCompletion
+	categoryControl
+	categoryMacro
+)
+
+func (c commandCategory) String() string {
+	switch c {
+	case categoryMovement:
+		return "Movement"
+	case categoryEditing:
+		return "Editing"
+	case categoryKill:
+		return "Killing and yanking"
+	case categoryHistory:
+		return "History"
+	case categoryCompletion:
+		return "Completion"
+	case categoryControl:
+		return "Input control"
+	case categoryMacro:
+		return "Macros"
+	}
+	return "Unknown"
+}
+
+// commandInfo describes a command which may be bound to a key.
+type commandInfo struct {
+	category commandCategory
+	// description is a short description of the command, displayed by the
+	// help overlay.
+	description string
+}
+
+// commandRegistry holds the commands which may be bound to keys. The commands
+// are implemented by the command maps of the components handling them, such
+// as baseCommands and historyCommands.
+var commandRegistry = map[command]commandInfo{
+	cmdBackwardChar:    {categoryMovement, "Move back one character"},
+	cmdBackwardWord:    {categoryMovement, "Move back one word"},
+	cmdBeginningOfLine: {categoryMovement, "Move to the start of the line"},
+	cmdEndOfLine:       {categoryMovement, "Move to the end of the line"},
+	cmdForwardChar:     {categoryMovement, "Move forward one character"},
+	cmdForwardWord:     {categoryMovement, "Move forward one word"},
+	cmdSetMark:         {categoryMovement, "Set the mark at the cursor"},
+
+	cmdAddCursorNextMatch:    {categoryEditing, "Add a cursor at the next occurrence of the word"},
+	cmdBackwardDeleteChar:    {categoryEditing, "Delete the character before the cursor"},
+	cmdDeleteChar:            {categoryEditing, "Delete the character at the cursor"},
+	cmdDeleteHorizontalSpace: {categoryEditing, "Delete the whitespace around the cursor"},
+	cmdEnter:                 {categoryEditing, "Insert a newline"},
+	cmdInsertChar:            {categoryEditing, "Insert the character"},
+	cmdInsertUnicode:         {categoryEditing, "Insert a character by digraph or code point"},
+	cmdOverwriteMode:         {categoryEditing, "Toggle between inserting and overwriting characters"},
+	cmdTransposeChars:        {categoryEditing, "Transpose the characters at the cursor"},
+	cmdTransposeWords:        {categoryEditing, "Transpose the words at the cursor"},
+	cmdUndo:                  {categoryEditing, "Undo the last edit"},
+
+	cmdBackwardKillLine: {categoryKill, "Kill from the start of the line to the cursor"},
+	cmdBackwardKillWord: {categoryKill, "Kill the word before the cursor"},
+	cmdCopyBuffer:       {categoryKill, "Copy the input to the kill ring"},
+	cmdKillLine:         {categoryKill, "Kill from the cursor to the end of the line"},
+	cmdKillRectangle:    {categoryKill, "Kill the rectangle between the mark and the cursor"},
+	cmdKillWord:         {categoryKill, "Kill the word after the cursor"},
+	cmdYank:             {categoryKill, "Insert the most recently killed text"},
+	cmdYankPop:          {categoryKill, "Replace the yanked text with earlier killed text"},
+	cmdYankRectangle:    {categoryKill, "Yank the killed rectangle at the cursor"},
+
+	cmdAbort:                 {categoryHistory, "Abort history search, restoring the input"},
+	cmdExpandHistory:         {categoryHistory, "Expand a collapsed history entry"},
+	cmdForwardSearchHistory:  {categoryHistory, "Search forward through history"},
+	cmdHistoryExpandLine:     {categoryHistory, "Perform history expansion of the input"},
+	cmdNextHistory:           {categoryHistory, "Recall the next history entry"},
+	cmdPatternSearchBackward: {categoryHistory, "Search backward through history by regexp"},
+	cmdPatternSearchForward:  {categoryHistory, "Search forward through history by regexp"},
+	cmdPreviousHistory:       {categoryHistory, "Recall the previous history entry"},
+	cmdReverseSearchHistory:  {categoryHistory, "Search backward through history"},
+	cmdSearchNextOccurrence:  {categoryHistory, "Move to the next match within the search result"},
+	cmdSearchPrevOccurrence:  {categoryHistory, "Move to the previous match within the search result"},
+	cmdSubstrSearchBackward:  {categoryHistory, "Recall the previous entry containing the input"},
+	cmdSubstrSearchForward:   {categoryHistory, "Recall the next entry containing the input"},
+	cmdToggleSearchRegexp:    {categoryHistory, "Toggle regexp history search"},
+
+	cmdComplete:    {categoryCompletion, "Accept or list completions"},
+	cmdCompleteNth: {categoryCompletion, "Accept the Nth displayed completion"},
+
+	cmdCancel:           {categoryControl, "Cancel the input"},
+	cmdClearScreen:      {categoryControl, "Clear the screen"},
+	cmdExitOrDeleteChar: {categoryControl, "Exit if the input is empty, else delete a character"},
+	cmdFinishOrEnter:    {categoryControl, "Accept the input if finished, else insert a newline"},
+	cmdHelp:             {categoryControl, "Display the key bindings"},
+}
+
+// describeCommand returns the description of cmd, which may be a macro.
+func describeCommand(cmd command) commandInfo {
+	if isMacro(cmd) {
+		return commandInfo{categoryMacro, "Type the macro"}
+	}
+	return commandRegistry[cmd]
+}
+
+// lookupCommand returns the command with the specified name, which may be an
+// alias of the command. An unknown name is reported along with the most
+// similar command name, if any, as a likely misspelling.
+func lookupCommand(name string) (command, error) {
+	cmd := command(name)
+	if c, ok := commandAliases[name]; ok {
+		cmd = c
+	}
+	if _, ok := commandRegistry[cmd]; ok {
+		return cmd, nil
+	}
+	var best command
+	bestDist := len(name)/3 + 1
+	for c := range commandRegistry {
+		if d := editDistance(name, string(c)); d < bestDist || (d == bestDist && best != "" && c < best) {
+			best, bestDist = c, d
+		}
+	}
+	if best != "" {
+		return "", fmt.Errorf("unknown command: %s (did you mean %s?)", name, best)
+	}
+	return "", fmt.Errorf("unknown command: %s", name)
+}
+
+// editDistance returns the Levenshtein distance between a and b, the number of
+// single byte insertions, deletions, and substitutions transforming a into b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if d := prev[j] + 1; d < cur[j] {
+				cur[j] = d
+			}
+			if d := cur[j-1] + 1; d < cur[j] {
+				cur[j] = d
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,62 @@
+package prompt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandRegistry(t *testing.T) {
+	// Every implemented command is registered, and every registered command is
+	// implemented.
+	implemented := make(map[command]bool)
+	for _, commands := range []map[command]commandFunc{
+		baseCommands, completionCommands, cursorCommands, helpCommands, historyCommands, killCommands,
+		unicodeCommands, yankCommands,
+	} {
+		for cmd := range commands {
+			implemented[cmd] = true
+			info, ok := commandRegistry[cmd]
+			require.True(t, ok, "command %q is not registered", cmd)
+			require.NotEmpty(t, info.description, "command %q has no description", cmd)
+		}
+	}
+	for cmd := range commandRegistry {
+		require.True(t, implemented[cmd], "command %q is not implemented", cmd)
+	}
+	for alias, cmd := range commandAliases {
+		_, ok := commandRegistry[cmd]
+		require.True(t, ok, "alias %q of unknown command %q", alias, cmd)
+	}
+}
+
+func TestLookupCommand(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected command
+		err      string
+	}{
+		{"kill-line", cmdKillLine, ""},
+		{"unix-line-discard", cmdBackwardKillLine, ""},
+		{"kill-lien", "", "unknown command: kill-lien (did you mean kill-line?)"},
+		{"backword-word", "", "unknown command: backword-word (did you mean backward-word?)"},
+		{"bogus", "", "unknown command: bogus"},
+	}
+	for _, c := range testCases {
+		cmd, err := lookupCommand(c.name)
+		if c.err != "" {
+			require.EqualError(t, err, c.err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, c.expected, cmd)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	require.Equal(t, 0, editDistance("", ""))
+	require.Equal(t, 3, editDistance("", "abc"))
+	require.Equal(t, 1, editDistance("yank", "yanks"))
+	require.Equal(t, 2, editDistance("kill-lien", "kill-line"))
+	require.Equal(t, 3, editDistance("kitten", "sitting"))
+}
@@ -1,31 +1,116 @@
 package prompt
 
-import "strings"
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
 
 var completionCommands = map[command]commandFunc{
 	cmdComplete: func(s *state, key rune) (bool, error) {
 		return s.completer.Accept(s)
 	},
+	cmdCompleteNth: func(s *state, key rune) (bool, error) {
+		// The command is bound to Meta-1 through Meta-9.
+		if n := key &^ (keyAlt | keyCtrl); n >= '1' && n <= '9' {
+			return s.completer.AcceptNth(s, int(n-'1'))
+		}
+		return true, nil
+	},
 }
 
 // CompletionFunc is used to find the completions of the word delineated by
 // [wordStart,wordEnd) within text. The full text is provided so that
 // context-sensitive completion can be performed (e.g. a keyword might only be
 // valid in certain contexts). The list of completions should be returned in
-// priority order.
+// priority order. See ContextCompletionFunc for a variant which also receives
+// the kind of token being completed.
 type CompletionFunc func(text []rune, wordStart, wordEnd int) []string
 
+// Document holds the input text and the cursor position within it.
+type Document struct {
+	// Text is the input text.
+	Text []rune
+	// Cursor is the position of the cursor within Text.
+	Cursor int
+	// Token is the kind of the token containing the word being completed, as
+	// specified by the spans returned by the highlighter (see Span.Kind). This
+	// allows completions to be suppressed within string literals and comments.
+	// It is only set for completion callbacks and rankers.
+	Token TokenKind
+	// Completion describes the completion hint and listing displayed for the
+	// word at the cursor, allowing status decorations to display contextual
+	// key hints. It is only set for render hooks and binding conditions.
+	Completion CompletionState
+}
+
+// CompletionState describes the completions displayed for the word at the
+// cursor. See Document.Completion.
+type CompletionState struct {
+	// Hint is true if a completion hint is displayed after the word.
+	Hint bool
+	// Listed is true if the completions are listed below the input.
+	Listed bool
+	// Candidates is the number of completions of the word, or zero if no
+	// completion hint is displayed.
+	Candidates int
+}
+
+// ContextCompletionFunc is like CompletionFunc, but additionally receives a
+// context and the Document containing the input text and cursor position. The
+// function is invoked on its own goroutine so that input continues to be
+// processed while it runs, and the completions are displayed once it returns.
+// The context is canceled when the next key is processed, the input is
+// accepted, or the Prompt is closed, allowing completers which perform
+// expensive operations (e.g. database queries) to abandon work whose results
+// will not be displayed. Completions returned after the context is canceled
+// are discarded.
+type ContextCompletionFunc func(ctx context.Context, doc Document, wordStart, wordEnd int) []string
+
+// CompletionRanker orders the completions of the word delineated by
+// [wordStart,wordEnd) within doc, returning them in priority order. It is
+// applied to the completions returned by the completion callback and history
+// completion, allowing the order in which completions are presented to be
+// decoupled from their generation (e.g. ranking recently used schema objects
+// first). The ranker may also remove completions. The completions slice may be
+// modified by the ranker.
+type CompletionRanker func(doc Document, wordStart, wordEnd int, completions []string) []string
+
 // completer implements tab completion. Whenever a character is inserted or
 // deleted, a list of completions of the word at the current cursor position is
 // computed and used to display a completion hint in dimmed text. If the hint is
 // accepted the completion is inserted into the document. Any other command
 // (such as cursor movement or the insertion of another character) causes the
 // hint to be removed. If the new command was the insertion of another character
-// a new hint will be displayed.
+// a new hint will be displayed. The hint only displays the first few of
+// multiple completions. If the completions share no text beyond the word being
+// completed, the complete command lists all of them in a grid below the input
+// instead.
 type completer struct {
 	// fn is the completion function to invoke to compute completions of the word at
 	// the cursor position.
 	fn CompletionFunc
+	// ctxFn is used instead of fn if non-nil. It is invoked on its own goroutine,
+	// which acquires mu in order to display the completions and then re-renders
+	// the input using flush. cancel cancels the context passed to the invocation
+	// of ctxFn in progress, if any.
+	ctxFn  ContextCompletionFunc
+	mu     sync.Locker
+	flush  func()
+	cancel context.CancelFunc
+	// history specifies whether completions are also drawn from the history
+	// entries. See WithHistoryCompletion.
+	history HistoryCompletion
+	// ranker orders the completions before they are post-processed. See
+	// WithCompletionRanker.
+	ranker CompletionRanker
+	// dedup, limit, and sort configure the post-processing of the completions.
+	// See WithCompletionDedup, WithCompletionLimit, and WithCompletionSort.
+	dedup bool
+	limit int
+	sort  bool
 	// wordStart and wordEnd are the start and end position of the word being
 	// completed.
 	wordStart int
@@ -38,11 +123,23 @@ type completer struct {
 	// shared is the portion of suffix that is identical across the completions and
 	// which will be accepted when a complete command is invoked.
 	shared int
+	// completions holds the completions of the word being completed. If there
+	// are multiple completions which share no text beyond the word, the
+	// complete command lists them below the input. listed is true while the
+	// listing is displayed.
+	completions []string
+	listed      bool
+	// listRows holds the rows of the listing. If the listing does not fit on
+	// the screen, it is displayed a page of listPageSize rows at a time, and
+	// listPage is the index of the displayed page.
+	listRows     []string
+	listPage     int
+	listPageSize int
 }
 
 // Try performs completion of the word at the current cursor position.
 func (c *completer) Try(s *state) {
-	if c.fn == nil {
+	if c.fn == nil && c.ctxFn == nil && c.history == NoHistoryCompletion {
 		// No completion callback specified.
 		return
 	}
@@ -56,7 +153,70 @@ func (c *completer) Try(s *state) {
 		return
 	}
 
-	completions := c.fn(text, wordStart, wordEnd)
+	// The kind of the token being completed is passed to the callbacks which
+	// receive a Document. The highlighting is brought up to date first so that
+	// it reflects the modification which triggered completion.
+	var token TokenKind
+	if c.ctxFn != nil || c.ranker != nil {
+		s.highlighter.Update(s)
+		token = s.screen.TokenAt(wordStart)
+	}
+
+	if c.ctxFn != nil {
+		if s.inputPending {
+			// The completions would be discarded by the processing of the pending
+			// input.
+			return
+		}
+		c.CancelContext()
+		ctx, cancel := context.WithCancel(context.Background())
+		c.cancel = cancel
+		doc := Document{Text: append([]rune(nil), text...), Cursor: pos, Token: token}
+		go func() {
+			start := time.Now()
+			completions := c.ctxFn(ctx, doc, wordStart, wordEnd)
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if ctx.Err() != nil {
+				// The input changed while the completions were being computed.
+				return
+			}
+			cancel()
+			c.cancel = nil
+			s.metrics.AddCallback(callbackCompleter, start)
+			c.show(s, doc.Text, pos, wordStart, wordEnd, token, completions)
+			c.flush()
+		}()
+		return
+	}
+
+	var completions []string
+	if c.fn != nil {
+		start := time.Now()
+		completions = c.fn(text, wordStart, wordEnd)
+		s.metrics.AddCallback(callbackCompleter, start)
+	}
+	c.show(s, text, pos, wordStart, wordEnd, token, completions)
+}
+
+// show displays a completion hint for the completions of the word delineated
+// by [wordStart,wordEnd) within text, adding the history completions and
+// ordering and filtering them first.
+func (c *completer) show(
+	s *state, text []rune, pos, wordStart, wordEnd int, token TokenKind, completions []string,
+) {
+	if c.history != NoHistoryCompletion {
+		// History completions have a lower priority than those of the completion
+		// callback. The completions are owned by the completion callback, so they
+		// are capped to be copied rather than appended to in place.
+		completions = appendUniqueCompletions(completions[:len(completions):len(completions)],
+			s.history.Complete(c.history, text, wordStart, wordEnd, s.screen.isWord), nil)
+	}
+	if c.ranker != nil && len(completions) > 0 {
+		doc := Document{Text: append([]rune(nil), text...), Cursor: pos, Token: token}
+		completions = c.ranker(doc, wordStart, wordEnd, append([]string(nil), completions...))
+	}
+	completions = c.postprocess(completions)
 	if len(completions) == 0 {
 		return
 	}
@@ -99,14 +259,15 @@ func (c *completer) Try(s *state) {
 	// completed modulo capitalization.
 	c.prefix = []rune(completions[0][:wordEnd-wordStart])
 	c.suffix = []rune(suffix.String())
+	c.completions = completions
 	c.wordStart = wordStart
 	c.wordEnd = wordEnd
 	c.shared = shared - (wordEnd - wordStart)
 
 	s.screen.MoveTo(c.wordEnd)
 	// TODO(peter): attrDim doesn't seem to be supported on Warp. Perhaps it isn't
-	// supported on other terminals.
-	s.screen.SetAttrs(attrDim)
+	// supported on other terminals. The style can be changed with WithTheme.
+	s.screen.SetAttrs(string(s.theme.CompletionHint))
 	s.screen.Insert(c.suffix...)
 	s.screen.SetAttrs("")
 	s.screen.MoveTo(pos)
@@ -114,8 +275,14 @@ func (c *completer) Try(s *state) {
 
 // Accept accepts the currently displayed completion hint. After accepting the
 // completion, or if there was no completion hint currently displayed, another
-// attempt is made to perform completion at the cursor position.
+// attempt is made to perform completion at the cursor position. If there are
+// multiple completions and none of the hint can be accepted, the completions
+// are listed below the input instead.
 func (c *completer) Accept(s *state) (ok bool, err error) {
+	if c.suffix != nil && c.shared == 0 && len(c.completions) > 1 {
+		c.list(s)
+		return true, nil
+	}
 	if c.suffix != nil {
 		s.screen.MoveTo(c.wordStart)
 		s.screen.EraseTo(c.wordEnd + len(c.suffix))
@@ -128,6 +295,27 @@ func (c *completer) Accept(s *state) (ok bool, err error) {
 	return true, nil
 }
 
+// AcceptNth accepts the nth (0-indexed) of the completions displayed by the
+// completion hint or listing, replacing the word being completed. After
+// accepting the completion another attempt is made to perform completion at
+// the cursor position. Nothing is done if there are fewer than n+1
+// completions.
+func (c *completer) AcceptNth(s *state, n int) (ok bool, err error) {
+	if c.suffix == nil || n >= len(c.completions) {
+		return true, nil
+	}
+	completion := c.completions[n]
+	s.screen.MoveTo(c.wordStart)
+	s.screen.EraseTo(c.wordEnd + len(c.suffix))
+	s.screen.Insert([]rune(completion)...)
+	c.prefix = nil
+	c.suffix = nil
+	c.completions = nil
+	c.closeList(s)
+	c.Try(s)
+	return true, nil
+}
+
 // Cancel cancels the current completion, restoring the display to the
 // pre-completion hint state.
 func (c *completer) Cancel(s *state) {
@@ -140,14 +328,216 @@ func (c *completer) Cancel(s *state) {
 	s.screen.MoveTo(pos)
 	c.prefix = nil
 	c.suffix = nil
+	c.completions = nil
+	c.closeList(s)
+}
+
+// closeList removes the listing of the completions.
+func (c *completer) closeList(s *state) {
+	if c.listed {
+		c.listed = false
+		c.listRows = nil
+		s.annotations.Set(&s.screen, annotationCompletions, Annotation{})
+	}
+}
+
+// list displays the completions in a grid below the input. If the grid does
+// not fit on the screen below the input, it is paged: the first page is
+// displayed followed by a "--More--" prompt, Space displays the next page, and
+// q closes the listing.
+func (c *completer) list(s *state) {
+	if c.listed {
+		return
+	}
+	c.listed = true
+	c.listRows = formatGrid(c.completions, s.screen.width, s.screen.runeWidth)
+	c.listPage = 0
+	c.listPageSize = len(c.listRows)
+	if avail := s.screen.height - s.screen.InputRows(); s.screen.height > 0 && len(c.listRows) > avail {
+		// Leave room for the "--More--" prompt.
+		c.listPageSize = avail - 1
+		if c.listPageSize < 1 {
+			c.listPageSize = 1
+		}
+	}
+	c.showListPage(s)
+}
+
+// Resize lays out the listing of the completions, if it is displayed, for the
+// size of the screen after it has been resized. The first page of the listing
+// is displayed.
+func (c *completer) Resize(s *state) {
+	if !c.listed {
+		return
+	}
+	c.listed = false
+	c.list(s)
+}
+
+// showListPage displays the current page of the listing.
+func (c *completer) showListPage(s *state) {
+	start := c.listPage * c.listPageSize
+	end := start + c.listPageSize
+	if end > len(c.listRows) {
+		end = len(c.listRows)
+	}
+	text := "\n" + strings.Join(c.listRows[start:end], "\n")
+	if c.hasMorePages() {
+		text += "\n--More--"
+	}
+	s.annotations.Set(&s.screen, annotationCompletions, Annotation{
+		Text:     text,
+		Priority: AnnotationPriorityCompletions,
+	})
+}
+
+// hasMorePages returns true if the listing is displayed and there are pages
+// following the displayed page.
+func (c *completer) hasMorePages() bool {
+	return c.listed && (c.listPage+1)*c.listPageSize < len(c.listRows)
+}
+
+// completionGridGap is the number of spaces between the columns of the
+// completion grid.
+const completionGridGap = 2
+
+// formatGrid formats items into the rows of a grid of aligned columns which
+// fit within width, like the completion listings of bash. The items are
+// ordered down the columns and then across.
+func formatGrid(items []string, width int, runeWidth func(r rune) int) []string {
+	widths := make([]int, len(items))
+	colWidth := 0
+	for i, item := range items {
+		for _, r := range item {
+			widths[i] += runeWidth(r)
+		}
+		if colWidth < widths[i] {
+			colWidth = widths[i]
+		}
+	}
+	// The last column is not padded. Rows are kept narrower than the width to
+	// avoid wrapping at the right edge of the terminal.
+	cols := (width - 1 + completionGridGap) / (colWidth + completionGridGap)
+	if cols < 1 {
+		cols = 1
+	}
+	rows := make([]string, (len(items)+cols-1)/cols)
+
+	var buf strings.Builder
+	for row := range rows {
+		buf.Reset()
+		for col := 0; col < cols; col++ {
+			i := col*len(rows) + row
+			if i >= len(items) {
+				break
+			}
+			if col > 0 {
+				buf.WriteString(strings.Repeat(" ", colWidth-widths[i-len(rows)]+completionGridGap))
+			}
+			buf.WriteString(items[i])
+		}
+		rows[row] = buf.String()
+	}
+	return rows
+}
+
+// State returns the state of the displayed completions.
+func (c *completer) State() CompletionState {
+	return CompletionState{
+		Hint:       len(c.suffix) > 0,
+		Listed:     c.listed,
+		Candidates: len(c.completions),
+	}
+}
+
+// CancelContext cancels the context passed to the invocation of the context
+// completion function in progress, discarding its completions.
+func (c *completer) CancelContext() {
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
 }
 
 // Dispatch processes the specified command, canceling the current completion if
 // the command is not a completion command.
 func (c *completer) Dispatch(s *state, cmd command, key rune) (ok bool, err error) {
+	c.CancelContext()
+	if c.hasMorePages() && cmd == cmdInsertChar {
+		switch key {
+		case ' ':
+			c.listPage++
+			c.showListPage(s)
+			return true, nil
+		case 'q':
+			c.closeList(s)
+			return true, nil
+		}
+	}
 	if fn, ok := completionCommands[cmd]; ok {
 		return fn(s, key)
 	}
 	c.Cancel(s)
 	return false, nil
 }
+
+// MergeCompleters returns a CompletionFunc which combines the completions of
+// the specified completion functions. The completions are ordered by the
+// priority of the completion function which produced them (earlier functions
+// have higher priority), preserving the order returned by each function.
+// Duplicate completions are removed, retaining the highest priority
+// occurrence.
+func MergeCompleters(completers ...CompletionFunc) CompletionFunc {
+	return func(text []rune, wordStart, wordEnd int) []string {
+		var merged []string
+		seen := make(map[string]struct{})
+		for _, fn := range completers {
+			merged = appendUniqueCompletions(merged, fn(text, wordStart, wordEnd), seen)
+		}
+		return merged
+	}
+}
+
+// postprocess de-duplicates, limits, and sorts the completions as configured.
+// Duplicates are removed keeping the first, highest priority, occurrence, and
+// the limit retains the highest priority completions, so sorting only affects
+// the order in which the retained completions are displayed. The completions
+// are copied before being modified as they may be owned by the completion
+// callback.
+func (c *completer) postprocess(completions []string) []string {
+	if !c.dedup && !c.sort && (c.limit <= 0 || len(completions) <= c.limit) {
+		return completions
+	}
+	if c.dedup {
+		completions = appendUniqueCompletions(nil, completions, map[string]struct{}{})
+	} else {
+		completions = append([]string(nil), completions...)
+	}
+	if c.limit > 0 && len(completions) > c.limit {
+		completions = completions[:c.limit]
+	}
+	if c.sort {
+		sort.Strings(completions)
+	}
+	return completions
+}
+
+// appendUniqueCompletions appends the completions in src which are not already
+// present in dst to dst. If seen is non-nil it holds the completions in dst and
+// is updated with the appended completions.
+func appendUniqueCompletions(dst, src []string, seen map[string]struct{}) []string {
+	if seen == nil {
+		seen = make(map[string]struct{}, len(dst))
+		for _, c := range dst {
+			seen[c] = struct{}{}
+		}
+	}
+	for _, c := range src {
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		seen[c] = struct{}{}
+		dst = append(dst, c)
+	}
+	return dst
+}
@@ -0,0 +1,254 @@
+package prompt
+
+import (
+	"context"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/stretchr/testify/require"
+)
+
+// waitCompletion waits for the context completion function invoked for the
+// most recent input to return and its completions to be displayed.
+func waitCompletion(t *testing.T, p *Prompt) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.mu.state.completer.cancel == nil
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestCompleterContext(t *testing.T) {
+	type call struct {
+		ctx  context.Context
+		doc  Document
+		word string
+	}
+	calls := make(chan call, 10)
+	completer := func(ctx context.Context, doc Document, wordStart, wordEnd int) []string {
+		word := string(doc.Text[wordStart:wordEnd])
+		calls <- call{ctx, doc, word}
+		if word == "a" {
+			// Block until the next key cancels the completion.
+			<-ctx.Done()
+		}
+		return []string{word + "xyz"}
+	}
+
+	p := newTestPrompt(t, WithCompleterContext(completer))
+	p.mu.state.active = true
+
+	text := func() string {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return string(p.mu.state.screen.Text())
+	}
+
+	// The completer runs while further input is processed.
+	feedInput(t, p, "a")
+	c := <-calls
+	require.Equal(t, Document{Text: []rune("a"), Cursor: 1}, c.doc)
+	require.NoError(t, c.ctx.Err())
+
+	// The next key cancels the context of the previous completion, whose
+	// completions are discarded.
+	feedInput(t, p, "b")
+	require.Error(t, c.ctx.Err())
+	c = <-calls
+	require.Equal(t, "ab", c.word)
+	waitCompletion(t, p)
+	require.Equal(t, "abxyz", text())
+
+	// Completion is skipped while there is pending input.
+	feedInput(t, p, "cd")
+	c = <-calls
+	require.Equal(t, "abcd", c.word)
+	waitCompletion(t, p)
+	require.Equal(t, "abcdxyz", text())
+	require.Len(t, calls, 0)
+
+	// Closing the prompt cancels the context of a completion in progress.
+	feedInput(t, p, "\x7f\x7f\x7f\x7fa")
+	c = <-calls
+	require.Equal(t, "a", c.word)
+	require.NoError(t, p.Close())
+	require.Error(t, c.ctx.Err())
+}
+
+func TestCompleterTokens(t *testing.T) {
+	// The highlighter marks string literals and comments, re-highlighting the
+	// entire text.
+	highlight := func(text []rune, dirtyStart, dirtyEnd int) ([]Span, int, int) {
+		var spans []Span
+		for i := 0; i < len(text); i++ {
+			switch {
+			case text[i] == '\'':
+				j := i + 1
+				for j < len(text) && text[j] != '\'' {
+					j++
+				}
+				if j < len(text) {
+					j++
+				}
+				spans = append(spans, Span{Start: i, End: j, Kind: TokenString})
+				i = j - 1
+			case text[i] == '-' && i+1 < len(text) && text[i+1] == '-':
+				spans = append(spans, Span{Start: i, End: len(text), Kind: TokenComment})
+				i = len(text)
+			}
+		}
+		return spans, 0, len(text)
+	}
+
+	// Keywords are only completed outside of string literals and comments.
+	var tokens []TokenKind
+	completer := func(ctx context.Context, doc Document, wordStart, wordEnd int) []string {
+		tokens = append(tokens, doc.Token)
+		if doc.Token == TokenOther && strings.HasPrefix("select", string(doc.Text[wordStart:wordEnd])) {
+			return []string{"select"}
+		}
+		return nil
+	}
+
+	for _, c := range []struct {
+		input    string
+		token    TokenKind
+		expected string
+	}{
+		{"s", TokenOther, "select"},
+		{"'s", TokenString, "'s"},
+		{"'a' s", TokenOther, "'a' select"},
+		{"'a s", TokenString, "'a s"},
+		{"-- s", TokenComment, "-- s"},
+		{"x -- 'a' s", TokenComment, "x -- 'a' s"},
+	} {
+		t.Run(c.input, func(t *testing.T) {
+			p := newTestPrompt(t, WithHighlighter(highlight), WithCompleterContext(completer))
+			tokens = nil
+			for _, r := range c.input {
+				feedInput(t, p, string(r))
+				waitCompletion(t, p)
+			}
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			require.Equal(t, c.token, tokens[len(tokens)-1])
+			require.Equal(t, c.expected, string(p.mu.state.screen.Text()))
+		})
+	}
+}
+
+func TestMergeCompleters(t *testing.T) {
+	static := func(completions ...string) CompletionFunc {
+		return func(text []rune, wordStart, wordEnd int) []string {
+			return completions
+		}
+	}
+	var words []string
+	word := func(text []rune, wordStart, wordEnd int) []string {
+		words = append(words, string(text[wordStart:wordEnd]))
+		return nil
+	}
+
+	merged := MergeCompleters(
+		static("select", "set"),
+		word,
+		static("session", "select", "set"),
+		static(),
+		static("sequence", "session"),
+	)
+	require.Equal(t,
+		[]string{"select", "set", "session", "sequence"},
+		merged([]rune("x se"), 2, 4))
+	require.Equal(t, []string{"se"}, words)
+
+	require.Nil(t, MergeCompleters()([]rune("se"), 0, 2))
+}
+
+func TestCompleterPostprocess(t *testing.T) {
+	completions := []string{"set", "select", "set", "session", "select", "sequence"}
+	orig := append([]string(nil), completions...)
+
+	testCases := []struct {
+		dedup    bool
+		limit    int
+		sort     bool
+		expected []string
+	}{
+		{false, 0, false, completions},
+		{true, 0, false, []string{"set", "select", "session", "sequence"}},
+		{false, 3, false, []string{"set", "select", "set"}},
+		{false, 0, true, []string{"select", "select", "sequence", "session", "set", "set"}},
+		{true, 3, false, []string{"set", "select", "session"}},
+		{true, 3, true, []string{"select", "session", "set"}},
+		{true, 10, true, []string{"select", "sequence", "session", "set"}},
+	}
+	for _, c := range testCases {
+		cmp := completer{dedup: c.dedup, limit: c.limit, sort: c.sort}
+		require.Equal(t, c.expected, cmp.postprocess(completions),
+			"dedup=%t limit=%d sort=%t", c.dedup, c.limit, c.sort)
+		// The completions returned by the callback are not modified.
+		require.Equal(t, orig, completions)
+	}
+}
+
+func TestCompletionRanker(t *testing.T) {
+	tables := []string{"orders", "order_items", "organizations"}
+	completer := func(text []rune, wordStart, wordEnd int) []string {
+		return tables
+	}
+	// Rank recently queried tables first, otherwise preserving the order of
+	// the completions.
+	recent := map[string]int{"organizations": 2, "order_items": 1}
+	var docs []Document
+	ranker := func(doc Document, wordStart, wordEnd int, completions []string) []string {
+		docs = append(docs, doc)
+		sort.SliceStable(completions, func(i, j int) bool {
+			return recent[completions[i]] > recent[completions[j]]
+		})
+		return completions
+	}
+
+	p, err := New(WithOutput(ioutil.Discard), WithSize(80, 1),
+		WithCompleter(completer), WithCompletionRanker(ranker), WithCompletionLimit(2))
+	require.NoError(t, err)
+	p.mu.state.screen.Reset([]rune("> "))
+
+	p.mu.Lock()
+	p.parser.Feed([]byte("from or"))
+	_, err = p.processInputLocked()
+	p.mu.Unlock()
+	require.NoError(t, err)
+
+	// The limit retains the highest ranked completions.
+	require.Equal(t, []string{"organizations", "order_items"}, p.mu.state.completer.completions)
+	require.Equal(t, Document{Text: []rune("from or"), Cursor: 7}, docs[len(docs)-1])
+	// The ranker does not modify the completions returned by the callback.
+	require.Equal(t, []string{"orders", "order_items", "organizations"}, tables)
+}
+
+func TestFormatGrid(t *testing.T) {
+	items := []string{"a", "bb", "ccc", "dddd", "e", "f", "g"}
+	testCases := []struct {
+		width    int
+		expected string
+	}{
+		{0, "a|bb|ccc|dddd|e|f|g"},
+		{7, "a|bb|ccc|dddd|e|f|g"},
+		{12, "a     e|bb    f|ccc   g|dddd"},
+		{18, "a     dddd  g|bb    e|ccc   f"},
+		{80, "a     bb    ccc   dddd  e     f     g"},
+	}
+	for _, c := range testCases {
+		rows := formatGrid(items, c.width, runewidth.RuneWidth)
+		require.Equal(t, c.expected, strings.Join(rows, "|"), "width=%d", c.width)
+	}
+
+	// Wide characters occupy two columns.
+	rows := formatGrid([]string{"日本", "a", "b", "c"}, 12, runewidth.RuneWidth)
+	require.Equal(t, []string{"日本  b", "a     c"}, rows)
+}
@@ -0,0 +1,476 @@
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config holds user-level settings read from a config file by LoadConfig or
+// ReadConfig. It allows the end users of an application to customize the key
+// bindings, theme, history, and terminal handling without the application
+// exposing every option itself. Settings which are unset (nil or empty) leave
+// the application's configuration unchanged.
+type Config struct {
+	// Bindings are key bindings using the syntax of WithBindings, one per
+	// element (e.g. "bind Control-o enter").
+	Bindings []string
+	// EditMode is the editing mode. Only "emacs" is currently supported.
+	EditMode string
+	History  HistoryConfig
+	Theme    Theme
+	Terminal TerminalConfig
+
+	// source identifies the config in binding conflicts.
+	source string
+}
+
+// HistoryConfig holds the history settings of a Config.
+type HistoryConfig struct {
+	// File and Size correspond to the arguments of WithHistory. A leading "~/"
+	// in File is expanded to the user's home directory. Size is only used if
+	// non-zero.
+	File string
+	Size int
+	// SubstringSearch, Sync, and Collapse correspond to the
+	// WithHistorySubstringSearch, WithHistorySync, and WithCollapsedHistory
+	// options.
+	SubstringSearch *bool
+	Sync            *bool
+	Collapse        *bool
+	// Completion corresponds to the WithHistoryCompletion option.
+	Completion *HistoryCompletion
+}
+
+// TerminalConfig holds the terminal settings of a Config, which correspond to
+// the WithEightBitMeta, WithTerminfo, and WithInsertDeleteChars options.
+type TerminalConfig struct {
+	EightBitMeta      *bool
+	Terminfo          *bool
+	InsertDeleteChars *bool
+}
+
+// LoadConfig reads the config file at path. See ReadConfig for the format. A
+// missing config file is not an error and results in an empty Config, as
+// config files are optional.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	c, err := ReadConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	c.source = path
+	return c, nil
+}
+
+// ReadConfig reads a config file in a subset of TOML: "key = value" settings
+// grouped into "[section]" tables, where a value is a string (double quoted
+// with backslash escapes, or single quoted and literal), an integer, a
+// boolean, or an array of strings, which may span multiple lines. Comments
+// begin with '#'. For example:
+//
+//	bindings = [
+//	  "bind Control-o enter",
+//	  "bind Meta-p history-substring-search-backward",
+//	  'bind Control-j "SELECT * FROM "',
+//	]
+//	edit-mode = "emacs"
+//
+//	[history]
+//	file = "~/.myapp_history"
+//	size = 1000
+//	substring-search = true
+//	sync = true
+//	collapse = false
+//	completion = "words"   # none, words, or lines
+//
+//	[theme]
+//	completion-hint = "dark-gray"
+//	search-match = "bold on-yellow"
+//	search-occurrence = "underline"
+//	diagnostic-error = "underline red"
+//	diagnostic-warning = "underline yellow"
+//	diagnostic-info = "underline cyan"
+//	secondary-cursor = "reverse"
+//
+//	[terminal]
+//	eight-bit-meta = false
+//	terminfo = true
+//	insert-delete-chars = true
+//
+// Styles are parsed by ParseStyle. Unknown settings are an error so that typos
+// are reported rather than silently ignored.
+func ReadConfig(r io.Reader) (*Config, error) {
+	c := &Config{source: "config"}
+	seen := make(map[string]bool)
+	var section string
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		startLine := lineNum
+		line := strings.TrimSpace(stripConfigComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") && !strings.Contains(line, "=") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			switch section {
+			case "history", "theme", "terminal":
+			default:
+				return nil, fmt.Errorf("line %d: unknown section [%s]", startLine, section)
+			}
+			continue
+		}
+
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value: %s", startLine, line)
+		}
+		key := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+		// An array may span multiple lines.
+		for strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") && scanner.Scan() {
+			lineNum++
+			value += " " + strings.TrimSpace(stripConfigComment(scanner.Text()))
+		}
+
+		name := key
+		if section != "" {
+			name = section + "." + key
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("line %d: duplicate setting %s", startLine, name)
+		}
+		seen[name] = true
+
+		v, err := parseConfigValue(value)
+		if err == nil {
+			err = c.set(name, v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", startLine, name, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// set sets the setting name to the value v.
+func (c *Config) set(name string, v interface{}) error {
+	var err error
+	switch name {
+	case "bindings":
+		c.Bindings, err = configStrings(v)
+	case "edit-mode":
+		if c.EditMode, err = configString(v); err == nil && c.EditMode != "emacs" {
+			err = fmt.Errorf("unsupported edit mode %q", c.EditMode)
+		}
+
+	case "history.file":
+		c.History.File, err = configString(v)
+	case "history.size":
+		c.History.Size, err = configInt(v)
+	case "history.substring-search":
+		c.History.SubstringSearch, err = configBool(v)
+	case "history.sync":
+		c.History.Sync, err = configBool(v)
+	case "history.collapse":
+		c.History.Collapse, err = configBool(v)
+	case "history.completion":
+		var s string
+		if s, err = configString(v); err != nil {
+			break
+		}
+		var mode HistoryCompletion
+		switch s {
+		case "none":
+			mode = NoHistoryCompletion
+		case "words":
+			mode = HistoryCompletionWords
+		case "lines":
+			mode = HistoryCompletionLines
+		default:
+			return fmt.Errorf("unknown history completion %q", s)
+		}
+		c.History.Completion = &mode
+
+	case "theme.completion-hint":
+		c.Theme.CompletionHint, err = configStyle(v)
+	case "theme.search-match":
+		c.Theme.SearchMatch, err = configStyle(v)
+	case "theme.search-occurrence":
+		c.Theme.SearchOccurrence, err = configStyle(v)
+	case "theme.diagnostic-error":
+		c.Theme.DiagnosticError, err = configStyle(v)
+	case "theme.diagnostic-warning":
+		c.Theme.DiagnosticWarning, err = configStyle(v)
+	case "theme.diagnostic-info":
+		c.Theme.DiagnosticInfo, err = configStyle(v)
+	case "theme.secondary-cursor":
+		c.Theme.SecondaryCursor, err = configStyle(v)
+
+	case "terminal.eight-bit-meta":
+		c.Terminal.EightBitMeta, err = configBool(v)
+	case "terminal.terminfo":
+		c.Terminal.Terminfo, err = configBool(v)
+	case "terminal.insert-delete-chars":
+		c.Terminal.InsertDeleteChars, err = configBool(v)
+
+	default:
+		return fmt.Errorf("unknown setting")
+	}
+	return err
+}
+
+// Options returns the options corresponding to the settings in the config.
+// The options should be passed to New after the application's own options so
+// that the user's settings take precedence. Keys bound by the config are
+// reported by Prompt.BindingConflicts with the config file as the source.
+func (c *Config) Options() []Option {
+	var options []Option
+	if len(c.Bindings) > 0 {
+		options = append(options, configBindingsOption{
+			source:   c.source,
+			bindings: strings.Join(c.Bindings, "\n"),
+		})
+	}
+	if c.History.File != "" || c.History.Size != 0 {
+		options = append(options, configHistoryOption{
+			path:    expandHome(c.History.File),
+			maxSize: c.History.Size,
+		})
+	}
+	if v := c.History.SubstringSearch; v != nil {
+		options = append(options, WithHistorySubstringSearch(*v))
+	}
+	if v := c.History.Sync; v != nil {
+		options = append(options, WithHistorySync(*v))
+	}
+	if v := c.History.Collapse; v != nil {
+		options = append(options, WithCollapsedHistory(*v))
+	}
+	if v := c.History.Completion; v != nil {
+		options = append(options, WithHistoryCompletion(*v))
+	}
+	if c.Theme != (Theme{}) {
+		options = append(options, WithTheme(c.Theme))
+	}
+	if v := c.Terminal.EightBitMeta; v != nil {
+		options = append(options, WithEightBitMeta(*v))
+	}
+	if v := c.Terminal.Terminfo; v != nil {
+		options = append(options, WithTerminfo(*v))
+	}
+	if v := c.Terminal.InsertDeleteChars; v != nil {
+		options = append(options, WithInsertDeleteChars(*v))
+	}
+	return options
+}
+
+type configBindingsOption struct {
+	source   string
+	bindings string
+}
+
+func (o configBindingsOption) apply(p *Prompt) {
+	p.extraBindings = append(p.extraBindings, extraBindings{o.source, o.bindings})
+}
+
+// configHistoryOption configures the history file and size, leaving either
+// unchanged if it is unset.
+type configHistoryOption struct {
+	path    string
+	maxSize int
+}
+
+func (o configHistoryOption) apply(p *Prompt) {
+	if o.path != "" {
+		p.mu.state.history.path = o.path
+	}
+	if o.maxSize != 0 {
+		p.mu.state.history.maxSize = o.maxSize
+	}
+}
+
+// expandHome expands a leading "~/" in path to the user's home directory.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// stripConfigComment removes a trailing comment from line. A '#' within a
+// quoted string does not begin a comment.
+func stripConfigComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch b := line[i]; {
+		case quote != 0:
+			if b == '\\' && quote == '"' {
+				i++
+			} else if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseConfigValue parses a setting value, returning a string, int64, bool, or
+// []string.
+func parseConfigValue(s string) (interface{}, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("missing value")
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case s[0] == '"' || s[0] == '\'':
+		v, rest, err := parseConfigString(s)
+		if err != nil {
+			return nil, err
+		}
+		if rest != "" {
+			return nil, fmt.Errorf("unexpected text after string: %s", rest)
+		}
+		return v, nil
+	case s[0] == '[':
+		var elems []string
+		rest := strings.TrimSpace(s[1:])
+		for {
+			if strings.HasPrefix(rest, "]") {
+				rest = rest[1:]
+				break
+			}
+			if rest == "" || (rest[0] != '"' && rest[0] != '\'') {
+				return nil, fmt.Errorf("arrays may only contain strings")
+			}
+			v, r, err := parseConfigString(rest)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, v)
+			rest = strings.TrimSpace(r)
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "]") {
+				return nil, fmt.Errorf("expected ',' or ']' in array")
+			}
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("unexpected text after array: %s", rest)
+		}
+		return elems, nil
+	default:
+		n, err := strconv.ParseInt(strings.ReplaceAll(s, "_", ""), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value: %s", s)
+		}
+		return n, nil
+	}
+}
+
+// parseConfigString parses the quoted string at the start of s, returning the
+// string and the remainder of s.
+func parseConfigString(s string) (string, string, error) {
+	quote := s[0]
+	var buf strings.Builder
+	for i := 1; i < len(s); i++ {
+		b := s[i]
+		switch {
+		case b == quote:
+			return buf.String(), strings.TrimSpace(s[i+1:]), nil
+		case b == '\\' && quote == '"':
+			if i+1 == len(s) {
+				return "", "", fmt.Errorf("unterminated string")
+			}
+			i++
+			switch s[i] {
+			case '"', '\\':
+				buf.WriteByte(s[i])
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'e':
+				buf.WriteByte('\x1b')
+			case 'u':
+				if i+5 > len(s) {
+					return "", "", fmt.Errorf("invalid escape: %s", s[i-1:])
+				}
+				r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", "", fmt.Errorf("invalid escape: %s", s[i-1:i+5])
+				}
+				buf.WriteRune(rune(r))
+				i += 4
+			default:
+				return "", "", fmt.Errorf("invalid escape: \\%c", s[i])
+			}
+		default:
+			buf.WriteByte(b)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
+
+func configString(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("expected a string")
+}
+
+func configStrings(v interface{}) ([]string, error) {
+	if s, ok := v.([]string); ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("expected an array of strings")
+}
+
+func configInt(v interface{}) (int, error) {
+	if n, ok := v.(int64); ok {
+		return int(n), nil
+	}
+	return 0, fmt.Errorf("expected an integer")
+}
+
+func configBool(v interface{}) (*bool, error) {
+	if b, ok := v.(bool); ok {
+		return &b, nil
+	}
+	return nil, fmt.Errorf("expected a boolean")
+}
+
+func configStyle(v interface{}) (Style, error) {
+	s, err := configString(v)
+	if err != nil {
+		return "", err
+	}
+	return ParseStyle(s)
+}
@@ -0,0 +1,189 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfig(t *testing.T) {
+	const data = `
+# Key bindings.
+bindings = [
+  "bind Control-o enter",   # insert a newline
+  'bind Meta-p history-substring-search-backward',
+]
+edit-mode = "emacs"
+
+[history]
+file = "/tmp/history # not a comment"
+size = 1_000
+substring-search = true
+sync = false
+completion = "words"
+
+[theme]
+completion-hint = "dark-gray"
+search-match = "bold on-yellow"
+search-occurrence = "underline"
+diagnostic-error = "underline red"
+
+[terminal]
+terminfo = true
+`
+	c, err := ReadConfig(strings.NewReader(data))
+	require.NoError(t, err)
+
+	yes, no := true, false
+	words := HistoryCompletionWords
+	require.Equal(t, &Config{
+		Bindings: []string{
+			"bind Control-o enter",
+			"bind Meta-p history-substring-search-backward",
+		},
+		EditMode: "emacs",
+		History: HistoryConfig{
+			File:            "/tmp/history # not a comment",
+			Size:            1000,
+			SubstringSearch: &yes,
+			Sync:            &no,
+			Completion:      &words,
+		},
+		Theme: Theme{
+			CompletionHint:   "\x1b[90m",
+			SearchMatch:      "\x1b[1;103m",
+			SearchOccurrence: "\x1b[4m",
+			DiagnosticError:  "\x1b[4;91m",
+		},
+		Terminal: TerminalConfig{
+			Terminfo: &yes,
+		},
+		source: "config",
+	}, c)
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	testCases := []struct {
+		data string
+		err  string
+	}{
+		{"[colors]", "line 1: unknown section [colors]"},
+		{"bindings", "line 1: expected key = value: bindings"},
+		{"\nfoo = 1", "line 2: foo: unknown setting"},
+		{"[history]\nsize = 1\nsize = 2", "line 3: duplicate setting history.size"},
+		{"[history]\nsize = big", "line 2: history.size: invalid value: big"},
+		{"[history]\nsize = true", "line 2: history.size: expected an integer"},
+		{"[history]\nsync = 1", "line 2: history.sync: expected a boolean"},
+		{"[history]\nfile = \"abc", "line 2: history.file: unterminated string"},
+		{"[history]\nfile = \"a\\qb\"", `line 2: history.file: invalid escape: \q`},
+		{"[history]\nfile = \"a\" b", "line 2: history.file: unexpected text after string: b"},
+		{"[history]\ncompletion = \"all\"", `line 2: history.completion: unknown history completion "all"`},
+		{"edit-mode = \"vi\"", `line 1: edit-mode: unsupported edit mode "vi"`},
+		{"bindings = [1]", "line 1: bindings: arrays may only contain strings"},
+		{"bindings = [\"a\" \"b\"]", "line 1: bindings: expected ',' or ']' in array"},
+		{"bindings = [\n\"a\",\n", "line 1: bindings: arrays may only contain strings"},
+		{"bindings = \"a\"", "line 1: bindings: expected an array of strings"},
+		{"[theme]\nsearch-match = \"blinking\"", `line 2: theme.search-match: invalid style: "blinking"`},
+		{"edit-mode =", "line 1: edit-mode: missing value"},
+	}
+	for _, c := range testCases {
+		t.Run(c.data, func(t *testing.T) {
+			_, err := ReadConfig(strings.NewReader(c.data))
+			require.EqualError(t, err, c.err)
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	// A missing config file is not an error.
+	c, err := LoadConfig(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	require.Empty(t, c.Options())
+
+	path := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(path, []byte(`
+bindings = ["bind Control-a end-of-line"]
+
+[history]
+file = "~/history"
+collapse = true
+
+[theme]
+completion-hint = "underline"
+`), 0644))
+	c, err = LoadConfig(path)
+	require.NoError(t, err)
+
+	// The config overrides the application's options.
+	options := []Option{
+		WithInteractive(true),
+		WithHistory("", 100),
+		WithCollapsedHistory(false),
+	}
+	p, err := New(append(options, c.Options()...)...)
+	require.NoError(t, err)
+
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(home, "history"), p.mu.state.history.path)
+	require.Equal(t, 100, p.mu.state.history.maxSize)
+	require.True(t, p.mu.state.history.collapse)
+	require.Equal(t, Theme{
+		CompletionHint:    "\x1b[4m",
+		SearchMatch:       attrReverse,
+		SearchOccurrence:  attrUnderline,
+		DiagnosticError:   defaultTheme.DiagnosticError,
+		DiagnosticWarning: defaultTheme.DiagnosticWarning,
+		DiagnosticInfo:    defaultTheme.DiagnosticInfo,
+		SecondaryCursor:   attrReverse,
+	}, p.mu.state.theme)
+	require.Equal(t, command(cmdEndOfLine), p.bindings.commands[keyCtrlA])
+	require.Equal(t, []BindingConflict{{
+		Key:         "Control-a",
+		Command:     cmdEndOfLine,
+		Source:      path,
+		Line:        1,
+		PrevCommand: cmdBeginningOfLine,
+		PrevSource:  "default",
+		PrevLine:    p.bindings.conflicts[0].PrevLine,
+	}}, p.BindingConflicts())
+
+	require.NoError(t, os.WriteFile(path, []byte("foo = 1"), 0644))
+	_, err = LoadConfig(path)
+	require.EqualError(t, err, path+": line 1: foo: unknown setting")
+}
+
+func TestParseStyle(t *testing.T) {
+	testCases := []struct {
+		s        string
+		expected Style
+		err      string
+	}{
+		{"", "", ""},
+		{"bold", attrBold, ""},
+		{"red", fgRed, ""},
+		{"on-red", bgRed, ""},
+		{"on-dark-blue", bgDarkBlue, ""},
+		{"reverse  underline", "\x1b[7;4m", ""},
+		{"38;5;208 on-default", "\x1b[38;5;208;49m", ""},
+		{"on-bold", "", `invalid style: "on-bold"`},
+		{"38;;5", "", `invalid style: "38;;5"`},
+		{"orange", "", `invalid style: "orange"`},
+	}
+	for _, c := range testCases {
+		t.Run(c.s, func(t *testing.T) {
+			style, err := ParseStyle(c.s)
+			if c.err != "" {
+				require.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, style)
+		})
+	}
+}
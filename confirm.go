@@ -0,0 +1,69 @@
+package prompt
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// errNotConfirmed is the error displayed when input matching a pattern
+// configured by WithConfirmPatterns is not confirmed.
+var errNotConfirmed = errors.New("not confirmed")
+
+type confirmPatternsOption struct {
+	patterns []*regexp.Regexp
+	prompt   string
+}
+
+func (o confirmPatternsOption) apply(p *Prompt) {
+	p.confirmPatterns = o.patterns
+	p.confirmPrompt = o.prompt
+}
+
+// WithConfirmPatterns configures input matching any of patterns, such as
+// `(?i)\bdrop\s+database\b`, to require confirmation before it is accepted.
+// The confirmation is read by a nested ReadLine displaying prompt (e.g.
+// "Really drop the database? [y/N] ") below the input, which is redrawn once
+// the confirmation has been read. Answering "y" or "yes", ignoring case,
+// accepts the input. Any other answer returns to editing the input. The
+// confirmation is requested after any check configured by WithAcceptCheck has
+// passed.
+func WithConfirmPatterns(patterns []*regexp.Regexp, prompt string) Option {
+	return confirmPatternsOption{patterns, prompt}
+}
+
+// addConfirmCheck extends the accept check to request confirmation of input
+// matching the patterns configured by WithConfirmPatterns.
+func (p *Prompt) addConfirmCheck() {
+	if len(p.confirmPatterns) == 0 {
+		return
+	}
+	check := p.mu.state.acceptCheck
+	p.mu.state.acceptCheck = func(line string) error {
+		if check != nil {
+			if err := check(line); err != nil {
+				return err
+			}
+		}
+		for _, re := range p.confirmPatterns {
+			if re.MatchString(line) {
+				return p.confirmLocked()
+			}
+		}
+		return nil
+	}
+}
+
+// confirmLocked reads the confirmation of input matching a confirmation pattern,
+// returning errNotConfirmed unless it is given.
+func (p *Prompt) confirmLocked() error {
+	answer, err := p.readNestedLocked(p.confirmPrompt, p.readLineLocked)
+	if err != nil {
+		return errNotConfirmed
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errNotConfirmed
+}
@@ -0,0 +1,171 @@
+package prompt
+
+import "sort"
+
+var cursorCommands = map[command]commandFunc{
+	cmdAddCursorNextMatch: func(s *state, key rune) (bool, error) {
+		s.cursors.AddNextMatch(s)
+		return true, nil
+	},
+}
+
+// cursors implements multiple cursors. The add-cursor-next-match command adds
+// a secondary cursor at the next occurrence of the word at the cursor, at the
+// same position within the occurrence as the cursor is within the word.
+// Insertions and deletions are then performed at every cursor, which allows
+// editing repeated identifiers simultaneously. Any other command removes the
+// secondary cursors.
+type cursors struct {
+	// positions holds the positions of the secondary cursors within the input
+	// text, in the order in which they were added.
+	positions []int
+}
+
+// Reset removes the secondary cursors without updating the screen.
+func (c *cursors) Reset() {
+	c.positions = nil
+}
+
+// Clear removes the secondary cursors.
+func (c *cursors) Clear(s *state) {
+	if c.positions == nil {
+		return
+	}
+	c.positions = nil
+	s.screen.ReplaceAttrs(attrKindCursor, nil)
+}
+
+// Dispatch processes the specified command. Insertion and deletion commands
+// are performed at every cursor, and other commands remove the secondary
+// cursors. Multiple cursors are not supported during history search.
+func (c *cursors) Dispatch(s *state, cmd command, key rune) (ok bool, err error) {
+	if s.history.searchDir != 0 {
+		c.Clear(s)
+		return false, nil
+	}
+	if fn, ok := cursorCommands[cmd]; ok {
+		return fn(s, key)
+	}
+	if c.positions == nil {
+		return false, nil
+	}
+
+	var edit func()
+	switch cmd {
+	case cmdInsertChar:
+		edit = func() { s.screen.Insert(key) }
+	case cmdBackwardDeleteChar:
+		edit = func() { s.screen.EraseTo(s.screen.PrevGraphemeStart()) }
+	case cmdDeleteChar, cmdExitOrDeleteChar:
+		edit = func() { s.screen.EraseTo(s.screen.NextGraphemeEnd()) }
+	default:
+		c.Clear(s)
+		return false, nil
+	}
+
+	s.completer.Cancel(s)
+	primary := s.screen.Position()
+	all := append([]int{primary}, c.positions...)
+	order := make([]int, len(all))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return all[order[i]] < all[order[j]] })
+
+	// Perform the edit at each cursor, starting with the last so that the
+	// positions of the preceding cursors are unaffected. Each cursor is then
+	// shifted by the change in the length of the text preceding it.
+	deltas := make([]int, len(all))
+	for j := len(order) - 1; j >= 0; j-- {
+		i := order[j]
+		n := len(s.screen.Text())
+		s.screen.MoveTo(all[i])
+		edit()
+		deltas[i] = len(s.screen.Text()) - n
+		all[i] = s.screen.Position()
+	}
+	var shift int
+	for _, i := range order {
+		all[i] += shift
+		shift += deltas[i]
+	}
+
+	s.screen.MoveTo(all[0])
+	c.positions = all[1:]
+	c.render(s)
+	return true, nil
+}
+
+// AddNextMatch adds a secondary cursor at the next occurrence of the word at
+// the cursor following the most recently added cursor. The search wraps
+// around to the start of the input text. The terminal bell is rung if there
+// are no more occurrences.
+func (c *cursors) AddNextMatch(s *state) {
+	s.completer.Cancel(s)
+	text := s.screen.Text()
+	pos := s.screen.Position()
+
+	// Determine the word at the cursor, which may end at the cursor.
+	end := s.screen.NextWordEnd(pos)
+	start := s.screen.PrevWordStart(end)
+	if start > pos {
+		start = s.screen.PrevWordStart(pos)
+		end = s.screen.NextWordEnd(start)
+	}
+	if start == end || start > pos || end < pos {
+		s.screen.outbuf.WriteRune(keyCtrlG) // ctrl-G == bell/beep
+		return
+	}
+	word := string(text[start:end])
+	offset := pos - start
+
+	// isMatch returns true if an occurrence of the word starts at i.
+	isMatch := func(i int) bool {
+		j := i + end - start
+		if j > len(text) || string(text[i:j]) != word {
+			return false
+		}
+		return (i == 0 || !s.screen.isWord(text[i-1])) && (j == len(text) || !s.screen.isWord(text[j]))
+	}
+	hasCursor := func(p int) bool {
+		if p == pos {
+			return true
+		}
+		for _, q := range c.positions {
+			if p == q {
+				return true
+			}
+		}
+		return false
+	}
+
+	from := start
+	if n := len(c.positions); n > 0 {
+		from = c.positions[n-1] - offset
+	}
+	for k := 1; k <= len(text); k++ {
+		i := (from + k) % len(text)
+		if isMatch(i) && !hasCursor(i+offset) {
+			c.positions = append(c.positions, i+offset)
+			c.render(s)
+			return
+		}
+	}
+	s.screen.outbuf.WriteRune(keyCtrlG) // ctrl-G == bell/beep
+}
+
+// render displays the secondary cursors by styling the character at each
+// cursor.
+func (c *cursors) render(s *state) {
+	text := s.screen.Text()
+	attrs := make([]attrInfo, 0, len(c.positions))
+	for _, pos := range c.positions {
+		if pos >= len(text) || text[pos] == '\n' {
+			// There is no character at the cursor to style.
+			continue
+		}
+		attrs = append(attrs, attrInfo{startPos: pos, endPos: pos + 1, value: string(s.theme.SecondaryCursor)})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].startPos < attrs[j].startPos })
+	s.screen.ReplaceAttrs(attrKindCursor, attrs)
+}
@@ -2,37 +2,48 @@ package prompt
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"sync"
 	"unicode/utf8"
 )
 
-var dbg = struct {
-	sync.Once
-	w   io.WriteCloser
-	err error
-}{}
+// debugLog writes debugging output to the file named by the PROMPT_DEBUG
+// environment variable. Each Prompt opens its own debugLog, appending to the
+// file so that the output of concurrent prompts is interleaved rather than
+// overwritten. The methods are no-ops on a nil receiver.
+type debugLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
 
-func initDebug() {
+// newDebugLog opens the debug log, returning nil if PROMPT_DEBUG is not set or
+// the file cannot be opened.
+func newDebugLog() *debugLog {
 	path := os.Getenv("PROMPT_DEBUG")
 	if path == "" {
-		return
+		return nil
 	}
-	f, err := os.Create(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
-		dbg.err = err
-		return
+		return nil
 	}
-	dbg.w = f
+	return &debugLog{f: f}
 }
 
-func debugPrintf(format string, args ...interface{}) {
-	dbg.Do(initDebug)
-	if dbg.w == nil {
+func (l *debugLog) Printf(format string, args ...interface{}) {
+	if l == nil {
 		return
 	}
-	fmt.Fprintf(dbg.w, format, args...)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.f, format, args...)
+}
+
+func (l *debugLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
 }
 
 func debugKey(r rune) string {
@@ -0,0 +1,170 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// draftInterval is the minimum interval between writes of the draft file.
+const draftInterval = time.Second
+
+// draft saves the input text of ReadLine to a file as it is edited, so that
+// the input can be restored by a subsequent process if the process dies before
+// the input is accepted. Writes are debounced: the file is written at most
+// once per interval, with the most recent text written once the interval has
+// elapsed. The file is removed when the input is accepted or emptied. See the
+// WithDraftFile option.
+type draft struct {
+	path     string
+	interval time.Duration
+	// mu is the mutex of the Prompt, which is acquired by the timer which
+	// writes the pending text.
+	mu    sync.Locker
+	debug *debugLog
+	// pending is the text to save, and saved the text most recently saved.
+	pending   string
+	saved     string
+	lastWrite time.Time
+	timer     *time.Timer
+}
+
+// Load returns the text saved in the draft file, or the empty string if there
+// is no draft file.
+func (d *draft) Load() (string, error) {
+	data, err := os.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	d.saved = string(data)
+	d.pending = d.saved
+	return d.saved, nil
+}
+
+// Update records text as the current input text, writing it to the draft file
+// immediately if the interval has elapsed since the previous write, and
+// otherwise once it has. Must be called with mu held.
+func (d *draft) Update(text string) {
+	d.pending = text
+	if d.pending == d.saved || d.timer != nil {
+		return
+	}
+	if wait := d.interval - time.Since(d.lastWrite); wait > 0 {
+		d.timer = time.AfterFunc(wait, func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.timer = nil
+			d.flush()
+		})
+		return
+	}
+	d.flush()
+}
+
+// Clear removes the draft file, such as when the input is accepted. Must be
+// called with mu held.
+func (d *draft) Clear() {
+	d.Stop()
+	d.pending = ""
+	d.flush()
+}
+
+// Stop cancels any pending write of the draft file. Must be called with mu
+// held.
+func (d *draft) Stop() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
+
+// flush writes the pending text to the draft file, removing the file if the
+// text is empty. The file is written to a temporary file which is renamed over
+// the draft file so that a crash during the write does not lose the draft.
+func (d *draft) flush() {
+	if d.pending == d.saved {
+		return
+	}
+	if err := d.write(d.pending); err != nil {
+		d.debug.Printf("draft: %v\n", err)
+		return
+	}
+	d.saved = d.pending
+	d.lastWrite = time.Now()
+}
+
+func (d *draft) write(text string) error {
+	if text == "" {
+		if err := os.Remove(d.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeFileAtomic(d.path, []byte(text))
+}
+
+// writeFileAtomic writes data to a temporary file which is renamed over the
+// file at path, creating the directory containing the file if necessary. The
+// file is only readable by the owner.
+func writeFileAtomic(path string, data []byte) (err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// restoreDraftLocked restores the input text saved in the draft file by a
+// previous process, displaying a notice until the next key is pressed. The
+// restoration is recorded as an edit, so that it can be discarded by undo.
+func (p *Prompt) restoreDraftLocked() {
+	d := p.mu.draft
+	if d == nil || p.mu.draftChecked {
+		return
+	}
+	p.mu.draftChecked = true
+	text, err := d.Load()
+	if err != nil {
+		d.debug.Printf("draft: %v\n", err)
+		return
+	}
+	if text == "" {
+		return
+	}
+	s := &p.mu.state
+	s.screen.Insert([]rune(text)...)
+	u := s.history.undoList(s.history.index)
+	u.Record(undoState{}, cmdYank)
+	s.draftRestored = true
+	s.annotations.Set(&s.screen, annotationDraft, Annotation{
+		Text:     "\n(restored unsaved input; undo to discard)",
+		Priority: AnnotationPriorityDraft,
+	})
+}
+
+// clearDraftNotice removes the notice displayed by restoreDraftLocked.
+func clearDraftNotice(s *state) {
+	if s.draftRestored {
+		s.draftRestored = false
+		s.annotations.Set(&s.screen, annotationDraft, Annotation{})
+	}
+}
@@ -0,0 +1,122 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDraft(t *testing.T) {
+	var mu sync.Mutex
+	path := filepath.Join(t.TempDir(), "app", "draft")
+	d := &draft{path: path, interval: time.Hour, mu: &mu}
+
+	readDraft := func() string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return "<none>"
+		}
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	text, err := d.Load()
+	require.NoError(t, err)
+	require.Equal(t, "", text)
+
+	// The first update is written immediately, creating the directory.
+	mu.Lock()
+	d.Update("select")
+	mu.Unlock()
+	require.Equal(t, "select", readDraft())
+
+	// Subsequent updates are written once the interval has elapsed.
+	mu.Lock()
+	d.Update("select 1")
+	d.Update("select 1;")
+	require.NotNil(t, d.timer)
+	mu.Unlock()
+	require.Equal(t, "select", readDraft())
+
+	mu.Lock()
+	d.timer.Reset(0)
+	mu.Unlock()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return d.timer == nil
+	}, 10*time.Second, time.Millisecond)
+	require.Equal(t, "select 1;", readDraft())
+
+	// Clearing the draft removes the file and cancels any pending write.
+	mu.Lock()
+	d.Update("select 2")
+	d.Clear()
+	mu.Unlock()
+	require.Nil(t, d.timer)
+	require.Equal(t, "<none>", readDraft())
+}
+
+func TestDraftRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "draft")
+	require.NoError(t, os.WriteFile(path, []byte("select *\n  from t"), 0600))
+
+	var out strings.Builder
+	p, err := New(WithOutput(&out), WithInteractive(true), WithDraftFile(path))
+	require.NoError(t, err)
+	p.mu.draft.interval = 0
+
+	process := func(input string) string {
+		t.Helper()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.parser.Feed([]byte(input))
+		result, err := p.processInputLocked()
+		require.NoError(t, err)
+		return result
+	}
+	readDraft := func() string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return "<none>"
+		}
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	p.mu.state.Reset([]rune("> "))
+	p.mu.state.active = true
+	p.restoreDraftLocked()
+	require.Equal(t, "select *\n  from t", p.CurrentText())
+	require.Equal(t, "\n(restored unsaved input; undo to discard)", string(p.mu.state.screen.suffix))
+
+	// The notice is removed by the next key, and edits are saved.
+	process(";")
+	require.Equal(t, "", string(p.mu.state.screen.suffix))
+	require.Equal(t, "select *\n  from t;", readDraft())
+
+	// Undo discards the restored input, removing the draft file.
+	process("\x1f\x1f")
+	require.Equal(t, "", p.CurrentText())
+	require.Equal(t, "<none>", readDraft())
+
+	// The draft file is only restored by the first ReadLine.
+	require.NoError(t, os.WriteFile(path, []byte("select 1"), 0600))
+	p.mu.state.Reset([]rune("> "))
+	p.restoreDraftLocked()
+	require.Equal(t, "", p.CurrentText())
+
+	// The draft file is removed when the input is accepted.
+	process("select 2")
+	require.Equal(t, "select 2", readDraft())
+	require.Equal(t, "select 2", process("\r"))
+	require.Equal(t, "<none>", readDraft())
+	require.NoError(t, p.Close())
+}
@@ -0,0 +1,135 @@
+package prompt
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// Encoding specifies the character encoding used by the terminal. Prompt
+// operates on UTF-8 internally. When a legacy encoding is specified, input is
+// translated to UTF-8 as it is read and output is translated from UTF-8 as it
+// is written.
+type Encoding int
+
+const (
+	// UTF8 is the default encoding and requires no translation.
+	UTF8 Encoding = iota
+	// Latin1 is ISO-8859-1, in which every byte maps directly to the Unicode code
+	// point of the same value.
+	Latin1
+	// CP1252 is Windows-1252, a superset of Latin1 which uses the 0x80-0x9f range
+	// for printable characters such as the euro sign and curly quotes.
+	CP1252
+)
+
+// cp1252 maps the bytes 0x80-0x9f to their Unicode code points. The undefined
+// bytes map to the corresponding C1 control code point, as is done by Windows.
+var cp1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+// decode returns the rune for the specified byte.
+func (e Encoding) decode(b byte) rune {
+	if e == CP1252 && b >= 0x80 && b < 0xa0 {
+		return cp1252[b-0x80]
+	}
+	return rune(b)
+}
+
+// encode returns the byte for the specified rune, or '?' if the rune is not
+// representable in the encoding.
+func (e Encoding) encode(r rune) byte {
+	if e == CP1252 {
+		if r >= 0x80 && r < 0xa0 {
+			// These code points are only representable if they are undefined in CP1252.
+			if cp1252[r-0x80] == r {
+				return byte(r)
+			}
+			return '?'
+		}
+		for i, c := range cp1252 {
+			if c == r {
+				return byte(0x80 + i)
+			}
+		}
+	}
+	if r < 0x100 {
+		return byte(r)
+	}
+	return '?'
+}
+
+// encodingReader translates input in a legacy encoding to UTF-8.
+type encodingReader struct {
+	r        io.Reader
+	enc      Encoding
+	buf      []byte
+	pending  []byte
+	pendBuf  []byte
+	utf8Char [utf8.UTFMax]byte
+}
+
+func (r *encodingReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		// Every input byte translates to at most 3 bytes of UTF-8.
+		n := len(p) / 3
+		if n == 0 {
+			n = 1
+		}
+		if cap(r.buf) < n {
+			r.buf = make([]byte, n)
+		}
+		n, err := r.r.Read(r.buf[:n])
+		if n == 0 {
+			return 0, err
+		}
+		r.pendBuf = r.pendBuf[:0]
+		for _, b := range r.buf[:n] {
+			l := utf8.EncodeRune(r.utf8Char[:], r.enc.decode(b))
+			r.pendBuf = append(r.pendBuf, r.utf8Char[:l]...)
+		}
+		r.pending = r.pendBuf
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// encodingWriter translates UTF-8 output to a legacy encoding.
+type encodingWriter struct {
+	w       io.Writer
+	enc     Encoding
+	buf     []byte
+	partial []byte
+}
+
+func (w *encodingWriter) Write(p []byte) (int, error) {
+	data := p
+	if len(w.partial) > 0 {
+		data = append(w.partial, p...)
+		w.partial = nil
+	}
+	w.buf = w.buf[:0]
+	for len(data) > 0 {
+		if data[0] < utf8.RuneSelf {
+			w.buf = append(w.buf, data[0])
+			data = data[1:]
+			continue
+		}
+		if !utf8.FullRune(data) {
+			// Save the partial UTF-8 sequence for the next write.
+			w.partial = append([]byte(nil), data...)
+			break
+		}
+		r, l := utf8.DecodeRune(data)
+		w.buf = append(w.buf, w.enc.encode(r))
+		data = data[l:]
+	}
+	if _, err := w.w.Write(w.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,48 @@
+package prompt
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodingRoundtrip(t *testing.T) {
+	testCases := []struct {
+		enc     Encoding
+		encoded string
+		decoded string
+	}{
+		{Latin1, "caf\xe9", "café"},
+		{Latin1, "\x1b[A\xab\xbb", "\x1b[A«»"},
+		{CP1252, "\x80 \x93quoted\x94", "€ “quoted”"},
+		{CP1252, "na\xefve", "naïve"},
+	}
+	for _, c := range testCases {
+		t.Run("", func(t *testing.T) {
+			r := &encodingReader{r: strings.NewReader(c.encoded), enc: c.enc}
+			decoded, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, c.decoded, string(decoded))
+
+			var buf bytes.Buffer
+			w := &encodingWriter{w: &buf, enc: c.enc}
+			// Split the write in the middle of a UTF-8 sequence.
+			for _, b := range []byte(c.decoded) {
+				_, err := w.Write([]byte{b})
+				require.NoError(t, err)
+			}
+			require.Equal(t, c.encoded, buf.String())
+		})
+	}
+}
+
+func TestEncodingUnrepresentable(t *testing.T) {
+	var buf bytes.Buffer
+	w := &encodingWriter{w: &buf, enc: Latin1}
+	_, err := w.Write([]byte("€ and 世界"))
+	require.NoError(t, err)
+	require.Equal(t, "? and ??", buf.String())
+}
@@ -0,0 +1,231 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+)
+
+var helpCommands = map[command]commandFunc{
+	cmdHelp: func(s *state, key rune) (bool, error) {
+		s.help.Open(s)
+		return true, nil
+	},
+}
+
+// helpOverlay implements the help command, which displays the key bindings
+// and their descriptions below the input. While the overlay is open it
+// captures all keys: Space and Page-Down scroll down a page, Page-Up scrolls
+// up a page, Down and Up scroll a row, / searches the bindings, and q, Escape,
+// Control-g, and Control-c close the overlay. While searching, typed
+// characters are appended to the search text, which is matched against the
+// keys, commands, and descriptions, and Enter or Escape ends the search.
+type helpOverlay struct {
+	// bindings are the key bindings of the Prompt.
+	bindings *keyBindings
+	// active is true while the overlay is displayed.
+	active bool
+	// rows holds the rows for the bindings, and matches the subset of rows
+	// which match the search text.
+	rows    []string
+	matches []string
+	// searching is true while the search text is being typed.
+	searching bool
+	search    []rune
+	// top is the index of the first displayed row of matches.
+	top int
+}
+
+// Open displays the overlay.
+func (h *helpOverlay) Open(s *state) {
+	if h.bindings == nil {
+		return
+	}
+	h.active = true
+	h.rows = h.formatRows(s)
+	h.matches = h.rows
+	h.searching = false
+	h.search = nil
+	h.top = 0
+	h.render(s)
+}
+
+// Close removes the overlay.
+func (h *helpOverlay) Close(s *state) {
+	if !h.active {
+		return
+	}
+	h.Reset()
+	s.annotations.Set(&s.screen, annotationHelp, Annotation{})
+}
+
+// Resize re-formats the rows of the overlay, if it is displayed, which are
+// truncated to the width of the screen, after the screen has been resized.
+func (h *helpOverlay) Resize(s *state) {
+	if !h.active {
+		return
+	}
+	h.rows = h.formatRows(s)
+	h.filter()
+	h.render(s)
+}
+
+// Reset closes the overlay without updating the screen, which is expected to
+// have been reset.
+func (h *helpOverlay) Reset() {
+	h.active = false
+	h.rows = nil
+	h.matches = nil
+	h.search = nil
+}
+
+// Dispatch processes the specified command while the overlay is displayed,
+// returning false if the overlay is not displayed.
+func (h *helpOverlay) Dispatch(s *state, cmd command, key rune) (ok bool, err error) {
+	if !h.active {
+		return false, nil
+	}
+	if h.searching {
+		switch {
+		case key == keyEscape || cmd == cmdFinishOrEnter || cmd == cmdEnter:
+			h.searching = false
+		case cmd == cmdBackwardDeleteChar:
+			if len(h.search) > 0 {
+				h.search = h.search[:len(h.search)-1]
+				h.filter()
+			}
+		case cmd == cmdInsertChar && key != '\n' && isPrintable(key):
+			h.search = append(h.search, key)
+			h.filter()
+		}
+		h.render(s)
+		return true, nil
+	}
+
+	switch {
+	case key == 'q' || key == keyEscape || cmd == cmdAbort || cmd == cmdCancel:
+		h.Close(s)
+		return true, nil
+	case key == '/':
+		h.searching = true
+	case key == ' ' || key == keyPageDown:
+		h.scroll(s, h.pageSize(s))
+	case key == keyPageUp:
+		h.scroll(s, -h.pageSize(s))
+	case cmd == cmdNextHistory:
+		h.scroll(s, 1)
+	case cmd == cmdPreviousHistory:
+		h.scroll(s, -1)
+	}
+	h.render(s)
+	return true, nil
+}
+
+// formatRows returns a row for each key binding, listing the key, the
+// description of the command, and the command, ordered by the category of the
+// command and then by command.
+func (h *helpOverlay) formatRows(s *state) []string {
+	bindings := h.bindings.list()
+	var keyWidth int
+	for _, b := range bindings {
+		if keyWidth < len(b.key) {
+			keyWidth = len(b.key)
+		}
+	}
+	rows := make([]string, len(bindings))
+	for i, b := range bindings {
+		desc := describeCommand(b.cmd).description
+		row := fmt.Sprintf("%-*s  %s (%s)", keyWidth, b.key, desc, b.cmd)
+		if b.cond != "" {
+			row = fmt.Sprintf("%-*s  %s (%s if %s)", keyWidth, b.key, desc, b.cmd, b.cond)
+		}
+		rows[i] = truncateWidth(row, s.screen.width-1, s.screen.runeWidth)
+	}
+	return rows
+}
+
+// filter computes the rows which match the search text.
+func (h *helpOverlay) filter() {
+	h.top = 0
+	if len(h.search) == 0 {
+		h.matches = h.rows
+		return
+	}
+	search := strings.ToLower(string(h.search))
+	h.matches = nil
+	for _, row := range h.rows {
+		if strings.Contains(strings.ToLower(row), search) {
+			h.matches = append(h.matches, row)
+		}
+	}
+}
+
+// pageSize returns the number of rows displayed at a time, leaving room on the
+// screen for the input, the header, and the footer.
+func (h *helpOverlay) pageSize(s *state) int {
+	if s.screen.height <= 0 {
+		return len(h.matches)
+	}
+	n := s.screen.height - s.screen.InputRows() - 2
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// scroll scrolls the displayed rows by n rows.
+func (h *helpOverlay) scroll(s *state, n int) {
+	h.top += n
+	if max := len(h.matches) - h.pageSize(s); h.top > max {
+		h.top = max
+	}
+	if h.top < 0 {
+		h.top = 0
+	}
+}
+
+// render displays the overlay as an annotation.
+func (h *helpOverlay) render(s *state) {
+	// The header is truncated like the rows, so that it occupies one row.
+	var header string
+	switch {
+	case h.searching:
+		header = fmt.Sprintf("/%s", string(h.search))
+	case len(h.search) > 0:
+		header = fmt.Sprintf("Key bindings matching %q (q to close)", string(h.search))
+	default:
+		header = "Key bindings (q to close, / to search)"
+	}
+	var buf strings.Builder
+	buf.WriteString("\n")
+	buf.WriteString(truncateWidth(header, s.screen.width-1, s.screen.runeWidth))
+
+	pageSize := h.pageSize(s)
+	end := h.top + pageSize
+	if end > len(h.matches) {
+		end = len(h.matches)
+	}
+	for _, row := range h.matches[h.top:end] {
+		buf.WriteString("\n")
+		buf.WriteString(row)
+	}
+	if len(h.matches) == 0 {
+		buf.WriteString("\nNo matching bindings")
+	} else if len(h.matches) > pageSize {
+		fmt.Fprintf(&buf, "\n-- %d-%d of %d --", h.top+1, end, len(h.matches))
+	}
+	s.annotations.Set(&s.screen, annotationHelp, Annotation{
+		Text:     buf.String(),
+		Priority: AnnotationPriorityHelp,
+	})
+}
+
+// truncateWidth truncates s to at most width columns.
+func truncateWidth(s string, width int, runeWidth func(r rune) int) string {
+	var w int
+	for i, r := range s {
+		if w += runeWidth(r); w > width {
+			return s[:i]
+		}
+	}
+	return s
+}
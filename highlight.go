@@ -0,0 +1,138 @@
+package prompt
+
+import "time"
+
+// Style is an ANSI SGR escape sequence used to style text, such as "\x1b[1m"
+// for bold text or "\x1b[32m" for green text.
+type Style string
+
+// Span specifies the style to apply to the range [Start,End) of the input text.
+// A highlighter may also specify the kind of token spanned, which is passed to
+// completers in Document.Token.
+type Span struct {
+	Start, End int
+	Style      Style
+	Kind       TokenKind
+}
+
+// TokenKind identifies the kind of a token of the input text, such as a string
+// literal or a comment, as determined by a highlighter.
+type TokenKind int8
+
+const (
+	// TokenOther is any token which is not one of the kinds below, and the kind
+	// of text not spanned by a highlighting span.
+	TokenOther TokenKind = iota
+	// TokenString is a string literal.
+	TokenString
+	// TokenComment is a comment.
+	TokenComment
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenString:
+		return "string"
+	case TokenComment:
+		return "comment"
+	}
+	return "other"
+}
+
+// HighlightFunc is used to highlight the input text. Rather than highlighting
+// the entire text on every modification, the highlighter is passed the region
+// [dirtyStart,dirtyEnd) of text which was modified since the previous
+// invocation. An empty dirty region indicates that text was erased at that
+// position. The highlighter returns the highlighting spans for the region
+// [start,end) of text, which should encompass the dirty region and is usually
+// expanded to the boundaries of the surrounding tokens or lines. The spans
+// replace any previous highlighting within [start,end). To re-highlight the
+// entire text, return a region of [0,len(text)). The text must not be retained
+// or modified.
+type HighlightFunc func(text []rune, dirtyStart, dirtyEnd int) (spans []Span, start, end int)
+
+// highlighter applies syntax highlighting to the input text after every
+// modification. The displayed completion hint is excluded from the text passed
+// to the highlight function.
+type highlighter struct {
+	fn HighlightFunc
+}
+
+// Update invokes the highlight function on the region of the input text which
+// was modified since the last update, and applies the returned highlighting.
+func (h *highlighter) Update(s *state) {
+	dirtyStart, dirtyEnd, ok := s.screen.TakeDirty()
+	if !ok || h.fn == nil {
+		return
+	}
+
+	// Exclude the completion hint, if any.
+	text, m := excludeHint(s)
+
+	callStart := time.Now()
+	spans, start, end := h.fn(text, m.toText(dirtyStart), m.toText(dirtyEnd))
+	s.metrics.AddCallback(callbackHighlighter, callStart)
+	if start < 0 {
+		start = 0
+	}
+	if end > len(text) {
+		end = len(text)
+	}
+
+	attrs := make([]attrInfo, 0, len(spans))
+	for _, span := range spans {
+		spanStart, spanEnd := m.toInput(span.Start, false), m.toInput(span.End, true)
+		if span.Start < m.start && span.End > m.start {
+			// The span crosses the hint. Split it into the portions before and after
+			// the hint.
+			attrs = append(attrs, attrInfo{startPos: spanStart, endPos: m.start, value: string(span.Style), token: span.Kind})
+			spanStart = m.start + m.len
+		}
+		attrs = append(attrs, attrInfo{startPos: spanStart, endPos: spanEnd, value: string(span.Style), token: span.Kind})
+	}
+	// The region includes the hint if it is adjacent to the hint so that any
+	// stale highlighting of the hint is removed.
+	s.screen.SetHighlights(m.toInput(start, true), m.toInput(end, false), attrs)
+}
+
+// hintMapping maps positions between the input text and the input text with the
+// completion hint, which occupies [start,start+len) of the input text, excluded.
+// The start is -1 if there is no completion hint.
+type hintMapping struct {
+	start, len int
+}
+
+// excludeHint returns the input text excluding the completion hint, if any, and
+// the mapping between positions in the input text and the returned text.
+func excludeHint(s *state) ([]rune, hintMapping) {
+	text := s.screen.Text()
+	c := &s.completer
+	if c.suffix == nil {
+		return text, hintMapping{start: -1}
+	}
+	m := hintMapping{start: c.wordEnd, len: len(c.suffix)}
+	return append(append([]rune(nil), text[:m.start]...), text[m.start+m.len:]...), m
+}
+
+// toText maps a position in the input text to a position in the text excluding
+// the hint.
+func (m hintMapping) toText(pos int) int {
+	switch {
+	case m.start == -1 || pos <= m.start:
+		return pos
+	case pos <= m.start+m.len:
+		return m.start
+	default:
+		return pos - m.len
+	}
+}
+
+// toInput maps a position in the text excluding the hint to a position in the
+// input text. The position of the hint maps to the start of the hint if
+// beforeHint is true and the end of the hint otherwise.
+func (m hintMapping) toInput(pos int, beforeHint bool) int {
+	if m.start == -1 || pos < m.start || (beforeHint && pos == m.start) {
+		return pos
+	}
+	return pos + m.len
+}
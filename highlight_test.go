@@ -0,0 +1,85 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHighlighter(t *testing.T) {
+	const bold = Style(attrBold)
+
+	// The highlighter highlights the keywords within the words surrounding the
+	// dirty region, recording the dirty regions and the text it was invoked on.
+	var calls []string
+	highlight := func(text []rune, dirtyStart, dirtyEnd int) ([]Span, int, int) {
+		calls = append(calls, fmt.Sprintf("%q [%d,%d)", string(text), dirtyStart, dirtyEnd))
+		start, end := dirtyStart, dirtyEnd
+		for start > 0 && !unicode.IsSpace(text[start-1]) {
+			start--
+		}
+		for end < len(text) && !unicode.IsSpace(text[end]) {
+			end++
+		}
+		var spans []Span
+		for i := start; i < end; {
+			j := i
+			for j < end && !unicode.IsSpace(text[j]) {
+				j++
+			}
+			if w := string(text[i:j]); w == "select" || w == "from" {
+				spans = append(spans, Span{Start: i, End: j, Style: bold})
+			}
+			for i = j; i < end && unicode.IsSpace(text[i]); i++ {
+			}
+		}
+		return spans, start, end
+	}
+
+	completer := func(text []rune, wordStart, wordEnd int) []string {
+		if word := string(text[wordStart:wordEnd]); strings.HasPrefix("from", word) {
+			return []string{"from"}
+		}
+		return nil
+	}
+
+	p := newTestPrompt(t, WithHighlighter(highlight), WithCompleter(completer))
+
+	highlights := func() string {
+		var buf strings.Builder
+		s := &p.mu.state.screen
+		for _, a := range s.attrs.All() {
+			if a.kind == attrKindHighlight {
+				fmt.Fprintf(&buf, "[%s]", string(s.text[a.startPos:a.endPos]))
+			}
+		}
+		return buf.String()
+	}
+
+	feedInput(t, p, "select")
+	require.Equal(t, "[select]", highlights())
+	require.Equal(t, `"select" [5,6)`, calls[len(calls)-1])
+
+	// Modifying a word only re-highlights the modified word.
+	feedInput(t, p, " x")
+	require.Equal(t, "[select]", highlights())
+	require.Equal(t, `"select x" [7,8)`, calls[len(calls)-1])
+	feedInput(t, p, "\x7f")
+	require.Equal(t, `"select " [7,7)`, calls[len(calls)-1])
+
+	// The completion hint is excluded from the highlighted text.
+	feedInput(t, p, "f")
+	require.Equal(t, "[select]", highlights())
+	require.Equal(t, `"select f" [7,8)`, calls[len(calls)-1])
+	require.Equal(t, "select from", string(p.mu.state.screen.Text()))
+	feedInput(t, p, "\t")
+	require.Equal(t, "[select][from]", highlights())
+
+	// Erasing text removes the highlighting.
+	feedInput(t, p, "\x1b[H\x1b[3~")
+	require.Equal(t, `"elect from" [0,0)`, calls[len(calls)-1])
+	require.Equal(t, "[from]", highlights())
+}
@@ -1,12 +1,14 @@
 package prompt
 
 import (
-	"bufio"
 	"fmt"
-	"io"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 	"unicode/utf8"
+
+	"github.com/petermattis/prompt/libedit"
 )
 
 var historyCommands = map[command]commandFunc{
@@ -19,9 +21,28 @@ var historyCommands = map[command]commandFunc{
 	cmdCancel: func(s *state, key rune) (bool, error) {
 		return s.history.CancelSearch(s)
 	},
+	cmdExpandHistory: func(s *state, key rune) (bool, error) {
+		return s.history.Expand(s)
+	},
 	cmdForwardSearchHistory: func(s *state, key rune) (bool, error) {
 		return s.history.ForwardSearch(s)
 	},
+	cmdHistoryExpandLine: func(s *state, key rune) (bool, error) {
+		s.history.ExpandLine(s)
+		return true, nil
+	},
+	cmdPatternSearchBackward: func(s *state, key rune) (bool, error) {
+		return s.history.PatternSearch(s, -1)
+	},
+	cmdPatternSearchForward: func(s *state, key rune) (bool, error) {
+		return s.history.PatternSearch(s, +1)
+	},
+	cmdSubstrSearchBackward: func(s *state, key rune) (bool, error) {
+		return s.history.SubstringSearchBackward(s)
+	},
+	cmdSubstrSearchForward: func(s *state, key rune) (bool, error) {
+		return s.history.SubstringSearchForward(s)
+	},
 	cmdInsertChar: func(s *state, key rune) (bool, error) {
 		return s.history.AppendSearchKey(s, key)
 	},
@@ -34,16 +55,78 @@ var historyCommands = map[command]commandFunc{
 	cmdPreviousHistory: func(s *state, key rune) (bool, error) {
 		return s.history.Previous(s)
 	},
+	cmdToggleSearchRegexp: func(s *state, key rune) (bool, error) {
+		return s.history.ToggleSearchRegexp(s)
+	},
+	cmdSearchNextOccurrence: func(s *state, key rune) (bool, error) {
+		return s.history.NextOccurrence(s)
+	},
+	cmdSearchPrevOccurrence: func(s *state, key rune) (bool, error) {
+		return s.history.PrevOccurrence(s)
+	},
 }
 
+// defaultHistoryFileMode is the permission bits used when creating a history
+// file. History files often contain sensitive input, so they are only readable
+// by the owner by default.
+const defaultHistoryFileMode os.FileMode = 0600
+
+// HistoryDedup specifies the suppression of duplicate history entries. See
+// WithHistoryDedup.
+type HistoryDedup int
+
+const (
+	// HistoryDedupAdjacent suppresses an entry identical to the previous entry.
+	HistoryDedupAdjacent HistoryDedup = iota
+	// HistoryDedupNone adds every entry, including duplicates.
+	HistoryDedupNone
+	// HistoryDedupEraseOldest moves an entry identical to an earlier entry to
+	// the front of history, erasing the earlier entry, so that history holds no
+	// duplicates. This matches the HIST_IGNORE_ALL_DUPS option of zsh.
+	HistoryDedupEraseOldest
+)
+
+// HistoryWritePolicy specifies when history entries are written to the history
+// store. See WithHistoryWritePolicy.
+type HistoryWritePolicy int
+
+const (
+	// HistoryWriteOnAccept writes each entry to the history store as it is
+	// added, when the line is accepted.
+	HistoryWriteOnAccept HistoryWritePolicy = iota
+	// HistoryWriteOnClose buffers the entries, writing them to the history store
+	// when Prompt.SyncHistory is called or the Prompt is closed.
+	HistoryWriteOnClose
+	// HistoryWriteManual buffers the entries, writing them to the history store
+	// only when Prompt.SyncHistory is called. The entries still buffered when
+	// the Prompt is closed are discarded.
+	HistoryWriteManual
+)
+
 // history implements a fixed size circular list of history entries and commands
 // for navigating and searching the list. Adjacent duplicate history entries are
-// suppressed. Forward and reverse incremental search of both history entries
-// and the pending input including positioning of the cursor within the
-// currently matched line when there is more than one match on a line.
+// suppressed by default. Forward and reverse incremental search of both history
+// entries and the pending input including positioning of the cursor within the
+// currently matched line when there is more than one match on a line. The
+// search key is matched literally, as a regular expression if regexp search has
+// been toggled on or pattern search was started, or fuzzily if fuzzy search is
+// enabled, and the search may be scoped to the entries sharing the first word
+// of the input. Substring search navigates through the history entries which
+// contain the text that was input when the search was started.
 type history struct {
-	path             string
-	file             io.WriteCloser
+	// store persists the entries. If no store is configured, the entries are
+	// persisted to the history file at path, if any, which is created with the
+	// permission bits mode and synced after each append if sync is true.
+	store HistoryStore
+	path  string
+	mode  os.FileMode
+	sync  bool
+	// debug logs the handling of added entries. May be nil.
+	debug *debugLog
+	// maxEntryLen is the maximum length in bytes of an entry. Longer entries are
+	// either skipped or truncated to maxEntryLen if truncateEntries is true.
+	maxEntryLen      int
+	truncateEntries  bool
 	pending          string
 	entries          []string
 	head             int
@@ -54,102 +137,417 @@ type history struct {
 	searchMatched    bool
 	searchKey        string
 	searchMatchedKey string
+	// meta holds the metadata attached to each entry by AnnotateLast, indexed
+	// like entries. annotatable is true if the most recently added entry (or
+	// the duplicate of the most recent entry which was elided) may be
+	// annotated.
+	meta        []map[string]string
+	annotatable bool
+	// searchRegexp is true if the search key is interpreted as a regular
+	// expression. searchRE caches the compilation of searchREKey. During
+	// pattern search, a search key which is not a valid regular expression is
+	// matched literally.
+	searchRegexp  bool
+	searchRE      *regexp.Regexp
+	searchREKey   string
+	searchPattern bool
+	// searchHighlighted is true if the occurrences of the search key are
+	// highlighted in the input text.
+	searchHighlighted bool
+	// scopedSearch is true if incremental search is constrained to the entries
+	// whose first word matches searchScope, the first word of the input when
+	// the search was started. See WithScopedHistorySearch.
+	scopedSearch bool
+	searchScope  string
+	// searchMode specifies the matching of the search key. During fuzzy
+	// search, fuzzy holds the entries matching fuzzyKey in rank order, and
+	// fuzzyPos is the position of the displayed entry within it. fuzzyRanked
+	// is true once the entries have been ranked. See WithHistorySearchMode.
+	searchMode  HistorySearchMode
+	fuzzy       []fuzzyMatch
+	fuzzyKey    string
+	fuzzyPos    int
+	fuzzyRanked bool
+	// substrKey is the text being searched for by substring search and
+	// substrText is the entry most recently displayed by it. Substring search is
+	// active if substrActive is true.
+	substrActive bool
+	substrKey    string
+	substrText   string
+	// searchPrompt formats the search prompt displayed below the input during
+	// incremental search. See the WithSearchPrompt option.
+	searchPrompt SearchPromptFunc
+	// collapse is true if multi-line entries are collapsed to their first line
+	// during navigation. collapsed holds the full text of the currently
+	// displayed entry if it is collapsed.
+	collapse  bool
+	collapsed string
+	// cursorMemory is true if the cursor position within each entry is
+	// remembered during history navigation. cursors holds the remembered
+	// positions, keyed by the index of the entry within entries (-1 for the
+	// pending input). See WithHistoryCursorMemory.
+	cursorMemory bool
+	cursors      map[int]int
+	// expandOnAccept is true if history expansion is performed when the input
+	// is accepted, and expandFunc determines whether each '!' is expanded. See
+	// WithHistoryExpansion.
+	expandOnAccept bool
+	expandFunc     HistoryExpandFunc
+	// storeEntries is the number of entries in the history store, counting
+	// those loaded and those since appended. The history store is compacted
+	// once it is oversized, in the background if compactDone is non-nil, which is
+	// closed when the compaction completes.
+	storeEntries int
+	compactDone  chan struct{}
+	// sanitize is invoked with each entry before it is written to the history
+	// file. unwritten is true if the most recent entry was suppressed by it, in
+	// which case the metadata attached to the entry isn't written either. See
+	// WithHistorySanitizer.
+	sanitize  func(entry string) (string, bool)
+	unwritten bool
+	// writePolicy specifies when entries are written to the history store.
+	// unsynced holds the entries and metadata buffered until the history is
+	// synced. See WithHistoryWritePolicy.
+	writePolicy HistoryWritePolicy
+	unsynced    []HistoryEntry
+	// dedup specifies the suppression of duplicate entries. When duplicates are
+	// erased, slots maps each entry to its index within entries. See
+	// WithHistoryDedup.
+	dedup HistoryDedup
+	slots map[string]int
+	// undo holds the undo list of each entry edited during history navigation,
+	// keyed by the index of the entry (-1 for the pending input). The undo
+	// lists are discarded when a line is read.
+	undo map[int]*undoList
 }
 
-// Load loads history entries from file. The history entries are expected to be
-// encoded one per line in the file with whitespace encoded as \000 octal
-// escapes. The first entry must be "_HiStOrY_V2_".
+// Load loads the history entries from the history store, which is the history
+// file if no store was configured.
 func (h *history) Load() error {
-	// This is the special value stored at the start of libedit history files.
-	const historyCookie = "_HiStOrY_V2_"
-
-	if h.path == "" {
-		return nil
+	if h.store == nil {
+		if h.path == "" {
+			return nil
+		}
+		h.store = &historyFile{path: h.path, mode: h.mode, sync: h.sync}
 	}
-
-	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	entries, err := h.store.Load()
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if f != nil {
-			f.Close()
+	for _, e := range entries {
+		h.add(e.Text)
+		if len(e.Meta) > 0 && h.annotatable {
+			h.annotate(e.Meta)
 		}
-	}()
+	}
+	// If the store is oversized, it is compacted after the first line is read
+	// rather than delaying startup.
+	h.storeEntries = len(entries)
+	// Only the entries added after loading may be annotated.
+	h.annotatable = false
+	return nil
+}
 
-	var n int
-	for s := bufio.NewScanner(f); s.Scan(); n++ {
-		text := s.Text()
-		if n == 0 {
-			if text != historyCookie {
-				return fmt.Errorf("malformed history cookie: %q != %q", text, historyCookie)
-			}
-			continue
+// Close closes the history store (if any), first writing the buffered entries
+// to it if the write policy is HistoryWriteOnClose, and compacting it if it is
+// oversized.
+func (h *history) Close() error {
+	if h.store != nil {
+		var err error
+		if h.writePolicy == HistoryWriteOnClose {
+			err = h.Sync()
 		}
-		v, err := decodeVis(text)
-		if err != nil {
-			return err
+		if compactErr := h.waitCompact(); err == nil {
+			err = compactErr
 		}
-		h.Add(v)
+		if closeErr := h.store.Close(); err == nil {
+			err = closeErr
+		}
+		return err
 	}
+	return nil
+}
 
-	if count := n - 1; count < 0 {
-		// If the history file was empty, write a cookie to initialize it.
-		fmt.Fprintf(f, "%s\n", historyCookie)
-	} else if count > (h.maxSize*5)/4 {
-		// The history file is 25% large than the max size, rewrite it.
-		f.Close()
-		f, err = os.OpenFile(h.path, os.O_CREATE|os.O_RDWR|os.O_APPEND|os.O_TRUNC, 0644)
-		if err != nil {
+// Sync writes the buffered entries to the history store, then commits the
+// store to stable storage if it implements HistorySyncer.
+func (h *history) Sync() error {
+	if h.store == nil {
+		return nil
+	}
+	for len(h.unsynced) > 0 {
+		if err := h.append(h.unsynced[0]); err != nil {
 			return err
 		}
-		fmt.Fprintf(f, "%s\n", historyCookie)
-		for i := len(h.entries) - 1; i >= 0; i-- {
-			fmt.Fprintf(f, "%s\n", encodeVis(h.entry(i)))
-		}
+		h.unsynced = h.unsynced[1:]
+	}
+	h.unsynced = nil
+	if s, ok := h.store.(HistorySyncer); ok {
+		return s.Sync()
 	}
-
-	h.file, f = f, nil
 	return nil
 }
 
-// Close closes the history file (if one is open).
-func (h *history) Close() error {
-	if h.file != nil {
-		if err := h.file.Close(); err != nil {
-			return err
-		}
+// write appends entry to the history store, or buffers it until the history
+// is synced if the write policy defers writes.
+func (h *history) write(entry HistoryEntry) error {
+	if h.writePolicy == HistoryWriteOnAccept {
+		return h.append(entry)
 	}
+	h.unsynced = append(h.unsynced, entry)
 	return nil
 }
 
+// append appends entry to the history store.
+func (h *history) append(entry HistoryEntry) error {
+	if entry.Text != "" {
+		h.storeEntries++
+	}
+	return h.store.Append(entry)
+}
+
 // Add adds a new entry to history, overwriting the oldest entry if the max
 // number of history entries has been reached. The current index in the history
-// navigation is reset.
-func (h *history) Add(s string) {
+// navigation is reset. If there is a history store, the entry is appended to it,
+// as sanitized if a sanitizer is configured, or buffered if the write policy
+// defers writes. An error is returned if the entry could not be appended to the
+// history store.
+func (h *history) Add(s string) error {
+	s, ok := h.add(s)
+	if !ok {
+		return nil
+	}
+	if h.store != nil {
+		if h.sanitize != nil {
+			if s, ok = h.sanitize(s); !ok {
+				h.unwritten = true
+				return nil
+			}
+		}
+		h.unwritten = false
+		return h.write(HistoryEntry{Text: s})
+	}
+	return nil
+}
+
+// add adds a new entry to the in-memory history, returning the entry as added
+// and false if the entry was not added.
+func (h *history) add(s string) (string, bool) {
+	h.annotatable = false
 	if h.maxSize == 0 {
 		// History is disabled.
-		debugPrintf("history: disabled\n")
-		return
+		h.debug.Printf("history: disabled\n")
+		return s, false
 	}
-	if h.entry(0) == s {
-		// Don't add a new entry if it is identical to the previous entry.
-		debugPrintf("history: elide duplicate\n")
-		return
+	if h.maxEntryLen > 0 && len(s) > h.maxEntryLen {
+		if !h.truncateEntries {
+			// Don't add entries which exceed the length limit.
+			h.debug.Printf("history: elide long entry\n")
+			return s, false
+		}
+		t := truncateEntry(s, h.maxEntryLen)
+		if t == "" {
+			// Don't add entries whose first character exceeds the length limit.
+			h.debug.Printf("history: elide long entry\n")
+			return s, false
+		}
+		s = t
 	}
-	if h.maxSize == -1 || len(h.entries) < h.maxSize {
-		h.entries = append(h.entries, "")
+	if h.dedup != HistoryDedupNone && h.entry(0) == s {
+		// Don't add a new entry if it is identical to the previous entry. The
+		// previous entry is annotated in its place.
+		h.debug.Printf("history: elide duplicate\n")
+		h.annotatable = len(h.entries) > 0
+		return s, false
+	}
+	if i, ok := h.slots[s]; ok {
+		// Erase the earlier duplicate, vacating the head for the new entry.
+		h.debug.Printf("history: erase duplicate\n")
+		h.erase(i)
+	} else {
+		if h.maxSize == -1 || len(h.entries) < h.maxSize {
+			h.entries = append(h.entries, "")
+			h.meta = append(h.meta, nil)
+		}
+		h.head = (h.head + 1) % len(h.entries)
+		if i, ok := h.slots[h.entries[h.head]]; ok && i == h.head {
+			delete(h.slots, h.entries[h.head])
+		}
 	}
-	h.head = (h.head + 1) % len(h.entries)
 	h.entries[h.head] = s
+	h.meta[h.head] = nil
+	if h.dedup == HistoryDedupEraseOldest {
+		if h.slots == nil {
+			h.slots = make(map[string]int)
+		}
+		h.slots[s] = h.head
+	}
+	delete(h.cursors, h.head)
+	delete(h.cursors, -1)
+	h.annotatable = true
 	h.index = -1
+	h.collapsed = ""
+	return s, true
+}
 
-	// If we have a history file, append the new entry.
-	if h.file != nil {
-		fmt.Fprintf(h.file, "%s\n", encodeVis(s))
+// erase removes the entry at index i within entries, shifting the more recent
+// entries, along with their metadata and remembered cursor positions, into the
+// preceding slots of the circular buffer. The slot of the most recent entry is
+// left to be overwritten.
+func (h *history) erase(i int) {
+	for i != h.head {
+		next := (i + 1) % len(h.entries)
+		h.entries[i] = h.entries[next]
+		h.meta[i] = h.meta[next]
+		h.slots[h.entries[i]] = i
+		if pos, ok := h.cursors[next]; ok {
+			h.cursors[i] = pos
+		} else {
+			delete(h.cursors, i)
+		}
+		i = next
 	}
 }
 
+// AnnotateLast attaches metadata to the most recently added history entry,
+// merging it with any metadata previously attached to the entry. If there is a
+// history store, the metadata is appended to it, or buffered along with the
+// entry if the write policy defers writes.
+// AnnotateLast has no effect if the most recently read line was not added to
+// history.
+func (h *history) AnnotateLast(meta map[string]string) error {
+	for k := range meta {
+		if k == "" || strings.ContainsRune(k, '=') {
+			return fmt.Errorf("invalid history metadata key: %q", k)
+		}
+	}
+	if !h.annotatable || len(meta) == 0 {
+		return nil
+	}
+	h.annotate(meta)
+	if h.store != nil && !h.unwritten {
+		m := make(map[string]string, len(meta))
+		for k, v := range meta {
+			m[k] = v
+		}
+		return h.write(HistoryEntry{Meta: m})
+	}
+	return nil
+}
+
+// annotate merges meta into the metadata of the most recent entry.
+func (h *history) annotate(meta map[string]string) {
+	m := h.meta[h.head]
+	if m == nil {
+		m = make(map[string]string, len(meta))
+		h.meta[h.head] = m
+	}
+	for k, v := range meta {
+		m[k] = v
+	}
+}
+
+// entryMeta returns the metadata attached to entry n, or nil if there is none.
+func (h *history) entryMeta(n int) map[string]string {
+	if n == -1 {
+		return nil
+	}
+	i := h.entryIndex(n)
+	if i == -1 || i >= len(h.meta) {
+		return nil
+	}
+	return h.meta[i]
+}
+
+// historyMetaPrefix begins the lines of the history file holding the metadata
+// attached to the preceding entry by AnnotateLastHistory. The visual encoding
+// of entries never produces this prefix as backslashes are encoded as \134.
+// Note that libedit itself reads the metadata lines as entries.
+const historyMetaPrefix = `\$`
+
+// encodeHistoryMeta encodes metadata as a line of the history file. The
+// metadata is encoded as space separated key=value pairs, sorted by key, with
+// the keys and values encoded using the visual encoding.
+func encodeHistoryMeta(meta map[string]string) string {
+	var buf strings.Builder
+	buf.WriteString(historyMetaPrefix)
+	for i, k := range sortedMetaKeys(meta) {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(libedit.EncodeVis(k))
+		buf.WriteByte('=')
+		buf.WriteString(libedit.EncodeVis(meta[k]))
+	}
+	return buf.String()
+}
+
+// decodeHistoryMeta decodes a line of the history file encoded by
+// encodeHistoryMeta.
+func decodeHistoryMeta(line string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Fields(strings.TrimPrefix(line, historyMetaPrefix)) {
+		i := strings.IndexByte(pair, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("malformed history metadata: %q", pair)
+		}
+		k, err := libedit.DecodeVis(pair[:i])
+		if err != nil {
+			return nil, err
+		}
+		v, err := libedit.DecodeVis(pair[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		meta[k] = v
+	}
+	return meta, nil
+}
+
+// formatHistoryMeta formats metadata for display as space separated key=value
+// pairs, sorted by key.
+func formatHistoryMeta(meta map[string]string) string {
+	var buf strings.Builder
+	for i, k := range sortedMetaKeys(meta) {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		fmt.Fprintf(&buf, "%s=%s", k, meta[k])
+	}
+	return buf.String()
+}
+
+func sortedMetaKeys(meta map[string]string) []string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// showMeta displays the metadata attached to the current history entry below
+// the input, or removes the display if there is no metadata.
+func (h *history) showMeta(s *state) {
+	var a Annotation
+	if meta := h.entryMeta(h.index); len(meta) > 0 {
+		a = Annotation{
+			Text:     "\n" + formatHistoryMeta(meta),
+			Priority: AnnotationPriorityHistoryMeta,
+		}
+	}
+	s.annotations.Set(&s.screen, annotationHistoryMeta, a)
+}
+
+// truncateEntry truncates s to at most maxLen bytes without splitting a UTF-8
+// encoded character.
+func truncateEntry(s string, maxLen int) string {
+	n := maxLen
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
 // Next saves the current history entry, advances to the next entry, and sets
 // that entry as the input text. If history search is active next advances to
 // the next forward search result.
@@ -160,11 +558,11 @@ func (h *history) Next(s *state) (bool, error) {
 	if h.index == -1 {
 		return false, nil
 	}
-	h.save(s.screen.Text())
+	h.save(h.text(s))
+	h.rememberCursor(s)
 	h.index--
-	s.screen.MoveTo(0)
-	s.screen.EraseTo(s.screen.End())
-	s.screen.Insert([]rune(h.entry(h.index))...)
+	h.show(s, h.entry(h.index), -1, -1)
+	h.restoreCursor(s)
 	return true, nil
 }
 
@@ -178,11 +576,64 @@ func (h *history) Previous(s *state) (bool, error) {
 	if h.index+1 >= len(h.entries) {
 		return false, nil
 	}
-	h.save(s.screen.Text())
+	h.save(h.text(s))
+	h.rememberCursor(s)
 	h.index++
-	s.screen.MoveTo(0)
-	s.screen.EraseTo(s.screen.End())
-	s.screen.Insert([]rune(h.entry(h.index))...)
+	h.show(s, h.entry(h.index), -1, -1)
+	h.restoreCursor(s)
+	return true, nil
+}
+
+// rememberCursor records the cursor position within the current entry if
+// cursor memory is enabled.
+func (h *history) rememberCursor(s *state) {
+	if !h.cursorMemory {
+		return
+	}
+	key := -1
+	if h.index != -1 {
+		key = h.entryIndex(h.index)
+	}
+	if h.cursors == nil {
+		h.cursors = make(map[int]int)
+	}
+	h.cursors[key] = s.screen.Position()
+}
+
+// restoreCursor moves the cursor to the remembered position within the current
+// entry, if any. The cursor is left at the end of the entry otherwise.
+func (h *history) restoreCursor(s *state) {
+	key := -1
+	if h.index != -1 {
+		key = h.entryIndex(h.index)
+	}
+	if pos, ok := h.cursors[key]; ok && pos < len(s.screen.Text()) {
+		s.screen.MoveTo(pos)
+	}
+}
+
+// SubstringSearchBackward advances to the previous history entry which contains
+// the search key, and sets that entry as the input text with the occurrence of
+// the search key highlighted. The search key is the input text at the time
+// substring search was started. If the input text is empty, or history search
+// is active, SubstringSearchBackward behaves like Previous.
+func (h *history) SubstringSearchBackward(s *state) (bool, error) {
+	if h.searchDir != 0 || !h.maybeInitSubstringSearch(s) {
+		return h.Previous(s)
+	}
+	h.substringSearch(s, +1)
+	return true, nil
+}
+
+// SubstringSearchForward advances to the next history entry which contains the
+// search key. Advancing past the most recent entry restores the input text
+// which was being edited. If the input text is empty, or history search is
+// active, SubstringSearchForward behaves like Next.
+func (h *history) SubstringSearchForward(s *state) (bool, error) {
+	if h.searchDir != 0 || !h.maybeInitSubstringSearch(s) {
+		return h.Next(s)
+	}
+	h.substringSearch(s, -1)
 	return true, nil
 }
 
@@ -206,11 +657,26 @@ func (h *history) CancelSearch(s *state) (bool, error) {
 	if h.searchDir == 0 {
 		return false, nil
 	}
-	s.screen.SetSuffix(nil)
+	s.annotations.Set(&s.screen, annotationSearch, Annotation{})
+	if h.searchHighlighted {
+		h.searchHighlighted = false
+		text := append([]rune(nil), s.screen.Text()...)
+		pos := s.screen.Position()
+		s.screen.MoveTo(0)
+		s.screen.EraseTo(s.screen.End())
+		s.screen.Insert(text...)
+		s.screen.MoveTo(pos)
+	}
 	h.searchDir = 0
 	h.searchMatched = false
 	h.searchKey = ""
 	h.searchMatchedKey = ""
+	h.searchRegexp = false
+	h.searchRE = nil
+	h.searchREKey = ""
+	h.searchPattern = false
+	h.searchScope = ""
+	h.resetFuzzy()
 	return true, nil
 }
 
@@ -232,6 +698,33 @@ func (h *history) ReverseSearch(s *state) (bool, error) {
 	return true, nil
 }
 
+// PatternSearch starts history search if inactive, with the search key
+// interpreted as a regular expression, and switches to the specified direction
+// of search. The regular expression is compiled as the search key is edited,
+// and while it is invalid (such as while a group is being typed) the search
+// key is matched literally.
+func (h *history) PatternSearch(s *state, dir int) (bool, error) {
+	if h.searchDir == 0 {
+		h.maybeInitSearch(s)
+		h.searchRegexp = true
+		h.searchPattern = true
+	}
+	h.searchDir = dir
+	h.updateSearch(s, true /* advance */)
+	return true, nil
+}
+
+// ToggleSearchRegexp toggles whether the search key is interpreted as a regular
+// expression or matched literally.
+func (h *history) ToggleSearchRegexp(s *state) (bool, error) {
+	if h.searchDir == 0 {
+		return false, nil
+	}
+	h.searchRegexp = !h.searchRegexp
+	h.updateSearch(s, false /* advance */)
+	return true, nil
+}
+
 // AppendSearchKey appends the specified character to the search key.
 func (h *history) AppendSearchKey(s *state, key rune) (bool, error) {
 	if h.searchDir == 0 {
@@ -304,6 +797,24 @@ func (h *history) entryIndex(n int) int {
 	return index
 }
 
+// undoList returns the undo list of entry i, creating it if necessary.
+func (h *history) undoList(i int) *undoList {
+	u := h.undo[i]
+	if u == nil {
+		if h.undo == nil {
+			h.undo = make(map[int]*undoList)
+		}
+		u = &undoList{}
+		h.undo[i] = u
+	}
+	return u
+}
+
+// ResetUndo discards the undo lists of the entries.
+func (h *history) ResetUndo() {
+	h.undo = nil
+}
+
 func (h *history) save(cur []rune) {
 	if h.index == -1 {
 		h.pending = string(cur)
@@ -313,15 +824,27 @@ func (h *history) save(cur []rune) {
 	if index == -1 {
 		return
 	}
+	if i, ok := h.slots[h.entries[index]]; ok && i == index {
+		delete(h.slots, h.entries[index])
+	}
 	h.entries[index] = string(cur)
+	if h.dedup == HistoryDedupEraseOldest {
+		if h.slots == nil {
+			h.slots = make(map[string]int)
+		}
+		h.slots[h.entries[index]] = index
+	}
 }
 
 func (h *history) searchEntry(s *state, i int, advance bool) bool {
 	var pos int
 	entry := h.entry(i)
 
-	switch h.searchDir {
-	case +1:
+	switch {
+	case h.searchRegexp:
+		pos = h.searchEntryRegexp(s, i, entry, advance)
+
+	case h.searchDir == +1:
 		var n int
 		if i == h.index {
 			n = s.screen.Position()
@@ -337,7 +860,7 @@ func (h *history) searchEntry(s *state, i int, advance bool) bool {
 			pos += n
 		}
 
-	case -1:
+	case h.searchDir == -1:
 		n := len(entry)
 		if i == h.index {
 			n = s.screen.Position() + len(h.searchKey)
@@ -357,23 +880,216 @@ func (h *history) searchEntry(s *state, i int, advance bool) bool {
 	if pos == -1 {
 		return false
 	}
+	h.showSearchMatch(s, i, pos)
+	return true
+}
 
+// showSearchMatch sets entry i as the input text with the cursor positioned at
+// the match of the search key at byte offset pos. The match is highlighted,
+// along with the other occurrences of the search key within the entry.
+func (h *history) showSearchMatch(s *state, i int, pos int) {
 	h.save(s.screen.Text())
 	h.index = i
+	entry := h.entry(i)
+
+	// The current match may overlap occurrences of the search key which
+	// precede it, and those occurrences are not highlighted.
+	cur := []int{pos, pos + len(h.searchKey)}
+	if re := h.compileSearchKey(); h.searchRegexp && re != nil {
+		if m := re.FindStringIndex(entry[pos:]); m != nil {
+			cur[1] = pos + m[1]
+		}
+	}
+	var matches [][]int
+	for _, m := range h.occurrences(entry) {
+		if m[1] <= cur[0] || m[0] >= cur[1] {
+			matches = append(matches, m)
+		}
+	}
+	matches = append(matches, cur)
+	sort.Slice(matches, func(i, j int) bool { return matches[i][0] < matches[j][0] })
+
 	s.screen.MoveTo(0)
 	s.screen.EraseTo(s.screen.End())
-	s.screen.Insert([]rune(entry)...)
+	var last int
+	for _, m := range matches {
+		style := s.theme.SearchOccurrence
+		if m[0] == pos {
+			style = s.theme.SearchMatch
+		}
+		s.screen.Insert([]rune(entry[last:m[0]])...)
+		s.screen.SetAttrs(string(style))
+		s.screen.Insert([]rune(entry[m[0]:m[1]])...)
+		s.screen.SetAttrs("")
+		last = m[1]
+	}
+	s.screen.Insert([]rune(entry[last:])...)
 	s.screen.MoveTo(utf8.RuneCountInString(entry[:pos]))
-	return true
+	h.searchHighlighted = true
+	h.showMeta(s)
+}
+
+// occurrences returns the byte offsets of the non-overlapping, non-empty
+// occurrences of the search key within entry. Fuzzy matches have no
+// occurrences.
+func (h *history) occurrences(entry string) [][]int {
+	if len(h.searchKey) == 0 || h.fuzzySearch() {
+		return nil
+	}
+	if !h.searchRegexp {
+		var matches [][]int
+		for i := 0; ; {
+			j := strings.Index(entry[i:], h.searchKey)
+			if j == -1 {
+				return matches
+			}
+			i += j
+			matches = append(matches, []int{i, i + len(h.searchKey)})
+			i += len(h.searchKey)
+		}
+	}
+	re := h.compileSearchKey()
+	if re == nil {
+		return nil
+	}
+	var matches [][]int
+	for _, m := range re.FindAllStringIndex(entry, -1) {
+		if m[0] < m[1] {
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// NextOccurrence moves to the next occurrence of the search key within the
+// current entry during incremental search. If there are no more occurrences in
+// the entry, it moves to the first occurrence in the next matching entry in the
+// direction of the search.
+func (h *history) NextOccurrence(s *state) (bool, error) {
+	if h.searchDir == 0 {
+		return false, nil
+	}
+	cur := len(string(s.screen.Text()[:s.screen.Position()]))
+	for _, m := range h.occurrences(h.entry(h.index)) {
+		if m[0] > cur {
+			h.showSearchMatch(s, h.index, m[0])
+			h.updateSearchPrompt(s)
+			return true, nil
+		}
+	}
+	for i := h.index - h.searchDir; i >= -1 && i < len(h.entries); i -= h.searchDir {
+		if !h.inSearchScope(i) {
+			continue
+		}
+		if matches := h.occurrences(h.entry(i)); len(matches) > 0 {
+			h.showSearchMatch(s, i, matches[0][0])
+			h.updateSearchPrompt(s)
+			return true, nil
+		}
+	}
+	return true, nil
+}
+
+// PrevOccurrence moves to the previous occurrence of the search key within the
+// current entry during incremental search. If there are no earlier occurrences
+// in the entry, it moves to the last occurrence in the previous matching entry,
+// reversing NextOccurrence.
+func (h *history) PrevOccurrence(s *state) (bool, error) {
+	if h.searchDir == 0 {
+		return false, nil
+	}
+	cur := len(string(s.screen.Text()[:s.screen.Position()]))
+	matches := h.occurrences(h.entry(h.index))
+	for j := len(matches) - 1; j >= 0; j-- {
+		if matches[j][0] < cur {
+			h.showSearchMatch(s, h.index, matches[j][0])
+			h.updateSearchPrompt(s)
+			return true, nil
+		}
+	}
+	for i := h.index + h.searchDir; i >= -1 && i < len(h.entries); i += h.searchDir {
+		if !h.inSearchScope(i) {
+			continue
+		}
+		if matches := h.occurrences(h.entry(i)); len(matches) > 0 {
+			h.showSearchMatch(s, i, matches[len(matches)-1][0])
+			h.updateSearchPrompt(s)
+			return true, nil
+		}
+	}
+	return true, nil
+}
+
+// compileSearchKey returns the compiled regular expression of the search key,
+// caching the compilation, or nil if the search key is not a valid regular
+// expression. During pattern search, an invalid search key is compiled to a
+// regular expression matching it literally instead.
+func (h *history) compileSearchKey() *regexp.Regexp {
+	if h.searchRE != nil && h.searchREKey == h.searchKey {
+		return h.searchRE
+	}
+	h.searchRE, h.searchREKey = nil, h.searchKey
+	re, err := regexp.Compile(h.searchKey)
+	if err != nil && h.searchPattern {
+		re, err = regexp.Compile(regexp.QuoteMeta(h.searchKey))
+	}
+	if err == nil {
+		h.searchRE = re
+	}
+	return h.searchRE
+}
+
+// searchEntryRegexp returns the byte offset of the match of the search key
+// regular expression within entry, or -1 if there is no match. If entry i is
+// the current entry, the match must be after (forward search) or before
+// (reverse search) the current cursor position.
+func (h *history) searchEntryRegexp(s *state, i int, entry string, advance bool) int {
+	re := h.compileSearchKey()
+	if re == nil {
+		return -1
+	}
+
+	matches := re.FindAllStringIndex(entry, -1)
+	if i != h.index {
+		switch {
+		case len(matches) == 0:
+			return -1
+		case h.searchDir > 0:
+			return matches[0][0]
+		default:
+			return matches[len(matches)-1][0]
+		}
+	}
+
+	cur := len(string(s.screen.Text()[:s.screen.Position()]))
+	if h.searchDir > 0 {
+		for _, m := range matches {
+			if m[0] > cur || m[0] == cur && !advance {
+				return m[0]
+			}
+		}
+		return -1
+	}
+	for j := len(matches) - 1; j >= 0; j-- {
+		if m := matches[j]; m[0] < cur || m[0] == cur && !advance {
+			return m[0]
+		}
+	}
+	return -1
 }
 
 func (h *history) updateSearch(s *state, advance bool) {
 	h.searchMatched = false
-	if len(h.searchKey) > 0 {
+	if h.fuzzySearch() {
+		h.updateFuzzySearch(s, advance)
+		if h.searchMatched {
+			h.searchMatchedKey = h.searchKey
+		}
+	} else if len(h.searchKey) > 0 {
 		switch h.searchDir {
 		case +1:
 			for i := h.index; i >= -1; i-- {
-				if h.searchEntry(s, i, advance) {
+				if h.inSearchScope(i) && h.searchEntry(s, i, advance) {
 					h.searchMatched = true
 					h.searchMatchedKey = h.searchKey
 					break
@@ -382,7 +1098,7 @@ func (h *history) updateSearch(s *state, advance bool) {
 
 		case -1:
 			for i := h.index; i < len(h.entries); i++ {
-				if h.searchEntry(s, i, advance) {
+				if h.inSearchScope(i) && h.searchEntry(s, i, advance) {
 					h.searchMatched = true
 					h.searchMatchedKey = h.searchKey
 					break
@@ -391,18 +1107,225 @@ func (h *history) updateSearch(s *state, advance bool) {
 		}
 	}
 
+	h.updateSearchPrompt(s)
+}
+
+// updateSearchPrompt displays the search prompt below the input.
+func (h *history) updateSearchPrompt(s *state) {
 	dir := "fwd"
 	if h.searchDir < 0 {
 		dir = "bck"
 	}
+	if h.searchRegexp {
+		dir += "-re"
+	} else if h.fuzzySearch() {
+		dir += "-fuzzy"
+	}
+	matched := len(h.searchKey) == 0 || h.searchMatched
+
+	var prompt string
+	if h.searchPrompt == nil {
+		prompt = defaultSearchPrompt(dir, h.searchKey, matched, 0, 0)
+	} else {
+		n, total := h.searchMatchCounts()
+		prompt = h.searchPrompt(dir, h.searchKey, matched, n, total)
+	}
+	s.annotations.Set(&s.screen, annotationSearch, Annotation{
+		Text:     "\n" + prompt,
+		Priority: AnnotationPrioritySearch,
+	})
+}
+
+// SearchPromptFunc formats the prompt displayed on the line below the input
+// during incremental history search. The dir is "bck" for reverse search and
+// "fwd" for forward search, suffixed with "-re" if regexp search is enabled (or
+// for pattern search) or "-fuzzy" if fuzzy search is enabled (see
+// WithHistorySearchMode). The key is the search key, and matched is false if
+// the search key failed to match. The n and total are the position of the
+// matching history entry among the entries that match the search key, counting
+// from the most recent entry (or from the best match for fuzzy search) starting
+// at 1, and the number of such entries. The n is 0 if the match is within the
+// pending input rather than a history entry, or if there is no match.
+type SearchPromptFunc func(dir, key string, matched bool, n, total int) string
+
+// defaultSearchPrompt formats the search prompt as "bck:`key'", with the ':'
+// replaced by '?' if the search failed.
+func defaultSearchPrompt(dir, key string, matched bool, n, total int) string {
+	sep := "?"
+	if matched {
+		sep = ":"
+	}
+	return fmt.Sprintf("%s%s`%s'", dir, sep, key)
+}
+
+// searchMatchCounts returns the position of the current entry among the
+// history entries matching the search key, counting from the most recent
+// entry, and the number of matching entries. The position is 0 if the search
+// failed or the current entry is the pending input.
+func (h *history) searchMatchCounts() (n, total int) {
+	if len(h.searchKey) == 0 {
+		return 0, 0
+	}
+	if h.fuzzySearch() {
+		if h.searchMatched {
+			n = h.fuzzyPos + 1
+		}
+		return n, len(h.fuzzy)
+	}
+	matches := func(entry string) bool {
+		return strings.Contains(entry, h.searchKey)
+	}
+	if h.searchRegexp {
+		re := h.compileSearchKey()
+		if re == nil {
+			return 0, 0
+		}
+		matches = re.MatchString
+	}
+	for i := 0; i < len(h.entries); i++ {
+		if h.inSearchScope(i) && matches(h.entry(i)) {
+			total++
+			if h.searchMatched && i <= h.index {
+				n++
+			}
+		}
+	}
+	return n, total
+}
+
+// show sets entry as the input text, highlighting entry[hlStart:hlEnd] if
+// hlStart is not -1. If collapsing of multi-line entries is enabled, only the
+// first line of a multi-line entry is displayed followed by an indication of
+// the number of additional lines.
+func (h *history) show(s *state, entry string, hlStart, hlEnd int) {
+	wasCollapsed := h.collapsed != ""
+	h.collapsed = ""
+	h.showMeta(s)
+
+	var suffix string
+	if h.collapse {
+		if i := strings.IndexByte(entry, '\n'); i != -1 {
+			h.collapsed = entry
+			n := strings.Count(entry[i:], "\n")
+			if n == 1 {
+				suffix = " … (+1 line)"
+			} else {
+				suffix = fmt.Sprintf(" … (+%d lines)", n)
+			}
+			entry = entry[:i]
+			if hlStart >= i {
+				hlStart, hlEnd = -1, -1
+			} else if hlEnd > i {
+				hlEnd = i
+			}
+		}
+	}
+
+	s.screen.MoveTo(0)
+	s.screen.EraseTo(s.screen.End())
+	if wasCollapsed || h.collapsed != "" {
+		s.annotations.Set(&s.screen, annotationCollapsed, Annotation{
+			Text:     suffix,
+			Priority: AnnotationPriorityCollapsed,
+		})
+	}
+	if hlStart == -1 {
+		s.screen.Insert([]rune(entry)...)
+		return
+	}
+	s.screen.Insert([]rune(entry[:hlStart])...)
+	s.screen.SetAttrs(string(s.theme.SearchMatch))
+	s.screen.Insert([]rune(entry[hlStart:hlEnd])...)
+	s.screen.SetAttrs("")
+	s.screen.Insert([]rune(entry[hlEnd:])...)
+}
+
+// text returns the full input text, including the hidden lines of a collapsed
+// history entry.
+func (h *history) text(s *state) []rune {
+	if h.collapsed != "" {
+		return []rune(h.collapsed)
+	}
+	return s.screen.Text()
+}
+
+// Expand displays all of the lines of a collapsed history entry.
+func (h *history) Expand(s *state) (bool, error) {
+	if h.collapsed == "" {
+		return true, nil
+	}
+	text := []rune(h.collapsed)
+	h.collapsed = ""
+
+	pos := s.screen.Position()
+	s.annotations.Set(&s.screen, annotationCollapsed, Annotation{})
+	s.screen.MoveTo(0)
+	s.screen.EraseTo(s.screen.End())
+	s.screen.Insert(text...)
+	s.screen.MoveTo(pos)
+	return true, nil
+}
+
+// MaybeExpand expands a collapsed history entry before any command other than
+// history navigation is performed.
+func (h *history) MaybeExpand(s *state, cmd command) error {
+	switch cmd {
+	case cmdNextHistory, cmdPreviousHistory, cmdSubstrSearchBackward, cmdSubstrSearchForward, cmdExpandHistory:
+		return nil
+	}
+	_, err := h.Expand(s)
+	return err
+}
+
+// maybeInitSubstringSearch starts substring search if it is inactive or if the
+// input text was changed since the last substring search. Returns false if
+// there is no text to search for.
+func (h *history) maybeInitSubstringSearch(s *state) bool {
+	text := string(h.text(s))
+	if !h.substrActive || text != h.substrText {
+		h.substrActive = text != ""
+		h.substrKey = text
+		h.substrText = text
+	}
+	return h.substrActive
+}
+
+// substringSearch searches for the next entry containing the search key in the
+// specified direction (+1 for older entries, -1 for newer entries).
+func (h *history) substringSearch(s *state, dir int) {
+	for i := h.index + dir; i >= -1 && i < len(h.entries); i += dir {
+		entry := h.entry(i)
+		if i != -1 && (entry == h.substrText || !strings.Contains(entry, h.substrKey)) {
+			continue
+		}
+		h.save(h.text(s))
+		h.index = i
+		h.substrText = entry
+		if pos := strings.Index(entry, h.substrKey); pos != -1 {
+			h.show(s, entry, pos, pos+len(h.substrKey))
+		} else {
+			h.show(s, entry, -1, -1)
+		}
+		return
+	}
+}
 
-	matched := "?"
-	if len(h.searchKey) == 0 || h.searchMatched {
-		matched = ":"
+// endSubstringSearch ends substring search if active, removing the
+// highlighting of the search key from the input text.
+func (h *history) endSubstringSearch(s *state) {
+	if !h.substrActive {
+		return
 	}
+	h.substrActive = false
+	h.substrKey = ""
+	h.substrText = ""
 
-	newSuffix := fmt.Sprintf("\n%s%s`%s'", dir, matched, h.searchKey)
-	s.screen.SetSuffix([]rune(newSuffix))
+	text := append([]rune(nil), s.screen.Text()...)
+	pos := s.screen.Position()
+	s.screen.MoveTo(0)
+	s.screen.EraseTo(s.screen.End())
+	s.screen.Insert(text...)
+	s.screen.MoveTo(pos)
 }
 
 func (h *history) maybeInitSearch(s *state) {
@@ -413,5 +1336,29 @@ func (h *history) maybeInitSearch(s *state) {
 		h.index = -1
 	}
 	h.save(s.screen.Text())
+	h.rememberCursor(s)
 	h.searchMatchedKey = ""
+	h.resetFuzzy()
+	h.searchScope = ""
+	if h.scopedSearch {
+		h.searchScope = firstWord(string(s.screen.Text()))
+	}
+}
+
+// inSearchScope returns true if entry i is within the scope of the search. The
+// pending input is always within the scope.
+func (h *history) inSearchScope(i int) bool {
+	if h.searchScope == "" || i == -1 {
+		return true
+	}
+	return strings.EqualFold(firstWord(h.entry(i)), h.searchScope)
+}
+
+// firstWord returns the first whitespace delimited word of text.
+func firstWord(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
 }
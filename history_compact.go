@@ -0,0 +1,52 @@
+package prompt
+
+// oversized returns true if the history store holds more than 25% more
+// entries than the max history size, and should be compacted.
+func (h *history) oversized() bool {
+	return h.store != nil && h.maxSize >= 0 && h.storeEntries > (h.maxSize*5)/4
+}
+
+// maybeCompact starts compacting the history store in the background if it is
+// oversized, by rewriting it to hold only the most recent max history size
+// entries. It is invoked once ReadLine returns, so that the compaction neither
+// delays startup nor the processing of input.
+func (h *history) maybeCompact() {
+	if h.compactDone != nil {
+		select {
+		case <-h.compactDone:
+			h.compactDone = nil
+		default:
+			// A compaction is already in progress.
+			return
+		}
+	}
+	if !h.oversized() {
+		return
+	}
+
+	// The entries added during the compaction are appended to the compacted
+	// store.
+	h.storeEntries = h.maxSize
+	done := make(chan struct{})
+	h.compactDone = done
+	go func(store HistoryStore, maxSize int) {
+		defer close(done)
+		if err := store.Rewrite(maxSize); err != nil {
+			h.debug.Printf("history: compact: %v\n", err)
+		}
+	}(h.store, h.maxSize)
+}
+
+// waitCompact waits for a background compaction of the history store to
+// complete, then compacts the history store if it is still oversized.
+func (h *history) waitCompact() error {
+	if h.compactDone != nil {
+		<-h.compactDone
+		h.compactDone = nil
+	}
+	if !h.oversized() {
+		return nil
+	}
+	h.storeEntries = h.maxSize
+	return h.store.Rewrite(h.maxSize)
+}
@@ -0,0 +1,117 @@
+package prompt
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// HistoryCompletion specifies how history entries are used to compute
+// completions. See WithHistoryCompletion.
+type HistoryCompletion int
+
+const (
+	// NoHistoryCompletion disables completion from history.
+	NoHistoryCompletion HistoryCompletion = iota
+	// HistoryCompletionWords completes the word at the cursor using the words
+	// of history entries.
+	HistoryCompletionWords
+	// HistoryCompletionLines completes the input text using the history entries
+	// which begin with it. Completion is only performed when the cursor is at
+	// the end of the input.
+	HistoryCompletionLines
+)
+
+// historyCompletionHalfLife is the number of entries after which the weight of
+// an occurrence of a completion candidate in history halves.
+const historyCompletionHalfLife = 50
+
+// Complete returns the completions of the word delineated by
+// [wordStart,wordEnd) within text drawn from the history entries, ranked by
+// frecency. Each entry containing a candidate contributes a weight to the
+// candidate which decays with the age of the entry, so frequently used
+// candidates rank highly and recently used candidates rank above older ones
+// that were used as often. Candidates which are identical to the text being
+// completed are excluded. isWord determines the characters which form the
+// words of the entries.
+func (h *history) Complete(
+	mode HistoryCompletion, text []rune, wordStart, wordEnd int, isWord func(r rune) bool,
+) []string {
+	type candidate struct {
+		text  string
+		score float64
+		// age is the age of the most recent entry containing the candidate and is
+		// used to break ties.
+		age int
+	}
+	var candidates []*candidate
+	index := make(map[string]*candidate)
+	add := func(s string, age int, seen map[string]bool) {
+		if seen != nil {
+			if seen[s] {
+				return
+			}
+			seen[s] = true
+		}
+		c := index[s]
+		if c == nil {
+			c = &candidate{text: s, age: age}
+			index[s] = c
+			candidates = append(candidates, c)
+		}
+		c.score += math.Exp2(-float64(age) / historyCompletionHalfLife)
+	}
+
+	switch mode {
+	case HistoryCompletionWords:
+		word := string(text[wordStart:wordEnd])
+		seen := make(map[string]bool)
+		for age := 0; age < len(h.entries); age++ {
+			for k := range seen {
+				delete(seen, k)
+			}
+			entry := []rune(h.entry(age))
+			for i := 0; i < len(entry); {
+				if !isWord(entry[i]) {
+					i++
+					continue
+				}
+				j := i
+				for j < len(entry) && isWord(entry[j]) {
+					j++
+				}
+				if w := string(entry[i:j]); len(w) > len(word) && strings.HasPrefix(w, word) {
+					add(w, age, seen)
+				}
+				i = j
+			}
+		}
+
+	case HistoryCompletionLines:
+		if wordEnd != len(text) {
+			return nil
+		}
+		line := string(text)
+		before := string(text[:wordStart])
+		for age := 0; age < len(h.entries); age++ {
+			if entry := h.entry(age); len(entry) > len(line) && strings.HasPrefix(entry, line) {
+				add(entry[len(before):], age, nil)
+			}
+		}
+
+	default:
+		return nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].age < candidates[j].age
+	})
+	var completions []string
+	for _, c := range candidates {
+		completions = append(completions, c.text)
+	}
+	return completions
+}
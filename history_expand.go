@@ -0,0 +1,150 @@
+package prompt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// HistoryExpandFunc is invoked during history expansion with the input text
+// and the position of a '!' within it, as a rune offset. It returns false if
+// the '!' should be left as is rather than expanded, such as within a quoted
+// string. See WithHistoryExpansion.
+type HistoryExpandFunc func(text []rune, pos int) bool
+
+// expandHistory performs csh-style history expansion of text, replacing the
+// history events it references with the history entries, which are ordered
+// from the most recent. The supported events are:
+//
+//   - !!      the previous entry
+//   - !N      entry N, numbering the oldest entry 1
+//   - !-N     the Nth previous entry
+//   - !$      the last word of the previous entry
+//   - !prefix the most recent entry beginning with prefix
+//
+// A '!' followed by whitespace, '=', '(' or the end of the text is not
+// expanded, and neither is a '!' preceded by a backslash, which is removed. If
+// expand is non-nil, a '!' at a position for which it returns false is not
+// expanded either. The prefix of an event extends to the next whitespace or
+// one of ";:'\"". An error is returned for an event which does not match an
+// entry.
+func expandHistory(
+	text []rune, entries []string, expand func(pos int) bool,
+) (expanded []rune, changed bool, err error) {
+	previous := func() (string, bool) {
+		if len(entries) == 0 {
+			return "", false
+		}
+		return entries[0], true
+	}
+
+	for i := 0; i < len(text); i++ {
+		r := text[i]
+		if r == '\\' && i+1 < len(text) && text[i+1] == '!' && (expand == nil || expand(i+1)) {
+			expanded = append(expanded, '!')
+			changed = true
+			i++
+			continue
+		}
+		if r != '!' || i+1 == len(text) || (expand != nil && !expand(i)) {
+			expanded = append(expanded, r)
+			continue
+		}
+
+		var entry string
+		var ok bool
+		end := i + 2
+		switch next := text[i+1]; {
+		case unicode.IsSpace(next) || next == '=' || next == '(':
+			expanded = append(expanded, r)
+			continue
+
+		case next == '!':
+			entry, ok = previous()
+
+		case next == '$':
+			if entry, ok = previous(); ok {
+				if words := strings.Fields(entry); len(words) > 0 {
+					entry = words[len(words)-1]
+				} else {
+					entry = ""
+				}
+			}
+
+		case next == '-' || unicode.IsDigit(next):
+			start := i + 1
+			if next == '-' {
+				start++
+			}
+			for end = start; end < len(text) && unicode.IsDigit(text[end]); end++ {
+			}
+			n, _ := strconv.Atoi(string(text[start:end]))
+			if next == '-' {
+				n = len(entries) - n + 1
+			}
+			if end > start && n >= 1 && n <= len(entries) {
+				entry, ok = entries[len(entries)-n], true
+			}
+
+		default:
+			for end = i + 1; end < len(text) && !unicode.IsSpace(text[end]) &&
+				!strings.ContainsRune(";:'\"", text[end]); end++ {
+			}
+			if end == i+1 {
+				expanded = append(expanded, r)
+				continue
+			}
+			prefix := string(text[i+1 : end])
+			for _, e := range entries {
+				if strings.HasPrefix(e, prefix) {
+					entry, ok = e, true
+					break
+				}
+			}
+		}
+
+		if !ok {
+			return nil, false, fmt.Errorf("%s: event not found", string(text[i:end]))
+		}
+		expanded = append(expanded, []rune(entry)...)
+		changed = true
+		i = end - 1
+	}
+	return expanded, changed, nil
+}
+
+// ExpandLine performs history expansion of the input text (see
+// WithHistoryExpansion), replacing the input text with the expansion and
+// moving the cursor to the end. It returns false if an event does not match a
+// history entry, in which case the input text is left as is and the error is
+// displayed until the next key is processed.
+func (h *history) ExpandLine(s *state) bool {
+	text := undoSnapshot(s).text
+	if !strings.ContainsRune(string(text), '!') {
+		return true
+	}
+	entries := make([]string, len(h.entries))
+	for i := range entries {
+		entries[i] = h.entry(i)
+	}
+	expand := func(pos int) bool {
+		if h.expandFunc != nil {
+			return h.expandFunc(text, pos)
+		}
+		kind := s.screen.TokenAt(pos)
+		return kind != TokenString && kind != TokenComment
+	}
+
+	expanded, changed, err := expandHistory(text, entries, expand)
+	if err != nil {
+		showAcceptError(s, err)
+		return false
+	}
+	if changed {
+		s.screen.MoveTo(0)
+		s.screen.EraseTo(s.screen.End())
+		s.screen.Insert(expanded...)
+	}
+	return true
+}
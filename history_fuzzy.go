@@ -0,0 +1,218 @@
+package prompt
+
+import (
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// HistorySearchMode specifies how the search key of incremental history search
+// is matched against history entries. See WithHistorySearchMode.
+type HistorySearchMode int
+
+const (
+	// HistorySearchLiteral matches entries containing the search key. Matching
+	// entries are visited from the most recent.
+	HistorySearchLiteral HistorySearchMode = iota
+	// HistorySearchFuzzy matches entries containing the characters of the
+	// search key in order, though not necessarily adjacent, such as "slfr"
+	// matching "select * from". Matching ignores case unless the search key
+	// contains an upper case letter. Matching entries are visited in order of
+	// rank, with better matches (adjacent characters, and characters at the
+	// start of words) ranked first and ties broken by recency. Duplicate
+	// entries are only visited once.
+	HistorySearchFuzzy
+)
+
+// Scores of fuzzy matches. Each matched character scores fuzzyScoreMatch,
+// plus a bonus if it immediately follows the previously matched character or
+// starts a word. Each unmatched character between the first and last matched
+// characters costs fuzzyScoreGap, with an additional cost for the start of
+// each gap.
+const (
+	fuzzyScoreMatch       = 16
+	fuzzyBonusConsecutive = 8
+	fuzzyBonusBoundary    = 8
+	fuzzyScoreGapStart    = -3
+	fuzzyScoreGap         = -1
+)
+
+// fuzzyMatch is a history entry matching the search key of fuzzy search.
+type fuzzyMatch struct {
+	// index is the index of the entry, as passed to history.entry.
+	index int
+	score int
+	// positions holds the byte offsets within the entry of the matched
+	// characters.
+	positions []int
+}
+
+// fuzzyScore matches key against text, returning the score of the match and
+// the byte offsets of the matched characters within text. The characters of
+// key must occur in text in order. The matched characters are those within the
+// shortest window ending at the first occurrence of the subsequence.
+func fuzzyScore(key, text string) (score int, positions []int, ok bool) {
+	if key == "" {
+		return 0, nil, false
+	}
+	ignoreCase := true
+	for _, r := range key {
+		if unicode.IsUpper(r) {
+			ignoreCase = false
+			break
+		}
+	}
+	equal := func(a, b rune) bool {
+		if ignoreCase {
+			return unicode.ToLower(a) == b
+		}
+		return a == b
+	}
+
+	// Find the end of the first occurrence of key as a subsequence of text.
+	keyRunes := []rune(key)
+	var runes []rune
+	var offsets []int
+	for off, r := range text {
+		runes = append(runes, r)
+		offsets = append(offsets, off)
+	}
+	k, end := 0, -1
+	for i, r := range runes {
+		if equal(r, keyRunes[k]) {
+			if k++; k == len(keyRunes) {
+				end = i
+				break
+			}
+		}
+	}
+	if end == -1 {
+		return 0, nil, false
+	}
+
+	// Scan backwards from the end to find the shortest window containing the
+	// subsequence.
+	matched := make([]int, len(keyRunes))
+	k = len(keyRunes) - 1
+	for i := end; k >= 0; i-- {
+		if equal(runes[i], keyRunes[k]) {
+			matched[k] = i
+			k--
+		}
+	}
+
+	positions = make([]int, len(matched))
+	for j, i := range matched {
+		positions[j] = offsets[i]
+		score += fuzzyScoreMatch
+		if j > 0 {
+			if gap := i - matched[j-1] - 1; gap == 0 {
+				score += fuzzyBonusConsecutive
+			} else {
+				score += fuzzyScoreGapStart + gap*fuzzyScoreGap
+			}
+		}
+		if i == 0 || (!isWordRune(runes[i-1]) && isWordRune(runes[i])) {
+			score += fuzzyBonusBoundary
+		}
+	}
+	return score, positions, true
+}
+
+// isWordRune returns true if r is a letter or digit, for the purposes of
+// ranking fuzzy matches at the start of words.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// fuzzySearch returns true if the search key is matched by fuzzy search.
+func (h *history) fuzzySearch() bool {
+	return h.searchMode == HistorySearchFuzzy && !h.searchRegexp
+}
+
+// updateFuzzySearch performs fuzzy search. The matching entries are ranked
+// when the search key changes. Otherwise, if advance is true, reverse search
+// advances to the next lower ranked match and forward search returns to the
+// next higher ranked match.
+func (h *history) updateFuzzySearch(s *state, advance bool) {
+	if !h.fuzzyRanked || h.fuzzyKey != h.searchKey {
+		h.rankFuzzy()
+		if len(h.fuzzy) > 0 {
+			h.showFuzzyMatch(s, h.fuzzy[0])
+			h.fuzzyPos = 0
+			h.searchMatched = true
+		}
+		return
+	}
+	if len(h.fuzzy) == 0 {
+		return
+	}
+	pos := h.fuzzyPos
+	if advance {
+		pos -= h.searchDir
+	}
+	if pos < 0 || pos >= len(h.fuzzy) {
+		return
+	}
+	h.fuzzyPos = pos
+	h.showFuzzyMatch(s, h.fuzzy[pos])
+	h.searchMatched = true
+}
+
+// rankFuzzy finds and ranks the entries matching the search key.
+func (h *history) rankFuzzy() {
+	h.fuzzyRanked = true
+	h.fuzzyKey = h.searchKey
+	h.fuzzy = h.fuzzy[:0]
+	h.fuzzyPos = -1
+	if h.searchKey == "" {
+		return
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < len(h.entries); i++ {
+		entry := h.entry(i)
+		if seen[entry] || !h.inSearchScope(i) {
+			continue
+		}
+		seen[entry] = true
+		if score, positions, ok := fuzzyScore(h.searchKey, entry); ok {
+			h.fuzzy = append(h.fuzzy, fuzzyMatch{index: i, score: score, positions: positions})
+		}
+	}
+	// The entries are ordered by recency, which breaks ties.
+	sort.SliceStable(h.fuzzy, func(i, j int) bool {
+		return h.fuzzy[i].score > h.fuzzy[j].score
+	})
+}
+
+// showFuzzyMatch sets the matching entry as the input text with the matched
+// characters highlighted and the cursor positioned at the first of them.
+func (h *history) showFuzzyMatch(s *state, m fuzzyMatch) {
+	h.save(s.screen.Text())
+	h.index = m.index
+	entry := h.entry(m.index)
+
+	s.screen.MoveTo(0)
+	s.screen.EraseTo(s.screen.End())
+	var last int
+	for _, pos := range m.positions {
+		_, size := utf8.DecodeRuneInString(entry[pos:])
+		s.screen.Insert([]rune(entry[last:pos])...)
+		s.screen.SetAttrs(string(s.theme.SearchMatch))
+		s.screen.Insert([]rune(entry[pos : pos+size])...)
+		s.screen.SetAttrs("")
+		last = pos + size
+	}
+	s.screen.Insert([]rune(entry[last:])...)
+	s.screen.MoveTo(utf8.RuneCountInString(entry[:m.positions[0]]))
+	h.searchHighlighted = true
+	h.showMeta(s)
+}
+
+// resetFuzzy discards the ranked matches of fuzzy search.
+func (h *history) resetFuzzy() {
+	h.fuzzyRanked = false
+	h.fuzzy = nil
+	h.fuzzyKey = ""
+	h.fuzzyPos = -1
+}
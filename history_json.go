@@ -0,0 +1,83 @@
+package prompt
+
+import "encoding/json"
+
+// historySnapshot is the JSON encoding of the history. See
+// Prompt.ExportHistory.
+type historySnapshot struct {
+	// Entries holds the entries, ordered from the oldest, as edited during
+	// history navigation.
+	Entries []HistoryEntry `json:"entries"`
+	// Unsynced holds the entries and metadata buffered by the write policy
+	// which have not been written to the history store.
+	Unsynced []HistoryEntry `json:"unsynced,omitempty"`
+}
+
+// MarshalJSON encodes the entries and their metadata, along with the entries
+// buffered by the write policy, as a historySnapshot.
+func (h *history) MarshalJSON() ([]byte, error) {
+	snap := historySnapshot{Entries: make([]HistoryEntry, 0, len(h.entries))}
+	for n := len(h.entries) - 1; n >= 0; n-- {
+		e := HistoryEntry{Text: h.entry(n)}
+		if m := h.entryMeta(n); len(m) > 0 {
+			e.Meta = make(map[string]string, len(m))
+			for k, v := range m {
+				e.Meta[k] = v
+			}
+		}
+		snap.Entries = append(snap.Entries, e)
+	}
+	snap.Unsynced = h.unsynced
+	return json.Marshal(snap)
+}
+
+// UnmarshalJSON replaces the entries and their metadata with those of a
+// historySnapshot, retaining the most recent entries if there are more than the
+// max history size. The history store is not rewritten, but the buffered
+// entries of the snapshot are written to it as specified by the write policy,
+// in place of those currently buffered. The current index in the history
+// navigation is reset.
+func (h *history) UnmarshalJSON(data []byte) error {
+	var snap historySnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	entries := snap.Entries
+	if h.maxSize >= 0 && len(entries) > h.maxSize {
+		entries = entries[len(entries)-h.maxSize:]
+	}
+
+	h.entries = make([]string, len(entries))
+	h.meta = make([]map[string]string, len(entries))
+	h.head = 0
+	h.slots = nil
+	for i, e := range entries {
+		h.entries[i] = e.Text
+		if len(e.Meta) > 0 {
+			h.meta[i] = e.Meta
+		}
+		h.head = i
+		if h.dedup == HistoryDedupEraseOldest {
+			if h.slots == nil {
+				h.slots = make(map[string]int)
+			}
+			h.slots[e.Text] = i
+		}
+	}
+	h.index = -1
+	h.pending = ""
+	h.collapsed = ""
+	h.cursors = nil
+	h.undo = nil
+	h.annotatable = false
+
+	h.unsynced = nil
+	if h.store != nil {
+		for _, e := range snap.Unsynced {
+			if err := h.write(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/petermattis/prompt/libedit"
+)
+
+// HistoryEntry is a history entry persisted by a HistoryStore, along with the
+// metadata attached to it by Prompt.AnnotateLastHistory.
+type HistoryEntry struct {
+	Text string            `json:"text"`
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// HistoryStore persists history entries, such as to a file or a database. The
+// default store is the history file configured by WithHistory. See
+// WithHistoryStore.
+type HistoryStore interface {
+	// Load returns the persisted entries, ordered from the oldest. It is
+	// invoked once, when the Prompt is created.
+	Load() ([]HistoryEntry, error)
+	// Append persists an entry added to history. An entry with empty text
+	// holds metadata attached to the most recently appended entry, which is
+	// merged with the metadata of that entry.
+	Append(entry HistoryEntry) error
+	// Rewrite discards all but the most recent n entries. It is invoked when
+	// the number of entries loaded and appended exceeds the max history size by
+	// 25%, in the background once ReadLine returns, or on Close, so it may be
+	// invoked concurrently with Append.
+	Rewrite(n int) error
+	// Close closes the store. It is invoked by Prompt.Close.
+	Close() error
+}
+
+// HistorySyncer is implemented by a HistoryStore which can commit the entries
+// appended to it to stable storage, such as by syncing a file. See
+// Prompt.SyncHistory.
+type HistorySyncer interface {
+	Sync() error
+}
+
+// historyFile is the default HistoryStore, persisting history to a file in the
+// format of libedit. The entries are encoded one per line in the file with
+// whitespace encoded as \000 octal escapes, and the metadata of an entry is
+// encoded on the lines following it (see encodeHistoryMeta). The first line
+// must be "_HiStOrY_V2_". The file may be shared by multiple processes, which
+// append their entries to it.
+type historyFile struct {
+	path string
+	file *os.File
+	mode os.FileMode
+	sync bool
+}
+
+// Load implements HistoryStore, creating the history file, and the directory
+// containing it, if necessary.
+func (f *historyFile) Load() ([]HistoryEntry, error) {
+	mode := f.mode
+	if mode == 0 {
+		mode = defaultHistoryFileMode
+	}
+	// Create the directory containing the history file if necessary, such as
+	// the application's directory within $XDG_STATE_HOME (see HistoryPath).
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, mode)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		// If the history file was empty, write a cookie to initialize it.
+		if _, err := fmt.Fprintf(file, "%s\n", libedit.HistoryCookie); err != nil {
+			return nil, err
+		}
+		f.file, file = file, nil
+		return nil, nil
+	}
+
+	var entries []HistoryEntry
+	err = libedit.ReadHistoryLines(file, func(line string) error {
+		if strings.HasPrefix(line, historyMetaPrefix) {
+			meta, err := decodeHistoryMeta(line)
+			if err != nil {
+				return err
+			}
+			if len(entries) > 0 {
+				e := &entries[len(entries)-1]
+				if e.Meta == nil {
+					e.Meta = meta
+				} else {
+					for k, v := range meta {
+						e.Meta[k] = v
+					}
+				}
+			}
+			return nil
+		}
+		v, err := libedit.DecodeVis(line)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, HistoryEntry{Text: v})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	f.file, file = file, nil
+	return entries, nil
+}
+
+// Append implements HistoryStore, holding a shared lock on the history file so
+// that the appended lines are not lost to a concurrent rewrite of the file. If
+// the file has been replaced by a rewrite, the replacement is appended to
+// instead. The file is synced to stable storage if syncing is enabled.
+func (f *historyFile) Append(entry HistoryEntry) error {
+	ok, err := lockedHistoryFile(f.file, f.path, syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		file, err := lockHistoryFile(f.path, syscall.LOCK_SH)
+		if err != nil {
+			return err
+		}
+		f.file.Close()
+		f.file = file
+	}
+	defer syscall.Flock(int(f.file.Fd()), syscall.LOCK_UN)
+
+	if entry.Text != "" {
+		if err := libedit.WriteEntry(f.file, entry.Text); err != nil {
+			return err
+		}
+	}
+	if len(entry.Meta) > 0 {
+		if _, err := fmt.Fprintf(f.file, "%s\n", encodeHistoryMeta(entry.Meta)); err != nil {
+			return err
+		}
+	}
+	if f.sync {
+		return f.file.Sync()
+	}
+	return nil
+}
+
+// Rewrite implements HistoryStore. See compactHistoryFile.
+func (f *historyFile) Rewrite(n int) error {
+	return compactHistoryFile(f.path, n)
+}
+
+// Sync implements HistorySyncer, syncing the history file to stable storage.
+func (f *historyFile) Sync() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+// Close implements HistoryStore.
+func (f *historyFile) Close() error {
+	if f.file != nil {
+		return f.file.Close()
+	}
+	return nil
+}
+
+// compactHistoryFile rewrites the history file at path to contain only its
+// most recent maxSize entries, along with their metadata, if it holds more
+// than 25% more entries than that. The history file is locked while it is
+// compacted, so that the entries appended by other processes sharing the file
+// are not lost, and so that two processes don't both compact it. The entries
+// are written to a temporary file which is then renamed over the history file
+// so that a crash during the compaction does not lose history.
+func compactHistoryFile(path string, maxSize int) (err error) {
+	src, err := lockHistoryFile(path, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Read the history file afresh, as it may have been appended to by other
+	// processes, or compacted by them already. The lines of each entry are
+	// retained verbatim, starting with the line holding the entry and followed
+	// by the lines holding its metadata.
+	var lines []string
+	var starts []int
+	err = libedit.ReadHistoryLines(src, func(line string) error {
+		if !strings.HasPrefix(line, historyMetaPrefix) {
+			starts = append(starts, len(lines))
+		} else if len(starts) == 0 {
+			// Metadata without an entry is discarded.
+			return nil
+		}
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(starts) <= (maxSize*5)/4 {
+		return nil
+	}
+	if maxSize == 0 {
+		lines = nil
+	} else {
+		lines = lines[starts[len(starts)-maxSize]:]
+	}
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "%s\n", libedit.HistoryCookie)
+	for _, line := range lines {
+		fmt.Fprintf(w, "%s\n", line)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// lockHistoryFile opens the history file at path for appending and locks it
+// (see flock(2) for the lock types). If the history file is replaced by a
+// compaction while waiting for the lock, the replacement is opened and locked
+// instead.
+func lockHistoryFile(path string, how int) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0)
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := lockedHistoryFile(f, path, how); err != nil || !ok {
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return f, nil
+	}
+}
+
+// lockedHistoryFile locks f, returning false, with f unlocked, if f is no
+// longer the history file at path, having been replaced by a compaction.
+func lockedHistoryFile(f *os.File, path string, how int) (bool, error) {
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		return false, err
+	}
+	fi, err := f.Stat()
+	if err == nil {
+		var pi os.FileInfo
+		if pi, err = os.Stat(path); err == nil && os.SameFile(fi, pi) {
+			return true, nil
+		}
+	}
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return false, err
+}
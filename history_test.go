@@ -0,0 +1,671 @@
+package prompt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryCompact(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history")
+	const contents = "_HiStOrY_V2_\n1\n\\$a=1\n2\n3\n4\n5\n\\$b=5\n6\n"
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0640))
+	readFile := func() string {
+		buf, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(buf)
+	}
+
+	// Loading an oversized history file doesn't compact it.
+	h := &history{path: path, maxSize: 4}
+	require.NoError(t, h.Load())
+	require.Equal(t, contents, readFile())
+
+	// The compaction happens in the background, retaining the metadata of the
+	// entries. Entries added while the compaction is in progress are appended
+	// to the compacted file.
+	h.maybeCompact()
+	require.NotNil(t, h.compactDone)
+	require.NoError(t, h.Add("7"))
+	<-h.compactDone
+	require.NoError(t, h.Add("8"))
+	require.Contains(t, readFile(), "4\n5\n\\$b=5\n6\n")
+	require.True(t, strings.HasSuffix(readFile(), "8\n"))
+
+	// Close compacts the history file if it is oversized again.
+	require.NoError(t, h.Add("9"))
+	require.NoError(t, h.Close())
+	require.Equal(t, "_HiStOrY_V2_\n6\n7\n8\n9\n", readFile())
+
+	// The compaction preserves the file mode and doesn't leave behind the
+	// temporary file.
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestHistoryCompactConcurrent(t *testing.T) {
+	// Processes sharing a history file append to it while it is compacted
+	// by each of them, without losing entries.
+	path := filepath.Join(t.TempDir(), "history")
+	const numHistories = 4
+	const numEntries = 50
+	var contents strings.Builder
+	contents.WriteString("_HiStOrY_V2_\n")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&contents, "old-%d\n", i)
+	}
+	require.NoError(t, os.WriteFile(path, []byte(contents.String()), 0600))
+	errs := make(chan error, numHistories)
+	for i := 0; i < numHistories; i++ {
+		go func(i int) {
+			errs <- func() error {
+				h := &history{path: path, maxSize: 1000}
+				if err := h.Load(); err != nil {
+					return err
+				}
+				for j := 0; j < numEntries; j++ {
+					if err := h.Add(fmt.Sprintf("%d-%d", i, j)); err != nil {
+						return err
+					}
+					if j%10 == 0 {
+						if err := compactHistoryFile(path, numHistories*numEntries); err != nil {
+							return err
+						}
+					}
+				}
+				return h.Close()
+			}()
+		}(i)
+	}
+	for i := 0; i < numHistories; i++ {
+		require.NoError(t, <-errs)
+	}
+
+	h := &history{path: path, maxSize: 1000}
+	require.NoError(t, h.Load())
+	require.NoError(t, h.Close())
+	require.Less(t, len(h.entries), 300)
+	found := make(map[string]bool)
+	for _, e := range h.entries {
+		found[e] = true
+	}
+	for i := 0; i < numHistories; i++ {
+		for j := 0; j < numEntries; j++ {
+			require.True(t, found[fmt.Sprintf("%d-%d", i, j)], "%d-%d", i, j)
+		}
+	}
+}
+
+func TestHistoryFileMode(t *testing.T) {
+	dir := t.TempDir()
+	for _, mode := range []os.FileMode{0, 0640} {
+		path := filepath.Join(dir, mode.String())
+		h := &history{path: path, maxSize: 10, mode: mode}
+		require.NoError(t, h.Load())
+		require.NoError(t, h.Close())
+
+		expected := mode
+		if expected == 0 {
+			expected = defaultHistoryFileMode
+		}
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, expected, info.Mode().Perm())
+	}
+}
+
+func TestHistoryAddError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	h := &history{path: path, maxSize: 10, sync: true}
+	require.NoError(t, h.Load())
+	require.NoError(t, h.Add("1"))
+
+	// Writes to a closed history file are reported as errors, though the entry
+	// is still added to the in-memory history.
+	require.NoError(t, h.store.(*historyFile).file.Close())
+	require.Error(t, h.Add("2"))
+	require.Equal(t, "2", h.entry(0))
+}
+
+// memHistoryStore is an in-memory HistoryStore.
+type memHistoryStore struct {
+	entries  []HistoryEntry
+	rewrites []int
+	syncs    int
+	closed   bool
+}
+
+func (s *memHistoryStore) Load() ([]HistoryEntry, error) {
+	return append([]HistoryEntry(nil), s.entries...), nil
+}
+
+func (s *memHistoryStore) Append(entry HistoryEntry) error {
+	if entry.Text == "" {
+		last := &s.entries[len(s.entries)-1]
+		if last.Meta == nil {
+			last.Meta = make(map[string]string)
+		}
+		for k, v := range entry.Meta {
+			last.Meta[k] = v
+		}
+		return nil
+	}
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *memHistoryStore) Rewrite(n int) error {
+	s.rewrites = append(s.rewrites, n)
+	if len(s.entries) > n {
+		s.entries = append([]HistoryEntry(nil), s.entries[len(s.entries)-n:]...)
+	}
+	return nil
+}
+
+func (s *memHistoryStore) Sync() error {
+	s.syncs++
+	return nil
+}
+
+func (s *memHistoryStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestHistoryStore(t *testing.T) {
+	store := &memHistoryStore{
+		entries: []HistoryEntry{
+			{Text: "1"},
+			{Text: "2", Meta: map[string]string{"exit": "0"}},
+			{Text: "3"},
+		},
+	}
+
+	// The store takes precedence over the history file.
+	path := filepath.Join(t.TempDir(), "history")
+	h := &history{store: store, path: path, maxSize: 3}
+	require.NoError(t, h.Load())
+	require.Equal(t, "[3, 2, 1]", h.String())
+	require.Equal(t, map[string]string{"exit": "0"}, h.entryMeta(1))
+	_, err := os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	// Added entries and their metadata are appended to the store.
+	require.NoError(t, h.Add("4"))
+	require.NoError(t, h.AnnotateLast(map[string]string{"exit": "1"}))
+	require.Equal(t, HistoryEntry{Text: "4", Meta: map[string]string{"exit": "1"}},
+		store.entries[len(store.entries)-1])
+
+	// Close rewrites the store once it is oversized, then closes it.
+	require.NoError(t, h.Add("5"))
+	require.NoError(t, h.Close())
+	require.Equal(t, []int{3}, store.rewrites)
+	require.True(t, store.closed)
+	require.Len(t, store.entries, 3)
+}
+
+func TestHistoryWritePolicy(t *testing.T) {
+	texts := func(store *memHistoryStore) []string {
+		var result []string
+		for _, e := range store.entries {
+			result = append(result, e.Text)
+		}
+		return result
+	}
+
+	// The entries and their metadata are buffered until the history is synced
+	// or closed.
+	store := &memHistoryStore{}
+	h := &history{store: store, maxSize: 10, writePolicy: HistoryWriteOnClose}
+	require.NoError(t, h.Load())
+	require.NoError(t, h.Add("1"))
+	require.NoError(t, h.AnnotateLast(map[string]string{"exit": "0"}))
+	require.Empty(t, store.entries)
+	require.NoError(t, h.Sync())
+	require.Equal(t, []HistoryEntry{{Text: "1", Meta: map[string]string{"exit": "0"}}}, store.entries)
+	require.Equal(t, 1, store.syncs)
+	require.NoError(t, h.Add("2"))
+	require.NoError(t, h.Close())
+	require.Equal(t, []string{"1", "2"}, texts(store))
+
+	// The entries not synced manually are discarded on close.
+	store = &memHistoryStore{}
+	h = &history{store: store, maxSize: 10, writePolicy: HistoryWriteManual}
+	require.NoError(t, h.Load())
+	require.NoError(t, h.Add("1"))
+	require.NoError(t, h.Sync())
+	require.NoError(t, h.Add("2"))
+	require.NoError(t, h.Close())
+	require.Equal(t, []string{"1"}, texts(store))
+	require.Equal(t, "[2, 1]", h.String())
+
+	// Syncing the history file writes the buffered entries to it.
+	path := filepath.Join(t.TempDir(), "history")
+	h = &history{path: path, maxSize: 10, writePolicy: HistoryWriteManual}
+	require.NoError(t, h.Load())
+	require.NoError(t, h.Add("select 1"))
+	buf, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "_HiStOrY_V2_\n", string(buf))
+	require.NoError(t, h.Sync())
+	buf, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "_HiStOrY_V2_\nselect\\0401\n", string(buf))
+	require.NoError(t, h.Close())
+}
+
+func TestHistoryJSON(t *testing.T) {
+	h := &history{maxSize: 10, writePolicy: HistoryWriteManual, store: &memHistoryStore{}}
+	require.NoError(t, h.Load())
+	require.NoError(t, h.Add("select 1"))
+	require.NoError(t, h.AnnotateLast(map[string]string{"exit": "0"}))
+	require.NoError(t, h.Sync())
+	require.NoError(t, h.Add("select 2"))
+	// Edits made during history navigation are retained in the snapshot.
+	h.index = 1
+	h.save([]rune("select 10"))
+
+	data, err := json.Marshal(h)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+  "entries": [{"text": "select 10", "meta": {"exit": "0"}}, {"text": "select 2"}],
+  "unsynced": [{"text": "select 2"}]
+}`, string(data))
+
+	// Importing the snapshot replaces the entries, and writes the unsynced
+	// entries as specified by the write policy.
+	store := &memHistoryStore{}
+	h2 := &history{maxSize: 10, store: store}
+	require.NoError(t, h2.Load())
+	require.NoError(t, h2.Add("select 3"))
+	require.NoError(t, json.Unmarshal(data, h2))
+	require.Equal(t, "[select 2, select 10]", h2.String())
+	require.Equal(t, map[string]string{"exit": "0"}, h2.entryMeta(1))
+	require.Equal(t, -1, h2.index)
+	require.Equal(t, []HistoryEntry{{Text: "select 3"}, {Text: "select 2"}}, store.entries)
+
+	// Entries added after the import follow the imported entries.
+	require.NoError(t, h2.Add("select 4"))
+	require.Equal(t, "[select 4, select 2, select 10]", h2.String())
+
+	// Only the most recent entries are retained if there are more than the max
+	// history size.
+	h3 := &history{maxSize: 1}
+	require.NoError(t, json.Unmarshal(data, h3))
+	require.Equal(t, "[select 2]", h3.String())
+	require.NoError(t, h3.Add("select 5"))
+	require.Equal(t, "[select 5]", h3.String())
+
+	require.Error(t, json.Unmarshal([]byte(`{"entries": 1}`), h3))
+}
+
+func TestExportImportHistory(t *testing.T) {
+	p, err := New(WithOutput(io.Discard), WithInteractive(true), WithHistory("", 10))
+	require.NoError(t, err)
+	require.NoError(t, p.mu.state.history.Add("select 1"))
+	data, err := p.ExportHistory()
+	require.NoError(t, err)
+
+	q, err := New(WithOutput(io.Discard), WithInteractive(true), WithHistory("", 10))
+	require.NoError(t, err)
+	require.NoError(t, q.ImportHistory(data))
+	require.Equal(t, "[select 1]", q.mu.state.history.String())
+
+	// The history cannot be replaced while ReadLine is active.
+	q.mu.state.active = true
+	require.Error(t, q.ImportHistory(data))
+}
+
+func TestHistoryAnnotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	h := &history{path: path, maxSize: 10}
+	require.NoError(t, h.Load())
+
+	// Nothing has been added yet, so there is nothing to annotate.
+	require.NoError(t, h.AnnotateLast(map[string]string{"exit": "1"}))
+	require.NoError(t, h.Add("select 1"))
+	require.NoError(t, h.AnnotateLast(map[string]string{"exit": "0", "rows": "1 row"}))
+	require.NoError(t, h.AnnotateLast(map[string]string{"duration": "1.5ms"}))
+	// A duplicate entry annotates the existing entry.
+	require.NoError(t, h.Add("select 1"))
+	require.NoError(t, h.AnnotateLast(map[string]string{"exit": "2"}))
+	require.NoError(t, h.Add("select 2"))
+	require.Error(t, h.AnnotateLast(map[string]string{"a=b": "c"}))
+	require.Error(t, h.AnnotateLast(map[string]string{"": "c"}))
+	require.NoError(t, h.Close())
+
+	buf, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `_HiStOrY_V2_
+select\0401
+\$exit=0 rows=1\040row
+\$duration=1.5ms
+\$exit=2
+select\0402
+`, string(buf))
+
+	// The metadata is restored when the history file is loaded.
+	h = &history{path: path, maxSize: 10}
+	require.NoError(t, h.Load())
+	require.Equal(t, "[select 2, select 1]", h.String())
+	require.Equal(t, map[string]string{"exit": "2", "rows": "1 row", "duration": "1.5ms"}, h.entryMeta(1))
+	require.Equal(t, "duration=1.5ms exit=2 rows=1 row", formatHistoryMeta(h.entryMeta(1)))
+	// Entries loaded from the file cannot be annotated.
+	require.NoError(t, h.AnnotateLast(map[string]string{"exit": "3"}))
+	require.Nil(t, h.entryMeta(0))
+	require.NoError(t, h.Close())
+}
+
+func TestHistoryEntryLimit(t *testing.T) {
+	h := &history{maxSize: 10, maxEntryLen: 4}
+	require.NoError(t, h.Add("abcd"))
+	require.NoError(t, h.Add("abcde"))
+	require.Equal(t, "[abcd]", h.String())
+
+	h = &history{maxSize: 10, maxEntryLen: 4, truncateEntries: true}
+	require.NoError(t, h.Add("abcdef"))
+	require.NoError(t, h.Add("abcd"))
+	require.NoError(t, h.Add("abc«"))
+	require.Equal(t, "[abc, abcd]", h.String())
+
+	// Entries which can't be truncated without splitting their first character
+	// are skipped.
+	h = &history{maxSize: 10, maxEntryLen: 1, truncateEntries: true}
+	require.NoError(t, h.Add("é"))
+	require.NoError(t, h.Add("ab"))
+	require.Equal(t, "[a]", h.String())
+}
+
+func TestHistoryComplete(t *testing.T) {
+	h := &history{maxSize: 100}
+	for _, e := range []string{
+		"select * from orders",
+		"select name from users",
+		"show tables",
+		"select * from orders where id = 1",
+		"select sum(total) from orders",
+		"set timezone = 'utc'",
+	} {
+		require.NoError(t, h.Add(e))
+	}
+
+	complete := func(mode HistoryCompletion, text string) []string {
+		runes := []rune(text)
+		wordStart := len(runes)
+		for wordStart > 0 && isWord(runes[wordStart-1]) {
+			wordStart--
+		}
+		return h.Complete(mode, runes, wordStart, len(runes), isWord)
+	}
+
+	// "select" occurs in more entries than the other words, which are ordered by
+	// recency.
+	require.Equal(t, []string{"select", "set", "sum", "show"}, complete(HistoryCompletionWords, "s"))
+	require.Equal(t, []string{"orders"}, complete(HistoryCompletionWords, "from o"))
+	require.Equal(t, []string{"timezone", "total", "tables"}, complete(HistoryCompletionWords, "t"))
+	// Words identical to the word being completed are excluded.
+	require.Nil(t, complete(HistoryCompletionWords, "orders"))
+
+	require.Equal(t,
+		[]string{"orders where id = 1", "orders"},
+		complete(HistoryCompletionLines, "select * from or"))
+	require.Equal(t,
+		[]string{"select sum(total) from orders", "select * from orders where id = 1",
+			"select name from users", "select * from orders"},
+		complete(HistoryCompletionLines, "sel"))
+	require.Nil(t, complete(HistoryCompletionLines, "select * from orders where id = 1"))
+	require.Nil(t, complete(NoHistoryCompletion, "s"))
+}
+
+func TestHistorySearchOccurrences(t *testing.T) {
+	p := newTestPrompt(t, WithSize(80, 2), WithHistory("", 100))
+	for _, e := range []string{"bar", "foo bar foo", "baz"} {
+		require.NoError(t, p.mu.state.history.Add(e))
+	}
+
+	// highlights returns the input text with the current match enclosed in [] and
+	// the other occurrences of the search key enclosed in {}.
+	highlights := func() string {
+		s := &p.mu.state.screen
+		var buf strings.Builder
+		last := len(s.prefix)
+		for _, a := range s.attrs.All() {
+			if a.startPos < last || a.endPos > len(s.prefix)+len(s.Text()) {
+				continue
+			}
+			open, close := "{", "}"
+			if a.value == string(p.mu.state.theme.SearchMatch) {
+				open, close = "[", "]"
+			}
+			buf.WriteString(string(s.text[last:a.startPos]))
+			buf.WriteString(open + string(s.text[a.startPos:a.endPos]) + close)
+			last = a.endPos
+		}
+		buf.WriteString(string(s.text[last : len(s.prefix)+len(s.Text())]))
+		return buf.String()
+	}
+
+	feedInput(t, p, "\x12ba")
+	require.Equal(t, "[ba]z", highlights())
+	feedInput(t, p, "\x12")
+	require.Equal(t, "foo [ba]r foo", highlights())
+	feedInput(t, p, "r")
+	require.Equal(t, "foo [bar] foo", highlights())
+
+	// Every occurrence of the search key in the entry is highlighted. Meta-n
+	// moves to the next occurrence within the entry, and then to the next
+	// matching entry in the direction of the search. Meta-p reverses Meta-n.
+	feedInput(t, p, "\x7f\x7f\x7ffoo")
+	require.Equal(t, "[foo] bar {foo}", highlights())
+	feedInput(t, p, "\x1bn")
+	require.Equal(t, "{foo} bar [foo]", highlights())
+	feedInput(t, p, "\x1bn")
+	require.Equal(t, "{foo} bar [foo]", highlights())
+	feedInput(t, p, "\x1bp")
+	require.Equal(t, "[foo] bar {foo}", highlights())
+	feedInput(t, p, "\x1bp")
+	require.Equal(t, "[foo] bar {foo}", highlights())
+
+	// Cancelling the search removes the highlighting, leaving the cursor in place.
+	feedInput(t, p, "\x1b[C")
+	require.Equal(t, "foo bar foo", highlights())
+	require.Equal(t, 1, p.mu.state.screen.Position())
+}
+
+func TestHistorySearchHighlighter(t *testing.T) {
+	// The highlighter highlights every occurrence of "select".
+	highlight := func(text []rune, dirtyStart, dirtyEnd int) ([]Span, int, int) {
+		var spans []Span
+		for i := 0; i+6 <= len(text); i++ {
+			if string(text[i:i+6]) == "select" {
+				spans = append(spans, Span{Start: i, End: i + 6, Style: Style(attrBold)})
+			}
+		}
+		return spans, 0, len(text)
+	}
+	p := newTestPrompt(t, WithSize(80, 2), WithHistory("", 100), WithHighlighter(highlight))
+	require.NoError(t, p.mu.state.history.Add("select 1"))
+
+	// attrs returns the spans of the attributes of the input text, marking the
+	// highlighter's spans with "h" and the search match with "m".
+	attrs := func() string {
+		s := &p.mu.state.screen
+		var buf strings.Builder
+		for _, a := range s.attrs.All() {
+			switch {
+			case a.kind == attrKindHighlight:
+				fmt.Fprintf(&buf, "h[%s]", string(s.text[a.startPos:a.endPos]))
+			case a.value == string(p.mu.state.theme.SearchMatch):
+				fmt.Fprintf(&buf, "m[%s]", string(s.text[a.startPos:a.endPos]))
+			}
+		}
+		return buf.String()
+	}
+
+	// The search match is displayed in reverse video on top of the highlighting
+	// of the entry.
+	feedInput(t, p, "\x12lec")
+	require.Equal(t, "select 1", string(p.mu.state.screen.Text()))
+	require.Equal(t, "h[select]m[lec]", attrs())
+	require.Equal(t, attrReverse, string(p.mu.state.theme.SearchMatch))
+
+	// The search match is cleared when the search ends, leaving the
+	// highlighting.
+	feedInput(t, p, "\x1b[C")
+	require.Equal(t, "h[select]", attrs())
+}
+
+func TestFuzzyScore(t *testing.T) {
+	for _, c := range []struct {
+		key, text string
+		positions []int
+	}{
+		{"sfo", "select * from orders", []int{0, 9, 11}},
+		{"abc", "xaxbxc", []int{1, 3, 5}},
+		// The shortest window ending at the first occurrence is matched.
+		{"ab", "a a ab", []int{4, 5}},
+		// Matching ignores case unless the key has an upper case letter.
+		{"SEL", "select", nil},
+		{"sel", "SELECT", []int{0, 1, 2}},
+		{"Sel", "Select", []int{0, 1, 2}},
+		// Positions are byte offsets.
+		{"éb", "xéxb", []int{1, 4}},
+		{"ba", "abc", nil},
+		{"", "abc", nil},
+	} {
+		_, positions, ok := fuzzyScore(c.key, c.text)
+		require.Equal(t, c.positions != nil, ok, "%q %q", c.key, c.text)
+		require.Equal(t, c.positions, positions, "%q %q", c.key, c.text)
+	}
+
+	// Adjacent characters and characters at the start of words score higher.
+	score := func(key, text string) int {
+		s, _, ok := fuzzyScore(key, text)
+		require.True(t, ok)
+		return s
+	}
+	require.Greater(t, score("from", "from t"), score("from", "f r o m"))
+	require.Greater(t, score("ft", "from t"), score("ft", "afrom at"))
+	require.Greater(t, score("ab", "ab"), score("ab", "a-b"))
+	require.Greater(t, score("ab", "a-b"), score("ab", "a---b"))
+}
+
+func TestExpandHistory(t *testing.T) {
+	// The entries are ordered from the most recent.
+	entries := []string{"select c from t", "show tables", "select a from u"}
+	for _, c := range []struct {
+		text, expected, err string
+	}{
+		{text: "select 1", expected: "select 1"},
+		{text: "!!", expected: "select c from t"},
+		{text: "explain !!;", expected: "explain select c from t;"},
+		{text: "!1", expected: "select a from u"},
+		{text: "!3x", expected: "select c from tx"},
+		{text: "!-2", expected: "show tables"},
+		{text: "select * from !$", expected: "select * from t"},
+		{text: "!sh; !sel", expected: "show tables; select c from t"},
+		{text: "!s:x", expected: "select c from t:x"},
+		{text: "a != b, !(c), !", expected: "a != b, !(c), !"},
+		{text: `\!! !!`, expected: "!! select c from t"},
+		{text: "'!!' !!", expected: "'!!' select c from t"},
+		{text: "!4", err: "!4: event not found"},
+		{text: "!-0", err: "!-0: event not found"},
+		{text: "!-", err: "!-: event not found"},
+		{text: "!drop x", err: "!drop: event not found"},
+	} {
+		t.Run(c.text, func(t *testing.T) {
+			text := []rune(c.text)
+			// '!' within single quotes is not expanded.
+			expand := func(pos int) bool {
+				return strings.Count(string(text[:pos]), "'")%2 == 0
+			}
+			expanded, changed, err := expandHistory(text, entries, expand)
+			if c.err != "" {
+				require.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, string(expanded))
+			require.Equal(t, c.expected != c.text, changed)
+		})
+	}
+
+	_, _, err := expandHistory([]rune("!!"), nil, nil)
+	require.EqualError(t, err, "!!: event not found")
+}
+
+func TestHistoryDedup(t *testing.T) {
+	h := &history{maxSize: 10, dedup: HistoryDedupNone}
+	for _, e := range []string{"a", "a", "b", "a"} {
+		require.NoError(t, h.Add(e))
+	}
+	require.Equal(t, "[a, b, a, a]", h.String())
+
+	// Duplicates are moved to the front, including once the circular buffer
+	// has wrapped around.
+	h = &history{maxSize: 3, dedup: HistoryDedupEraseOldest}
+	for _, c := range []struct {
+		entry    string
+		expected string
+	}{
+		{"a", "[a]"},
+		{"b", "[b, a]"},
+		{"a", "[a, b]"},
+		{"a", "[a, b]"},
+		{"c", "[c, a, b]"},
+		{"b", "[b, c, a]"},
+		{"d", "[d, b, c]"},
+		{"c", "[c, d, b]"},
+		{"a", "[a, c, d]"},
+		{"d", "[d, a, c]"},
+	} {
+		require.NoError(t, h.Add(c.entry))
+		require.Equal(t, c.expected, h.String(), "%s", c.entry)
+		require.Len(t, h.slots, len(h.entries))
+		for e, i := range h.slots {
+			require.Equal(t, e, h.entries[i])
+		}
+	}
+
+	// Entries edited during history navigation are deduplicated by their
+	// edited text.
+	h = &history{maxSize: 3, dedup: HistoryDedupEraseOldest}
+	require.NoError(t, h.Add("a"))
+	require.NoError(t, h.Add("b"))
+	h.index = 1
+	h.save([]rune("x"))
+	require.NoError(t, h.Add("a"))
+	require.Equal(t, "[a, b, x]", h.String())
+	require.NoError(t, h.Add("x"))
+	require.Equal(t, "[x, a, b]", h.String())
+	require.Len(t, h.slots, len(h.entries))
+	for e, i := range h.slots {
+		require.Equal(t, e, h.entries[i])
+	}
+}
+
+func TestHistorySubstringSearchEnd(t *testing.T) {
+	p := newTestPrompt(t, WithSize(80, 2), WithHistory("", 100), WithHistorySubstringSearch(true))
+	for _, e := range []string{"select * from t;", "delete from t;"} {
+		require.NoError(t, p.mu.state.history.Add(e))
+	}
+
+	// A command consumed by the kill ring ends substring search.
+	feedInput(t, p, "from t\x1b[A")
+	require.True(t, p.mu.state.history.substrActive)
+	require.Equal(t, "delete from t;", string(p.mu.state.screen.Text()))
+	feedInput(t, p, "\x17")
+	require.False(t, p.mu.state.history.substrActive)
+	require.Equal(t, "delete from ", string(p.mu.state.screen.Text()))
+}
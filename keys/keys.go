@@ -0,0 +1,383 @@
+// Package keys decodes the key input sequences sent by terminals. Keys are
+// represented as runes: printable characters and control characters are
+// represented by their own value, special keys (such as the arrow keys) by
+// values in the UTF-16 surrogate area which are not valid characters, and the
+// Ctrl and Alt modifiers by high bits which are or'd with the key.
+package keys
+
+import "unicode/utf8"
+
+// Control characters.
+const (
+	CtrlA = iota + 1
+	CtrlB
+	CtrlC
+	CtrlD
+	CtrlE
+	CtrlF
+	CtrlG
+	CtrlH
+	CtrlI
+	CtrlJ
+	CtrlK
+	CtrlL
+	CtrlM
+	CtrlN
+	CtrlO
+	CtrlP
+	CtrlQ
+	CtrlR
+	CtrlS
+	CtrlT
+	CtrlU
+	CtrlV
+	CtrlW
+	CtrlX
+	CtrlY
+	CtrlZ
+	Escape
+
+	Enter     = '\r'
+	Tab       = '\t'
+	Backspace = 127
+)
+
+// Special keys. The values lie within the UTF-16 surrogate area and thus do
+// not conflict with valid characters.
+const (
+	Unknown = 0xd800 + iota
+	Up
+	Down
+	Left
+	Right
+	Home
+	End
+	PageUp
+	PageDown
+	Delete
+	// PasteStart and PasteEnd delimit text pasted while bracketed paste mode is
+	// enabled.
+	PasteStart
+	PasteEnd
+	Insert
+)
+
+// Modifiers which are or'd with a key.
+const (
+	Ctrl = 0x20000000
+	Alt  = 0x40000000
+)
+
+// A map of the supported control sequences to the Go code that will be emitted
+// when the control sequence is matched.
+//
+// Note that we can't specify control sequences to cover the desired key input
+// for all terminals because the same control sequence is sometimes used by
+// different terminals to represent different keys. The control sequences below
+// support 75% of the ~2500 terminals listed in my terminfo database.
+var supportedSeqs = map[string]rune{
+	"\x1b[3~":   Delete,
+	"\x1bOB":    Down,
+	"\x1b[B":    Down,
+	"\x1bOb":    Down | Ctrl,
+	"\x1b[1;5B": Down | Ctrl,
+	"\x1b[1;3B": Down | Alt,
+	"\x1b[1;9B": Down | Alt,
+	"\x1bOF":    End,
+	"\x1b[F":    End,
+	"\x1b[4~":   End,
+	"\x1b[8~":   End,
+	"\x1bOH":    Home,
+	"\x1b[H":    Home,
+	"\x1b[1~":   Home,
+	"\x1b[7~":   Home,
+	"\x1bOD":    Left,
+	"\x1b[D":    Left,
+	"\x1bOd":    Left | Ctrl,
+	"\x1b[1;5D": Left | Ctrl,
+	"\x1b[1;3D": Left | Alt,
+	"\x1b[1;9D": Left | Alt,
+	"\x1b[6~":   PageDown,
+	"\x1b[5~":   PageUp,
+	"\x1b[200~": PasteStart,
+	"\x1b[201~": PasteEnd,
+	"\x1b[2~":   Insert,
+	"\x1bOC":    Right,
+	"\x1b[C":    Right,
+	"\x1bOc":    Right | Ctrl,
+	"\x1b[1;5C": Right | Ctrl,
+	"\x1b[1;3C": Right | Alt,
+	"\x1b[1;9C": Right | Alt,
+	"\x1bOA":    Up,
+	"\x1b[A":    Up,
+	"\x1bOa":    Up | Ctrl,
+	"\x1b[1;5A": Up | Ctrl,
+	"\x1b[1;3A": Up | Alt,
+	"\x1b[1;9A": Up | Alt,
+}
+
+// keySeqs maps each key in supportedSeqs to the sequence returned for it by
+// Sequence.
+var keySeqs = func() map[rune]string {
+	m := make(map[rune]string, len(supportedSeqs))
+	for seq, key := range supportedSeqs {
+		if prev, ok := m[key]; !ok || preferSeq(seq, prev) {
+			m[key] = seq
+		}
+	}
+	return m
+}()
+
+// preferSeq returns true if seq is preferred over prev as the sequence to send
+// for a key: CSI sequences ("\x1b[...") are preferred over SS3 sequences
+// ("\x1bO..."), then shorter sequences, and then the lexically smaller.
+func preferSeq(seq, prev string) bool {
+	if csi, prevCSI := seq[1] == '[', prev[1] == '['; csi != prevCSI {
+		return csi
+	}
+	if len(seq) != len(prev) {
+		return len(seq) < len(prev)
+	}
+	return seq < prev
+}
+
+// Sequence returns the input sequence sent by a terminal for a special key,
+// possibly modified by Ctrl or Alt, which Parse parses as the key. As
+// terminals differ, one of the supported sequences is chosen, preferring those
+// sent by xterm. Returns false if the key has no input sequence of its own,
+// such as a printable character or control character, which is sent as is.
+func Sequence(key rune) (string, bool) {
+	seq, ok := keySeqs[key]
+	return seq, ok
+}
+
+type seqTrie struct {
+	children []seqTrie
+	key      byte
+	value    rune
+}
+
+func (t *seqTrie) findChild(b byte) *seqTrie {
+	for i := range t.children {
+		child := &t.children[i]
+		if child.key == b {
+			return child
+		}
+	}
+	return nil
+}
+
+func (t *seqTrie) add(seq []byte, value rune) {
+	node := t
+	for _, b := range seq {
+		child := node.findChild(b)
+		if child == nil {
+			node.children = append(node.children, seqTrie{key: b})
+			child = &node.children[len(node.children)-1]
+		}
+		node = child
+	}
+	node.value = value
+}
+
+func (t *seqTrie) match(buf, origBuf []byte, mods rune) (rune, []byte) {
+	node := t
+	for i, b := range buf {
+		node = node.findChild(b)
+		if node == nil {
+			// If we get here then we have a sequence that we don't recognise, or a partial
+			// sequence. It's not clear how one should find the end of a sequence without
+			// knowing them all, but it seems that [a-zA-Z~] only appears at the end of a
+			// sequence.
+			for j := i; j < len(buf); j++ {
+				b := buf[j]
+				if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b == '~' {
+					return Unknown, buf[i+1:]
+				}
+			}
+			return utf8.RuneError, origBuf
+		}
+		if len(node.children) == 0 {
+			// We've reached a leaf node, so return the value.
+			//
+			// Special case handling for the PasteStart and PasteEnd sequences: we
+			// don't include the supplied modifiers.
+			if node.value == PasteStart || node.value == PasteEnd {
+				mods = 0
+			}
+			return node.value | mods, buf[i+1:]
+		}
+	}
+	// We were matching a sequence but ran out of bytes in the supplied buffer.
+	// Return an error so the caller knows to read more and try again.
+	return utf8.RuneError, origBuf
+}
+
+// lookup returns the value of the sequence which is a prefix of buf along
+// with its length. If buf is a proper prefix of a sequence, partial is true.
+// If no sequence matches, n is 0 and partial is false.
+func (t *seqTrie) lookup(buf []byte) (value rune, n int, partial bool) {
+	node := t
+	for i, b := range buf {
+		node = node.findChild(b)
+		if node == nil {
+			return 0, 0, false
+		}
+		if len(node.children) == 0 {
+			return node.value, i + 1, false
+		}
+	}
+	return 0, 0, true
+}
+
+// Table is a set of key input sequences used to parse keys. The zero value is
+// not usable; use NewTable.
+type Table struct {
+	trie seqTrie
+}
+
+// NewTable returns a Table containing the default supported input sequences.
+// Additional sequences may be registered with Add.
+func NewTable() *Table {
+	t := &Table{}
+	for seq, value := range supportedSeqs {
+		t.trie.add([]byte(seq), value)
+	}
+	return t
+}
+
+// Add registers the input sequence seq for key, replacing the key for an
+// existing identical sequence. Returns false and leaves the table unchanged if
+// seq does not begin with an escape followed by at least one byte, or if seq is
+// a prefix of a registered sequence or has a registered sequence as a prefix,
+// as such sequences could never be matched unambiguously.
+func (t *Table) Add(seq string, key rune) bool {
+	if len(seq) < 2 || seq[0] != Escape {
+		return false
+	}
+	node := &t.trie
+	for i := 0; i < len(seq); i++ {
+		child := node.findChild(seq[i])
+		if child == nil {
+			if node != &t.trie && len(node.children) == 0 {
+				// A registered sequence is a prefix of seq.
+				return false
+			}
+			t.trie.add([]byte(seq), key)
+			return true
+		}
+		node = child
+	}
+	if len(node.children) != 0 {
+		// seq is a prefix of a registered sequence.
+		return false
+	}
+	node.value = key
+	return true
+}
+
+// Parse parses a single key from the prefix of buf in the same manner as the
+// package-level Parse function, using the sequences registered in the table.
+// Sequences which begin with an escape followed by a character other than 'O'
+// or '[' take precedence over interpreting the escape as the Alt modifier.
+func (t *Table) Parse(buf []byte) (rune, []byte) {
+	if len(buf) >= 2 && buf[0] == Escape && buf[1] != 'O' && buf[1] != '[' {
+		if value, n, partial := t.trie.lookup(buf); n > 0 {
+			return value, buf[n:]
+		} else if partial {
+			return utf8.RuneError, buf
+		}
+	}
+	return parse(buf, &t.trie)
+}
+
+// ParseMeta parses a single key from the prefix of buf in the same manner as
+// the package-level ParseMeta function, using the sequences registered in the
+// table.
+func (t *Table) ParseMeta(buf []byte) (rune, []byte) {
+	return parseMeta(buf, t.Parse)
+}
+
+var defaultTable = NewTable()
+
+// Parse parses a single key from the prefix of the specified byte slice.
+// Parsing keys is challenging because the input sequences used by terminals
+// differ. Rather than the termcap/terminfo approach of determining the input
+// sequences based on the $TERM env var, this code takes the approach of
+// handling the most common sequences used by the majority (~75%) of terminals
+// and all modern terminals. This is also the approached used by linenoise, and
+// libraries inspired by linenoise.
+//
+// If the input sequence is not recognized or is not valid UTF-8, Unknown is
+// returned. If a prefix of a recognized input sequence is matched but there are
+// insufficient bytes in the input, utf8.RuneError is returned. On success, the
+// remaining bytes in the input will be returned.
+//
+// See https://invisible-island.net/xterm/xterm-function-keys.html which
+// describes the xterm function keys, and also points to dumping term output
+// using infocmp.
+//
+// See https://en.wikipedia.org/wiki/ANSI_escape_code#Terminal_input_sequences
+// which describes the general structure of terminal input sequences.
+//
+// See Table and TerminfoSequences for parsing additional input sequences.
+func Parse(buf []byte) (rune, []byte) {
+	return parse(buf, &defaultTable.trie)
+}
+
+func parse(buf []byte, trie *seqTrie) (rune, []byte) {
+	var origBuf = buf
+	var mods rune
+
+	for len(buf) >= 2 {
+		// An escape that is not the beginning of "\x1bO..." or "\x1b[..." sets the Alt
+		// modifier.
+		if buf[0] != Escape || buf[1] == 'O' || buf[1] == '[' {
+			break
+		}
+		mods |= Alt
+		buf = buf[1:]
+	}
+
+	if len(buf) <= 0 {
+		return utf8.RuneError, origBuf
+	}
+
+	if buf[0] != Escape {
+		if !utf8.FullRune(buf) {
+			return utf8.RuneError, origBuf
+		}
+		r, l := utf8.DecodeRune(buf)
+		if r == utf8.RuneError && l == 1 {
+			// The input is not valid UTF-8. Skip the invalid byte rather than waiting
+			// for more input that will never make it valid.
+			return Unknown, buf[l:]
+		}
+		return r | mods, buf[l:]
+	}
+
+	return trie.match(buf, origBuf, mods)
+}
+
+// ParseMeta parses a single key from the prefix of the specified byte slice
+// in the same manner as Parse, except that a byte with the high bit set is
+// interpreted as a Meta chord (0200 | ch) rather than as the start of a UTF-8
+// sequence. Some terminals (e.g. xterm with eightBitInput enabled) send Meta
+// chords in this fashion instead of prefixing the character with an escape.
+//
+// An escape followed by a byte with the high bit set is parsed as a single
+// Meta chord, rather than the escape setting the Alt modifier of a UTF-8
+// sequence which would never be completed.
+func ParseMeta(buf []byte) (rune, []byte) {
+	return parseMeta(buf, Parse)
+}
+
+func parseMeta(buf []byte, parse func(buf []byte) (rune, []byte)) (rune, []byte) {
+	switch {
+	case len(buf) >= 2 && buf[0] == Escape && buf[1] >= 0x80:
+		return rune(buf[1]&0x7f) | Alt, buf[2:]
+	case len(buf) == 0 || buf[0] < 0x80:
+		return parse(buf)
+	}
+	return rune(buf[0]&0x7f) | Alt, buf[1:]
+}
@@ -0,0 +1,327 @@
+package keys
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	var sequences = map[string]rune{
+		"\x7f":      Backspace,
+		"a":         rune('a'),
+		"b":         rune('b'),
+		"«":         rune('«'),
+		"»":         rune('»'),
+		"\x1bb":     rune('b') | Alt,
+		"\x1bf":     rune('f') | Alt,
+		"\x1b«":     rune('«') | Alt,
+		"\x1b»":     rune('»') | Alt,
+		"\x01":      CtrlA,
+		"\x02":      CtrlB,
+		"\x05":      CtrlE,
+		"\x06":      CtrlF,
+		"\x08":      CtrlH,
+		"\x0b":      CtrlK,
+		"\x0c":      CtrlL,
+		"\x10":      CtrlP,
+		"\x17":      CtrlW,
+		"\x1bOA":    Up,
+		"\x1bOB":    Down,
+		"\x1bOC":    Right,
+		"\x1bOD":    Left,
+		"\x1bOH":    Home,
+		"\x1bOF":    End,
+		"\x1bOa":    Up | Ctrl,
+		"\x1bOb":    Down | Ctrl,
+		"\x1bOc":    Right | Ctrl,
+		"\x1bOd":    Left | Ctrl,
+		"\x1b[A":    Up,
+		"\x1b[B":    Down,
+		"\x1b[C":    Right,
+		"\x1b[D":    Left,
+		"\x1b[H":    Home,
+		"\x1b[F":    End,
+		"\x1b[1;3A": Up | Alt,
+		"\x1b[1;3B": Down | Alt,
+		"\x1b[1;3C": Right | Alt,
+		"\x1b[1;3D": Left | Alt,
+		"\x1b[1;9A": Up | Alt,
+		"\x1b[1;9B": Down | Alt,
+		"\x1b[1;9C": Right | Alt,
+		"\x1b[1;9D": Left | Alt,
+		"\x1b[1;5A": Up | Ctrl,
+		"\x1b[1;5B": Down | Ctrl,
+		"\x1b[1;5C": Right | Ctrl,
+		"\x1b[1;5D": Left | Ctrl,
+		"\x1b[1~":   Home,
+		"\x1b[200~": PasteStart,
+		"\x1b[201~": PasteEnd,
+		"\x1b[2~":   Insert,
+		"\x1b[3~":   Delete,
+		"\x1b[4~":   End,
+		"\x1b[5~":   PageUp,
+		"\x1b[6~":   PageDown,
+		"\x1b[7~":   Home,
+		"\x1b[8~":   End,
+	}
+
+	incomplete := map[string]rune{
+		"":          utf8.RuneError,
+		"\x1b":      utf8.RuneError,
+		"\x1b[G":    Unknown,
+		"\x1b[10":   utf8.RuneError,
+		"\x1b[1;":   utf8.RuneError,
+		"\x1b[1;3E": Unknown,
+		"\x1b[1;5E": Unknown,
+		"\x1b[9":    utf8.RuneError,
+		"\xff":      Unknown,
+		"\xe4":      utf8.RuneError,
+	}
+
+	for seq, key := range sequences {
+		k, _ := Parse([]byte(seq))
+		require.Equalf(t, key, k, "%q", seq)
+
+		// An escape prefix on an escape sequence will add the Alt modifier.
+		seq = "\x1b" + seq
+		k, _ = Parse([]byte(seq))
+		if key != PasteStart && key != PasteEnd {
+			key |= Alt
+		}
+		require.Equalf(t, key, k, "%q", seq)
+	}
+
+	for seq, key := range incomplete {
+		k, _ := Parse([]byte(seq))
+		require.Equal(t, key, k, "%q", seq)
+	}
+}
+
+func TestParseMeta(t *testing.T) {
+	var sequences = map[string]rune{
+		"a":        rune('a'),
+		"\xe2":     rune('b') | Alt,
+		"\xe6":     rune('f') | Alt,
+		"\xf9":     rune('y') | Alt,
+		"\x88":     CtrlH | Alt,
+		"\xff":     Backspace | Alt,
+		"\x1bOA":   Up,
+		"\x1bb":    rune('b') | Alt,
+		"\x1b\xe2": rune('b') | Alt,
+	}
+
+	for seq, key := range sequences {
+		k, rem := ParseMeta([]byte(seq))
+		require.Equalf(t, key, k, "%q", seq)
+		require.Equalf(t, 0, len(rem), "%q", seq)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	for _, c := range []struct {
+		key rune
+		seq string
+	}{
+		{Up, "\x1b[A"},
+		{Home, "\x1b[H"},
+		{End, "\x1b[F"},
+		{Left | Ctrl, "\x1b[1;5D"},
+		{Left | Alt, "\x1b[1;3D"},
+		{PasteStart, "\x1b[200~"},
+	} {
+		seq, ok := Sequence(c.key)
+		require.True(t, ok)
+		require.Equalf(t, c.seq, seq, "%q", c.key)
+	}
+	_, ok := Sequence('a')
+	require.False(t, ok)
+
+	// The sequence of every supported key parses as the key.
+	for _, key := range supportedSeqs {
+		seq, ok := Sequence(key)
+		require.True(t, ok)
+		k, rem := Parse([]byte(seq))
+		require.Equalf(t, key, k, "%q", seq)
+		require.Empty(t, rem)
+	}
+}
+
+func TestSupportedTerms(t *testing.T) {
+	t.Skip("not really a test, unskip to recompute the number of supported terminals")
+
+	const termInfoDir = "/usr/share/terminfo"
+
+	// capRE extracts the capabilities from the infocmp output. Note that we only
+	// support control sequences that begin with "\E[" (the standard Control
+	// Sequence Introducer) or "\EO" (the introducer used by DEC terminals for some
+	// keys and then somehow copied to lots of unrelated terminals).
+	capRE := regexp.MustCompile(`(\bkey_\w+)=(\\E[\[O][^,]*),`)
+
+	// A map from terminfo capability name to the Go const name we'll output when
+	// the key's control sequence is matched.
+	capToKey := map[string]rune{
+		"key_dc":    Delete,
+		"key_down":  Down,
+		"key_end":   End,
+		"key_home":  Home,
+		"key_ic":    Insert,
+		"key_left":  Left,
+		"key_npage": PageDown,
+		"key_ppage": PageUp,
+		"key_right": Right,
+		"key_up":    Up,
+	}
+
+	supportedTerms := make(map[string]struct{})
+	unsupportedTerms := make(map[string]struct{})
+
+	processLine := func(term string, line []byte) {
+		m := capRE.FindSubmatch(line)
+		if len(m) == 0 {
+			return
+		}
+		key, seq := string(m[1]), string(m[2])
+		seq = strings.ReplaceAll(seq, "\\E", "\x1b")
+		if supportedSeqs[seq] == capToKey[key] {
+			return
+		}
+		unsupportedTerms[term] = struct{}{}
+	}
+
+	processTermInfo := func(term string) {
+		c := exec.Command("infocmp", "-L1", "-A", termInfoDir, term)
+		out, err := c.CombinedOutput()
+		if err != nil {
+			t.Fatalf("infocmp failed: %+v %s\n%s", err, c.Args, out)
+		}
+
+		for buf := bytes.NewBuffer(out); ; {
+			line, err := buf.ReadBytes('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				t.Fatalf("%s: %+v\n", term, err)
+			}
+
+			processLine(term, line)
+
+			if errors.Is(err, io.EOF) {
+				break
+			}
+		}
+
+		if _, ok := unsupportedTerms[term]; !ok {
+			supportedTerms[term] = struct{}{}
+		}
+	}
+
+	err := filepath.WalkDir(termInfoDir, func(path string, d fs.DirEntry, err error) error {
+		if d.Type().IsRegular() {
+			processTermInfo(filepath.Base(path))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unable to find terminfos: %+v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%4d supported terms\n", len(supportedTerms))
+	fmt.Fprintf(os.Stderr, "%4d unsupported terms\n", len(unsupportedTerms))
+}
+
+func TestTable(t *testing.T) {
+	table := NewTable()
+
+	// Sequences must begin with an escape and must not conflict with registered
+	// sequences.
+	require.False(t, table.Add("", Up))
+	require.False(t, table.Add("\x1b", Up))
+	require.False(t, table.Add("A", Up))
+	require.False(t, table.Add("\x1b[", Up))
+	require.False(t, table.Add("\x1b[1;5", Up))
+	require.False(t, table.Add("\x1b[A~", Up))
+	require.True(t, table.Add("\x1bA", Up))
+	require.True(t, table.Add("\x1b[U", PageDown))
+	require.True(t, table.Add("\x1b[11~", Home|Ctrl))
+	require.True(t, table.Add("\x1b[H", End))
+	require.False(t, table.Add("\x1bAB", Down))
+
+	sequences := map[string]rune{
+		"\x1bA":     Up,
+		"\x1b[U":    PageDown,
+		"\x1b[11~":  Home | Ctrl,
+		"\x1b[H":    End,
+		"\x1b[A":    Up,
+		"\x1b[1;5C": Right | Ctrl,
+		"\x1bb":     rune('b') | Alt,
+		"\x1b\x1bA": rune('A') | Alt | Alt,
+		"a":         rune('a'),
+	}
+	for seq, key := range sequences {
+		k, rem := table.Parse([]byte(seq))
+		require.Equalf(t, key, k, "%q", seq)
+		require.Equalf(t, 0, len(rem), "%q", seq)
+	}
+
+	// The default sequences are unaffected.
+	k, _ := Parse([]byte("\x1bA"))
+	require.Equal(t, rune('A')|Alt, k)
+	k, _ = Parse([]byte("\x1b[U"))
+	require.Equal(t, rune(Unknown), k)
+
+	// A partial sequence requires more input.
+	k, rem := table.Parse([]byte("\x1b[1"))
+	require.Equal(t, utf8.RuneError, k)
+	require.Equal(t, "\x1b[1", string(rem))
+
+	k, rem = table.ParseMeta([]byte("\x1bA\xe2"))
+	require.Equal(t, rune(Up), k)
+	k, _ = table.ParseMeta(rem)
+	require.Equal(t, rune('b')|Alt, k)
+}
+
+func TestTerminfoSequences(t *testing.T) {
+	// testdata/terminfo/p/prompt-test is compiled from prompt-test.ti with:
+	//
+	//   tic -o testdata/terminfo testdata/terminfo/prompt-test.ti
+	defer func(v string) { _ = os.Setenv("TERMINFO", v) }(os.Getenv("TERMINFO"))
+	require.NoError(t, os.Setenv("TERMINFO", "testdata/terminfo"))
+
+	seqs, err := TerminfoSequences("prompt-test")
+	require.NoError(t, err)
+	require.Equal(t, map[string]rune{
+		"\x1bA":   Up,
+		"\x1bB":   Down,
+		"\x1bC":   Right,
+		"\x1bD":   Left,
+		"\x1bH":   Home,
+		"\x1b[K~": End,
+		"\x1b[3~": Delete,
+		"\x1b[U":  PageDown,
+		"\x1b[V":  PageUp,
+	}, seqs)
+
+	_, err = TerminfoSequences("no-such-terminal")
+	require.True(t, errors.Is(err, ErrTerminfoNotFound))
+	_, err = TerminfoSequences("../p/prompt-test")
+	require.Error(t, err)
+
+	_, err = parseTerminfo([]byte("not a terminfo entry"))
+	require.Equal(t, errInvalidTerminfo, err)
+
+	data, err := ioutil.ReadFile("testdata/terminfo/p/prompt-test")
+	require.NoError(t, err)
+	_, err = parseTerminfo(data[:len(data)-1])
+	require.Equal(t, errInvalidTerminfo, err)
+}
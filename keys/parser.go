@@ -0,0 +1,147 @@
+package keys
+
+import "unicode/utf8"
+
+// ParserState describes the input buffered by a Parser which has not yet been
+// parsed as a key.
+type ParserState int
+
+const (
+	// StateGround indicates that no input is buffered.
+	StateGround ParserState = iota
+	// StateEscape indicates that the buffered input is one or more escapes,
+	// which are either Escape keys or the Alt modifier of the following key.
+	StateEscape
+	// StateSequence indicates that the buffered input is a partial key input
+	// sequence, optionally preceded by escapes setting the Alt modifier.
+	StateSequence
+	// StateUTF8 indicates that the buffered input is a partial UTF-8 encoded
+	// character, optionally preceded by escapes setting the Alt modifier.
+	StateUTF8
+)
+
+func (s ParserState) String() string {
+	switch s {
+	case StateGround:
+		return "ground"
+	case StateEscape:
+		return "escape"
+	case StateSequence:
+		return "sequence"
+	case StateUTF8:
+		return "utf8"
+	}
+	return "unknown"
+}
+
+// Parser parses keys from input which arrives incrementally, such as the
+// successive reads of a terminal. A partial key input sequence at the end of
+// the input, such as an escape whose remainder is in the next read, is
+// buffered until the remainder arrives so that sequences split across reads
+// are reassembled, and the escapes setting the Alt modifier are retained along
+// with the key they modify.
+type Parser struct {
+	table    *Table
+	meta     bool
+	buf      []byte
+	pos      int
+	timedOut bool
+}
+
+// NewParser returns a Parser which parses the sequences registered in table, or
+// the default sequences if table is nil. If meta is true, a byte with the high
+// bit set is parsed as a Meta chord as by ParseMeta.
+func NewParser(table *Table, meta bool) *Parser {
+	if table == nil {
+		table = defaultTable
+	}
+	return &Parser{table: table, meta: meta}
+}
+
+// Feed appends data to the input to be parsed.
+func (p *Parser) Feed(data []byte) {
+	if p.pos > 0 {
+		n := copy(p.buf, p.buf[p.pos:])
+		p.buf = p.buf[:n]
+		p.pos = 0
+	}
+	p.buf = append(p.buf, data...)
+	p.timedOut = false
+}
+
+// Next parses the next key from the input, returning false if the input is
+// empty or holds a partial key input sequence.
+func (p *Parser) Next() (rune, bool) {
+	buf := p.buf[p.pos:]
+	if len(buf) == 0 {
+		return 0, false
+	}
+	var key rune
+	var rest []byte
+	if p.meta {
+		key, rest = p.table.ParseMeta(buf)
+	} else {
+		key, rest = p.table.Parse(buf)
+	}
+	if key == utf8.RuneError {
+		if !p.timedOut {
+			return 0, false
+		}
+		key, rest = parseExpired(buf)
+	}
+	p.pos += len(buf) - len(rest)
+	return key, true
+}
+
+// Expire indicates that the remainder of the partial key input sequence held
+// by the input did not arrive in time, such as within a key timeout. Until more
+// input is fed to the parser, the partial sequence is then parsed as the keys
+// it begins with: an escape followed by an ASCII character as a Meta chord,
+// an escape alone as the Escape key, and the characters following the chord
+// as literal characters. The bytes of a partial UTF-8 encoded character are
+// parsed as Unknown keys.
+func (p *Parser) Expire() {
+	p.timedOut = true
+}
+
+// parseExpired parses a key from the prefix of buf, which holds a partial key
+// input sequence. See Parser.Expire.
+func parseExpired(buf []byte) (rune, []byte) {
+	if buf[0] != Escape {
+		return Unknown, buf[1:]
+	}
+	if len(buf) >= 2 && buf[1] < utf8.RuneSelf {
+		return rune(buf[1]) | Alt, buf[2:]
+	}
+	return Escape, buf[1:]
+}
+
+// Buffered returns the input which has not yet been parsed. The returned slice
+// is only valid until the next call to Feed.
+func (p *Parser) Buffered() []byte {
+	return p.buf[p.pos:]
+}
+
+// State returns the state of the buffered input, which once Next has returned
+// false describes the partial key input sequence awaiting more input.
+func (p *Parser) State() ParserState {
+	buf := p.buf[p.pos:]
+	if len(buf) == 0 {
+		return StateGround
+	}
+	if _, n, partial := p.table.trie.lookup(buf); n == 0 && partial && len(buf) > 1 {
+		// A prefix of a sequence registered in the table.
+		return StateSequence
+	}
+	for len(buf) > 1 && buf[0] == Escape && buf[1] != 'O' && buf[1] != '[' {
+		buf = buf[1:]
+	}
+	switch {
+	case buf[0] != Escape:
+		return StateUTF8
+	case len(buf) == 1:
+		return StateEscape
+	default:
+		return StateSequence
+	}
+}
@@ -0,0 +1,93 @@
+package keys
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// parseAll parses the keys from p until it requires more input.
+func parseAll(p *Parser) []rune {
+	var result []rune
+	for {
+		key, ok := p.Next()
+		if !ok {
+			return result
+		}
+		result = append(result, key)
+	}
+}
+
+func TestParserSplitReads(t *testing.T) {
+	testCases := []struct {
+		meta     bool
+		reads    []string
+		expected []rune
+		states   []ParserState
+	}{
+		{false, []string{"a\x1b", "[A"}, []rune{'a', Up}, []ParserState{StateEscape, StateGround}},
+		{false, []string{"\x1b[", "1;5", "C"}, []rune{Right | Ctrl},
+			[]ParserState{StateSequence, StateSequence, StateGround}},
+		{false, []string{"\x1b\x1b", "[A"}, []rune{Up | Alt}, []ParserState{StateEscape, StateGround}},
+		{false, []string{"\x1b", "b"}, []rune{'b' | Alt}, []ParserState{StateEscape, StateGround}},
+		{false, []string{"\x1b\xc3", "\xa9"}, []rune{'é' | Alt}, []ParserState{StateUTF8, StateGround}},
+		{false, []string{"\xe2\x82", "\xac"}, []rune{'€'}, []ParserState{StateUTF8, StateGround}},
+		// With eight-bit meta, an escape followed by a byte with the high bit set
+		// is a single Meta chord.
+		{true, []string{"\x1b", "\xe2"}, []rune{'b' | Alt}, []ParserState{StateEscape, StateGround}},
+		{true, []string{"\xe2\x1b", "[A"}, []rune{'b' | Alt, Up}, []ParserState{StateEscape, StateGround}},
+	}
+	for _, c := range testCases {
+		p := NewParser(nil, c.meta)
+		var result []rune
+		var states []ParserState
+		for _, r := range c.reads {
+			p.Feed([]byte(r))
+			result = append(result, parseAll(p)...)
+			states = append(states, p.State())
+		}
+		require.Equal(t, c.expected, result, "%q", c.reads)
+		require.Equal(t, c.states, states, "%q", c.reads)
+	}
+}
+
+func TestParserTable(t *testing.T) {
+	table := NewTable()
+	require.True(t, table.Add("\x1bxy", Home))
+	p := NewParser(table, false)
+	p.Feed([]byte("\x1bx"))
+	require.Empty(t, parseAll(p))
+	require.Equal(t, StateSequence, p.State())
+	p.Feed([]byte("y"))
+	require.Equal(t, []rune{Home}, parseAll(p))
+}
+
+func TestParserExpire(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []rune
+	}{
+		{"\x1b", []rune{Escape}},
+		{"\x1b[", []rune{'[' | Alt}},
+		{"\x1b[1;", []rune{'[' | Alt, '1', ';'}},
+		{"\x1b\x1b", []rune{Escape | Alt}},
+		{"\xe2\x82", []rune{Unknown, Unknown}},
+	}
+	for _, c := range testCases {
+		p := NewParser(nil, false)
+		p.Feed([]byte(c.input))
+		require.Empty(t, parseAll(p), "%q", c.input)
+		p.Expire()
+		require.Equal(t, c.expected, parseAll(p), "%q", c.input)
+		require.Equal(t, StateGround, p.State())
+	}
+
+	// Feeding more input cancels the expiry.
+	p := NewParser(nil, false)
+	p.Feed([]byte("\x1b"))
+	p.Expire()
+	p.Feed([]byte("["))
+	require.Empty(t, parseAll(p))
+	p.Feed([]byte("A"))
+	require.Equal(t, []rune{Up}, parseAll(p))
+}
@@ -0,0 +1,168 @@
+package keys
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Terminfo magic numbers for the legacy format, which uses 16-bit numbers, and
+// the extended number format, which uses 32-bit numbers.
+const (
+	terminfoMagic    = 0432
+	terminfoMagic32  = 01036
+	terminfoHeaderSz = 12
+)
+
+// terminfoKeys maps the indices of string capabilities in the compiled
+// terminfo format to keys. Only the capabilities for the keys which Parse
+// recognizes are included.
+var terminfoKeys = map[int]rune{
+	59:  Delete,   // kdch1
+	61:  Down,     // kcud1
+	76:  Home,     // khome
+	77:  Insert,   // kich1
+	79:  Left,     // kcub1
+	81:  PageDown, // knp
+	82:  PageUp,   // kpp
+	83:  Right,    // kcuf1
+	87:  Up,       // kcuu1
+	164: End,      // kend
+}
+
+// terminfoDirs returns the directories to search for terminfo entries, in the
+// order used by ncurses.
+func terminfoDirs() []string {
+	var dirs []string
+	if dir := os.Getenv("TERMINFO"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+	defaults := []string{
+		"/etc/terminfo",
+		"/lib/terminfo",
+		"/usr/share/terminfo",
+		"/usr/lib/terminfo",
+		"/usr/share/lib/terminfo",
+	}
+	if list := os.Getenv("TERMINFO_DIRS"); list != "" {
+		for _, dir := range strings.Split(list, ":") {
+			if dir == "" {
+				// An empty entry specifies the default directories.
+				dirs = append(dirs, defaults...)
+				continue
+			}
+			dirs = append(dirs, dir)
+		}
+		return dirs
+	}
+	return append(dirs, defaults...)
+}
+
+// readTerminfo reads the compiled terminfo entry for term. Entries are stored
+// in a subdirectory named by the first character of the terminal name, or by
+// its hexadecimal value on case-insensitive filesystems (e.g. macOS).
+func readTerminfo(term string) ([]byte, error) {
+	if term == "" || strings.ContainsAny(term, "/") {
+		return nil, fmt.Errorf("invalid terminal name %q", term)
+	}
+	for _, dir := range terminfoDirs() {
+		for _, sub := range []string{term[:1], fmt.Sprintf("%x", term[0])} {
+			data, err := ioutil.ReadFile(filepath.Join(dir, sub, term))
+			if err == nil {
+				return data, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", term, ErrTerminfoNotFound)
+}
+
+// ErrTerminfoNotFound is returned by TerminfoSequences if there is no
+// terminfo entry for the terminal.
+var ErrTerminfoNotFound = errors.New("terminfo entry not found")
+
+var errInvalidTerminfo = errors.New("invalid terminfo entry")
+
+// parseTerminfo extracts the key input sequences from a compiled terminfo
+// entry. See term(5) for a description of the format.
+func parseTerminfo(data []byte) (map[string]rune, error) {
+	if len(data) < terminfoHeaderSz {
+		return nil, errInvalidTerminfo
+	}
+	header := make([]int, terminfoHeaderSz/2)
+	for i := range header {
+		header[i] = int(int16(binary.LittleEndian.Uint16(data[2*i:])))
+	}
+	numSize := 2
+	switch header[0] {
+	case terminfoMagic:
+	case terminfoMagic32:
+		numSize = 4
+	default:
+		return nil, errInvalidTerminfo
+	}
+	namesSize, boolCount, numCount, strCount, tableSize :=
+		header[1], header[2], header[3], header[4], header[5]
+	if namesSize < 0 || boolCount < 0 || numCount < 0 || strCount < 0 || tableSize < 0 {
+		return nil, errInvalidTerminfo
+	}
+
+	offset := terminfoHeaderSz + namesSize + boolCount
+	if offset%2 != 0 {
+		// The numbers section is aligned on an even byte boundary.
+		offset++
+	}
+	offset += numCount * numSize
+	strOffsets := offset
+	table := strOffsets + 2*strCount
+	if table+tableSize > len(data) {
+		return nil, errInvalidTerminfo
+	}
+
+	seqs := make(map[string]rune)
+	for index, key := range terminfoKeys {
+		if index >= strCount {
+			continue
+		}
+		// Negative offsets indicate absent or cancelled capabilities.
+		off := int(int16(binary.LittleEndian.Uint16(data[strOffsets+2*index:])))
+		if off < 0 || off >= tableSize {
+			continue
+		}
+		s := data[table+off : table+tableSize]
+		end := 0
+		for end < len(s) && s[end] != 0 {
+			end++
+		}
+		if end > 0 {
+			seqs[string(s[:end])] = key
+		}
+	}
+	return seqs, nil
+}
+
+// TerminfoSequences returns the key input sequences described by the terminfo
+// entry for the terminal term (usually the value of $TERM). The entry is
+// located in the same directories searched by ncurses: $TERMINFO,
+// ~/.terminfo, $TERMINFO_DIRS, and the system terminfo directories. Only the
+// sequences for the keys which Parse recognizes (the arrow keys, Home, End,
+// PageUp, PageDown, Insert, and Delete) are returned. The sequences may be registered
+// with Table.Add to parse input from terminals whose sequences are not
+// supported by default.
+func TerminfoSequences(term string) (map[string]rune, error) {
+	data, err := readTerminfo(term)
+	if err != nil {
+		return nil, err
+	}
+	seqs, err := parseTerminfo(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", term, err)
+	}
+	return seqs, nil
+}
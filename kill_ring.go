@@ -1,6 +1,12 @@
 package prompt
 
-import "strings"
+import (
+	"bytes"
+	"os"
+	"strings"
+
+	"github.com/petermattis/prompt/libedit"
+)
 
 const killRingMax = 10
 
@@ -26,6 +32,18 @@ var killCommands = map[command]commandFunc{
 		}
 		return true, nil
 	},
+	cmdCopyBuffer: func(s *state, key rune) (bool, error) {
+		// Copy the input without modifying it.
+		text := string(s.screen.Text())
+		if len(text) == 0 {
+			return true, nil
+		}
+		s.killRing.Push(text)
+		if s.clipboard {
+			writeClipboard(&s.screen.outbuf, text, s.tmux)
+		}
+		return true, nil
+	},
 	cmdKillWord: func(s *state, key rune) (bool, error) {
 		// TODO(peter): if a mark is set, kill-region.
 
@@ -58,11 +76,63 @@ var yankCommands = map[command]commandFunc{
 // killing text, the deleted text is saved for future retrieval in the kill
 // ring. Consecutive kills cause the text to be accumulated in a single entry
 // which can be yanked all at once. Commands which do not kill text separate the
-// entries on the kill ring.
+// entries on the kill ring. If a kill ring file is configured, the entries are
+// loaded from the file and the file is rewritten when ReadLine returns or the
+// Prompt is closed if the kill ring changed, so that killed text survives
+// restarts.
 type killRing struct {
 	entries []string
 	killing bool
 	yanking bool
+	// path is the file the kill ring is persisted to. See WithKillRingFile.
+	// dirty is true if the entries have changed since they were last written.
+	// debug logs failures to write the file.
+	path  string
+	dirty bool
+	debug *debugLog
+}
+
+// Load loads the entries of the kill ring file, if any. The entries are
+// encoded like the entries of a history file, oldest first. Only the newest
+// entries are loaded if there are more than fit in the kill ring.
+func (r *killRing) Load() error {
+	if r.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries, err := libedit.ReadHistory(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if len(entries) > killRingMax {
+		entries = entries[len(entries)-killRingMax:]
+	}
+	r.entries = append(make([]string, 0, killRingMax), entries...)
+	return nil
+}
+
+// Sync writes the entries to the kill ring file, if any, if they have changed
+// since they were last written. Errors are logged rather than returned so that
+// a failure to persist the kill ring does not interrupt editing.
+func (r *killRing) Sync() {
+	if r.path == "" || !r.dirty {
+		return
+	}
+	r.dirty = false
+	var buf bytes.Buffer
+	if err := libedit.WriteHistory(&buf, r.entries); err != nil {
+		r.debug.Printf("kill ring: %v\n", err)
+		return
+	}
+	if err := writeFileAtomic(r.path, buf.Bytes()); err != nil {
+		r.debug.Printf("kill ring: %v\n", err)
+	}
 }
 
 // Append appends text to the current kill ring entry. If the previous command
@@ -83,6 +153,15 @@ func (r *killRing) Prepend(e string) {
 	r.entries[head] = e + r.entries[head]
 }
 
+// Push adds text to the kill ring as a new entry which is not accumulated
+// with the text of subsequent kills.
+func (r *killRing) Push(e string) {
+	r.killing = false
+	r.maybeBeginKill()
+	r.entries[len(r.entries)-1] = e
+	r.killing = false
+}
+
 // Yank returns the current kill ring entry, or nil if the kill ring is empty.
 func (r *killRing) Yank() []rune {
 	if len(r.entries) == 0 {
@@ -103,16 +182,39 @@ func (r *killRing) Rotate() {
 	r.entries[0] = last
 }
 
+// current returns the current kill ring entry.
+func (r *killRing) current() string {
+	if len(r.entries) == 0 {
+		return ""
+	}
+	return r.entries[len(r.entries)-1]
+}
+
 // Dispatch processes the specified command, clearing the killing and yanking
-// states if the command is neither a kill command or a yank command.
+// states if the command is neither a kill command or a yank command. If the
+// tmux paste buffer is enabled, text killed by a kill command is loaded into
+// it.
 func (r *killRing) Dispatch(s *state, cmd command, key rune) (ok bool, err error) {
 	if fn, ok := killCommands[cmd]; ok {
-		return fn(s, key)
+		prev, prevLen := r.current(), len(r.entries)
+		ok, err = fn(s, key)
+		if r.current() != prev || len(r.entries) != prevLen {
+			if s.tmuxBuffer != nil {
+				s.tmuxBuffer.Load(r.current())
+			}
+			r.dirty = true
+		}
+		return ok, err
 	}
 	r.killing = false
 
 	if fn, ok := yankCommands[cmd]; ok {
-		return fn(s, key)
+		ok, err = fn(s, key)
+		if cmd == cmdYankPop && r.yanking {
+			// Persist the rotation of the kill ring.
+			r.dirty = true
+		}
+		return ok, err
 	}
 	r.yanking = false
 
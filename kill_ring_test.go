@@ -0,0 +1,72 @@
+package prompt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKillRingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app", "killring")
+
+	newPrompt := func(input string) *Prompt {
+		t.Helper()
+		return newTestPrompt(t, WithInput(strings.NewReader(input)),
+			WithInteractive(true), WithKillRingFile(path))
+	}
+	readFile := func() string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// Killed text is written to the file when ReadLine returns, creating the
+	// directory.
+	p := newPrompt("select 1\x01\x0bx\r")
+	line, err := p.ReadLine("> ")
+	require.NoError(t, err)
+	require.Equal(t, "x", line)
+	require.Equal(t, "_HiStOrY_V2_\nselect\\0401\n", readFile())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// Consecutive kills are accumulated in a single entry, which is not written
+	// until the Prompt is closed.
+	p.mu.state.Reset([]rune("> "))
+	feedInput(t, p, "from t\x17\x17")
+	require.Equal(t, "_HiStOrY_V2_\nselect\\0401\n", readFile())
+	require.NoError(t, p.Close())
+	require.Equal(t, "_HiStOrY_V2_\nselect\\0401\nfrom\\040t\n", readFile())
+
+	// The entries are loaded by a new Prompt, and yank-pop persists the
+	// rotation of the kill ring.
+	p = newPrompt("")
+	require.Equal(t, "[from t, select 1]", p.mu.state.killRing.String())
+	feedInput(t, p, "\x19\x1by")
+	require.Equal(t, "select 1", string(p.mu.state.screen.Text()))
+	require.NoError(t, p.Close())
+	require.Equal(t, "_HiStOrY_V2_\nfrom\\040t\nselect\\0401\n", readFile())
+
+	// Only the newest entries which fit in the kill ring are loaded.
+	var buf strings.Builder
+	buf.WriteString("_HiStOrY_V2_\n")
+	for i := 0; i < killRingMax+2; i++ {
+		fmt.Fprintf(&buf, "%d\n", i)
+	}
+	require.NoError(t, os.WriteFile(path, []byte(buf.String()), 0600))
+	p = newPrompt("")
+	require.Equal(t, "[11, 10, 9, 8, 7, 6, 5, 4, 3, 2]", p.mu.state.killRing.String())
+	require.NoError(t, p.Close())
+
+	// A malformed file is an error.
+	require.NoError(t, os.WriteFile(path, []byte("killring\n"), 0600))
+	_, err = New(WithOutput(ioutil.Discard), WithInteractive(true), WithKillRingFile(path))
+	require.Error(t, err)
+}
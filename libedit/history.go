@@ -0,0 +1,73 @@
+package libedit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// HistoryCookie is the special value stored on the first line of libedit
+// history files.
+const HistoryCookie = "_HiStOrY_V2_"
+
+// ReadHistory reads the entries of a libedit history file, oldest first. The
+// entries are expected to be encoded one per line using the visual encoding
+// (see DecodeVis), following a first line containing HistoryCookie. An empty
+// file contains no entries.
+func ReadHistory(r io.Reader) ([]string, error) {
+	var entries []string
+	err := ReadHistoryLines(r, func(line string) error {
+		v, err := DecodeVis(line)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ReadHistoryLines reads the lines of a libedit history file following the
+// first line, which must contain HistoryCookie, invoking fn with each line as
+// is rather than decoding it. This allows an application to store lines other
+// than entries in the file, such as metadata, which it recognizes before
+// decoding the entries with DecodeVis. An empty file contains no lines.
+// Reading stops at the first error returned by fn, which is returned.
+func ReadHistoryLines(r io.Reader, fn func(line string) error) error {
+	s := bufio.NewScanner(r)
+	for n := 0; s.Scan(); n++ {
+		text := s.Text()
+		if n == 0 {
+			if text != HistoryCookie {
+				return fmt.Errorf("malformed history cookie: %q != %q", text, HistoryCookie)
+			}
+			continue
+		}
+		if err := fn(text); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// WriteHistory writes entries, oldest first, in the format of a libedit
+// history file.
+func WriteHistory(w io.Writer, entries []string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s\n", HistoryCookie)
+	for _, e := range entries {
+		if err := WriteEntry(bw, e); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteEntry appends a single entry to a libedit history file.
+func WriteEntry(w io.Writer, entry string) error {
+	_, err := fmt.Fprintf(w, "%s\n", EncodeVis(entry))
+	return err
+}
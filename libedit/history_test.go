@@ -0,0 +1,57 @@
+package libedit
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryRoundtrip(t *testing.T) {
+	entries := []string{"select 1", "multi\nline entry", `back\slash`, "\ttab"}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteHistory(&buf, entries))
+	require.Equal(t,
+		"_HiStOrY_V2_\nselect\\0401\nmulti\\012line\\040entry\nback\\134slash\n\\011tab\n",
+		buf.String())
+
+	decoded, err := ReadHistory(&buf)
+	require.NoError(t, err)
+	require.Equal(t, entries, decoded)
+
+	require.NoError(t, WriteEntry(&buf, "appended entry"))
+	require.Equal(t, "appended\\040entry\n", buf.String())
+}
+
+func TestReadHistory(t *testing.T) {
+	entries, err := ReadHistory(strings.NewReader(""))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	_, err = ReadHistory(strings.NewReader("foo\nbar\n"))
+	require.EqualError(t, err, `malformed history cookie: "foo" != "_HiStOrY_V2_"`)
+
+	_, err = ReadHistory(strings.NewReader("_HiStOrY_V2_\nbad\\\n"))
+	require.Error(t, err)
+}
+
+func TestReadHistoryLines(t *testing.T) {
+	var lines []string
+	require.NoError(t, ReadHistoryLines(strings.NewReader("_HiStOrY_V2_\nselect\\0401\n\\$meta\n"),
+		func(line string) error {
+			lines = append(lines, line)
+			return nil
+		}))
+	require.Equal(t, []string{"select\\0401", "\\$meta"}, lines)
+
+	err := ReadHistoryLines(strings.NewReader("_HiStOrY_V2_\na\nb\n"), func(line string) error {
+		return fmt.Errorf("stop at %s", line)
+	})
+	require.EqualError(t, err, "stop at a")
+
+	err = ReadHistoryLines(strings.NewReader("foo\n"), func(string) error { return nil })
+	require.EqualError(t, err, `malformed history cookie: "foo" != "_HiStOrY_V2_"`)
+}
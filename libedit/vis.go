@@ -1,4 +1,7 @@
-package prompt
+// Package libedit implements the file formats used by the libedit (editline)
+// library, allowing interoperation with the history files of tools that use
+// libedit such as psql and the cockroach sql shell.
+package libedit
 
 import (
 	"fmt"
@@ -8,9 +11,9 @@ import (
 	"unicode/utf8"
 )
 
-// encodeVis encodes a string using the visual encoding used by libedit for
+// EncodeVis encodes a string using the visual encoding used by libedit for
 // entries in the history file.
-func encodeVis(s string) string {
+func EncodeVis(s string) string {
 	var buf strings.Builder
 	for len(s) > 0 {
 		r, size := utf8.DecodeRuneInString(s)
@@ -30,10 +33,10 @@ func encodeVis(s string) string {
 	return buf.String()
 }
 
-// decodeVis decodes the visual encoding used by libedit for entries in the
+// DecodeVis decodes the visual encoding used by libedit for entries in the
 // history file. This function does not handle the "%<hex>", "&<amp>", or
 // "=<mime>" escape sequences which are not used in the history file.
-func decodeVis(s string) (string, error) {
+func DecodeVis(s string) (string, error) {
 	var buf strings.Builder
 
 	for len(s) > 0 {
@@ -1,4 +1,4 @@
-package prompt
+package libedit
 
 import (
 	"testing"
@@ -14,8 +14,8 @@ func TestVisRoundtrip(t *testing.T) {
 	}
 	for _, c := range testCases {
 		t.Run("", func(t *testing.T) {
-			e := encodeVis(c)
-			d, err := decodeVis(e)
+			e := EncodeVis(c)
+			d, err := DecodeVis(e)
 			require.NoError(t, err)
 			require.Equal(t, d, c)
 		})
@@ -49,7 +49,7 @@ func TestVisDecode(t *testing.T) {
 	}
 	for _, c := range testCases {
 		t.Run("", func(t *testing.T) {
-			d, err := decodeVis(c.encoded)
+			d, err := DecodeVis(c.encoded)
 			require.NoError(t, err)
 			require.Equalf(t, c.expected, d, "%q", d)
 		})
@@ -69,7 +69,7 @@ func TestVisDecodeError(t *testing.T) {
 	}
 	for _, c := range testCases {
 		t.Run("", func(t *testing.T) {
-			_, err := decodeVis(c)
+			_, err := DecodeVis(c)
 			require.Error(t, err)
 		})
 	}
@@ -0,0 +1,121 @@
+package prompt
+
+// Severity is the severity of a Diagnostic.
+type Severity int
+
+const (
+	// SeverityError indicates an error, such as a syntax error.
+	SeverityError Severity = iota
+	// SeverityWarning indicates a likely problem which does not prevent the
+	// input from being accepted.
+	SeverityWarning
+	// SeverityInfo indicates an informational note.
+	SeverityInfo
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// Diagnostic is a problem reported by a linter for the range [Start,End) of
+// the input text. Like the positions of a Span, Start and End are positions in
+// the input text in runes. An empty range indicates a problem at a position,
+// such as missing text.
+type Diagnostic struct {
+	Start, End int
+	Severity   Severity
+	Message    string
+}
+
+// LintFunc is used to check the input text, returning the diagnostics for the
+// problems found. It is invoked after pending input has been processed if the
+// input text was modified, rather than after every key. The ranges of the
+// diagnostics are displayed using the style of their severity (see Theme), and
+// the message of the diagnostic at the cursor position is displayed below the
+// input.
+type LintFunc func(text string) []Diagnostic
+
+// linter displays the diagnostics returned by the lint function. The displayed
+// completion hint is excluded from the text passed to the lint function.
+type linter struct {
+	fn LintFunc
+	// text is the text passed to the most recent invocation of fn, and valid is
+	// false if fn has not been invoked since the input was reset.
+	text  string
+	valid bool
+	// diagnostics holds the diagnostics returned for text.
+	diagnostics []Diagnostic
+	// message is the displayed message of the diagnostic at the cursor.
+	message string
+}
+
+// Reset discards the diagnostics of the previous input.
+func (l *linter) Reset() {
+	l.text = ""
+	l.valid = false
+	l.diagnostics = nil
+	l.message = ""
+}
+
+// Update invokes the lint function if the input text was modified since the
+// last update, and displays the diagnostics.
+func (l *linter) Update(s *state) {
+	if l.fn == nil {
+		return
+	}
+
+	// Exclude the completion hint, if any.
+	text, m := excludeHint(s)
+	if str := string(text); !l.valid || str != l.text {
+		l.text = str
+		l.valid = true
+		l.diagnostics = l.fn(str)
+	}
+
+	// The diagnostics are re-applied even if the text was not modified, as
+	// their attributes are lost if the text is erased and re-inserted (e.g. by
+	// history navigation). ReplaceAttrs does not re-render unchanged
+	// diagnostics.
+	attrs := make([]attrInfo, 0, len(l.diagnostics))
+	for _, d := range l.diagnostics {
+		if d.Start < 0 || d.End > len(text) || d.Start >= d.End {
+			continue
+		}
+		style := string(s.theme.diagnosticStyle(d.Severity))
+		start, end := m.toInput(d.Start, false), m.toInput(d.End, true)
+		if d.Start < m.start && d.End > m.start {
+			// The diagnostic crosses the hint. Split it into the portions before
+			// and after the hint.
+			attrs = append(attrs, attrInfo{startPos: start, endPos: m.start, value: style})
+			start = m.start + m.len
+		}
+		attrs = append(attrs, attrInfo{startPos: start, endPos: end, value: style})
+	}
+	s.screen.ReplaceAttrs(attrKindDiagnostic, attrs)
+
+	// Display the message of the first diagnostic at the cursor position.
+	pos := m.toText(s.screen.Position())
+	var message string
+	for _, d := range l.diagnostics {
+		if d.Start <= pos && pos <= d.End {
+			message = d.Severity.String() + ": " + d.Message
+			break
+		}
+	}
+	if message == l.message {
+		return
+	}
+	l.message = message
+	ann := Annotation{Priority: AnnotationPriorityDiagnostic}
+	if message != "" {
+		ann.Text = "\n" + message
+	}
+	s.annotations.Set(&s.screen, annotationDiagnostic, ann)
+}
@@ -0,0 +1,80 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinter(t *testing.T) {
+	// The linter reports unknown keywords as errors and lowercase keywords as
+	// warnings.
+	var calls []string
+	lint := func(text string) []Diagnostic {
+		calls = append(calls, text)
+		var diagnostics []Diagnostic
+		pos := 0
+		for _, word := range strings.Split(text, " ") {
+			n := len([]rune(word))
+			switch {
+			case word == "" || word == "SELECT" || word == "FROM":
+			case word == "select" || word == "from":
+				diagnostics = append(diagnostics, Diagnostic{
+					Start: pos, End: pos + n, Severity: SeverityWarning,
+					Message: "lowercase keyword",
+				})
+			default:
+				diagnostics = append(diagnostics, Diagnostic{
+					Start: pos, End: pos + n, Severity: SeverityError,
+					Message: fmt.Sprintf("unknown keyword %q", word),
+				})
+			}
+			pos += n + 1
+		}
+		return diagnostics
+	}
+
+	p := newTestPrompt(t, WithLinter(lint))
+
+	diagnostics := func() string {
+		var buf strings.Builder
+		s := &p.mu.state.screen
+		for _, a := range s.attrs.All() {
+			if a.kind == attrKindDiagnostic {
+				fmt.Fprintf(&buf, "[%s %q]", string(s.text[a.startPos:a.endPos]), a.value)
+			}
+		}
+		return buf.String()
+	}
+	message := func() string {
+		return strings.TrimPrefix(string(p.mu.state.screen.suffix), "\n")
+	}
+
+	// The linter is invoked once for the pending input.
+	feedInput(t, p, "SELECT x")
+	require.Equal(t, []string{"SELECT x"}, calls)
+	require.Equal(t, `[x "\x1b[4;31m"]`, diagnostics())
+	require.Equal(t, `error: unknown keyword "x"`, message())
+
+	// The message of the diagnostic at the cursor position is displayed.
+	feedInput(t, p, "\x1b[D\x1b[D")
+	require.Len(t, calls, 1)
+	require.Equal(t, "", message())
+
+	feedInput(t, p, "\x05 from")
+	require.Equal(t, `[x "\x1b[4;31m"][from "\x1b[4;33m"]`, diagnostics())
+	require.Equal(t, "warning: lowercase keyword", message())
+
+	// Diagnostics are removed when the problem is fixed.
+	feedInput(t, p, "\x7f\x7f\x7f\x7fFROM")
+	require.Equal(t, `[x "\x1b[4;31m"]`, diagnostics())
+	require.Equal(t, "", message())
+
+	// The diagnostics are discarded when the input is reset.
+	p.mu.state.Reset([]rune("> "))
+	require.Equal(t, "", diagnostics())
+	feedInput(t, p, "y")
+	require.Equal(t, `[y "\x1b[4;31m"]`, diagnostics())
+}
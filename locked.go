@@ -0,0 +1,76 @@
+package prompt
+
+// LockedPrompt gives a callback invoked by ReadLine access to the Prompt.
+// ReadLine holds the mutex of the Prompt while it invokes callbacks, so a
+// callback must not call the methods of the Prompt, which acquire the mutex.
+// The callbacks which may read secondary input or update the display, such as
+// an AcceptCheckFunc, are instead passed a LockedPrompt, whose methods are
+// performed with the mutex already held. A LockedPrompt is only valid until the
+// callback it was passed to returns, and must not be used by other goroutines.
+type LockedPrompt struct {
+	p *Prompt
+}
+
+// withLocked invokes fn with a LockedPrompt for p, whose mutex must be held,
+// invalidating the LockedPrompt once fn returns.
+func (p *Prompt) withLocked(fn func(lp *LockedPrompt)) {
+	lp := &LockedPrompt{p: p}
+	defer func() {
+		lp.p = nil
+	}()
+	fn(lp)
+}
+
+// ReadLine reads secondary input, e.g. to ask for confirmation, using a nested
+// ReadLine. The outer prompt is suspended while the nested ReadLine is active,
+// and its input text and screen state are restored afterwards. Input read by a
+// nested ReadLine is not added to history.
+func (lp *LockedPrompt) ReadLine(prompt string) (string, error) {
+	return lp.p.readNestedLocked(prompt, lp.p.readLineLocked)
+}
+
+// SetPrompt is like Prompt.SetPrompt.
+func (lp *LockedPrompt) SetPrompt(prompt string) {
+	lp.p.setPromptLocked(prompt)
+}
+
+// SetAnnotation is like Prompt.SetAnnotation.
+func (lp *LockedPrompt) SetAnnotation(name string, a Annotation) {
+	lp.p.setAnnotationLocked(name, a)
+}
+
+// ClearAnnotation is like Prompt.ClearAnnotation.
+func (lp *LockedPrompt) ClearAnnotation(name string) {
+	lp.p.setAnnotationLocked(name, Annotation{})
+}
+
+// CurrentText is like Prompt.CurrentText.
+func (lp *LockedPrompt) CurrentText() string {
+	text, _ := lp.p.currentInputLocked()
+	return text
+}
+
+// CursorPosition is like Prompt.CursorPosition.
+func (lp *LockedPrompt) CursorPosition() int {
+	_, pos := lp.p.currentInputLocked()
+	return pos
+}
+
+// ApplyStyle is like Prompt.ApplyStyle.
+func (lp *LockedPrompt) ApplyStyle(start, end int, style Style) {
+	lp.p.applyStyleLocked(start, end, style)
+}
+
+// ReadSecret reads secondary input without displaying it, as by
+// Prompt.ReadSecret, using a nested ReadLine. See LockedPrompt.ReadLine.
+func (lp *LockedPrompt) ReadSecret(prompt string) (string, error) {
+	return lp.p.readNestedLocked(prompt, lp.p.readSecretLocked)
+}
+
+// ReadLineTemplate reads secondary input initialized to the segments, as by
+// Prompt.ReadLineTemplate, using a nested ReadLine. See LockedPrompt.ReadLine.
+func (lp *LockedPrompt) ReadLineTemplate(prompt string, segments ...TemplateSegment) (string, error) {
+	return lp.p.readNestedLocked(prompt, func(prompt string) (string, error) {
+		return lp.p.readTemplateLocked(prompt, segments)
+	})
+}
@@ -0,0 +1,159 @@
+package prompt
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Metrics holds counters describing the work performed by a Prompt. The
+// counters are cumulative since the Prompt was created. Metrics are intended
+// to help diagnose a prompt which feels slow, e.g. over a high latency SSH
+// connection, by quantifying the output sent to the terminal and the time
+// spent in user callbacks.
+type Metrics struct {
+	// Elapsed is the time since the Prompt was created.
+	Elapsed time.Duration
+	// Keys is the number of keys processed.
+	Keys int64
+	// BytesWritten is the number of bytes written to the terminal.
+	BytesWritten int64
+	// Renders is the number of times rendered output was written to the
+	// terminal.
+	Renders int64
+	// FullRedraws is the number of times the entire prompt and input text was
+	// re-rendered, such as due to a terminal resize or a call to Prompt.Redraw.
+	FullRedraws int64
+	// Completer, Highlighter, and InputFinished hold the latencies of the
+	// completion, highlighting, and input finished callbacks.
+	Completer     CallbackMetrics
+	Highlighter   CallbackMetrics
+	InputFinished CallbackMetrics
+}
+
+// BytesPerKey returns the average number of bytes written to the terminal per
+// key processed.
+func (m Metrics) BytesPerKey() float64 {
+	if m.Keys == 0 {
+		return 0
+	}
+	return float64(m.BytesWritten) / float64(m.Keys)
+}
+
+// RendersPerSecond returns the average rate at which rendered output was
+// written to the terminal.
+func (m Metrics) RendersPerSecond() float64 {
+	if m.Elapsed <= 0 {
+		return 0
+	}
+	return float64(m.Renders) / m.Elapsed.Seconds()
+}
+
+// CallbackMetrics holds the latencies of invocations of a callback.
+type CallbackMetrics struct {
+	// Calls is the number of times the callback was invoked.
+	Calls int64
+	// Total is the total time spent in the callback.
+	Total time.Duration
+	// Max is the maximum time spent in a single invocation of the callback.
+	Max time.Duration
+}
+
+// Mean returns the average time spent in an invocation of the callback.
+func (m CallbackMetrics) Mean() time.Duration {
+	if m.Calls == 0 {
+		return 0
+	}
+	return m.Total / time.Duration(m.Calls)
+}
+
+type callbackKind int
+
+const (
+	callbackCompleter callbackKind = iota
+	callbackHighlighter
+	callbackInputFinished
+	numCallbackKinds
+)
+
+// metrics holds the counters exposed by Prompt.Metrics. The counters are
+// accessed atomically so that a snapshot can be taken without waiting for
+// input processing to finish. The methods are no-ops on a nil receiver.
+type metrics struct {
+	keys         int64
+	bytesWritten int64
+	renders      int64
+	fullRedraws  int64
+	callbacks    [numCallbackKinds]struct {
+		calls, total, max int64
+	}
+	start time.Time
+}
+
+func newMetrics() *metrics {
+	return &metrics{start: time.Now()}
+}
+
+// Snapshot returns the current values of the counters.
+func (m *metrics) Snapshot() Metrics {
+	if m == nil {
+		return Metrics{}
+	}
+	snapshot := Metrics{
+		Elapsed:      time.Since(m.start),
+		Keys:         atomic.LoadInt64(&m.keys),
+		BytesWritten: atomic.LoadInt64(&m.bytesWritten),
+		Renders:      atomic.LoadInt64(&m.renders),
+		FullRedraws:  atomic.LoadInt64(&m.fullRedraws),
+	}
+	callbacks := [numCallbackKinds]*CallbackMetrics{
+		callbackCompleter:     &snapshot.Completer,
+		callbackHighlighter:   &snapshot.Highlighter,
+		callbackInputFinished: &snapshot.InputFinished,
+	}
+	for kind, c := range callbacks {
+		cb := &m.callbacks[kind]
+		c.Calls = atomic.LoadInt64(&cb.calls)
+		c.Total = time.Duration(atomic.LoadInt64(&cb.total))
+		c.Max = time.Duration(atomic.LoadInt64(&cb.max))
+	}
+	return snapshot
+}
+
+// AddKey records the processing of a key.
+func (m *metrics) AddKey() {
+	if m != nil {
+		atomic.AddInt64(&m.keys, 1)
+	}
+}
+
+// AddRender records the writing of n bytes of rendered output.
+func (m *metrics) AddRender(n int) {
+	if m != nil && n > 0 {
+		atomic.AddInt64(&m.renders, 1)
+		atomic.AddInt64(&m.bytesWritten, int64(n))
+	}
+}
+
+// AddFullRedraw records a re-rendering of the entire prompt and input text.
+func (m *metrics) AddFullRedraw() {
+	if m != nil {
+		atomic.AddInt64(&m.fullRedraws, 1)
+	}
+}
+
+// AddCallback records an invocation of a callback which started at start.
+func (m *metrics) AddCallback(kind callbackKind, start time.Time) {
+	if m == nil {
+		return
+	}
+	d := int64(time.Since(start))
+	cb := &m.callbacks[kind]
+	atomic.AddInt64(&cb.calls, 1)
+	atomic.AddInt64(&cb.total, d)
+	for {
+		max := atomic.LoadInt64(&cb.max)
+		if d <= max || atomic.CompareAndSwapInt64(&cb.max, max, d) {
+			break
+		}
+	}
+}
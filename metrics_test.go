@@ -0,0 +1,46 @@
+package prompt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	var out bytes.Buffer
+	completer := func(text []rune, wordStart, wordEnd int) []string {
+		return []string{"hello"}
+	}
+	p := newTestPrompt(t, WithOutput(&out), WithInteractive(true), WithCompleter(completer),
+		WithInputFinished(func(text string) bool { return true }))
+	p.mu.state.screen.Flush(&out)
+
+	m := p.Metrics()
+	require.EqualValues(t, 0, m.Keys)
+	require.EqualValues(t, 1, m.Renders)
+	require.EqualValues(t, out.Len(), m.BytesWritten)
+
+	feedInput(t, p, "he")
+	m = p.Metrics()
+	require.EqualValues(t, 2, m.Keys)
+	require.EqualValues(t, 2, m.Renders)
+	require.EqualValues(t, out.Len(), m.BytesWritten)
+	require.EqualValues(t, float64(out.Len())/2, m.BytesPerKey())
+	require.EqualValues(t, 0, m.FullRedraws)
+	require.EqualValues(t, 2, m.Completer.Calls)
+	require.LessOrEqual(t, int64(m.Completer.Max), int64(m.Completer.Total))
+	require.EqualValues(t, 0, m.InputFinished.Calls)
+
+	p.Redraw()
+	m = p.Metrics()
+	require.EqualValues(t, 1, m.FullRedraws)
+	require.EqualValues(t, 3, m.Renders)
+	require.EqualValues(t, out.Len(), m.BytesWritten)
+
+	feedInput(t, p, "\r")
+	m = p.Metrics()
+	require.EqualValues(t, 3, m.Keys)
+	require.EqualValues(t, 1, m.InputFinished.Calls)
+	require.Greater(t, m.RendersPerSecond(), 0.0)
+}
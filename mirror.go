@@ -0,0 +1,72 @@
+package prompt
+
+import (
+	"io"
+	"strings"
+	"unicode"
+)
+
+type mirrorOutputOption struct {
+	w io.Writer
+}
+
+func (o mirrorOutputOption) apply(p *Prompt) {
+	p.mu.state.mirror = o.w
+}
+
+// WithMirrorOutput configures a plain-text transcript of the session to be
+// written to w, such as for an audit log. A line is written to w each time
+// input is accepted or cancelled, holding the prompt followed by the input as
+// it was left on screen and any echo configured by WithExitEcho, without the
+// escape sequences and intermediate rendering written to the terminal. The
+// input of ReadSecret is not written, and input read non-interactively is
+// written without the prompt, as the prompt is not displayed. Errors writing to
+// w are ignored.
+func WithMirrorOutput(w io.Writer) Option {
+	return mirrorOutputOption{w}
+}
+
+// mirrorLine writes a line of the transcript to the mirror output, if any,
+// holding the prompt followed by text and echo.
+func (s *state) mirrorLine(text, echo string) {
+	if s.mirror == nil {
+		return
+	}
+	var buf strings.Builder
+	buf.WriteString(stripEscapes(string(s.screen.prefix)))
+	buf.WriteString(text)
+	buf.WriteString(echo)
+	buf.WriteByte('\n')
+	if _, err := io.WriteString(s.mirror, buf.String()); err != nil {
+		s.debug.Printf("mirror: %v\n", err)
+	}
+}
+
+// stripEscapes removes the CSI and OSC escape sequences and other control
+// characters, other than newlines, from text.
+func stripEscapes(text string) string {
+	var buf strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\x1b' && i+1 < len(text) && text[i+1] == '[':
+			// CSI: parameter bytes followed by a final byte.
+			for i += 2; i < len(text) && (text[i] < 0x40 || text[i] > 0x7e); i++ {
+			}
+		case c == '\x1b' && i+1 < len(text) && text[i+1] == ']':
+			// OSC: terminated by BEL or ST.
+			for i += 2; i < len(text); i++ {
+				if text[i] == '\a' {
+					break
+				}
+				if text[i] == '\x1b' && i+1 < len(text) && text[i+1] == '\\' {
+					i++
+					break
+				}
+			}
+		case c == '\n' || c >= 0x80 || !unicode.IsControl(rune(c)):
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
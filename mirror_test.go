@@ -0,0 +1,72 @@
+package prompt
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorOutput(t *testing.T) {
+	var mirror bytes.Buffer
+	p, err := New(
+		WithScript(strings.NewReader(
+			"select 1;<Enter>"+
+				"select<Meta-Enter>2;<Enter>"+
+				"sel<Control-c>"+
+				"<Control-c>"+
+				"<Control-d>")),
+		WithOutput(io.Discard), WithSize(40, 5),
+		WithMirrorOutput(&mirror), WithExitEcho("^C", "^D"))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err := p.ReadLine("\x1b[1m>\x1b[0m ")
+		require.NoError(t, err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := p.ReadLine("> ")
+		require.Equal(t, io.EOF, err)
+	}
+	require.Equal(t, "> select 1;\n> select\n2;\n> sel^C\n> ^C\n> ^D\n", mirror.String())
+
+	// The input of ReadSecret is not mirrored.
+	mirror.Reset()
+	p, err = New(
+		WithScript(strings.NewReader("hunter2<Enter>")),
+		WithOutput(io.Discard), WithSize(40, 5), WithMirrorOutput(&mirror))
+	require.NoError(t, err)
+	secret, err := p.ReadSecret("Password: ")
+	require.NoError(t, err)
+	require.Equal(t, "hunter2", secret)
+	require.Equal(t, "Password: \n", mirror.String())
+
+	// Input read non-interactively is mirrored without the prompt.
+	mirror.Reset()
+	p, err = New(
+		WithInput(strings.NewReader("select 1;\nselect 2;\n")),
+		WithInteractive(false), WithMirrorOutput(&mirror))
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err := p.ReadLine("> ")
+		require.NoError(t, err)
+	}
+	require.Equal(t, "select 1;\nselect 2;\n", mirror.String())
+}
+
+func TestStripEscapes(t *testing.T) {
+	for _, c := range []struct {
+		text, expected string
+	}{
+		{"> ", "> "},
+		{"\x1b[1;32mdb\x1b[0m> ", "db> "},
+		{"\x1b]0;title\adb> ", "db> "},
+		{"\x1b]133;A\x1b\\db> ", "db> "},
+		{"a\tb\nc\x07", "ab\nc"},
+		{"été> ", "été> "},
+	} {
+		require.Equal(t, c.expected, stripEscapes(c.text), "%q", c.text)
+	}
+}
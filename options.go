@@ -3,6 +3,8 @@ package prompt
 import (
 	"io"
 	"os"
+	"strings"
+	"time"
 )
 
 // Option defines the interface for Prompt options.
@@ -27,6 +29,92 @@ func WithTTY(tty *os.File) Option {
 	}
 }
 
+type filesOption struct {
+	in, out *os.File
+}
+
+func (o *filesOption) apply(p *Prompt) {
+	p.in = o.in
+	p.out = o.out
+}
+
+// WithFiles allows configuring a prompt with separate input and output files.
+// The input file is the one placed in raw mode and queried for the terminal
+// size.
+func WithFiles(in, out *os.File) Option {
+	return &filesOption{
+		in:  in,
+		out: out,
+	}
+}
+
+type ttyFallbackOption struct {
+	enabled bool
+}
+
+func (o ttyFallbackOption) apply(p *Prompt) {
+	p.ttyFallback = o.enabled
+}
+
+type resizeSignalOption struct {
+	enabled bool
+}
+
+func (o resizeSignalOption) apply(p *Prompt) {
+	p.resizeSignal = o.enabled
+}
+
+// WithResizeSignal configures whether the size of a terminal input is updated
+// when the process receives SIGWINCH, which is enabled by default. It should be
+// disabled when the input is not the controlling terminal of the process, such
+// as the pty of one of many SSH sessions served by the process, as the signal
+// does not indicate which terminal was resized. The size is then updated by
+// Prompt.SetSize.
+func WithResizeSignal(enabled bool) Option {
+	return resizeSignalOption{enabled}
+}
+
+// WithTTYFallback configures whether the prompt falls back to interacting with
+// the controlling terminal (/dev/tty) when the input is not a terminal, such as
+// when stdin is a pipe. The terminal is then used for both input and output. If
+// the controlling terminal cannot be opened, the configured input and output
+// are used.
+func WithTTYFallback(enabled bool) Option {
+	return ttyFallbackOption{enabled}
+}
+
+type interactiveMode int
+
+const (
+	interactiveAuto interactiveMode = iota
+	interactiveOn
+	interactiveOff
+)
+
+type interactiveOption struct {
+	interactive bool
+}
+
+func (o interactiveOption) apply(p *Prompt) {
+	if o.interactive {
+		p.interactive = interactiveOn
+	} else {
+		p.interactive = interactiveOff
+	}
+}
+
+// WithInteractive forces input to be read interactively or non-interactively.
+// In non-interactive mode, ReadLine reads lines of input without placing the
+// terminal in raw mode or writing any output: the prompt is not displayed, no
+// editing is performed, and history is neither loaded nor added to. Lines are
+// accumulated until the WithInputFinished callback considers the input
+// complete. This allows programs to be used both interactively and with
+// scripted input. By default, input from a file which is not a terminal (such
+// as a pipe) is read non-interactively, and all other input interactively.
+func WithInteractive(interactive bool) Option {
+	return interactiveOption{interactive}
+}
+
 type inputOption struct {
 	r io.Reader
 }
@@ -59,6 +147,108 @@ func WithOutput(w io.Writer) Option {
 	}
 }
 
+type eightBitMetaOption struct {
+	enabled bool
+}
+
+func (o eightBitMetaOption) apply(p *Prompt) {
+	p.metaBit = o.enabled
+}
+
+// WithEightBitMeta configures whether input bytes with the high bit set are
+// interpreted as Meta chords. Some terminals send Meta-<ch> as the single byte
+// 0200|ch rather than as an escape followed by <ch>. Enabling this option
+// causes such bytes to be translated to the corresponding Meta key rather than
+// being decoded as (usually invalid) UTF-8. Note that enabling this option
+// precludes the input of non-ASCII characters.
+func WithEightBitMeta(enabled bool) Option {
+	return eightBitMetaOption{enabled}
+}
+
+type keyTimeoutOption struct {
+	d time.Duration
+}
+
+func (o keyTimeoutOption) apply(p *Prompt) {
+	p.keyTimeout = o.d
+}
+
+// WithKeyTimeout configures how long to wait for the remainder of a partial
+// key input sequence, such as an escape which may be the Escape key or the
+// start of the sequence sent by an arrow key, before parsing the input
+// received so far as the keys it begins with: an escape followed by a
+// character is parsed as a Meta chord, an escape alone as the Escape key, and
+// the remaining characters as literal characters. A longer timeout is useful
+// over high-latency links, where sequences may arrive split across reads. By
+// default, or if d is not positive, there is no timeout and a partial sequence
+// is parsed once more input arrives. Note that a read of the input still in
+// progress when the timeout expires completes in the background, and the input
+// it reads is processed by the next ReadLine.
+func WithKeyTimeout(d time.Duration) Option {
+	return keyTimeoutOption{d}
+}
+
+type encodingOption struct {
+	enc Encoding
+}
+
+func (o encodingOption) apply(p *Prompt) {
+	p.encoding = o.enc
+}
+
+// WithEncoding configures the character encoding used by the terminal. By
+// default the terminal is assumed to use UTF-8. Specifying a legacy encoding
+// such as Latin1 or CP1252 causes input to be translated to UTF-8 as it is read
+// and output to be translated to the legacy encoding as it is written.
+// Characters which are not representable in the legacy encoding are output as
+// '?'. If WithEightBitMeta is enabled, input bytes with the high bit set are
+// interpreted as Meta chords and input translation is disabled.
+func WithEncoding(enc Encoding) Option {
+	return encodingOption{enc}
+}
+
+type terminfoOption struct {
+	enabled bool
+}
+
+func (o terminfoOption) apply(p *Prompt) {
+	p.terminfo = o.enabled
+}
+
+// WithTerminfo configures whether the terminfo entry for the terminal
+// specified by $TERM is consulted for additional key input sequences. By
+// default, Prompt recognizes the input sequences used by the majority of
+// terminals without consulting terminfo. Enabling this option registers the
+// sequences for the arrow keys, Home, End, PageUp, PageDown, and Delete
+// described by the terminfo entry, which covers terminals whose sequences are
+// not supported by default. Sequences which conflict with the default
+// sequences are ignored. The entry is read by New, which returns an error if
+// the entry cannot be parsed. If $TERM is unset or there is no entry for the
+// terminal, the default sequences are used. A Prompt created by Clone reuses
+// the sequences read for the original Prompt.
+func WithTerminfo(enabled bool) Option {
+	return terminfoOption{enabled}
+}
+
+type keySequencesOption struct {
+	seqs map[string]rune
+}
+
+func (o keySequencesOption) apply(p *Prompt) {
+	p.keySequences = append(p.keySequences, o.seqs)
+}
+
+// WithKeySequences registers additional key input sequences, mapping each
+// sequence to a key from the keys package (e.g. keys.Up or keys.Left|keys.Ctrl).
+// A sequence must begin with an escape. Sequences which conflict with the
+// default sequences or with previously registered sequences (i.e. one is a
+// prefix of the other) are ignored, while an identical sequence is remapped.
+// The sequences are registered after those of the terminfo entry (see
+// WithTerminfo), and so take precedence over them.
+func WithKeySequences(seqs map[string]rune) Option {
+	return keySequencesOption{seqs}
+}
+
 type historyOption struct {
 	path    string
 	maxSize int
@@ -73,18 +263,307 @@ func WithHistory(path string, maxSize int) Option {
 	return historyOption{path, maxSize}
 }
 
+type draftFileOption struct {
+	path string
+}
+
+func (o draftFileOption) apply(p *Prompt) {
+	p.mu.draft = nil
+	if o.path != "" {
+		p.mu.draft = &draft{path: o.path, interval: draftInterval, mu: &p.mu.Mutex, debug: p.debug}
+	}
+}
+
+// WithDraftFile configures the input text to be saved to the file at path as
+// it is edited, so that a long statement is not lost if the process dies
+// before the input is accepted. Writes are debounced so that the file is
+// written at most once a second. The file is removed when the input is
+// accepted or cancelled. If the file exists when the first ReadLine is
+// called, its text is restored as the input along with a notice which
+// disappears when the next key is pressed; undo discards the restored text.
+// Processes sharing a draft file overwrite each other's drafts, so the path
+// should be unique to a session if the application may be run concurrently.
+// See DraftPath for a default location. An empty path disables the draft
+// file.
+func WithDraftFile(path string) Option {
+	return draftFileOption{path}
+}
+
+type killRingFileOption struct {
+	path string
+}
+
+func (o killRingFileOption) apply(p *Prompt) {
+	p.mu.state.killRing.path = o.path
+}
+
+// WithKillRingFile configures the kill ring to be persisted to the file at
+// path, so that killed text can be yanked after the application is restarted.
+// The entries are loaded when the Prompt is created, and the file is rewritten
+// when ReadLine returns or the Prompt is closed if text was killed. The entries
+// are encoded like the entries of a history file, and the file is only readable
+// by the owner as killed text may be sensitive. Processes sharing a kill ring
+// file overwrite each other's entries. See KillRingPath for a default location.
+// An empty path disables the kill ring file.
+func WithKillRingFile(path string) Option {
+	return killRingFileOption{path}
+}
+
+type historySubstringSearchOption struct {
+	enabled bool
+}
+
+func (o historySubstringSearchOption) apply(p *Prompt) {
+	origin := bindingOrigin{source: "WithHistorySubstringSearch"}
+	if o.enabled {
+		p.bindings.bind(keyUp, cmdSubstrSearchBackward, origin)
+		p.bindings.bind(keyDown, cmdSubstrSearchForward, origin)
+	} else {
+		p.bindings.bind(keyUp, cmdPreviousHistory, origin)
+		p.bindings.bind(keyDown, cmdNextHistory, origin)
+	}
+}
+
+// WithHistorySubstringSearch configures the Up and Down keys to perform history
+// substring search. After typing some text, Up and Down cycle through the
+// history entries which contain that text anywhere within them, with the
+// matching text highlighted. If the input is empty, Up and Down navigate
+// through history normally.
+func WithHistorySubstringSearch(enabled bool) Option {
+	return historySubstringSearchOption{enabled}
+}
+
+type scopedHistorySearchOption struct {
+	enabled bool
+}
+
+func (o scopedHistorySearchOption) apply(p *Prompt) {
+	p.mu.state.history.scopedSearch = o.enabled
+}
+
+// WithScopedHistorySearch configures incremental history search to only match
+// the history entries whose first word is the same as the first word of the
+// input when the search is started, ignoring case. For example, when the input
+// starts with "SELECT", Control-r only searches the SELECT statements in the
+// history. Searches started with empty input match all of the entries.
+func WithScopedHistorySearch(enabled bool) Option {
+	return scopedHistorySearchOption{enabled}
+}
+
+type historySearchModeOption struct {
+	mode HistorySearchMode
+}
+
+func (o historySearchModeOption) apply(p *Prompt) {
+	p.mu.state.history.searchMode = o.mode
+}
+
+// WithHistorySearchMode configures how incremental history search matches the
+// search key against history entries. With HistorySearchFuzzy, the entries
+// containing the characters of the search key in order are ranked, and reverse
+// search (Control-r) visits them from the best match while forward search
+// (Control-s) returns to better matches. The default is HistorySearchLiteral.
+// Regexp search can be toggled on in either mode.
+func WithHistorySearchMode(mode HistorySearchMode) Option {
+	return historySearchModeOption{mode}
+}
+
+type searchPromptOption struct {
+	fn SearchPromptFunc
+}
+
+func (o searchPromptOption) apply(p *Prompt) {
+	p.mu.state.history.searchPrompt = o.fn
+}
+
+// WithSearchPrompt configures the formatting of the prompt displayed below the
+// input during incremental history search, which defaults to "bck:`key'" for
+// reverse search and "fwd:`key'" for forward search, with the ':' replaced by
+// '?' if the search fails. This allows applications to localize the prompt or
+// to mimic the "(reverse-i-search)`key':" appearance of bash. See
+// SearchPromptFunc for a description of the arguments. A nil function restores
+// the default format.
+func WithSearchPrompt(fn SearchPromptFunc) Option {
+	return searchPromptOption{fn}
+}
+
+type historyEntryLimitOption struct {
+	maxLen   int
+	truncate bool
+}
+
+func (o historyEntryLimitOption) apply(p *Prompt) {
+	p.mu.state.history.maxEntryLen = o.maxLen
+	p.mu.state.history.truncateEntries = o.truncate
+}
+
+// WithHistoryEntryLimit configures the maximum length in bytes of a history
+// entry. Entries longer than maxLen are not added to history, or if truncate is
+// true, are truncated to maxLen bytes before being added. This prevents an
+// accidental large paste from bloating the history file. A maxLen of 0 (the
+// default) disables the limit.
+func WithHistoryEntryLimit(maxLen int, truncate bool) Option {
+	return historyEntryLimitOption{maxLen, truncate}
+}
+
+type historyFileModeOption struct {
+	mode os.FileMode
+}
+
+func (o historyFileModeOption) apply(p *Prompt) {
+	p.mu.state.history.mode = o.mode
+}
+
+// WithHistoryFileMode configures the permission bits used when creating the
+// history file. The default is 0600 as history files often contain sensitive
+// input. The permissions of an existing history file are not changed.
+func WithHistoryFileMode(mode os.FileMode) Option {
+	return historyFileModeOption{mode}
+}
+
+type historySyncOption struct {
+	enabled bool
+}
+
+func (o historySyncOption) apply(p *Prompt) {
+	p.mu.state.history.sync = o.enabled
+}
+
+// WithHistorySync configures whether the history file is synced to stable
+// storage after each entry is appended to it. Syncing guarantees that accepted
+// entries survive a crash at the cost of a slower accept.
+func WithHistorySync(enabled bool) Option {
+	return historySyncOption{enabled}
+}
+
+type historyWritePolicyOption struct {
+	policy HistoryWritePolicy
+}
+
+func (o historyWritePolicyOption) apply(p *Prompt) {
+	p.mu.state.history.writePolicy = o.policy
+}
+
+// WithHistoryWritePolicy configures when history entries are written to the
+// history file or store. By default, each entry is written when its line is
+// accepted (HistoryWriteOnAccept). The other policies buffer the entries, and
+// their metadata, until Prompt.SyncHistory is called, allowing applications to
+// batch writes or to persist entries only at specific points, and
+// HistoryWriteOnClose also writes the buffered entries when the Prompt is
+// closed.
+func WithHistoryWritePolicy(policy HistoryWritePolicy) Option {
+	return historyWritePolicyOption{policy}
+}
+
+type historyStoreOption struct {
+	store HistoryStore
+}
+
+func (o historyStoreOption) apply(p *Prompt) {
+	p.mu.state.history.store = o.store
+}
+
+// WithHistoryStore configures the store the history entries are loaded from
+// and appended to, in place of the history file configured by WithHistory. The
+// max history size configured by WithHistory still applies, and the store is
+// compacted to it when oversized. The store must not be shared with other
+// Prompts, including those created by Clone.
+func WithHistoryStore(store HistoryStore) Option {
+	return historyStoreOption{store}
+}
+
+type collapseHistoryOption struct {
+	enabled bool
+}
+
+func (o collapseHistoryOption) apply(p *Prompt) {
+	p.mu.state.history.collapse = o.enabled
+}
+
+// WithCollapsedHistory configures whether multi-line history entries are
+// collapsed during history navigation. A collapsed entry is displayed as its
+// first line followed by the number of additional lines (e.g. "… (+29
+// lines)"). The entry is expanded when editing begins or when the
+// expand-history command (Meta-e) is invoked.
+func WithCollapsedHistory(enabled bool) Option {
+	return collapseHistoryOption{enabled}
+}
+
+type historyCursorMemoryOption struct {
+	enabled bool
+}
+
+func (o historyCursorMemoryOption) apply(p *Prompt) {
+	p.mu.state.history.cursorMemory = o.enabled
+}
+
+// WithHistoryCursorMemory configures history navigation to remember the cursor
+// position within each history entry (and within the input being edited) for
+// the remainder of the session, so that returning to an entry restores the
+// cursor to where it was left rather than moving it to the end of the entry.
+func WithHistoryCursorMemory(enabled bool) Option {
+	return historyCursorMemoryOption{enabled}
+}
+
+type historyDedupOption struct {
+	dedup HistoryDedup
+}
+
+func (o historyDedupOption) apply(p *Prompt) {
+	p.mu.state.history.dedup = o.dedup
+}
+
+// WithHistoryDedup configures the suppression of duplicate history entries,
+// including those loaded from the history file. By default, an entry identical
+// to the previous entry is suppressed (HistoryDedupAdjacent). The history file
+// is appended to regardless, so it may hold duplicates.
+func WithHistoryDedup(dedup HistoryDedup) Option {
+	return historyDedupOption{dedup}
+}
+
+type historyExpansionOption struct {
+	onAccept bool
+	fn       HistoryExpandFunc
+}
+
+func (o historyExpansionOption) apply(p *Prompt) {
+	p.mu.state.history.expandOnAccept = o.onAccept
+	p.mu.state.history.expandFunc = o.fn
+}
+
+// WithHistoryExpansion configures csh-style history expansion of references to
+// history entries in the input: "!!" for the previous entry, "!N" for entry N
+// (numbering the oldest entry 1), "!-N" for the Nth previous entry, "!$" for
+// the last word of the previous entry, and "!prefix" for the most recent entry
+// beginning with prefix. A '!' followed by whitespace, '=' or '(' is not
+// expanded, and "\!" is replaced by a literal '!'. If onAccept is true, the
+// input is expanded when it is accepted, before it is checked for completeness
+// (see WithInputFinished), and the expansion is added to history. Otherwise the
+// input is only expanded by the history-expand-line command, which is not bound
+// by default. If an event does not match a history entry, the input is left as
+// is and the error is displayed, rather than the input being accepted.
+//
+// If fn is non-nil, it determines which occurrences of '!' are expanded, such
+// as to exclude those within quoted strings. Otherwise, occurrences within the
+// strings and comments identified by the highlighter (see Span.Kind) are not
+// expanded.
+func WithHistoryExpansion(onAccept bool, fn HistoryExpandFunc) Option {
+	return historyExpansionOption{onAccept, fn}
+}
+
 type sizeOption struct {
 	width, height int
 }
 
 func (o *sizeOption) apply(p *Prompt) {
-	p.mu.state.screen.SetSize(o.width, o.height)
+	p.mu.state.screen.SetSize(o.width, o.height, nil)
 }
 
 // WithSize allows configuring the initial width and height of a Prompt.
 // Typically, the width and height of the terminal are automatically determined.
 // This option is primarily useful for tests in conjunction with the WithInput
-// and WithOutput options.
+// and WithOutput options, and for input which is not a terminal, such as an SSH
+// channel, whose size is then updated by Prompt.SetSize.
 func WithSize(width, height int) Option {
 	return &sizeOption{
 		width:  width,
@@ -92,12 +571,238 @@ func WithSize(width, height int) Option {
 	}
 }
 
+type runeWidthOption struct {
+	fn func(r rune) int
+}
+
+func (o runeWidthOption) apply(p *Prompt) {
+	p.mu.state.screen.runeWidth = o.fn
+}
+
+// WithRuneWidth allows configuring the function used to determine the number of
+// columns a rune occupies on the terminal. By default, runewidth.RuneWidth from
+// github.com/mattn/go-runewidth is used. This option is useful for terminals
+// and fonts which render certain characters (e.g. Nerd Font icons or emoji)
+// with a different width than the Unicode East Asian Width tables specify.
+// Note that ASCII characters are always considered to have a width of 1.
+func WithRuneWidth(fn func(r rune) int) Option {
+	return runeWidthOption{fn}
+}
+
+type semanticMarksOption struct {
+	enabled bool
+}
+
+func (o semanticMarksOption) apply(p *Prompt) {
+	p.mu.state.screen.semanticMarks = o.enabled
+}
+
+// WithSemanticMarks configures the emission of the OSC 133 semantic prompt
+// marks, which delimit the prompt, the input, and the output of the accepted
+// input. Terminals such as WezTerm, Kitty, and iTerm2 use the marks to jump
+// between prompts and to select the input or output of a command. The end of
+// the output of a command is marked when the next prompt is displayed, or by
+// Prompt.CommandFinished which additionally reports the exit status.
+func WithSemanticMarks(enabled bool) Option {
+	return semanticMarksOption{enabled}
+}
+
+type workingDirectoryOption struct {
+	fn func() string
+}
+
+func (o workingDirectoryOption) apply(p *Prompt) {
+	p.mu.state.termState.cwd = o.fn
+}
+
+// WithWorkingDirectory configures a callback returning the current working
+// directory, which is reported to the terminal using OSC 7 before each prompt
+// is displayed. Terminals use the directory for features such as opening a new
+// tab in the same directory. Applications which track a logical location,
+// such as a database or schema, can report it as a path. An empty directory
+// is not reported.
+func WithWorkingDirectory(fn func() string) Option {
+	return workingDirectoryOption{fn}
+}
+
+type userVarsOption struct {
+	fn func() map[string]string
+}
+
+func (o userVarsOption) apply(p *Prompt) {
+	p.mu.state.termState.userVars = o.fn
+}
+
+// WithUserVars configures a callback returning user variables, which are
+// reported to the terminal using the OSC 1337 SetUserVar sequence before each
+// prompt is displayed. iTerm2 and WezTerm make the variables available to
+// status bars, tab titles, and scripts.
+func WithUserVars(fn func() map[string]string) Option {
+	return userVarsOption{fn}
+}
+
+type clipboardOption struct {
+	enabled bool
+}
+
+func (o clipboardOption) apply(p *Prompt) {
+	p.mu.state.clipboard = o.enabled
+}
+
+// WithClipboard configures the copy-buffer command to copy the input to the
+// system clipboard, in addition to the kill ring, using the OSC 52 escape
+// sequence. The copy-buffer command is not bound by default; it can be bound
+// using WithBindings, e.g. "bind Meta-w copy-buffer".
+func WithClipboard(enabled bool) Option {
+	return clipboardOption{enabled}
+}
+
+type tmuxBufferOption struct {
+	enabled bool
+}
+
+func (o tmuxBufferOption) apply(p *Prompt) {
+	p.mu.state.tmuxBuffer = nil
+	if o.enabled && p.mu.state.tmux {
+		p.mu.state.tmuxBuffer = newTmuxBuffer(loadTmuxBuffer, p.debug)
+	}
+}
+
+// WithTmuxBuffer configures killed text, and the input copied by the
+// copy-buffer command, to be loaded into the tmux paste buffer using "tmux
+// load-buffer" when running inside tmux, making it available for pasting in
+// other panes even if the outer terminal does not support OSC 52. The option
+// has no effect outside of tmux. Note that when running inside tmux, the OSC
+// 52 sequence emitted for WithClipboard is wrapped in the tmux passthrough
+// sequence, which tmux forwards to the outer terminal if its
+// allow-passthrough option is enabled.
+func WithTmuxBuffer(enabled bool) Option {
+	return tmuxBufferOption{enabled}
+}
+
+type exitEchoOption struct {
+	interrupt, eof string
+}
+
+func (o exitEchoOption) apply(p *Prompt) {
+	p.mu.state.echoInterrupt = o.interrupt
+	p.mu.state.echoEOF = o.eof
+}
+
+// WithExitEcho configures the text displayed after the input, before moving to
+// a fresh line, when the input is interrupted by the cancel command (Control-c)
+// and when EOF is triggered by the exit-or-delete-char command (Control-d) on
+// empty input. For example, WithExitEcho("^C", "^D") mimics the echoing of
+// control characters by the terminal, and WithExitEcho("^C", "exit") the
+// feedback of bash. Empty text disables the echo, in which case the cursor is
+// left at the end of the input on EOF.
+func WithExitEcho(interrupt, eof string) Option {
+	return exitEchoOption{interrupt, eof}
+}
+
+type wordClassifierOption struct {
+	fn func(r rune) bool
+}
+
+func (o wordClassifierOption) apply(p *Prompt) {
+	p.mu.state.screen.wordClassifier = o.fn
+}
+
+// WithWordClassifier allows configuring the function used to determine whether
+// a rune is part of a word. Words are used by the word movement, kill, and
+// transpose commands, and determine the text which is completed. By default,
+// words consist of letters and digits.
+func WithWordClassifier(fn func(r rune) bool) Option {
+	return wordClassifierOption{fn}
+}
+
+// WithWordCharacters configures the characters in chars to be treated as word
+// characters in addition to letters and digits. For example, "-_./" allows
+// paths and identifiers to be moved over, killed, and completed as single
+// words. See WithWordClassifier.
+func WithWordCharacters(chars string) Option {
+	return wordClassifierOption{func(r rune) bool {
+		return isWord(r) || strings.ContainsRune(chars, r)
+	}}
+}
+
+type wrapIndentOption struct {
+	enabled bool
+}
+
+func (o wrapIndentOption) apply(p *Prompt) {
+	p.mu.state.screen.wrapIndent = o.enabled
+}
+
+// WithWrapIndent configures whether soft-wrapped rows of the input text are
+// indented to align with the start of the input text on the first row (i.e.
+// after the prompt) rather than starting at column 0. Rows following a newline
+// in the input text are not indented.
+func WithWrapIndent(enabled bool) Option {
+	return wrapIndentOption{enabled}
+}
+
+type wordWrapOption struct {
+	enabled bool
+}
+
+func (o wordWrapOption) apply(p *Prompt) {
+	p.mu.state.screen.wordWrap = o.enabled
+}
+
+// WithWordWrap configures long lines of input text to be wrapped at word
+// boundaries: a word which does not fit on the remainder of a row is moved to
+// the next row, provided it fits on a row by itself, rather than being split
+// at the last column. Words are separated by whitespace. Editing re-renders
+// the line containing the cursor from its start, as an edit may change the
+// wrapping of the preceding rows.
+func WithWordWrap(enabled bool) Option {
+	return wordWrapOption{enabled}
+}
+
+type insertDeleteCharsOption struct {
+	enabled bool
+}
+
+func (o insertDeleteCharsOption) apply(p *Prompt) {
+	p.mu.state.screen.insertDelete = o.enabled
+}
+
+// WithInsertDeleteChars configures whether the terminal's support for inserting
+// and deleting characters (ICH and DCH) is used when editing the middle of a
+// line. Edits which do not change the wrapping of the text are then performed
+// in place rather than by re-rendering the remainder of the text, reducing the
+// output for long lines. Disabled by default as not all terminals support these
+// sequences.
+func WithInsertDeleteChars(enabled bool) Option {
+	return insertDeleteCharsOption{enabled}
+}
+
+type rendererOption struct {
+	renderer Renderer
+}
+
+func (o rendererOption) apply(p *Prompt) {
+	p.mu.state.screen.renderer = o.renderer
+}
+
+// WithRenderer configures the Renderer which generates the control sequences
+// that move the cursor and erase the display, such as for a terminal which does
+// not support the ANSI escape sequences generated by default. The Renderer is
+// invoked while the Prompt is locked, so it must not call methods of the
+// Prompt.
+func WithRenderer(r Renderer) Option {
+	return rendererOption{r}
+}
+
 type inputFinishedOption struct {
 	fn func(text string) bool
 }
 
 func (o inputFinishedOption) apply(p *Prompt) {
-	p.mu.state.inputFinished = o.fn
+	p.mu.state.inputFinished = func(text string, cursor int) bool {
+		return o.fn(text)
+	}
 }
 
 // WithInputFinished allows configuring a callback that will be invoked when
@@ -107,6 +812,236 @@ func WithInputFinished(fn func(text string) bool) Option {
 	return inputFinishedOption{fn}
 }
 
+type inputFinishedAtCursorOption struct {
+	fn func(text string, cursor int) bool
+}
+
+func (o inputFinishedAtCursorOption) apply(p *Prompt) {
+	p.mu.state.inputFinished = o.fn
+}
+
+// WithInputFinishedAtCursor is like WithInputFinished, but the callback also
+// receives the cursor position (in runes) within the input text. This allows
+// policies such as inserting a newline when enter is pressed in the middle of
+// the input and accepting the input when enter is pressed at the end.
+func WithInputFinishedAtCursor(fn func(text string, cursor int) bool) Option {
+	return inputFinishedAtCursorOption{fn}
+}
+
+type autoIndentOption struct {
+	enabled bool
+}
+
+func (o autoIndentOption) apply(p *Prompt) {
+	p.mu.state.autoIndent = o.enabled
+}
+
+// WithAutoIndent configures whether a newline inserted into the input is
+// followed by the leading whitespace (spaces and tabs) of the line containing
+// the cursor, preserving the indentation of multi-line input.
+func WithAutoIndent(enabled bool) Option {
+	return autoIndentOption{enabled}
+}
+
+// extraBindings holds bindings specified by an option. The source identifies
+// the bindings in binding conflicts, and is empty for WithBindings options.
+type extraBindings struct {
+	source   string
+	bindings string
+}
+
+type bindingsOption struct {
+	bindings string
+}
+
+func (o bindingsOption) apply(p *Prompt) {
+	p.extraBindings = append(p.extraBindings, extraBindings{bindings: o.bindings})
+}
+
+// WithBindings allows configuring additional key bindings, overriding the
+// default bindings. The bindings are specified one per line using the syntax
+// "bind <key> <command>", e.g. "bind Control-o enter" to make Control-o insert a
+// newline. A binding may be followed by "if <condition>" to only apply while the
+// condition holds (see WithBindingCondition). In place of a command, a key may
+// be bound to a macro: a double-quoted string, with the escapes of a Go string
+// literal, holding the keys to type in the syntax of ParseKeys, e.g.
+// `bind Control-j "SELECT * FROM "` or `bind Meta-g "git status<Enter>"`. The
+// keys of a macro perform the commands they are bound to, and may themselves be
+// bound to macros. Lines beginning with '#' are comments. New returns an error
+// if the bindings cannot be parsed. Keys which are bound more than once to
+// different commands are reported by Prompt.BindingConflicts.
+func WithBindings(bindings string) Option {
+	return bindingsOption{bindings}
+}
+
+type bindingConditionOption struct {
+	name string
+	fn   BindingCondition
+}
+
+func (o bindingConditionOption) apply(p *Prompt) {
+	if p.bindings.conditions == nil {
+		p.bindings.conditions = make(map[string]BindingCondition)
+	}
+	p.bindings.conditions[o.name] = o.fn
+}
+
+// WithBindingCondition registers a named condition which can guard key
+// bindings, such that a key does contextually different things. A binding
+// guarded by a condition is specified by appending "if <name>" to the binding,
+// or "if !<name>" to apply the binding while the condition does not hold, e.g.
+// "bind Tab insert-char if empty" to insert a tab rather than complete when
+// there is no input. The conditions empty, multi-line, searching (during
+// incremental history search), completion-list (while completions are listed),
+// and completion-hint (while a completion hint is displayed) are built in. A
+// conditional binding takes precedence over the unconditional binding of the
+// key while its condition holds.
+func WithBindingCondition(name string, fn BindingCondition) Option {
+	return bindingConditionOption{name, fn}
+}
+
+type traceOption struct {
+	size int
+}
+
+func (o traceOption) apply(p *Prompt) {
+	if o.size <= 0 {
+		p.tracer = nil
+		return
+	}
+	p.tracer = newTracer(o.size, p.debug)
+}
+
+// WithTrace enables tracing of the time spent parsing, dispatching, rendering,
+// and flushing each key, which helps diagnose whether a slow prompt is due to
+// slow completion or highlighting callbacks or a slow terminal. The traces of
+// the most recent size keys are retained and returned by Prompt.Trace. If the
+// PROMPT_DEBUG environment variable names a debug log file, the traces are also
+// written to it. A size of 0 disables tracing, which is the default.
+func WithTrace(size int) Option {
+	return traceOption{size}
+}
+
+type selfInsertOption struct {
+	fn SelfInsertFunc
+}
+
+func (o selfInsertOption) apply(p *Prompt) {
+	p.mu.state.selfInsert = o.fn
+}
+
+// WithSelfInsert configures a hook which intercepts the insertion of typed
+// characters by the insert-char command, allowing the text before the cursor to
+// be replaced in order to implement digraph entry or the expansion of
+// LaTeX-style symbol names. See SelfInsertFunc.
+func WithSelfInsert(fn SelfInsertFunc) Option {
+	return selfInsertOption{fn}
+}
+
+type acceptCheckOption struct {
+	fn AcceptCheckFunc
+}
+
+func (o acceptCheckOption) apply(p *Prompt) {
+	if o.fn == nil {
+		p.mu.state.acceptCheck = nil
+		return
+	}
+	p.mu.state.acceptCheck = func(line string) (err error) {
+		p.withLocked(func(lp *LockedPrompt) {
+			err = o.fn(lp, line)
+		})
+		return err
+	}
+}
+
+// WithAcceptCheck configures a callback which is invoked when the input is
+// accepted, once it is considered complete (see WithInputFinished), and which
+// may veto accepting it by returning an error. The error message is displayed
+// and editing continues, allowing the input to be corrected. The callback is
+// not invoked for the input of a nested ReadLine, so it may itself read a
+// confirmation using LockedPrompt.ReadLine. See AcceptCheckFunc.
+func WithAcceptCheck(fn AcceptCheckFunc) Option {
+	return acceptCheckOption{fn}
+}
+
+type onAcceptOption struct {
+	fn func(line string) (store bool, transformed string)
+}
+
+func (o onAcceptOption) apply(p *Prompt) {
+	p.onAccept = o.fn
+}
+
+// WithOnAccept allows configuring a callback that will be invoked when a line
+// is accepted, before it is added to history. If the callback returns false the
+// line is not stored in history. Otherwise, the transformed line is stored in
+// place of the accepted line. This allows applications to redact secrets,
+// normalize whitespace, or veto storage of a line. ReadLine always returns the
+// line as it was accepted.
+func WithOnAccept(fn func(line string) (store bool, transformed string)) Option {
+	return onAcceptOption{fn}
+}
+
+type historyFilterOption struct {
+	fn func(entry string) bool
+}
+
+func (o historyFilterOption) apply(p *Prompt) {
+	p.historyFilter = o.fn
+}
+
+// WithHistoryFilter allows configuring a callback that is consulted before each
+// accepted line is added to history, after any transformation by the callback
+// configured by WithOnAccept. If the callback returns false the entry is not
+// added to history or to the history file. This allows applications to
+// implement behavior like the HISTIGNORE variable of bash, excluding trivial
+// commands, lines consisting only of whitespace, or lines matching patterns.
+func WithHistoryFilter(fn func(entry string) bool) Option {
+	return historyFilterOption{fn}
+}
+
+type historySanitizerOption struct {
+	fn func(entry string) (string, bool)
+}
+
+func (o historySanitizerOption) apply(p *Prompt) {
+	p.mu.state.history.sanitize = o.fn
+}
+
+// WithHistorySanitizer allows configuring a callback that is invoked with each
+// history entry before it is written to the history file, so that secrets such
+// as passwords and tokens are not persisted. The callback returns the entry to
+// write, such as with the secrets redacted, or false if the entry should not be
+// written at all. The entry is kept in the in-memory history as it was added,
+// so it can still be recalled during the session.
+func WithHistorySanitizer(fn func(entry string) (string, bool)) Option {
+	return historySanitizerOption{fn}
+}
+
+type renderHooksOption struct {
+	pre  PreRenderFunc
+	post PostRenderFunc
+}
+
+func (o renderHooksOption) apply(p *Prompt) {
+	p.preRender = o.pre
+	p.postRender = o.post
+}
+
+// WithRenderHooks configures callbacks invoked immediately before and after
+// the prompt and input text are written to the terminal, either of which may
+// be nil. The pre-render hook returns a decoration displayed after the input
+// text, allowing dynamic decorations such as an elapsed time or a count of
+// characters; decorations which change without input, such as a timer, can be
+// refreshed by calling Redraw periodically. The post-render hook can be used
+// to measure render latency. The hooks are invoked with the mutex of the
+// Prompt held, so they should be fast, and are passed a LockedPrompt through
+// which they may access the Prompt. They are not invoked for ReadSecret.
+func WithRenderHooks(pre PreRenderFunc, post PostRenderFunc) Option {
+	return renderHooksOption{pre, post}
+}
+
 type completerOption struct {
 	fn CompletionFunc
 }
@@ -121,3 +1056,135 @@ func (o completerOption) apply(p *Prompt) {
 func WithCompleter(fn CompletionFunc) Option {
 	return completerOption{fn}
 }
+
+type historyCompletionOption struct {
+	mode HistoryCompletion
+}
+
+func (o historyCompletionOption) apply(p *Prompt) {
+	p.mu.state.completer.history = o.mode
+}
+
+// WithHistoryCompletion configures completion of the input text from the
+// history entries, either completing the word at the cursor using the words of
+// the history entries or completing the entire input using the entries which
+// begin with it. The completions are ranked by frecency: entries which are used
+// frequently and recently rank highest. History completions follow those of the
+// completion callback configured by WithCompleter or WithCompleterContext, if
+// any.
+func WithHistoryCompletion(mode HistoryCompletion) Option {
+	return historyCompletionOption{mode}
+}
+
+type completionDedupOption struct {
+	enabled bool
+}
+
+func (o completionDedupOption) apply(p *Prompt) {
+	p.mu.state.completer.dedup = o.enabled
+}
+
+// WithCompletionDedup configures removal of duplicate completions returned by
+// the completion callback. The first occurrence of each completion is
+// retained, preserving the priority order.
+func WithCompletionDedup(enabled bool) Option {
+	return completionDedupOption{enabled}
+}
+
+type completionLimitOption struct {
+	limit int
+}
+
+func (o completionLimitOption) apply(p *Prompt) {
+	p.mu.state.completer.limit = o.limit
+}
+
+// WithCompletionLimit configures the maximum number of completions which are
+// displayed. The highest priority completions are retained. A limit of 0
+// disables the limit.
+func WithCompletionLimit(limit int) Option {
+	return completionLimitOption{limit}
+}
+
+type completionSortOption struct {
+	enabled bool
+}
+
+func (o completionSortOption) apply(p *Prompt) {
+	p.mu.state.completer.sort = o.enabled
+}
+
+// WithCompletionSort configures sorting of the completions alphabetically
+// before they are displayed. Sorting is performed after duplicates are
+// removed and the limit is applied (see WithCompletionDedup and
+// WithCompletionLimit), so the retained completions are those with the highest
+// priority.
+func WithCompletionSort(enabled bool) Option {
+	return completionSortOption{enabled}
+}
+
+type completionRankerOption struct {
+	fn CompletionRanker
+}
+
+func (o completionRankerOption) apply(p *Prompt) {
+	p.mu.state.completer.ranker = o.fn
+}
+
+// WithCompletionRanker configures a callback which orders the completions
+// after they are generated by the completion callback and history completion.
+// Ranking is performed before duplicates are removed and the limit is applied
+// (see WithCompletionDedup and WithCompletionLimit), so the retained
+// completions are those ranked highest. Note that WithCompletionSort overrides
+// the ranked order of the retained completions.
+func WithCompletionRanker(fn CompletionRanker) Option {
+	return completionRankerOption{fn}
+}
+
+type completerContextOption struct {
+	fn ContextCompletionFunc
+}
+
+func (o completerContextOption) apply(p *Prompt) {
+	p.mu.state.completer.ctxFn = o.fn
+}
+
+// WithCompleterContext is like WithCompleter, but configures a completion
+// callback which runs on its own goroutine while further input is processed,
+// and which receives a context that is canceled when the next key is
+// processed or the Prompt is closed. Completion is skipped if there is
+// unprocessed input (e.g. when the user is typing quickly or pasting), as the
+// completions would immediately be discarded.
+func WithCompleterContext(fn ContextCompletionFunc) Option {
+	return completerContextOption{fn}
+}
+
+type linterOption struct {
+	fn LintFunc
+}
+
+func (o linterOption) apply(p *Prompt) {
+	p.mu.state.linter.fn = o.fn
+}
+
+// WithLinter allows configuring a callback that will be invoked after the input
+// text is modified in order to check the text for problems. See LintFunc for
+// details on how the diagnostics are displayed.
+func WithLinter(fn LintFunc) Option {
+	return linterOption{fn}
+}
+
+type highlighterOption struct {
+	fn HighlightFunc
+}
+
+func (o highlighterOption) apply(p *Prompt) {
+	p.mu.state.highlighter.fn = o.fn
+}
+
+// WithHighlighter allows configuring a callback that will be invoked after the
+// input text is modified in order to highlight the text. See HighlightFunc for
+// details on how the modified region of the text is tracked.
+func WithHighlighter(fn HighlightFunc) Option {
+	return highlighterOption{fn}
+}
@@ -0,0 +1,64 @@
+package prompt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+)
+
+// terminalState reports application state to the terminal using OSC
+// sequences as each prompt is displayed. See WithWorkingDirectory and
+// WithUserVars.
+type terminalState struct {
+	// cwd returns the current working directory to report using OSC 7.
+	cwd func() string
+	// userVars returns the user variables to report using OSC 1337.
+	userVars func() map[string]string
+	// hostname is the host name used in the OSC 7 file URL. Defaults to
+	// os.Hostname.
+	hostname func() (string, error)
+}
+
+// Write writes the OSC sequences reporting the terminal state to buf.
+func (t *terminalState) Write(buf *bytes.Buffer) {
+	if t.cwd != nil {
+		if dir := t.cwd(); dir != "" {
+			hostname := os.Hostname
+			if t.hostname != nil {
+				hostname = t.hostname
+			}
+			host, _ := hostname()
+			u := url.URL{Scheme: "file", Host: host, Path: dir}
+			fmt.Fprintf(buf, "\x1b]7;%s\x07", u.String())
+		}
+	}
+	if t.userVars != nil {
+		vars := t.userVars()
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(buf, "\x1b]1337;SetUserVar=%s=%s\x07",
+				name, base64.StdEncoding.EncodeToString([]byte(vars[name])))
+		}
+	}
+}
+
+// writeClipboard writes the OSC 52 sequence setting the system clipboard to
+// text to buf. Terminals supporting OSC 52 include xterm, iTerm2, Kitty, and
+// WezTerm, and the sequence works across ssh connections. Some terminals
+// require OSC 52 to be enabled in their settings. If tmux is true, the
+// sequence is wrapped in the tmux passthrough sequence so that it reaches the
+// outer terminal.
+func writeClipboard(buf *bytes.Buffer, text string, tmux bool) {
+	seq := fmt.Sprintf("\x1b]52;c;%s\x07", base64.StdEncoding.EncodeToString([]byte(text)))
+	if tmux {
+		seq = tmuxPassthrough(seq)
+	}
+	buf.WriteString(seq)
+}
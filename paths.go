@@ -0,0 +1,110 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// The XDG base directory specification is described at
+// https://specifications.freedesktop.org/basedir-spec/latest/.
+
+// xdgDir returns the directory specified by the environment variable env,
+// falling back to def (relative to the user's home directory) if the variable
+// is unset or not an absolute path, as required by the XDG specification.
+// Returns the empty string if the home directory cannot be determined.
+func xdgDir(env, def string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, def)
+}
+
+// HistoryPath returns the default location of the history file for the
+// application app, suitable for passing to WithHistory. If the legacy history
+// file ~/.<app>_history exists (the location used by psql, for example), it is
+// returned so that existing history is preserved. Otherwise the history file
+// is located in the XDG state directory: $XDG_STATE_HOME/<app>/history, where
+// $XDG_STATE_HOME defaults to ~/.local/state. The directory is created when
+// the history is loaded. Returns the empty string, which disables history, if
+// the user's home directory cannot be determined.
+func HistoryPath(app string) string {
+	if home, err := os.UserHomeDir(); err == nil {
+		if legacy := filepath.Join(home, "."+app+"_history"); fileExists(legacy) {
+			return legacy
+		}
+	}
+	dir := xdgDir("XDG_STATE_HOME", filepath.Join(".local", "state"))
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, app, "history")
+}
+
+// DraftPath returns the default location of the draft file for the
+// application app, suitable for passing to WithDraftFile. The draft file is
+// located in the XDG state directory: $XDG_STATE_HOME/<app>/draft, where
+// $XDG_STATE_HOME defaults to ~/.local/state. Returns the empty string, which
+// disables the draft file, if the user's home directory cannot be determined.
+func DraftPath(app string) string {
+	dir := xdgDir("XDG_STATE_HOME", filepath.Join(".local", "state"))
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, app, "draft")
+}
+
+// KillRingPath returns the default location of the kill ring file for the
+// application app, suitable for passing to WithKillRingFile. The kill ring file
+// is located in the XDG state directory: $XDG_STATE_HOME/<app>/killring, where
+// $XDG_STATE_HOME defaults to ~/.local/state. Returns the empty string, which
+// disables the kill ring file, if the user's home directory cannot be
+// determined.
+func KillRingPath(app string) string {
+	dir := xdgDir("XDG_STATE_HOME", filepath.Join(".local", "state"))
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, app, "killring")
+}
+
+// ConfigPath returns the location of the config file for the application app,
+// suitable for passing to LoadConfig. The config file is named config.toml and
+// is located in the XDG config directory, $XDG_CONFIG_HOME/<app>, where
+// $XDG_CONFIG_HOME defaults to ~/.config. If no such file exists, the
+// directories listed in $XDG_CONFIG_DIRS (default /etc/xdg) are searched in
+// order for a system-wide config file. If no config file exists, the path
+// within $XDG_CONFIG_HOME is returned, for which LoadConfig returns an empty
+// Config.
+func ConfigPath(app string) string {
+	const name = "config.toml"
+	var path string
+	if dir := xdgDir("XDG_CONFIG_HOME", ".config"); dir != "" {
+		if path = filepath.Join(dir, app, name); fileExists(path) {
+			return path
+		}
+	}
+
+	dirs := filepath.SplitList(os.Getenv("XDG_CONFIG_DIRS"))
+	if len(dirs) == 0 {
+		dirs = []string{"/etc/xdg"}
+	}
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		if p := filepath.Join(dir, app, name); fileExists(p) {
+			return p
+		}
+	}
+	return path
+}
+
+// fileExists returns true if path exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,97 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setenv sets the environment variable key to value for the duration of the
+// test.
+func setenv(t *testing.T, key, value string) {
+	prev, ok := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv(key, prev)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
+
+func TestHistoryPath(t *testing.T) {
+	home := t.TempDir()
+	setenv(t, "HOME", home)
+	setenv(t, "XDG_STATE_HOME", "")
+	require.Equal(t, filepath.Join(home, ".local/state/app/history"), HistoryPath("app"))
+
+	// Relative paths are ignored.
+	setenv(t, "XDG_STATE_HOME", "state")
+	require.Equal(t, filepath.Join(home, ".local/state/app/history"), HistoryPath("app"))
+
+	state := t.TempDir()
+	setenv(t, "XDG_STATE_HOME", state)
+	path := HistoryPath("app")
+	require.Equal(t, filepath.Join(state, "app/history"), path)
+
+	// The directory is created when the history is loaded.
+	p, err := New(WithInteractive(true), WithHistory(path, 10))
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+	require.FileExists(t, path)
+
+	// The legacy history file is used if it exists.
+	legacy := filepath.Join(home, ".app_history")
+	require.NoError(t, os.WriteFile(legacy, nil, 0600))
+	require.Equal(t, legacy, HistoryPath("app"))
+}
+
+func TestDraftPath(t *testing.T) {
+	home := t.TempDir()
+	setenv(t, "HOME", home)
+	setenv(t, "XDG_STATE_HOME", "")
+	require.Equal(t, filepath.Join(home, ".local/state/app/draft"), DraftPath("app"))
+
+	state := t.TempDir()
+	setenv(t, "XDG_STATE_HOME", state)
+	require.Equal(t, filepath.Join(state, "app/draft"), DraftPath("app"))
+}
+
+func TestKillRingPath(t *testing.T) {
+	home := t.TempDir()
+	setenv(t, "HOME", home)
+	setenv(t, "XDG_STATE_HOME", "")
+	require.Equal(t, filepath.Join(home, ".local/state/app/killring"), KillRingPath("app"))
+
+	state := t.TempDir()
+	setenv(t, "XDG_STATE_HOME", state)
+	require.Equal(t, filepath.Join(state, "app/killring"), KillRingPath("app"))
+}
+
+func TestConfigPath(t *testing.T) {
+	home := t.TempDir()
+	setenv(t, "HOME", home)
+	setenv(t, "XDG_CONFIG_HOME", "")
+	system := t.TempDir()
+	setenv(t, "XDG_CONFIG_DIRS", "relative"+string(filepath.ListSeparator)+system)
+
+	userPath := filepath.Join(home, ".config/app/config.toml")
+	require.Equal(t, userPath, ConfigPath("app"))
+
+	// A system-wide config file is used if the user has none.
+	systemPath := filepath.Join(system, "app/config.toml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(systemPath), 0755))
+	require.NoError(t, os.WriteFile(systemPath, nil, 0644))
+	require.Equal(t, systemPath, ConfigPath("app"))
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(userPath), 0755))
+	require.NoError(t, os.WriteFile(userPath, nil, 0644))
+	require.Equal(t, userPath, ConfigPath("app"))
+
+	config := t.TempDir()
+	setenv(t, "XDG_CONFIG_HOME", config)
+	require.Equal(t, systemPath, ConfigPath("app"))
+}
@@ -1,29 +1,134 @@
 package prompt
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 	"unicode/utf8"
 
+	"github.com/petermattis/prompt/keys"
 	"golang.org/x/term"
 )
 
 type state struct {
-	completer completer
-	history   history
-	killRing  killRing
-	screen    screen
+	completer   completer
+	cursors     cursors
+	help        helpOverlay
+	highlighter highlighter
+	linter      linter
+	history     history
+	killRing    killRing
+	screen      screen
+	unicode     unicodeInput
+
+	// active is true while ReadLine is reading input using the state.
+	active bool
+	// nested is true if the state belongs to a ReadLine invoked from a callback
+	// of an outer ReadLine. Input accepted by a nested ReadLine is not added to
+	// history.
+	nested bool
 
 	// inputFinished is a callback invoked by the finish-or-enter command to
 	// determine if the input is considered complete. If the callback is nil, or it
 	// returns true, the input is considered complete and ReadLine will return the
 	// input. Otherwise, a newline is inserted into the input. See the
-	// WithInputFinished option for configuration.
-	inputFinished func(text string) bool
+	// WithInputFinished and WithInputFinishedAtCursor options for configuration.
+	inputFinished func(text string, cursor int) bool
+	// selfInsert is invoked before a typed character is inserted. See the
+	// WithSelfInsert option.
+	selfInsert SelfInsertFunc
+	// acceptCheck is invoked when the input is accepted, and may veto it. See
+	// the WithAcceptCheck option. acceptErr is true while the error which
+	// prevented the input from being accepted is displayed.
+	acceptCheck func(line string) error
+	acceptErr   bool
+	// inputPending is true if there is unprocessed input following the key
+	// being processed.
+	inputPending bool
+	// autoIndent is true if inserted newlines are followed by the leading
+	// whitespace of the line containing the cursor. See the WithAutoIndent
+	// option.
+	autoIndent bool
+	// metrics records the latencies of callbacks, and debug writes to the
+	// debug log. Shared with nested states.
+	metrics *metrics
+	debug   *debugLog
+	// theme specifies the styles of the completion hint and search matches. See
+	// the WithTheme option.
+	theme Theme
+	// annotations holds the transient text displayed after the input. See
+	// SetAnnotation.
+	annotations annotations
+	// echoInterrupt and echoEOF are displayed after the input when it is
+	// cancelled or when the input is exited at EOF. See the WithExitEcho
+	// option.
+	echoInterrupt string
+	echoEOF       string
+	// mirror receives the transcript of the accepted and cancelled input. See
+	// the WithMirrorOutput option.
+	mirror io.Writer
+	// clipboard is true if the copy-buffer command also copies the input to
+	// the system clipboard. See the WithClipboard option.
+	clipboard bool
+	// overwrite is true if typed characters replace the character at the
+	// cursor rather than being inserted. See the overwrite-mode command.
+	overwrite bool
+	// mark is the position of the mark set by the set-mark command, or -1 if
+	// the mark is not set. The mark is adjusted as the text is edited.
+	mark int
+	// rectangle holds the lines of the rectangle killed by the kill-rectangle
+	// command.
+	rectangle []string
+	// template holds the read-only segments of the input text of
+	// ReadLineTemplate, or nil if ReadLineTemplate is not active.
+	template *template
+	// draftRestored is true while the notice of input restored from the draft
+	// file is displayed.
+	draftRestored bool
+	// tmux is true if running inside tmux, and tmuxBuffer is non-nil if
+	// killed text is loaded into the tmux paste buffer. See the WithTmuxBuffer
+	// option.
+	tmux       bool
+	tmuxBuffer *tmuxBuffer
+	// termState reports the working directory and user variables to the
+	// terminal as each prompt is displayed.
+	termState terminalState
+	// commandRunning is true if the OSC 133 mark for the start of the output
+	// of the accepted input has been emitted, but the mark for the end of the
+	// command has not. See WithSemanticMarks.
+	commandRunning bool
+}
+
+// Reset resets the state to read new input, displaying prompt. If the input
+// previously accepted is considered to be a running command (see
+// WithSemanticMarks), the end of the command is marked. The terminal state is
+// reported before the prompt is displayed.
+func (s *state) Reset(prompt []rune) {
+	if s.commandRunning {
+		s.commandRunning = false
+		s.screen.outbuf.WriteString(oscCommandEnd)
+	}
+	s.termState.Write(&s.screen.outbuf)
+	s.annotations.Reset()
+	s.overwrite = false
+	s.history.ResetUndo()
+	s.help.Reset()
+	s.linter.Reset()
+	s.cursors.Reset()
+	s.unicode.Reset()
+	s.mark = -1
+	s.template = nil
+	s.screen.Reset(prompt)
 }
 
 // Prompt contains the state for reading single or multi-line input from a
@@ -41,38 +146,141 @@ type state struct {
 //   - cursor-down:         ESC[B
 //   - cursor-right:        ESC[C
 //   - cursor-left:         ESC[D
+//   - cursor-column:       ESC[G
 //   - cursor-home:         ESC[H
 //   - erase-line-to-right: ESC[K
+//   - erase-below:         ESC[J
 //   - erase-screen:        ESC[2J
 //
-// Prompt eschews using more advanced terminal operations such as insert/delete
-// character and insert mode. This decision results in Prompt having to
-// re-render more lines of text on editing operations, yet for line editing the
-// difference usually amounts to sending a few hundred bytes to the terminal
-// (for a long line). On modern hardware and networks, this amount of data is
-// trivial. The benefit of eschewing more advanced terminal operations is that
-// the same rendering output is used for all terminals as opposed to the
-// libedit/readline approach which requires intimate knowledge of the terminal
-// capabilities (via terminfo) and which can sometimes go horribly wrong
-// resulting in corruption of the rendered text.
+// A terminal which does not support these sequences can be supported by
+// configuring a Renderer which generates the equivalent control sequences (see
+// WithRenderer).
+//
+// By default, Prompt eschews using more advanced terminal operations such as
+// insert/delete character and insert mode (see WithInsertDeleteChars). This
+// decision results in Prompt having to re-render more lines of text on editing
+// operations, yet for line editing the difference usually amounts to sending a
+// few hundred bytes to the terminal (for a long line). On modern hardware and
+// networks, this amount of data is trivial. The benefit of eschewing more
+// advanced terminal operations is that the same rendering output is used for
+// all terminals as opposed to the libedit/readline approach which requires
+// intimate knowledge of the terminal capabilities (via terminfo) and which can
+// sometimes go horribly wrong resulting in corruption of the rendered text.
 type Prompt struct {
 	fd  int
 	in  io.Reader
 	out io.Writer
 
-	// inBytes and inBuf are used by the reader loop to read data from the input.
-	inBytes []byte
-	inBuf   [256]byte
-	prompt  []rune
+	// parser parses the keys from the input, retaining a partial key input
+	// sequence until the remainder of the sequence has been read. inBuf is
+	// used by the reader loop to read data from the input.
+	parser *keys.Parser
+	inBuf  [256]byte
+	// keyTimeout is how long to wait for the remainder of a partial key input
+	// sequence. See the WithKeyTimeout option.
+	keyTimeout time.Duration
+	// pendingRead, if non-nil, receives the result of a read of the input
+	// which was still in progress when the key timeout expired.
+	pendingRead chan inputRead
+	prompt      []rune
+
+	// metaBit indicates that input bytes with the high bit set should be
+	// interpreted as Meta chords. See the WithEightBitMeta option.
+	metaBit bool
+	// keyTable, if non-nil, contains the key input sequences registered by the
+	// WithTerminfo and WithKeySequences options in addition to the defaults.
+	// terminfo is true if the terminfo entry is consulted, and terminfoSeqs
+	// holds its sequences once read by New. keySequences holds the sequences of
+	// the WithKeySequences options.
+	keyTable     *keys.Table
+	terminfo     bool
+	terminfoSeqs map[string]rune
+	keySequences []map[string]rune
+	// encoding is the character encoding used by the terminal. See the
+	// WithEncoding option.
+	encoding Encoding
+
+	// onAccept is invoked when a line is accepted to determine whether and how
+	// the line is stored in history. See the WithOnAccept option.
+	onAccept func(line string) (store bool, transformed string)
+	// historyFilter is invoked with each entry about to be added to history,
+	// which is excluded if it returns false. See the WithHistoryFilter option.
+	historyFilter func(entry string) bool
+	// confirmPatterns and confirmPrompt configure the confirmation required
+	// before input matching any of the patterns is accepted. See the
+	// WithConfirmPatterns option.
+	confirmPatterns []*regexp.Regexp
+	confirmPrompt   string
+	// preRender and postRender are invoked before and after the prompt and
+	// input text are written to the terminal. See the WithRenderHooks option.
+	preRender  PreRenderFunc
+	postRender PostRenderFunc
+
+	// ttyFallback is true if the controlling terminal is opened for input and
+	// output when the input is not a terminal. ttyPath is the path of the
+	// controlling terminal, which is overridden by tests. tty is the opened
+	// terminal, which is closed by Close. See the WithTTYFallback option.
+	ttyFallback bool
+	ttyPath     string
+	tty         *os.File
+	// resizeSignal is true if the size of a terminal input is updated when the
+	// process receives SIGWINCH. See the WithResizeSignal option.
+	resizeSignal bool
+
+	// interactive specifies whether input is read interactively, and
+	// nonInteractive is the resulting mode. See the WithInteractive option.
+	interactive    interactiveMode
+	nonInteractive bool
+	// lineReader buffers the input read by ReadLine in non-interactive mode.
+	lineReader *bufio.Reader
+	// script holds the scripted keystrokes which replace the input. See
+	// WithScript.
+	script io.Reader
+
+	// metrics holds the counters returned by Metrics.
+	metrics *metrics
+	// debug writes to the debug log, and is nil unless the PROMPT_DEBUG
+	// environment variable is set. See debugLog.
+	debug *debugLog
+	// tracer holds the key traces returned by Trace, and is nil unless tracing
+	// is enabled. See WithTrace.
+	tracer *tracer
 
 	// bindings holds key bindings, mapping key input to an command to perform. If a
 	// key is not present in the binding map it is inserted at the current cursor
 	// position.
-	bindings map[rune]command
+	bindings keyBindings
+	// extraBindings holds the bindings specified by WithBindings options and
+	// configs which are parsed after the default bindings.
+	extraBindings []extraBindings
+	// options holds the options the Prompt was created with. See Clone.
+	options []Option
 
 	mu struct {
 		sync.Mutex
 		state state
+		// rawSaved holds the terminal state to restore when leaving raw mode. It is
+		// non-nil while ReadLine has the terminal in raw mode.
+		rawSaved *term.State
+		// hidden is true if the prompt has been hidden by Hide. While hidden, input
+		// processing is paused until Show is called, which signals shown.
+		hidden bool
+		shown  sync.Cond
+		// lastLine is the most recently accepted line. See LastLine.
+		lastLine string
+		// draft saves the input text to the draft file, if configured by
+		// WithDraftFile. draftChecked is true once the draft file has been
+		// checked for input to restore.
+		draft        *draft
+		draftChecked bool
+		// inputStart is the time at which processing of the current input
+		// began, and rendering is true while the render hooks are invoked. See
+		// flushLocked.
+		inputStart time.Time
+		rendering  bool
+		// macroDepth is the nesting depth of the macros being typed. See
+		// dispatchMacroLocked.
+		macroDepth int
 	}
 }
 
@@ -80,63 +288,229 @@ type Prompt struct {
 // specified, the Prompt uses os.Stdin and os.Stdout for input and output.
 func New(options ...Option) (*Prompt, error) {
 	p := &Prompt{
-		in:       os.Stdin,
-		out:      os.Stdout,
-		bindings: make(map[rune]command),
+		fd:           -1,
+		in:           os.Stdin,
+		out:          os.Stdout,
+		ttyPath:      "/dev/tty",
+		resizeSignal: true,
+		bindings:     makeKeyBindings(),
+		metrics:      newMetrics(),
+		debug:        newDebugLog(),
 	}
+	p.mu.shown.L = &p.mu.Mutex
+	p.mu.state.metrics = p.metrics
+	p.mu.state.debug = p.debug
+	p.mu.state.history.debug = p.debug
+	p.mu.state.killRing.debug = p.debug
+	p.mu.state.theme = defaultTheme
+	p.mu.state.mark = -1
+	p.mu.state.help.bindings = &p.bindings
+	p.mu.state.completer.mu = &p.mu.Mutex
+	p.mu.state.completer.flush = p.flushLocked
+	p.mu.state.tmux = insideTmux()
 
-	if err := parseBindings(p.bindings, defaultBindings); err != nil {
+	if err := p.bindings.parse(defaultBindings, "default"); err != nil {
 		return nil, err
 	}
 
 	p.mu.state.screen.Init()
+	p.mu.state.screen.metrics = p.metrics
+	p.mu.state.screen.debug = p.debug
+	p.options = append([]Option(nil), options...)
 	for _, opt := range options {
 		opt.apply(p)
 	}
-
-	if err := p.mu.state.history.Load(); err != nil {
+	var numBindingsOptions int
+	for _, b := range p.extraBindings {
+		source := b.source
+		if source == "" {
+			numBindingsOptions++
+			source = fmt.Sprintf("WithBindings#%d", numBindingsOptions)
+		}
+		if err := p.bindings.parse(b.bindings, source); err != nil {
+			return nil, err
+		}
+	}
+	p.addConfirmCheck()
+	if err := p.initKeyTable(); err != nil {
 		return nil, err
 	}
 
-	type fdGetter interface {
-		Fd() uintptr
+	if p.script != nil {
+		if err := p.loadScript(); err != nil {
+			return nil, err
+		}
 	}
+
+	if p.ttyFallback && p.script == nil && !isTerminal(p.in) {
+		if tty, err := os.OpenFile(p.ttyPath, os.O_RDWR, 0); err == nil {
+			p.in, p.out, p.tty = tty, tty, tty
+		}
+	}
+
 	if f, ok := p.in.(fdGetter); ok {
 		p.fd = int(f.Fd())
 	}
+
+	switch p.interactive {
+	case interactiveAuto:
+		// Input from a file which is not a terminal, such as a pipe or regular
+		// file, is read non-interactively. Other readers are assumed to be
+		// terminals.
+		_, isFile := p.in.(fdGetter)
+		p.nonInteractive = isFile && !isTerminal(p.in)
+	case interactiveOff:
+		p.nonInteractive = true
+	}
+
+	if !p.nonInteractive {
+		if err := p.mu.state.history.Load(); err != nil {
+			if p.tty != nil {
+				p.tty.Close()
+			}
+			return nil, err
+		}
+		if err := p.mu.state.killRing.Load(); err != nil {
+			p.mu.state.history.Close()
+			if p.tty != nil {
+				p.tty.Close()
+			}
+			return nil, err
+		}
+	}
+
+	p.parser = keys.NewParser(p.keyTable, p.metaBit)
+
+	if p.encoding != UTF8 {
+		if !p.metaBit {
+			p.in = &encodingReader{r: p.in, enc: p.encoding}
+		}
+		p.out = &encodingWriter{w: p.out, enc: p.encoding}
+	}
 	return p, nil
 }
 
-// Close closes the Prompt, releasing any open resources.
+// Close closes the Prompt, releasing any open resources. All resources are
+// released even if releasing one fails, and the first error is returned.
 func (p *Prompt) Close() error {
-	return p.mu.state.history.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.state.completer.CancelContext()
+	p.mu.state.killRing.Sync()
+	if p.mu.draft != nil {
+		p.mu.draft.Stop()
+	}
+	var err error
+	if p.tty != nil {
+		err = p.tty.Close()
+	}
+	// Syncing and compacting the history accesses the history entries and
+	// store, which are guarded by the mutex. The history is closed even if
+	// closing the terminal failed.
+	if historyErr := p.mu.state.history.Close(); err == nil {
+		err = historyErr
+	}
+	if debugErr := p.debug.Close(); err == nil {
+		err = debugErr
+	}
+	return err
+}
+
+type fdGetter interface {
+	Fd() uintptr
+}
+
+// isTerminal returns true if r is a terminal.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(fdGetter)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// Clone creates a new Prompt configured with the options p was created with,
+// followed by the specified options, which take precedence. This allows a
+// Prompt configured by an application to serve as a template for multiple
+// prompts, such as one per SSH session, with the per-prompt options (e.g.
+// WithInput and WithOutput) passed to Clone. Clone replays the options rather
+// than copying the state of p: the key bindings and theme of the clone are
+// those configured by the options, which are fixed once a Prompt is created,
+// while the input text, kill ring, and history are not copied, the history
+// being loaded afresh from the history file, if any. The arguments of the
+// options are shared, however, so the prompts invoke the same callbacks and
+// write to the same WithMirrorOutput writer, and a WithScript reader consumed
+// by p provides no input to the clone.
+func (p *Prompt) Clone(options ...Option) (*Prompt, error) {
+	opts := append([]Option(nil), p.options...)
+	if p.terminfoSeqs != nil {
+		opts = append(opts, terminfoSeqsOption{p.terminfoSeqs})
+	}
+	return New(append(opts, options...)...)
+}
+
+// terminfoSeqsOption supplies the terminfo sequences read for the Prompt being
+// cloned, so that Clone does not read the terminfo entry again.
+type terminfoSeqsOption struct {
+	seqs map[string]rune
+}
+
+func (o terminfoSeqsOption) apply(p *Prompt) {
+	p.terminfoSeqs = o.seqs
 }
 
 // ReadLine reads a line of input. If the input is canceled, io.EOF is returned
-// as the error.
+// as the error. If the line was read but could not be written to the history
+// file, the line is returned along with the error.
+//
+// ReadLine must not be invoked from a callback invoked by ReadLine, which holds
+// the mutex of the Prompt. A callback passed a LockedPrompt may instead read
+// secondary input using LockedPrompt.ReadLine.
 func (p *Prompt) ReadLine(prompt string) (string, error) {
+	if p.nonInteractive {
+		line, err := p.readLineNonInteractive()
+		if err == nil {
+			p.mu.Lock()
+			p.mu.lastLine = line
+			if w := p.mu.state.mirror; w != nil {
+				_, _ = io.WriteString(w, line+"\n")
+			}
+			p.mu.Unlock()
+		}
+		return line, err
+	}
+
+	return p.readInteractive(prompt, p.readLineLocked)
+}
+
+// readInteractive places the terminal in raw mode and invokes read with the
+// mutex held, restoring the terminal mode afterwards.
+func (p *Prompt) readInteractive(
+	prompt string, read func(prompt string) (string, error),
+) (string, error) {
 	if err := p.updateSize(); err != nil {
 		return "", err
 	}
 
+	var saved *term.State
 	if p.fd != -1 {
-		// If we have a file descriptor, set up SIGWINCH handling so we can get notified
-		// of changes in the terminal's size.
-		winch := make(chan os.Signal, 1)
-		signal.Notify(winch, syscall.SIGWINCH)
-		go func() {
-			for range winch {
-				_ = p.updateSize()
-			}
-		}()
-		defer func() {
-			signal.Stop(winch)
-			close(winch)
-		}()
+		if p.resizeSignal {
+			// If we have a file descriptor, set up SIGWINCH handling so we can get
+			// notified of changes in the terminal's size.
+			winch := make(chan os.Signal, 1)
+			signal.Notify(winch, syscall.SIGWINCH)
+			go func() {
+				for range winch {
+					_ = p.updateSize()
+				}
+			}()
+			defer func() {
+				signal.Stop(winch)
+				close(winch)
+			}()
+		}
 
 		// Put the terminal into raw mode, restoring the
 		// original mode on exit.
-		saved, err := term.MakeRaw(p.fd)
+		var err error
+		saved, err = term.MakeRaw(p.fd)
 		if err != nil {
 			return "", err
 		}
@@ -145,60 +519,669 @@ func (p *Prompt) ReadLine(prompt string) (string, error) {
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	defer p.mu.state.history.maybeCompact()
+
+	if saved != nil {
+		p.mu.rawSaved = saved
+		defer func() {
+			p.mu.rawSaved = nil
+		}()
+	}
+
+	return read(prompt)
+}
+
+// readLineNonInteractive reads a line of input without using any terminal
+// functionality: the prompt is not displayed, no editing is performed, and the
+// input is not added to history. Lines are accumulated until the input finished
+// callback considers the input complete. Unterminated input at the end of the
+// input is returned as a line; subsequent calls return io.EOF.
+func (p *Prompt) readLineNonInteractive() (string, error) {
+	if p.lineReader == nil {
+		p.lineReader = bufio.NewReader(p.in)
+	}
+
+	var text strings.Builder
+	for lines := 0; ; lines++ {
+		line, err := p.lineReader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		if len(line) == 0 && err != nil {
+			if lines > 0 {
+				return text.String(), nil
+			}
+			return "", err
+		}
+		if lines > 0 {
+			text.WriteByte('\n')
+		}
+		text.WriteString(strings.TrimRight(line, "\r\n"))
+
+		s := text.String()
+		if p.mu.state.inputFinished == nil ||
+			p.mu.state.inputFinished(s, utf8.RuneCountInString(s)) || err != nil {
+			return s, nil
+		}
+	}
+}
+
+// readNestedLocked reads input using read while an outer ReadLine is suspended
+// in a callback. The outer prompt and input text are left on screen and redrawn
+// below the nested prompt once the nested input is complete.
+func (p *Prompt) readNestedLocked(
+	prompt string, read func(prompt string) (string, error),
+) (string, error) {
+	outer := p.mu.state
+	outer.screen.Suspend()
+	outer.screen.Flush(p.out)
 
-	p.mu.state.screen.Reset([]rune(prompt))
+	p.mu.state = state{
+		nested:        true,
+		metrics:       p.metrics,
+		debug:         p.debug,
+		theme:         outer.theme,
+		echoInterrupt: outer.echoInterrupt,
+		echoEOF:       outer.echoEOF,
+		mirror:        outer.mirror,
+	}
+	p.mu.state.help.bindings = outer.help.bindings
+	s := &p.mu.state.screen
+	s.Init()
+	s.metrics = p.metrics
+	s.debug = p.debug
+	s.runeWidth = outer.screen.runeWidth
+	s.wordClassifier = outer.screen.wordClassifier
+	s.renderer = outer.screen.renderer
+	s.width, s.height = outer.screen.width, outer.screen.height
+
+	result, err := read(prompt)
+	if err != nil {
+		p.mu.state.screen.Suspend()
+		p.mu.state.screen.Flush(p.out)
+	}
+
+	p.mu.state = outer
+	p.mu.state.screen.Redraw()
 	p.mu.state.screen.Flush(p.out)
+	return result, err
+}
+
+func (p *Prompt) readLineLocked(prompt string) (string, error) {
+	return p.readTemplateLocked(prompt, nil)
+}
+
+// readTemplateLocked reads a line of input, initializing the input text to the
+// segments if non-empty (see ReadLineTemplate).
+func (p *Prompt) readTemplateLocked(prompt string, segments []TemplateSegment) (string, error) {
+	p.mu.state.active = true
+	defer func() {
+		p.mu.state.completer.CancelContext()
+		p.mu.state.killRing.Sync()
+		p.mu.state.active = false
+	}()
+
+	s := &p.mu.state
+	s.Reset([]rune(prompt))
+	if len(segments) > 0 {
+		s.setTemplate(segments)
+	} else if !s.nested {
+		p.restoreDraftLocked()
+	}
+	p.flushLocked()
 
 	for {
+		// Wait for the prompt to be shown if it was hidden.
+		for p.mu.hidden {
+			p.mu.shown.Wait()
+		}
+
 		// Loop processing keys from the input.
 		if result, err := p.processInputLocked(); err != nil {
-			return "", err
+			return result, err
 		} else if len(result) > 0 {
 			return result, nil
 		}
 
-		// Read more input from the tty. This is slightly complicated in that we need to
-		// preserve the data in p.inBytes which may be a partial escape sequence.
-		if len(p.inBytes) > 0 {
-			n := copy(p.inBuf[:], p.inBytes)
-			p.inBytes = p.inBuf[:n]
+		if err := p.readInputLocked(); err != nil {
+			return "", err
 		}
-		readBuf := p.inBuf[len(p.inBytes):]
+	}
+}
+
+// readInputLocked reads more input from the tty, releasing the mutex while
+// waiting for input.
+func (p *Prompt) readInputLocked() error {
+	if p.pendingRead != nil || (p.keyTimeout > 0 && len(p.parser.Buffered()) > 0) {
+		return p.readInputTimeoutLocked()
+	}
+
+	p.mu.Unlock()
+	n, err := p.in.Read(p.inBuf[:])
+	p.mu.Lock()
+
+	if err != nil {
+		return err
+	}
+	p.parser.Feed(p.inBuf[:n])
+	return nil
+}
+
+// inputRead is the result of a read of the input.
+type inputRead struct {
+	data []byte
+	err  error
+}
 
-		p.mu.Unlock()
-		n, err := p.in.Read(readBuf)
+// readInputTimeoutLocked reads more input, waiting no longer than the key
+// timeout if the parser holds a partial key input sequence. As a read cannot
+// be interrupted, the read is performed in the background, and a read still in
+// progress when the timeout expires delivers its result to the next call.
+func (p *Prompt) readInputTimeoutLocked() error {
+	pending := p.pendingRead
+	if pending == nil {
+		pending = make(chan inputRead, 1)
+		p.pendingRead = pending
+		go func(in io.Reader) {
+			buf := make([]byte, len(p.inBuf))
+			n, err := in.Read(buf)
+			pending <- inputRead{data: buf[:n], err: err}
+		}(p.in)
+	}
+	var timeout <-chan time.Time
+	if p.keyTimeout > 0 && len(p.parser.Buffered()) > 0 {
+		t := time.NewTimer(p.keyTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	p.mu.Unlock()
+	select {
+	case r := <-pending:
+		p.mu.Lock()
+		p.pendingRead = nil
+		if r.err != nil {
+			return r.err
+		}
+		p.parser.Feed(r.data)
+	case <-timeout:
 		p.mu.Lock()
+		p.parser.Expire()
+	}
+	return nil
+}
 
-		if err != nil {
-			return "", err
+// SetPrompt changes the prompt displayed by the active ReadLine, re-rendering
+// the prompt and input text. SetPrompt may be called concurrently with
+// ReadLine. A callback invoked by ReadLine may instead call
+// LockedPrompt.SetPrompt. If ReadLine is not active, SetPrompt has no effect.
+func (p *Prompt) SetPrompt(prompt string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setPromptLocked(prompt)
+}
+
+func (p *Prompt) setPromptLocked(prompt string) {
+	if !p.mu.state.active {
+		return
+	}
+	p.mu.state.screen.SetPrefix([]rune(prompt))
+	p.flushLocked()
+}
+
+// SetAnnotation displays transient text after the input text of the active
+// ReadLine, such as "3 rows will be affected" or a lint warning, replacing any
+// annotation previously set with the same name. An annotation with empty text
+// removes the annotation. Multiple annotations are displayed in order of
+// decreasing priority, alongside those displayed by Prompt itself such as the
+// history search prompt (see AnnotationPrioritySearch). Annotations are removed
+// when the input is accepted or cancelled. SetAnnotation may be called
+// concurrently with ReadLine. A callback invoked by ReadLine may instead call
+// LockedPrompt.SetAnnotation. If ReadLine is not active, SetAnnotation has no
+// effect.
+func (p *Prompt) SetAnnotation(name string, a Annotation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setAnnotationLocked(name, a)
+}
+
+func (p *Prompt) setAnnotationLocked(name string, a Annotation) {
+	if !p.mu.state.active {
+		return
+	}
+	s := &p.mu.state
+	s.annotations.Set(&s.screen, annotationKey{name: name}, a)
+	p.flushLocked()
+}
+
+// ClearAnnotation removes the annotation with the specified name. It is
+// equivalent to calling SetAnnotation with an empty Annotation.
+func (p *Prompt) ClearAnnotation(name string) {
+	p.SetAnnotation(name, Annotation{})
+}
+
+// ApplyStyle applies style to the range [start,end) of the input text of the
+// active ReadLine, replacing any style previously applied to the range, and
+// re-renders the range. The positions are rune offsets within the input text,
+// and are clamped to it. An empty style removes the styles applied to the
+// range. Unlike the highlighting of WithHighlighter, the style is retained by
+// the text as it is edited, moving with the text when text is inserted or
+// deleted before it and extending over text inserted at its start or within
+// it, until the text is removed. The style is combined with the highlighting
+// and other styles of the text. ApplyStyle may be called concurrently with
+// ReadLine. A callback invoked by ReadLine may instead call
+// LockedPrompt.ApplyStyle. If ReadLine is not active, ApplyStyle has no effect.
+func (p *Prompt) ApplyStyle(start, end int, style Style) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.applyStyleLocked(start, end, style)
+}
+
+func (p *Prompt) applyStyleLocked(start, end int, style Style) {
+	if !p.mu.state.active {
+		return
+	}
+	s := &p.mu.state.screen
+	if n := len(s.text) - len(s.prefix) - len(s.suffix); end > n {
+		end = n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start >= end {
+		return
+	}
+	s.ApplyStyle(start, end, style)
+	p.flushLocked()
+}
+
+// CommandFinished reports the exit status of the command entered at the
+// prompt, for terminals supporting the OSC 133 semantic prompt marks (see
+// WithSemanticMarks). It should be called after the output of the command has
+// been written. If CommandFinished is not called, the end of the command is
+// marked without an exit status when the next prompt is displayed.
+func (p *Prompt) CommandFinished(status int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.mu.state.commandRunning {
+		return
+	}
+	p.mu.state.commandRunning = false
+	fmt.Fprintf(p.out, oscCommandEndFn, status)
+}
+
+// LastLine returns the line most recently returned by ReadLine, excluding
+// lines read by a nested ReadLine, or the empty string if no line has been
+// read. It is safe to call LastLine concurrently with ReadLine.
+func (p *Prompt) LastLine() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mu.lastLine
+}
+
+// AnnotateLastHistory attaches metadata, such as the exit status or duration
+// of the command, to the history entry most recently added by ReadLine. The
+// metadata is merged with any metadata previously attached to the entry, and
+// is displayed below the entry when it is recalled by history navigation or
+// search. If there is a history file, the metadata is persisted in it on a line
+// following the entry, which libedit based tools read as a separate entry.
+// Keys must be non-empty and must not contain '='. AnnotateLastHistory has no
+// effect if the last line read was not added to history. It may be called
+// concurrently with ReadLine.
+func (p *Prompt) AnnotateLastHistory(meta map[string]string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mu.state.history.AnnotateLast(meta)
+}
+
+// SyncHistory writes the history entries buffered by the write policy (see
+// WithHistoryWritePolicy) to the history file or store, then commits the
+// history to stable storage, syncing the history file or invoking the Sync
+// method of a store implementing HistorySyncer. It may be called concurrently
+// with ReadLine.
+func (p *Prompt) SyncHistory() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mu.state.history.Sync()
+}
+
+// ExportHistory returns a JSON snapshot of the history, allowing applications
+// which manage their own state files to save the history of the session. The
+// snapshot holds the entries, ordered from the oldest, as edited during history
+// navigation, along with their metadata and the entries buffered by the write
+// policy which have not yet been written to the history file or store. It may
+// be called concurrently with ReadLine.
+func (p *Prompt) ExportHistory() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return json.Marshal(&p.mu.state.history)
+}
+
+// ImportHistory replaces the history entries with those of a snapshot returned
+// by ExportHistory, retaining the most recent entries if there are more than
+// the max history size. The history file or store is not rewritten, but the
+// buffered entries of the snapshot are written to it as specified by the write
+// policy, replacing the entries currently buffered. ImportHistory returns an
+// error if ReadLine is active.
+func (p *Prompt) ImportHistory(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.state.active {
+		return errors.New("cannot import history while ReadLine is active")
+	}
+	return json.Unmarshal(data, &p.mu.state.history)
+}
+
+// CurrentText returns the input text of the active ReadLine, excluding any
+// displayed completion hint, or the empty string if ReadLine is not active.
+// Together with CursorPosition, it allows supervisory features such as the
+// periodic autosave of in-progress input. CurrentText may be called
+// concurrently with ReadLine. A callback invoked by ReadLine may instead call
+// LockedPrompt.CurrentText.
+func (p *Prompt) CurrentText() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	text, _ := p.currentInputLocked()
+	return text
+}
+
+// CursorPosition returns the position of the cursor, as a rune offset within
+// the text returned by CurrentText, or 0 if ReadLine is not active.
+// CursorPosition may be called concurrently with ReadLine. A callback invoked
+// by ReadLine may instead call LockedPrompt.CursorPosition.
+func (p *Prompt) CursorPosition() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, pos := p.currentInputLocked()
+	return pos
+}
+
+// currentInputLocked returns the input text and cursor position of the active
+// ReadLine.
+func (p *Prompt) currentInputLocked() (string, int) {
+	if !p.mu.state.active {
+		return "", 0
+	}
+	st := undoSnapshot(&p.mu.state)
+	return string(st.text), st.pos
+}
+
+// Interactive returns true if input is read interactively from a terminal, and
+// false if input is read line by line without editing. See WithInteractive.
+func (p *Prompt) Interactive() bool {
+	return !p.nonInteractive
+}
+
+// Metrics returns a snapshot of the counters describing the work performed by
+// the Prompt. It is safe to call Metrics concurrently with ReadLine.
+func (p *Prompt) Metrics() Metrics {
+	return p.metrics.Snapshot()
+}
+
+// Trace returns the timings of the most recently processed keys, oldest first,
+// or nil if tracing is not enabled (see WithTrace). It is safe to call Trace
+// concurrently with ReadLine.
+func (p *Prompt) Trace() []KeyTrace {
+	return p.tracer.Snapshot()
+}
+
+// BindingConflicts returns the keys which were bound more than once to
+// different commands while the Prompt was created, in the order the bindings
+// were applied. The last binding of a key takes effect, so a conflict is not
+// an error: overriding a default binding (PrevSource is "default") is usually
+// intentional. Conflicts between other sources, or within a single source,
+// often indicate a mistake in the bindings.
+func (p *Prompt) BindingConflicts() []BindingConflict {
+	return append([]BindingConflict(nil), p.bindings.conflicts...)
+}
+
+// DumpBindings writes the key bindings in effect, including the default
+// bindings, to w using the syntax of WithBindings, so that they can be edited
+// and passed back to WithBindings. The bindings are grouped by the category of
+// their command, and the bindings of each command are preceded by a comment
+// describing the command.
+func (p *Prompt) DumpBindings(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var prev command
+	category := commandCategory(-1)
+	for _, b := range p.bindings.list() {
+		info := describeCommand(b.cmd)
+		if info.category != category {
+			if category >= 0 {
+				bw.WriteString("\n")
+			}
+			category = info.category
+			fmt.Fprintf(bw, "# %s\n", category)
 		}
-		p.inBytes = p.inBuf[:n+len(p.inBytes)]
+		if b.cmd != prev {
+			prev = b.cmd
+			fmt.Fprintf(bw, "\n# %s\n", info.description)
+		}
+		fmt.Fprintf(bw, "bind %s %s", b.key, b.cmd)
+		if b.cond != "" {
+			fmt.Fprintf(bw, " if %s", b.cond)
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// Redraw redraws the prompt and the current input text. Redraw is intended to
+// be used when the application has written output to the terminal while
+// ReadLine is active, which leaves the rendered prompt in an unknown state. The
+// prompt is redrawn starting at the beginning of the line containing the
+// terminal cursor, so such output should normally be terminated by a newline.
+// It is safe to call Redraw concurrently with ReadLine.
+func (p *Prompt) Redraw() {
+	if p.nonInteractive {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.state.screen.Redraw()
+	p.flushLocked()
+}
+
+// Hide erases the prompt and input text from the terminal and, if ReadLine is
+// active, restores the terminal to the mode it was in before ReadLine was
+// called. Hide is intended to be used around operations which need control of
+// the terminal, such as running an external command. Keys are not processed
+// until Show is called, but Hide does not stop an active ReadLine from reading:
+// a read of the input which is in progress cannot be interrupted, so input
+// typed while the prompt is hidden is consumed by ReadLine rather than by the
+// external command, and is processed once the prompt is shown. Operations
+// which read the terminal input should therefore hide the prompt while
+// ReadLine is not active.
+func (p *Prompt) Hide() error {
+	if p.nonInteractive {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.mu.hidden {
+		return nil
+	}
+	p.mu.hidden = true
+	p.mu.state.screen.Erase()
+	p.mu.state.screen.Flush(p.out)
+
+	if p.mu.rawSaved != nil {
+		return term.Restore(p.fd, p.mu.rawSaved)
+	}
+	return nil
+}
+
+// Show re-enters raw mode if ReadLine is active and redraws the prompt and input
+// text previously hidden by Hide. The prompt is redrawn starting at the
+// beginning of the line containing the terminal cursor.
+func (p *Prompt) Show() error {
+	if p.nonInteractive {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.mu.hidden {
+		return nil
+	}
+	if p.mu.rawSaved != nil {
+		if _, err := term.MakeRaw(p.fd); err != nil {
+			return err
+		}
+	}
+	p.mu.hidden = false
+	p.mu.shown.Broadcast()
+	p.mu.state.screen.Redraw()
+	p.flushLocked()
+	return nil
+}
+
+// Exclusive hides the prompt, runs fn, and then shows the prompt again. This
+// allows fn to use the terminal in its normal (cooked) mode, such as for
+// running an external command like a pager. See Hide for why fn should not
+// read the terminal input while ReadLine is active.
+func (p *Prompt) Exclusive(fn func()) error {
+	if err := p.Hide(); err != nil {
+		return err
+	}
+	fn()
+	return p.Show()
+}
+
+// initKeyTable registers the sequences of the terminfo entry for the terminal,
+// if enabled by WithTerminfo, followed by those of the WithKeySequences
+// options.
+func (p *Prompt) initKeyTable() error {
+	if p.terminfo && p.terminfoSeqs == nil {
+		p.terminfoSeqs = map[string]rune{}
+		if term := os.Getenv("TERM"); term != "" {
+			seqs, err := keys.TerminfoSequences(term)
+			if err != nil && !errors.Is(err, keys.ErrTerminfoNotFound) {
+				return err
+			}
+			if seqs != nil {
+				p.terminfoSeqs = seqs
+			}
+		}
+	}
+	if p.terminfo && len(p.terminfoSeqs) > 0 {
+		p.addKeySequences(p.terminfoSeqs)
+	}
+	for _, seqs := range p.keySequences {
+		p.addKeySequences(seqs)
+	}
+	return nil
+}
+
+// addKeySequences registers additional key input sequences. The sequences are
+// registered in sorted order so that conflicts between them are resolved
+// deterministically.
+func (p *Prompt) addKeySequences(seqs map[string]rune) {
+	if p.keyTable == nil {
+		p.keyTable = keys.NewTable()
+	}
+	sorted := make([]string, 0, len(seqs))
+	for seq := range seqs {
+		sorted = append(sorted, seq)
+	}
+	sort.Strings(sorted)
+	for _, seq := range sorted {
+		p.keyTable.Add(seq, seqs[seq])
 	}
 }
 
 func (p *Prompt) processInputLocked() (string, error) {
+
+	p.mu.inputStart = time.Now()
+	defer func() {
+		p.mu.inputStart = time.Time{}
+	}()
+
+	// trace holds the timings of the most recently processed key if tracing is
+	// enabled. It is recorded once the next key has been parsed, or once the
+	// output has been flushed for the last key.
+	var trace *KeyTrace
 	var err error
 	for err == nil {
-		var key rune
-		origInBytes := p.inBytes
-		key, p.inBytes = parseKey(p.inBytes)
-		if key == utf8.RuneError {
+		origInBytes := p.parser.Buffered()
+		start := time.Now()
+		key, ok := p.parser.Next()
+		if !ok {
 			break
 		}
-		debugPrintf(" input: %q -> %s\n",
-			origInBytes[:len(origInBytes)-len(p.inBytes)], debugKey(key))
-		err = p.dispatchKeyLocked(key)
+		p.debug.Printf(" input: %q -> %s\n",
+			origInBytes[:len(origInBytes)-len(p.parser.Buffered())], debugKey(key))
+		p.metrics.AddKey()
+		if p.tracer != nil {
+			if trace != nil {
+				p.tracer.Add(*trace)
+			}
+			trace = &KeyTrace{Start: start, Key: keyName(key), Parse: time.Since(start)}
+		}
+		p.mu.state.inputPending = len(p.parser.Buffered()) > 0
+		var cmd command
+		dispatchStart := time.Now()
+		cmd, err = p.dispatchKeyLocked(key)
+		renderStart := time.Now()
+		if err == nil {
+			p.mu.state.highlighter.Update(&p.mu.state)
+		}
+		if trace != nil {
+			trace.Command = string(cmd)
+			trace.Dispatch = renderStart.Sub(dispatchStart)
+			trace.Render = time.Since(renderStart)
+		}
 	}
 
-	if err == nil || errors.Is(err, io.EOF) {
-		// Flush any buffered rendering commands.
+	// Lint the input and flush any buffered rendering commands. The linter and
+	// render hooks are not invoked once the input has been accepted.
+	renderStart := time.Now()
+	if err == nil {
+		p.mu.state.linter.Update(&p.mu.state)
+	}
+	flushStart, bytes := time.Now(), p.mu.state.screen.outbuf.Len()
+	if err == nil {
+		p.flushLocked()
+	} else if errors.Is(err, io.EOF) {
 		p.mu.state.screen.Flush(p.out)
 	}
+	if trace != nil {
+		trace.Render += flushStart.Sub(renderStart)
+		trace.Flush = time.Since(flushStart)
+		trace.Bytes = bytes
+		p.tracer.Add(*trace)
+	}
+
+	if d := p.mu.draft; d != nil && !p.mu.state.nested {
+		// Save the input to the draft file, removing it once the input has been
+		// accepted or cancelled.
+		switch {
+		case err == nil:
+			d.Update(string(undoSnapshot(&p.mu.state).text))
+		case errors.Is(err, io.EOF):
+			d.Clear()
+		}
+	}
 
 	if errors.Is(err, io.EOF) {
 		if text := string(p.mu.state.screen.Text()); len(text) > 0 {
-			p.mu.state.history.Add(text)
-			return text, nil
+			if p.mu.state.nested {
+				return text, nil
+			}
+			p.mu.lastLine = text
+			p.mu.state.history.annotatable = false
+			entry := text
+			if p.onAccept != nil {
+				var store bool
+				if store, entry = p.onAccept(text); !store {
+					return text, nil
+				}
+			}
+			if p.historyFilter != nil && !p.historyFilter(entry) {
+				return text, nil
+			}
+			return text, p.mu.state.history.Add(entry)
 		}
 	}
 	return "", err
@@ -213,20 +1196,108 @@ func (p *Prompt) updateSize() error {
 	if err != nil {
 		return err
 	}
+	p.SetSize(width, height)
+	return nil
+}
 
+// SetSize changes the width and height of the terminal, re-rendering the
+// prompt and input text of the active ReadLine to fit. The size of a terminal
+// input is determined automatically, and updated when the process receives
+// SIGWINCH unless disabled by WithResizeSignal. SetSize is intended for input
+// which is not the controlling terminal, such as an SSH channel, whose size
+// changes are reported by other means, such as SSH window-change requests.
+// This allows a process to serve many prompts, each sized independently,
+// without relying on process-wide signals. SetSize may be called concurrently
+// with ReadLine, but not from a callback invoked by ReadLine.
+func (p *Prompt) SetSize(width, height int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.mu.state.screen.SetSize(width, height)
-	p.mu.state.screen.Flush(p.out)
-	return nil
+	s := &p.mu.state
+	// The completion listing and the help overlay are laid out for the size of
+	// the screen.
+	s.screen.SetSize(width, height, func() {
+		s.completer.Resize(s)
+		s.help.Resize(s)
+	})
+	p.flushLocked()
 }
 
-func (p *Prompt) dispatchKeyLocked(key rune) error {
+// dispatchKeyLocked performs the command key is bound to, returning the
+// command.
+func (p *Prompt) dispatchKeyLocked(key rune) (command, error) {
 	s := &p.mu.state
-	cmd := p.bindings[key]
+	cmd := p.bindings.lookup(s, key)
 	if cmd == "" {
 		cmd = cmdInsertChar
 	}
+	if isMacro(cmd) {
+		return cmd, p.dispatchMacroLocked(p.bindings.macros[cmd])
+	}
+
+	if s.template != nil && templateDisabled(cmd) {
+		return cmd, nil
+	}
+
+	if cmd != cmdSubstrSearchBackward && cmd != cmdSubstrSearchForward {
+		// Any other command ends substring search, including those consumed by
+		// the completer, kill ring, or multiple cursors.
+		s.history.endSubstringSearch(s)
+	}
+	clearDraftNotice(s)
+	clearAcceptError(s)
+	before, index, searching := undoSnapshot(s), s.history.index, s.history.searchDir != 0
+	err := p.dispatchCommandLocked(cmd, key)
+	if err == nil {
+		if s.template != nil {
+			s.template.Enforce(s, before)
+		}
+		if s.mark >= 0 {
+			s.mark = adjustMark(s.mark, before.text, undoSnapshot(s).text)
+		}
+		recordUndo(s, cmd, before, index, searching)
+	}
+	return cmd, err
+}
+
+// maxMacroDepth limits the nesting of macros, which type keys that may
+// themselves be bound to macros, so that a macro typing its own key terminates.
+const maxMacroDepth = 8
+
+// dispatchMacroLocked types the keys of a macro, performing the command each
+// is bound to. The keys following one which accepts the input are discarded.
+func (p *Prompt) dispatchMacroLocked(macro []rune) error {
+	if p.mu.macroDepth >= maxMacroDepth {
+		return nil
+	}
+	p.mu.macroDepth++
+	defer func() {
+		p.mu.macroDepth--
+	}()
+	for _, key := range macro {
+		if _, err := p.dispatchKeyLocked(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dispatchCommandLocked dispatches cmd to the help overlay, insert-unicode
+// command, multiple cursors, completer, kill ring, history, base, and help
+// commands in turn, stopping at the first which handles it.
+func (p *Prompt) dispatchCommandLocked(cmd command, key rune) error {
+	s := &p.mu.state
+	if ok, err := s.help.Dispatch(s, cmd, key); err != nil || ok {
+		return err
+	}
+	if err := s.history.MaybeExpand(s, cmd); err != nil {
+		return err
+	}
+	if ok, err := s.unicode.Dispatch(s, cmd, key); err != nil || ok {
+		return err
+	}
+	if ok, err := s.cursors.Dispatch(s, cmd, key); err != nil || ok {
+		return err
+	}
 
 	if ok, err := s.completer.Dispatch(s, cmd, key); err != nil {
 		return err
@@ -251,5 +1322,10 @@ func (p *Prompt) dispatchKeyLocked(key rune) error {
 		return err
 	}
 
+	if fn, ok := helpCommands[cmd]; ok {
+		_, err := fn(s, key)
+		return err
+	}
+
 	return nil
 }
@@ -2,44 +2,69 @@ package prompt
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 	"unicode/utf8"
 
 	"github.com/cockroachdb/datadriven"
 	"github.com/mattn/go-runewidth"
+	"github.com/petermattis/prompt/keys"
 	"github.com/stretchr/testify/require"
 )
 
+// mockCell is a cell of the mock terminal, holding the rune displayed in the
+// cell and the SGR parameters of the style it was written with, such as "1;32"
+// for bold green text.
+type mockCell struct {
+	r     rune
+	style string
+}
+
 type mockTerm struct {
-	contents []rune
-	width    int
-	height   int
-	cursorX  int
-	cursorY  int
+	contents  []mockCell
+	width     int
+	height    int
+	cursorX   int
+	cursorY   int
+	runeWidth func(r rune) int
+	// osc records the OSC sequences written to the terminal, along with the
+	// cursor position at which they were written.
+	osc []string
+	// style holds the SGR parameters of the style of the text being written.
+	style string
 }
 
-var seqRE = regexp.MustCompile(`^\x1b\[(\d*)([ABCDHJKm])`)
+var seqRE = regexp.MustCompile(`^\x1b\[([\d;]*)([@ABCDGHJKPm])`)
+var oscRE = regexp.MustCompile(`^\x1b\]([^\x07\x1b]*)(?:\x07|\x1b\\)`)
 
 func newMockTerm(w, h int) *mockTerm {
 	return &mockTerm{
-		contents: make([]rune, w*h),
-		width:    w,
-		height:   h,
+		contents:  make([]mockCell, w*h),
+		width:     w,
+		height:    h,
+		runeWidth: runewidth.RuneWidth,
 	}
 }
 
 func (t *mockTerm) Write(p []byte) (int, error) {
 	for len(p) > 0 {
+		if m := oscRE.FindSubmatch(p); m != nil {
+			t.osc = append(t.osc, fmt.Sprintf("%q at %d,%d", m[1], t.cursorX, t.cursorY))
+			p = p[len(m[0]):]
+			continue
+		}
 		m := seqRE.FindSubmatch(p)
 		if m != nil {
 			var n int
-			if len(m[1]) > 0 {
+			if len(m[1]) > 0 && m[2][0] != 'm' {
 				var err error
 				n, err = strconv.Atoi(string(m[1]))
 				if err != nil {
@@ -47,13 +72,18 @@ func (t *mockTerm) Write(p []byte) (int, error) {
 				}
 			}
 			// \x1b[K     erase line to right
+			// \x1b[<N>@  insert <N> blank characters
+			// \x1b[<N>P  delete <N> characters
 			// \x1b[H     move cursor to 0,0
 			// \x1b[2J    erase screen from cursor down
 			// \x1b[<N>A  move cursor up <N>
 			// \x1b[<N>B  move cursor down <N>
 			// \x1b[<N>C  move cursor right <N>
 			// \x1b[<N>D  move cursor left <N>
+			// \x1b[<N>G  move cursor to column <N>
 			switch m[2][0] {
+			case '@':
+				t.insertChars(n)
 			case 'A':
 				t.moveUp(n)
 			case 'B':
@@ -62,14 +92,18 @@ func (t *mockTerm) Write(p []byte) (int, error) {
 				t.moveRight(n)
 			case 'D':
 				t.moveLeft(n)
+			case 'G':
+				t.moveColumn(n)
 			case 'H':
 				t.moveTo(0, 0)
 			case 'J':
 				t.eraseScreen(n)
 			case 'K':
 				t.eraseLine(n)
+			case 'P':
+				t.deleteChars(n)
 			case 'm':
-				// Set attribute, ignore
+				t.setStyle(string(m[1]))
 			default:
 				return -1, fmt.Errorf("unknown CSI command: %q", m[2][0])
 			}
@@ -99,7 +133,7 @@ func (t *mockTerm) String() string {
 		buf.WriteRune('│')
 		var prevWidth int
 		for x := 0; x < t.width; x++ {
-			r := t.contents[t.position(x, y)]
+			r := t.contents[t.position(x, y)].r
 			if r == 0 {
 				r = ' '
 			}
@@ -109,7 +143,7 @@ func (t *mockTerm) String() string {
 			if x == t.cursorX && y == t.cursorY {
 				buf.WriteRune('\u0332') // combining low line
 			}
-			prevWidth = runewidth.RuneWidth(r)
+			prevWidth = t.runeWidth(r)
 		}
 		buf.WriteString("│\n")
 	}
@@ -151,6 +185,13 @@ func (t *mockTerm) moveLeft(n int) {
 	t.moveTo(t.cursorX-n, t.cursorY)
 }
 
+func (t *mockTerm) moveColumn(n int) {
+	if n == 0 {
+		n = 1
+	}
+	t.moveTo(n-1, t.cursorY)
+}
+
 func (t *mockTerm) moveTo(x, y int) {
 	if x < 0 {
 		x = 0
@@ -159,8 +200,8 @@ func (t *mockTerm) moveTo(x, y int) {
 	}
 	if y < 0 {
 		y = 0
-	} else if y > t.height {
-		y = t.height
+	} else if y >= t.height {
+		y = t.height - 1
 	}
 	t.cursorX = x
 	t.cursorY = y
@@ -197,6 +238,30 @@ func (t *mockTerm) eraseLine(n int) {
 	}
 }
 
+func (t *mockTerm) insertChars(n int) {
+	if n == 0 {
+		n = 1
+	}
+	line := t.line(t.cursorY)[t.cursorX:]
+	if n > len(line) {
+		n = len(line)
+	}
+	copy(line[n:], line)
+	t.fill(t.cursorX, t.cursorY, n, 1, 0)
+}
+
+func (t *mockTerm) deleteChars(n int) {
+	if n == 0 {
+		n = 1
+	}
+	line := t.line(t.cursorY)[t.cursorX:]
+	if n > len(line) {
+		n = len(line)
+	}
+	copy(line, line[n:])
+	t.fill(t.width-n, t.cursorY, n, 1, 0)
+}
+
 func (t *mockTerm) scroll() {
 	for i := 1; i < t.height; i++ {
 		copy(t.line(i-1), t.line(i))
@@ -220,11 +285,11 @@ func (t *mockTerm) put(r rune) {
 		t.cursorX = 0
 		t.scroll()
 	default:
-		w := runewidth.RuneWidth(r)
+		w := t.runeWidth(r)
 		switch w {
 		case 0:
 		case 1:
-			t.contents[t.position(t.cursorX, t.cursorY)] = r
+			t.contents[t.position(t.cursorX, t.cursorY)] = mockCell{r, t.style}
 			if t.cursorX+1 < t.width {
 				t.cursorX++
 			}
@@ -234,69 +299,199 @@ func (t *mockTerm) put(r rune) {
 				t.scroll()
 			}
 			pos := t.position(t.cursorX, t.cursorY)
-			t.contents[pos] = r
-			t.contents[pos+1] = 0
+			t.contents[pos] = mockCell{r, t.style}
+			t.contents[pos+1] = mockCell{0, t.style}
 			t.cursorX += 2
 		}
 	}
 }
 
-func (t *mockTerm) line(y int) []rune {
+// resize changes the size of the terminal. When shrinking horizontally, rows
+// which no longer fit are wrapped, as by terminals which reflow their contents,
+// with the cursor moving along with the character it was positioned on. Rows
+// at the top are scrolled off as necessary to keep the cursor visible.
+func (t *mockTerm) resize(w, h int) {
+	var rows [][]mockCell
+	cursorX, cursorY := t.cursorX, 0
+	for y := 0; y < t.height; y++ {
+		line := t.line(y)
+		n := len(line)
+		for n > 0 && line[n-1].r == 0 {
+			n--
+		}
+		if y == t.cursorY {
+			cursorX, cursorY = t.cursorX, len(rows)
+			if w < t.width {
+				if n <= t.cursorX {
+					n = t.cursorX + 1
+				}
+				cursorX, cursorY = t.cursorX%w, len(rows)+t.cursorX/w
+			}
+		}
+		for x := 0; ; x += w {
+			end := x + w
+			if end > n {
+				end = n
+			}
+			rows = append(rows, line[x:end])
+			if end == n {
+				break
+			}
+		}
+	}
+	if cursorY >= h {
+		rows = rows[cursorY-h+1:]
+		cursorY = h - 1
+	}
+
+	t.width, t.height = w, h
+	t.contents = make([]mockCell, w*h)
+	for y := 0; y < h && y < len(rows); y++ {
+		copy(t.line(y), rows[y])
+	}
+	t.moveTo(cursorX, cursorY)
+}
+
+// setStyle applies the parameters of an SGR sequence to the style of the text
+// being written. An empty parameter or 0 resets the style, and the other
+// parameters are added to it.
+func (t *mockTerm) setStyle(params string) {
+	for _, p := range strings.Split(params, ";") {
+		switch p = strings.TrimLeft(p, "0"); {
+		case p == "":
+			t.style = ""
+		case t.style == "":
+			t.style = p
+		default:
+			t.style += ";" + p
+		}
+	}
+}
+
+// styles returns a description of the styled text displayed by the terminal,
+// listing each run of cells written with the same style on a separate line
+// along with its position and the SGR parameters of its style.
+func (t *mockTerm) styles() string {
+	var buf strings.Builder
+	for y := 0; y < t.height; y++ {
+		line := t.line(y)
+		for x := 0; x < len(line); {
+			style := line[x].style
+			end := x + 1
+			for end < len(line) && line[end].style == style {
+				end++
+			}
+			if style != "" {
+				var text strings.Builder
+				for _, c := range line[x:end] {
+					if c.r != 0 {
+						text.WriteRune(c.r)
+					}
+				}
+				fmt.Fprintf(&buf, "%d,%d-%d %s: %q\n", y, x, end, style, text.String())
+			}
+			x = end
+		}
+	}
+	return buf.String()
+}
+
+func (t *mockTerm) line(y int) []mockCell {
 	return t.contents[y*t.width : (y+1)*t.width]
 }
 
 func (t *mockTerm) fill(x, y, width, height int, r rune) {
 	for i := 0; i < height; i++ {
 		for j := 0; j < width; j++ {
-			t.contents[t.position(x+j, y+i)] = r
+			t.contents[t.position(x+j, y+i)] = mockCell{r: r}
 		}
 	}
 }
 
+// newTestPrompt creates a Prompt which discards its output, with a width of 80
+// and a height of 1 unless overridden by the options, and resets its state as
+// ReadLine does for the prompt "> ". Input is then processed by feedInput.
+func newTestPrompt(t *testing.T, options ...Option) *Prompt {
+	t.Helper()
+	options = append([]Option{WithOutput(io.Discard), WithSize(80, 1)}, options...)
+	p, err := New(options...)
+	require.NoError(t, err)
+	p.mu.state.Reset([]rune("> "))
+	return p
+}
+
+// feedInput processes the input s as if it had been read by ReadLine.
+func feedInput(t *testing.T, p *Prompt, s string) {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.parser.Feed([]byte(s))
+	_, err := p.processInputLocked()
+	require.NoError(t, err)
+}
+
 func TestPrompt(t *testing.T) {
 	var term *mockTerm
 	var p *Prompt
 
 	inputRE := regexp.MustCompile(`<[^>]*>`)
 	inputReplacements := map[string]string{
-		"<Control-a>":  string(rune(keyCtrlA)),
-		"<Control-b>":  string(rune(keyCtrlB)),
-		"<Control-c>":  string(rune(keyCtrlC)),
-		"<Control-d>":  string(rune(keyCtrlD)),
-		"<Control-e>":  string(rune(keyCtrlE)),
-		"<Control-f>":  string(rune(keyCtrlF)),
-		"<Control-g>":  string(rune(keyCtrlG)),
-		"<Control-h>":  string(rune(keyCtrlH)),
-		"<Control-k>":  string(rune(keyCtrlK)),
-		"<Control-l>":  string(rune(keyCtrlL)),
-		"<Control-n>":  string(rune(keyCtrlN)),
-		"<Control-p>":  string(rune(keyCtrlP)),
-		"<Control-r>":  string(rune(keyCtrlR)),
-		"<Control-s>":  string(rune(keyCtrlS)),
-		"<Control-t>":  string(rune(keyCtrlT)),
-		"<Control-u>":  string(rune(keyCtrlU)),
-		"<Control-w>":  string(rune(keyCtrlW)),
-		"<Control-y>":  string(rune(keyCtrlY)),
-		"<Meta-b>":     "\x1bb",
-		"<Meta-d>":     "\x1bd",
-		"<Meta-f>":     "\x1bf",
-		"<Meta-t>":     "\x1bt",
-		"<Meta-y>":     "\x1by",
-		"<Meta-\\>":    "\x1b\\",
-		"<Meta-Left>":  "\x1b\x1b[D",
-		"<Meta-Right>": "\x1b\x1b[C",
-		"<Meta-Enter>": "\x1b\r",
-		"<Backspace>":  "\x7f",
-		"<Delete>":     "\u001B[3~",
-		"<Down>":       "\x1b[B",
-		"<End>":        "\u001B[F",
-		"<Enter>":      "\r",
-		"<Home>":       "\u001B[H",
-		"<Left>":       "\x1b[D",
-		"<Right>":      "\x1b[C",
-		"<Space>":      " ",
-		"<Tab>":        "\t",
-		"<Up>":         "\x1b[A",
+		"<Control-a>":     string(rune(keyCtrlA)),
+		"<Control-b>":     string(rune(keyCtrlB)),
+		"<Control-c>":     string(rune(keyCtrlC)),
+		"<Control-d>":     string(rune(keyCtrlD)),
+		"<Control-e>":     string(rune(keyCtrlE)),
+		"<Control-f>":     string(rune(keyCtrlF)),
+		"<Control-g>":     string(rune(keyCtrlG)),
+		"<Control-h>":     string(rune(keyCtrlH)),
+		"<Control-k>":     string(rune(keyCtrlK)),
+		"<Control-l>":     string(rune(keyCtrlL)),
+		"<Control-n>":     string(rune(keyCtrlN)),
+		"<Control-o>":     "\x0f",
+		"<Control-p>":     string(rune(keyCtrlP)),
+		"<Control-r>":     string(rune(keyCtrlR)),
+		"<Control-s>":     string(rune(keyCtrlS)),
+		"<Control-t>":     string(rune(keyCtrlT)),
+		"<Control-u>":     string(rune(keyCtrlU)),
+		"<Control-v>":     "\x16",
+		"<Control-w>":     string(rune(keyCtrlW)),
+		"<Control-x>":     "\x18",
+		"<Control-y>":     string(rune(keyCtrlY)),
+		"<Control-_>":     "\x1f",
+		"<Meta-2>":        "\x1b2",
+		"<Meta-3>":        "\x1b3",
+		"<Meta-9>":        "\x1b9",
+		"<Meta-b>":        "\x1bb",
+		"<Meta-d>":        "\x1bd",
+		"<Meta-e>":        "\x1be",
+		"<Meta-f>":        "\x1bf",
+		"<Meta-h>":        "\x1bh",
+		"<Meta-m>":        "\x1bm",
+		"<Meta-n>":        "\x1bn",
+		"<Meta-p>":        "\x1bp",
+		"<Meta-r>":        "\x1br",
+		"<Meta-s>":        "\x1bs",
+		"<Meta-t>":        "\x1bt",
+		"<Meta-w>":        "\x1bw",
+		"<Meta-y>":        "\x1by",
+		"<Meta-\\>":       "\x1b\\",
+		"<Meta-Left>":     "\x1b\x1b[D",
+		"<Meta-Right>":    "\x1b\x1b[C",
+		"<Meta-Enter>":    "\x1b\r",
+		"<Control-Space>": "\x00",
+		"<Backspace>":     "\x7f",
+		"<Delete>":        "\u001B[3~",
+		"<Down>":          "\x1b[B",
+		"<End>":           "\u001B[F",
+		"<Enter>":         "\r",
+		"<Escape>":        "\x1b",
+		"<Home>":          "\u001B[H",
+		"<Insert>":        "\u001B[2~",
+		"<Left>":          "\x1b[D",
+		"<Right>":         "\x1b[C",
+		"<Space>":         " ",
+		"<Tab>":           "\t",
+		"<Up>":            "\x1b[A",
 	}
 	inputReplacementFunc := func(src string) string {
 		if r, ok := inputReplacements[src]; ok {
@@ -345,42 +540,287 @@ func TestPrompt(t *testing.T) {
 			func(t *testing.T, td *datadriven.TestData) string {
 				switch td.Cmd {
 				case "new-term":
-					if len(td.CmdArgs) != 2 {
-						return fmt.Sprintf("error: new-term <width> <height>\n")
+					if len(td.CmdArgs) < 2 {
+						return fmt.Sprintf("error: new-term <width> <height> [<options>...]\n")
 					}
 					var width, height int
 					td.ScanArgs(t, "width", &width)
 					td.ScanArgs(t, "height", &height)
 					term = newMockTerm(width, height)
 
-					var err error
-					p, err = New(
+					options := []Option{
 						WithOutput(term),
+						WithInteractive(true),
 						WithSize(width, height),
 						WithCompleter(completer),
 						WithInputFinished(inputFinished),
-						WithHistory(historyPath, 5))
+						WithHistory(historyPath, 5),
+					}
+					for _, arg := range td.CmdArgs {
+						switch arg.Key {
+						case "width", "height":
+						case "wide":
+							// Treat the specified code points (in hex) as occupying 2 columns.
+							wide := make(map[rune]bool)
+							for _, v := range arg.Vals {
+								r, err := strconv.ParseInt(v, 16, 32)
+								if err != nil {
+									return err.Error()
+								}
+								wide[rune(r)] = true
+							}
+							term.runeWidth = func(r rune) int {
+								if wide[r] {
+									return 2
+								}
+								return runewidth.RuneWidth(r)
+							}
+							options = append(options, WithRuneWidth(term.runeWidth))
+						case "collapse-history":
+							options = append(options, WithCollapsedHistory(true))
+						case "cursor-memory":
+							options = append(options, WithHistoryCursorMemory(true))
+						case "accept-check":
+							// Input dropping a database is refused unless confirmed via
+							// a nested prompt.
+							options = append(options, WithAcceptCheck(func(lp *LockedPrompt, line string) error {
+								if !strings.Contains(line, "drop database") {
+									return nil
+								}
+								if answer, err := lp.ReadLine("are you sure? "); err != nil || answer != "y" {
+									return fmt.Errorf("refusing to drop database")
+								}
+								return nil
+							}))
+						case "confirm-patterns":
+							options = append(options, WithConfirmPatterns([]*regexp.Regexp{
+								regexp.MustCompile(`(?i)\bdrop\s+database\b`),
+								regexp.MustCompile(`(?i)^\s*truncate\b`),
+							}, "really? [y/N] "))
+						case "bind":
+							// An optional third value is the condition guarding the binding.
+							switch len(arg.Vals) {
+							case 2:
+								options = append(options, WithBindings("bind "+arg.Vals[0]+" "+arg.Vals[1]))
+							case 3:
+								options = append(options, WithBindings("bind "+arg.Vals[0]+" "+arg.Vals[1]+" if "+arg.Vals[2]))
+							default:
+								return fmt.Sprintf("error: bind=(<key>,<command>[,<condition>])\n")
+							}
+						case "auto-indent":
+							options = append(options, WithAutoIndent(true))
+						case "smart-enter":
+							// Enter only accepts the input if the cursor is at the end.
+							options = append(options, WithInputFinishedAtCursor(func(text string, cursor int) bool {
+								return cursor == utf8.RuneCountInString(text) && inputFinished(text)
+							}))
+						case "wrap-indent":
+							options = append(options, WithWrapIndent(true))
+						case "word-wrap":
+							options = append(options, WithWordWrap(true))
+						case "insert-delete":
+							options = append(options, WithInsertDeleteChars(true))
+						case "on-accept":
+							// Lines with a leading space aren't stored in history, and
+							// other lines have their whitespace normalized.
+							options = append(options, WithOnAccept(func(line string) (bool, string) {
+								if strings.HasPrefix(line, " ") {
+									return false, ""
+								}
+								return true, strings.Join(strings.Fields(line), " ")
+							}))
+						case "history-filter":
+							// Entries consisting of "ls" commands or of only whitespace
+							// aren't stored in history.
+							options = append(options, WithHistoryFilter(func(entry string) bool {
+								return !strings.HasPrefix(entry, "ls") &&
+									strings.TrimSpace(strings.TrimSuffix(entry, ";")) != ""
+							}))
+						case "history-sanitizer":
+							// Passwords are redacted in the history file, and entries
+							// containing tokens aren't written to it.
+							passwordRE := regexp.MustCompile(`password '[^']*'`)
+							options = append(options, WithHistorySanitizer(func(entry string) (string, bool) {
+								if strings.Contains(entry, "token") {
+									return "", false
+								}
+								return passwordRE.ReplaceAllString(entry, "password '***'"), true
+							}))
+						case "history-dedup":
+							switch arg.Vals[0] {
+							case "none":
+								options = append(options, WithHistoryDedup(HistoryDedupNone))
+							case "erase-oldest":
+								options = append(options, WithHistoryDedup(HistoryDedupEraseOldest))
+							}
+						case "history-completion":
+							switch arg.Vals[0] {
+							case "words":
+								options = append(options, WithHistoryCompletion(HistoryCompletionWords))
+							case "lines":
+								options = append(options, WithHistoryCompletion(HistoryCompletionLines))
+							default:
+								return fmt.Sprintf("error: unknown history completion %q\n", arg.Vals[0])
+							}
+						case "vt52-keys":
+							// Register the VT52 cursor key sequences, which the default
+							// sequences interpret as Meta chords.
+							options = append(options, WithKeySequences(map[string]rune{
+								"\x1bA": keyUp,
+								"\x1bB": keyDown,
+								"\x1bC": keyRight,
+								"\x1bD": keyLeft,
+							}))
+						case "search-prompt":
+							// Mimic bash, including the position of the match.
+							options = append(options, WithSearchPrompt(
+								func(dir, key string, matched bool, n, total int) string {
+									name := "reverse-i-search"
+									if strings.HasPrefix(dir, "fwd") {
+										name = "i-search"
+									}
+									if strings.HasSuffix(dir, "-re") {
+										name = "regexp-" + name
+									} else if strings.HasSuffix(dir, "-fuzzy") {
+										name = "fuzzy-" + name
+									}
+									if !matched {
+										name = "failed " + name
+									}
+									return fmt.Sprintf("(%s)`%s': [%d/%d]", name, key, n, total)
+								}))
+						case "substring-search":
+							options = append(options, WithHistorySubstringSearch(true))
+						case "scoped-search":
+							options = append(options, WithScopedHistorySearch(true))
+						case "fuzzy-search":
+							options = append(options, WithHistorySearchMode(HistorySearchFuzzy))
+						case "history-expansion":
+							// Expand history references on accept, other than within
+							// single-quoted strings.
+							options = append(options, WithHistoryExpansion(true, func(text []rune, pos int) bool {
+								return strings.Count(string(text[:pos]), "'")%2 == 0
+							}))
+						case "cwd":
+							// Report a working directory and user variables.
+							const dir = "/db/my table"
+							options = append(options,
+								WithWorkingDirectory(func() string { return dir }),
+								WithUserVars(func() map[string]string {
+									return map[string]string{"db": "test", "cwd": dir}
+								}))
+						case "clipboard":
+							options = append(options, WithClipboard(true))
+						case "semantic-marks":
+							options = append(options, WithSemanticMarks(true))
+						case "exit-echo":
+							options = append(options, WithExitEcho("^C", "^D"))
+						case "render-hooks":
+							// Display a count of the characters before the cursor.
+							options = append(options, WithRenderHooks(func(_ *LockedPrompt, doc Document) Annotation {
+								if len(doc.Text) == 0 {
+									return Annotation{}
+								}
+								return Annotation{Text: fmt.Sprintf("  [%d/%d]", doc.Cursor, len(doc.Text))}
+							}, nil))
+						case "completion-status":
+							// Display key hints while completions are displayed.
+							options = append(options, WithRenderHooks(func(_ *LockedPrompt, doc Document) Annotation {
+								c := doc.Completion
+								switch {
+								case c.Listed:
+									return Annotation{Text: fmt.Sprintf("\n[C-g: dismiss · matches: %d]", c.Candidates)}
+								case c.Hint:
+									return Annotation{Text: fmt.Sprintf("\n[Tab: accept · matches: %d]", c.Candidates)}
+								}
+								return Annotation{}
+							}, nil))
+						case "word-chars":
+							options = append(options, WithWordCharacters(arg.Vals[0]))
+						case "latex":
+							// Expand \alpha, \beta, and \to to symbols when a space is typed.
+							symbols := map[string]string{`\alpha`: "α", `\beta`: "β", `\to`: "→"}
+							options = append(options, WithSelfInsert(func(doc Document, r rune) (int, string, bool) {
+								if r != ' ' {
+									return 0, "", false
+								}
+								start := doc.Cursor
+								for start > 0 && doc.Text[start-1] != '\\' && doc.Text[start-1] != ' ' {
+									start--
+								}
+								if start == 0 || doc.Text[start-1] != '\\' {
+									return 0, "", false
+								}
+								start--
+								sym, ok := symbols[string(doc.Text[start:doc.Cursor])]
+								return start, sym + " ", ok
+							}))
+						default:
+							return fmt.Sprintf("error: unknown option %q\n", arg.Key)
+						}
+					}
+
+					var err error
+					p, err = New(options...)
 					if err != nil {
 						return err.Error()
 					}
+					p.mu.state.termState.hostname = func() (string, error) {
+						return "host", nil
+					}
+					// Ignore whether the tests are running inside tmux.
+					p.mu.state.tmux = false
 					p.mu.state.screen.Reset([]rune("> "))
+					p.mu.state.active = true
 					return ""
 
 				case "input":
 					input := inputRE.ReplaceAllStringFunc(td.Input, inputReplacementFunc)
-					p.inBytes = []byte(input)
+					p.parser.Feed([]byte(input))
 					p.mu.Lock()
 					defer p.mu.Unlock()
-					for len(p.inBytes) > 0 {
+					for len(p.parser.Buffered()) > 0 {
 						if result, err := p.processInputLocked(); err != nil {
 							return err.Error()
 						} else if len(result) > 0 {
-							p.mu.state.screen.Reset([]rune("> "))
+							p.mu.state.Reset([]rune("> "))
 							p.mu.state.screen.Flush(p.out)
 						}
 					}
 					return term.String()
 
+				case "template":
+					// Start a template whose segments are the lines of the input, as
+					// ReadLineTemplate does after the prompt is displayed. Lines
+					// prefixed with "ro:" are read-only segments. A trailing "$" is
+					// stripped, allowing segments to end with spaces.
+					var segments []TemplateSegment
+					for _, line := range strings.Split(td.Input, "\n") {
+						seg := TemplateSegment{Text: strings.TrimSuffix(line, "$")}
+						if text := strings.TrimPrefix(seg.Text, "ro:"); text != seg.Text {
+							seg.Text, seg.ReadOnly = text, true
+						}
+						segments = append(segments, seg)
+					}
+					p.mu.Lock()
+					defer p.mu.Unlock()
+					p.mu.state.setTemplate(segments)
+					p.mu.state.screen.Flush(p.out)
+					return term.String()
+
+				case "osc":
+					// Display and clear the OSC sequences written to the terminal.
+					var buf strings.Builder
+					for _, osc := range term.osc {
+						fmt.Fprintln(&buf, osc)
+					}
+					term.osc = nil
+					return buf.String()
+
+				case "screen":
+					// Display the terminal, such as after input returned EOF.
+					return term.String()
+
 				case "fill":
 					var x, y, width, height int
 					td.ScanArgs(t, "x", &x)
@@ -390,6 +830,95 @@ func TestPrompt(t *testing.T) {
 					term.fill(x, y, width, height, '#')
 					return term.String()
 
+				case "write":
+					// Write output to the terminal outside of the prompt's control.
+					_, _ = term.Write([]byte(strings.ReplaceAll(td.Input, "\n", "\r\n") + "\r\n"))
+					return term.String()
+
+				case "set-prompt":
+					// A trailing space is appended to the specified prompt.
+					p.SetPrompt(td.Input + " ")
+					return term.String()
+
+				case "annotate":
+					// The annotation text is the input, which is displayed on its own
+					// line unless "inline" is specified. An empty input removes the
+					// annotation.
+					var name string
+					var priority int
+					td.ScanArgs(t, "name", &name)
+					if td.HasArg("priority") {
+						td.ScanArgs(t, "priority", &priority)
+					}
+					text := td.Input
+					if text != "" && !td.HasArg("inline") {
+						text = "\n" + text
+					}
+					p.SetAnnotation(name, Annotation{Text: text, Priority: priority})
+					return term.String()
+
+				case "apply-style":
+					// The style is specified by its SGR parameters, such as
+					// style=(1,32). No style removes the styles from the range.
+					var start, end int
+					var style string
+					td.ScanArgs(t, "start", &start)
+					td.ScanArgs(t, "end", &end)
+					for _, arg := range td.CmdArgs {
+						if arg.Key == "style" {
+							style = "\x1b[" + strings.Join(arg.Vals, ";") + "m"
+						}
+					}
+					p.ApplyStyle(start, end, Style(style))
+					return term.String() + "\n" + term.styles()
+
+				case "styles":
+					return term.styles()
+
+				case "annotate-history":
+					// Each input line is a key=value pair of the metadata attached to
+					// the last history entry.
+					meta := make(map[string]string)
+					for _, line := range strings.Split(td.Input, "\n") {
+						if i := strings.IndexByte(line, '='); i != -1 {
+							meta[line[:i]] = line[i+1:]
+						}
+					}
+					if err := p.AnnotateLastHistory(meta); err != nil {
+						return err.Error()
+					}
+					return ""
+
+				case "redraw":
+					p.Redraw()
+					return term.String()
+
+				case "hide":
+					if err := p.Hide(); err != nil {
+						return err.Error()
+					}
+					return term.String()
+
+				case "show":
+					if err := p.Show(); err != nil {
+						return err.Error()
+					}
+					return term.String()
+
+				case "resize":
+					var width, height int
+					td.ScanArgs(t, "width", &width)
+					td.ScanArgs(t, "height", &height)
+					term.resize(width, height)
+					p.SetSize(width, height)
+					return term.String()
+
+				case "close":
+					if err := p.Close(); err != nil {
+						return err.Error()
+					}
+					return ""
+
 				case "history-file-set":
 					input := td.Input
 					if len(input) > 0 {
@@ -412,3 +941,247 @@ func TestPrompt(t *testing.T) {
 			})
 	})
 }
+
+func TestCommandFinished(t *testing.T) {
+	var out strings.Builder
+	p, err := New(WithOutput(&out), WithInteractive(true), WithSemanticMarks(true))
+	require.NoError(t, err)
+
+	// Nothing is emitted if no command is running.
+	p.CommandFinished(0)
+	require.Equal(t, "", out.String())
+
+	p.mu.state.commandRunning = true
+	p.CommandFinished(2)
+	require.Equal(t, "\x1b]133;D;2\x07", out.String())
+
+	// The end of the command is only marked once.
+	p.mu.state.Reset([]rune("> "))
+	p.mu.state.screen.Flush(&out)
+	require.Equal(t, "\x1b]133;D;2\x07\x1b]133;A\x07> \x1b]133;B\x07", out.String())
+}
+
+func TestAccessors(t *testing.T) {
+	var out strings.Builder
+	p, err := New(WithOutput(&out), WithInteractive(true))
+	require.NoError(t, err)
+
+	// The accessors return zero values while ReadLine is not active.
+	require.Equal(t, "", p.LastLine())
+	require.Equal(t, "", p.CurrentText())
+	require.Equal(t, 0, p.CursorPosition())
+
+	p.mu.state.Reset([]rune("> "))
+	p.mu.state.active = true
+	p.parser.Feed([]byte("select 1\x1bb"))
+	p.mu.Lock()
+	_, err = p.processInputLocked()
+	p.mu.Unlock()
+	require.NoError(t, err)
+	require.Equal(t, "select 1", p.CurrentText())
+	require.Equal(t, 7, p.CursorPosition())
+
+	p.parser.Feed([]byte("\x05;\r"))
+	p.mu.Lock()
+	result, err := p.processInputLocked()
+	p.mu.Unlock()
+	require.NoError(t, err)
+	require.Equal(t, "select 1;", result)
+	require.Equal(t, "select 1;", p.LastLine())
+}
+
+func TestClone(t *testing.T) {
+	theme := Theme{SearchMatch: Style(attrBold)}
+	template, err := New(
+		WithOutput(ioutil.Discard), WithInteractive(true), WithTheme(theme),
+		WithBindings("bind Control-o enter"),
+		WithInputFinished(func(text string) bool {
+			return strings.HasSuffix(text, ";")
+		}))
+	require.NoError(t, err)
+
+	read := func(p *Prompt, input string) string {
+		p.mu.state.Reset([]rune("> "))
+		p.parser.Feed([]byte(input))
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		result, err := p.processInputLocked()
+		require.NoError(t, err)
+		return result
+	}
+
+	// The clone has the bindings, theme, and callbacks of the template, along
+	// with the options passed to Clone, which take precedence.
+	var out strings.Builder
+	p, err := template.Clone(WithOutput(&out), WithBindings("bind Control-t cancel"))
+	require.NoError(t, err)
+	require.Equal(t, Style(attrBold), p.mu.state.theme.SearchMatch)
+	require.Equal(t, "select\n1;", read(p, "select\x0f1;\r"))
+	require.Equal(t, "select 1;", read(p, "x\x14select 1;\r"))
+	require.Contains(t, out.String(), "select 1;")
+
+	// The clone shares no state with the template, or with other clones.
+	require.Equal(t, "select 1;", p.LastLine())
+	require.Equal(t, "", template.LastLine())
+	q, err := template.Clone()
+	require.NoError(t, err)
+	require.Equal(t, "", q.LastLine())
+	require.Equal(t, "a\nb;", read(q, "a\x0fb;\r"))
+	require.Equal(t, "xy;", read(q, "x\x14y;\r"))
+}
+
+func TestTerminfo(t *testing.T) {
+	for _, name := range []string{"TERM", "TERMINFO"} {
+		defer func(name, v string) { _ = os.Setenv(name, v) }(name, os.Getenv(name))
+	}
+	require.NoError(t, os.Setenv("TERM", "prompt-test"))
+	require.NoError(t, os.Setenv("TERMINFO", "keys/testdata/terminfo"))
+	parse := func(p *Prompt, seq string) rune {
+		key, _ := p.keyTable.Parse([]byte(seq))
+		return key
+	}
+
+	// The sequences of WithKeySequences take precedence over those of the
+	// terminfo entry.
+	p, err := New(WithOutput(io.Discard), WithTerminfo(true),
+		WithKeySequences(map[string]rune{"\x1bA": keys.Down}))
+	require.NoError(t, err)
+	require.Equal(t, rune(keys.Down), parse(p, "\x1bA"))
+	require.Equal(t, rune(keys.Home), parse(p, "\x1bH"))
+
+	// A clone reuses the sequences of the terminfo entry, which is not read
+	// again.
+	require.NoError(t, os.Setenv("TERMINFO", t.TempDir()))
+	q, err := p.Clone(WithKeySequences(map[string]rune{"\x1bA": keys.Up}))
+	require.NoError(t, err)
+	require.Equal(t, rune(keys.Up), parse(q, "\x1bA"))
+	require.Equal(t, rune(keys.Home), parse(q, "\x1bH"))
+
+	// A missing entry is not an error, unlike a malformed entry.
+	p, err = New(WithOutput(io.Discard), WithTerminfo(true))
+	require.NoError(t, err)
+	require.Nil(t, p.keyTable)
+	dir := filepath.Join(os.Getenv("TERMINFO"), "p")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "prompt-test"), []byte("malformed"), 0644))
+	_, err = New(WithOutput(io.Discard), WithTerminfo(true))
+	require.Error(t, err)
+}
+
+func TestKeyTimeout(t *testing.T) {
+	// An escape followed by "b" is Meta-b (backward-word), unless the "b"
+	// arrives after the key timeout has expired.
+	for _, c := range []struct {
+		timeout  time.Duration
+		expected string
+	}{
+		{0, "xab"},
+		{10 * time.Millisecond, "abbx"},
+	} {
+		t.Run(c.timeout.String(), func(t *testing.T) {
+			r, w := io.Pipe()
+			p, err := New(WithInput(r), WithOutput(ioutil.Discard), WithInteractive(true),
+				WithSize(80, 24), WithKeyTimeout(c.timeout))
+			require.NoError(t, err)
+			go func() {
+				fmt.Fprint(w, "ab\x1b")
+				time.Sleep(100 * time.Millisecond)
+				fmt.Fprint(w, "bx\r")
+			}()
+			line, err := p.ReadLine("> ")
+			require.NoError(t, err)
+			require.Equal(t, c.expected, line)
+		})
+	}
+}
+
+func TestAccessorsDuringCallback(t *testing.T) {
+	// The accessors may be called by other goroutines while a callback invoked
+	// by ReadLine is running, and wait for the callback to return. Run with
+	// -race to detect unsynchronized access.
+	r, w := io.Pipe()
+	called := make(chan struct{})
+	p, err := New(WithInput(r), WithOutput(ioutil.Discard), WithInteractive(true),
+		WithSize(80, 24),
+		WithInputFinished(func(text string) bool {
+			if text == "select 1;" {
+				close(called)
+				time.Sleep(10 * time.Millisecond)
+			}
+			return strings.HasSuffix(text, ";")
+		}))
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-called
+		p.SetPrompt("$ ")
+		_ = p.CurrentText()
+		_ = p.CursorPosition()
+		_ = p.LastLine()
+	}()
+	go func() {
+		fmt.Fprint(w, "select 1;\r")
+	}()
+	line, err := p.ReadLine("> ")
+	require.NoError(t, err)
+	require.Equal(t, "select 1;", line)
+	<-done
+}
+
+func TestConcurrentPrompts(t *testing.T) {
+	// Serve many prompts concurrently, as for one prompt per SSH connection,
+	// resizing each while it is reading input.
+	const numPrompts = 200
+	const numLines = 20
+	if testing.Short() {
+		t.Skip("short")
+	}
+
+	errs := make(chan error, numPrompts)
+	for i := 0; i < numPrompts; i++ {
+		go func(i int) {
+			errs <- func() error {
+				r, w := io.Pipe()
+				p, err := New(
+					WithInput(r), WithOutput(ioutil.Discard), WithInteractive(true),
+					WithSize(80, 24), WithHistory("", 100),
+					WithInputFinished(func(text string) bool {
+						return strings.HasSuffix(text, ";")
+					}))
+				if err != nil {
+					return err
+				}
+
+				go func() {
+					for j := 0; j < numLines; j++ {
+						p.SetSize(20+(i+j)%60, 5+j%20)
+						fmt.Fprintf(w, "select %d,\r%d;\r", i, j)
+					}
+					w.Close()
+				}()
+
+				for j := 0; ; j++ {
+					line, err := p.ReadLine(fmt.Sprintf("%d> ", i))
+					if err == io.EOF && j == numLines {
+						break
+					}
+					if err != nil {
+						return err
+					}
+					if expected := fmt.Sprintf("select %d,\n%d;", i, j); line != expected {
+						return fmt.Errorf("expected %q, but found %q", expected, line)
+					}
+					if last := p.LastLine(); last != line {
+						return fmt.Errorf("expected last line %q, but found %q", line, last)
+					}
+				}
+				return nil
+			}()
+		}(i)
+	}
+	for i := 0; i < numPrompts; i++ {
+		require.NoError(t, <-errs)
+	}
+}
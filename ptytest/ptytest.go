@@ -0,0 +1,225 @@
+// Package ptytest runs a command under a pseudo-terminal for integration tests
+// of programs which read input using a Prompt. Unlike the WithScript option,
+// which feeds keystrokes to a Prompt within the same process, the command
+// reads from a real terminal in raw mode, so tests exercise terminal handling
+// such as the switch to and from raw mode and the querying of the terminal
+// size. The output of the command is rendered to an emulated screen which can
+// be inspected by the test.
+//
+// The command is usually the test binary itself, re-executed to run the
+// program's REPL in place of the tests:
+//
+//	func TestMain(m *testing.M) {
+//		ptytest.RunMain("repl", runREPL)
+//		os.Exit(m.Run())
+//	}
+//
+//	func TestREPL(t *testing.T) {
+//		term, err := ptytest.Start(ptytest.MainCommand("repl"), 80, 24)
+//		...
+//		term.Type("select 1;<Enter>")
+//		term.WaitFor("1 row", time.Second)
+//	}
+package ptytest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/petermattis/prompt"
+	"github.com/petermattis/prompt/keys"
+)
+
+// envMain is the environment variable naming the main function to run by
+// RunMain.
+const envMain = "PTYTEST_MAIN"
+
+// RunMain runs fn and exits if the process was started by a command returned by
+// MainCommand with the specified name. Otherwise it returns immediately. It is
+// intended to be called from TestMain, before running the tests.
+func RunMain(name string, fn func()) {
+	if os.Getenv(envMain) != name {
+		return
+	}
+	fn()
+	os.Exit(0)
+}
+
+// MainCommand returns a command which re-executes the running test binary to
+// run the function registered with RunMain under the specified name, rather
+// than the tests.
+func MainCommand(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^$"}, args...)...)
+	cmd.Env = append(os.Environ(), envMain+"="+name)
+	return cmd
+}
+
+// Term is a command running under a pseudo-terminal, whose output is rendered
+// to an emulated screen.
+type Term struct {
+	cmd  *exec.Cmd
+	ptmx *os.File
+	// KeyDelay is the delay between the keys typed by Type, which allows
+	// exercising the handling of input arriving a key at a time as it does when
+	// typed by a user. If zero, the keys are written at once.
+	KeyDelay time.Duration
+
+	mu struct {
+		sync.Mutex
+		changed *sync.Cond
+		screen  *Screen
+		// done is true once the output of the command has been read.
+		done bool
+	}
+}
+
+// Start starts cmd under a pseudo-terminal of the specified size.
+func Start(cmd *exec.Cmd, width, height int) (*Term, error) {
+	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Cols: uint16(width), Rows: uint16(height)})
+	if err != nil {
+		return nil, err
+	}
+	t := &Term{cmd: cmd, ptmx: ptmx}
+	t.mu.changed = sync.NewCond(&t.mu.Mutex)
+	t.mu.screen = NewScreen(width, height)
+	go t.readOutput()
+	return t, nil
+}
+
+// readOutput renders the output of the command to the screen until the
+// pseudo-terminal is closed.
+func (t *Term) readOutput() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := t.ptmx.Read(buf)
+		t.mu.Lock()
+		if n > 0 {
+			_, _ = t.mu.screen.Write(buf[:n])
+		}
+		if err != nil {
+			// Reads fail with EIO rather than EOF once the command exits.
+			t.mu.done = true
+		}
+		t.mu.changed.Broadcast()
+		t.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Type writes the keystrokes in script, which uses the notation of
+// prompt.ParseKeys, to the terminal. If KeyDelay is non-zero, each key is
+// written separately followed by the delay.
+func (t *Term) Type(script string) error {
+	input, err := prompt.ParseKeys(script)
+	if err != nil {
+		return err
+	}
+	if t.KeyDelay == 0 {
+		_, err := t.ptmx.Write(input)
+		return err
+	}
+	for len(input) > 0 {
+		n := len(input)
+		if _, rest := keys.Parse(input); len(rest) < n {
+			n -= len(rest)
+		}
+		if _, err := t.ptmx.Write(input[:n]); err != nil {
+			return err
+		}
+		input = input[n:]
+		time.Sleep(t.KeyDelay)
+	}
+	return nil
+}
+
+// Resize changes the size of the terminal, which clears the emulated screen.
+func (t *Term) Resize(width, height int) error {
+	t.mu.Lock()
+	t.mu.screen = NewScreen(width, height)
+	t.mu.Unlock()
+	return pty.Setsize(t.ptmx, &pty.Winsize{Cols: uint16(width), Rows: uint16(height)})
+}
+
+// Screen returns the text of the emulated screen. See Screen.String.
+func (t *Term) Screen() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mu.screen.String()
+}
+
+// Cursor returns the position of the cursor on the emulated screen.
+func (t *Term) Cursor() (x, y int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mu.screen.Cursor()
+}
+
+// WaitFor waits until the emulated screen contains text, returning an error
+// including the contents of the screen if it does not within the timeout or
+// the command exits first.
+func (t *Term) WaitFor(text string, timeout time.Duration) error {
+	return t.WaitUntil(func(s *Screen) bool {
+		return strings.Contains(s.String(), text)
+	}, timeout)
+}
+
+// WaitUntil waits until fn returns true for the emulated screen, returning an
+// error including the contents of the screen if it does not within the timeout
+// or the command exits first. The output of the command is not processed while
+// fn is running, and the screen must not be retained by fn.
+func (t *Term) WaitUntil(fn func(s *Screen) bool, timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.mu.changed.Broadcast()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(timeout)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for {
+		if fn(t.mu.screen) {
+			return nil
+		}
+		screen := t.mu.screen.String()
+		if t.mu.done {
+			return fmt.Errorf("command exited, screen:\n%s", screen)
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("timed out after %s, screen:\n%s", timeout, screen)
+		}
+		t.mu.changed.Wait()
+	}
+}
+
+// Wait waits for the command to exit, after which the screen holds all of its
+// output, and closes the terminal.
+func (t *Term) Wait() error {
+	err := t.cmd.Wait()
+	t.mu.Lock()
+	for !t.mu.done {
+		t.mu.changed.Wait()
+	}
+	t.mu.Unlock()
+	if cerr := t.ptmx.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Close kills the command if it is still running and closes the terminal.
+func (t *Term) Close() error {
+	if t.cmd.ProcessState == nil {
+		_ = t.cmd.Process.Kill()
+		_ = t.cmd.Wait()
+	}
+	return t.ptmx.Close()
+}
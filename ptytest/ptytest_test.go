@@ -0,0 +1,102 @@
+package ptytest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petermattis/prompt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	RunMain("echo", echoREPL)
+	os.Exit(m.Run())
+}
+
+// echoREPL reads lines using a Prompt until EOF, echoing each line.
+func echoREPL() {
+	p, err := prompt.New()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer p.Close()
+	for {
+		line, err := p.ReadLine("> ")
+		if err == io.EOF {
+			fmt.Println("bye")
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("echo: %s\n", strings.ToUpper(line))
+	}
+}
+
+func TestTerm(t *testing.T) {
+	term, err := Start(MainCommand("echo"), 40, 6)
+	if err != nil {
+		t.Skipf("unable to start pty: %v", err)
+	}
+	defer term.Close()
+
+	require.NoError(t, term.WaitFor(">", 5*time.Second))
+	require.NoError(t, term.Type("hello<Control-a>x<Enter>"))
+	// Wait for the next prompt, as keys typed before the terminal is switched
+	// back to raw mode are echoed by the terminal.
+	require.NoError(t, term.WaitFor("echo: XHELLO\n>", 5*time.Second))
+
+	// Typing a key at a time exercises the handling of escape sequences split
+	// across reads.
+	term.KeyDelay = time.Millisecond
+	require.NoError(t, term.Type("world<Left><Left><Backspace><Meta-b>Y"))
+	// The cursor is moved after the text is output, so wait for both.
+	require.NoError(t, term.WaitUntil(func(s *Screen) bool {
+		x, y := s.Cursor()
+		return strings.Contains(s.String(), "> Ywold") && x == 3 && y == 2
+	}, 5*time.Second))
+
+	require.NoError(t, term.Type("<Enter><Control-d>"))
+	require.NoError(t, term.Wait())
+	require.Equal(t, `> xhello
+echo: XHELLO
+> Ywold
+echo: YWOLD
+> bye`, term.Screen())
+}
+
+func TestScreen(t *testing.T) {
+	s := NewScreen(10, 3)
+	write := func(str string) {
+		_, err := s.Write([]byte(str))
+		require.NoError(t, err)
+	}
+
+	// Output wraps at the right margin, and scrolls at the bottom.
+	write("0123456789abc\r\nline")
+	require.Equal(t, []string{"0123456789", "abc", "line"}, s.Lines())
+	write("\r\nnext")
+	require.Equal(t, []string{"abc", "line", "next"}, s.Lines())
+
+	// Control sequences may be split across writes.
+	write("\x1b[")
+	write("2D\x1b[K\x1b[1;31mX\x1b[m\x1b]133;A\x07")
+	require.Equal(t, "abc\nline\nneX", s.String())
+	write("\x1b[2;2H\x1b[2@")
+	require.Equal(t, "abc\nl  ine\nneX", s.String())
+	write("\x1b[3P\x1b[1A\x1b[J")
+	require.Equal(t, "a", s.String())
+
+	// Wide characters occupy two columns.
+	write("\r世界\x1b[G")
+	require.Equal(t, "世界", s.String())
+	x, y := s.Cursor()
+	require.Equal(t, 0, x)
+	require.Equal(t, 0, y)
+}
@@ -0,0 +1,276 @@
+package ptytest
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Screen emulates the display of a terminal, supporting the subset of the
+// VT100/xterm control sequences written by Prompt: cursor movement, erasure,
+// insertion and deletion of characters, and automatic wrapping at the right
+// margin. Other control sequences, such as those setting attributes or modes,
+// are ignored.
+type Screen struct {
+	width, height int
+	cells         [][]rune
+	x, y          int
+	// wrapPending is true if the cursor is at the right margin following the
+	// output of a character in the last column, in which case the next
+	// character is output at the start of the next line.
+	wrapPending bool
+	// partial holds an incomplete control sequence or UTF-8 encoded character
+	// at the end of the last write.
+	partial []byte
+}
+
+// NewScreen returns a blank screen with the specified dimensions.
+func NewScreen(width, height int) *Screen {
+	s := &Screen{width: width, height: height}
+	s.cells = make([][]rune, height)
+	for y := range s.cells {
+		s.cells[y] = make([]rune, width)
+	}
+	return s
+}
+
+// Write updates the screen with the output in p.
+func (s *Screen) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(s.partial) > 0 {
+		p = append(s.partial, p...)
+		s.partial = nil
+	}
+	for len(p) > 0 {
+		switch p[0] {
+		case '\x1b':
+			l := s.escape(p)
+			if l == 0 {
+				s.partial = append([]byte(nil), p...)
+				return n, nil
+			}
+			p = p[l:]
+			continue
+		case '\r':
+			s.moveTo(0, s.y)
+		case '\n':
+			s.lineFeed()
+		case '\b':
+			s.moveTo(s.x-1, s.y)
+		case '\a':
+		default:
+			if !utf8.FullRune(p) {
+				s.partial = append([]byte(nil), p...)
+				return n, nil
+			}
+			r, l := utf8.DecodeRune(p)
+			s.put(r)
+			p = p[l:]
+			continue
+		}
+		p = p[1:]
+	}
+	return n, nil
+}
+
+// Lines returns the text of each line of the screen, with trailing whitespace
+// removed.
+func (s *Screen) Lines() []string {
+	lines := make([]string, s.height)
+	for y, line := range s.cells {
+		var buf strings.Builder
+		for _, r := range line {
+			switch r {
+			case 0:
+				buf.WriteByte(' ')
+			case -1:
+				// The second column of a wide character.
+			default:
+				buf.WriteRune(r)
+			}
+		}
+		lines[y] = strings.TrimRight(buf.String(), " ")
+	}
+	return lines
+}
+
+// String returns the text of the screen, omitting trailing blank lines.
+func (s *Screen) String() string {
+	lines := s.Lines()
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Cursor returns the position of the cursor.
+func (s *Screen) Cursor() (x, y int) {
+	return s.x, s.y
+}
+
+// escape processes the control sequence at the start of p, returning its
+// length, or 0 if the sequence is incomplete.
+func (s *Screen) escape(p []byte) int {
+	if len(p) < 2 {
+		return 0
+	}
+	switch p[1] {
+	case '[':
+		// CSI: parameter bytes followed by a final byte.
+		i := 2
+		for i < len(p) && (p[i] < 0x40 || p[i] > 0x7e) {
+			i++
+		}
+		if i == len(p) {
+			return 0
+		}
+		s.csi(string(p[2:i]), p[i])
+		return i + 1
+	case ']':
+		// OSC: terminated by BEL or ST.
+		for i := 2; i < len(p); i++ {
+			if p[i] == '\a' {
+				return i + 1
+			}
+			if p[i] == '\x1b' && i+1 < len(p) && p[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return 0
+	}
+	return 2
+}
+
+// csi performs the CSI control sequence with the specified parameters and
+// final byte.
+func (s *Screen) csi(params string, final byte) {
+	if strings.HasPrefix(params, "?") {
+		// Private modes such as bracketed paste.
+		return
+	}
+	var args []int
+	for _, p := range strings.Split(params, ";") {
+		n, _ := strconv.Atoi(p)
+		args = append(args, n)
+	}
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] != 0 {
+			return args[i]
+		}
+		return def
+	}
+
+	switch final {
+	case 'A':
+		s.moveTo(s.x, s.y-arg(0, 1))
+	case 'B':
+		s.moveTo(s.x, s.y+arg(0, 1))
+	case 'C':
+		s.moveTo(s.x+arg(0, 1), s.y)
+	case 'D':
+		s.moveTo(s.x-arg(0, 1), s.y)
+	case 'G':
+		s.moveTo(arg(0, 1)-1, s.y)
+	case 'H':
+		s.moveTo(arg(1, 1)-1, arg(0, 1)-1)
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			s.fill(s.x, s.y, s.width, s.y+1)
+			s.fill(0, s.y+1, s.width, s.height)
+		case 1:
+			s.fill(0, 0, s.width, s.y)
+			s.fill(0, s.y, s.x+1, s.y+1)
+		case 2:
+			s.fill(0, 0, s.width, s.height)
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			s.fill(s.x, s.y, s.width, s.y+1)
+		case 1:
+			s.fill(0, s.y, s.x+1, s.y+1)
+		case 2:
+			s.fill(0, s.y, s.width, s.y+1)
+		}
+	case '@':
+		line := s.cells[s.y][s.x:]
+		n := min(arg(0, 1), len(line))
+		copy(line[n:], line)
+		s.fill(s.x, s.y, s.x+n, s.y+1)
+	case 'P':
+		line := s.cells[s.y][s.x:]
+		n := min(arg(0, 1), len(line))
+		copy(line, line[n:])
+		s.fill(s.width-n, s.y, s.width, s.y+1)
+	}
+}
+
+// put outputs r at the cursor.
+func (s *Screen) put(r rune) {
+	w := runewidth.RuneWidth(r)
+	if w == 0 {
+		return
+	}
+	if s.wrapPending || s.x+w > s.width {
+		s.x = 0
+		s.lineFeed()
+	}
+	s.cells[s.y][s.x] = r
+	if w == 2 {
+		s.cells[s.y][s.x+1] = -1
+	}
+	if s.x+w < s.width {
+		s.x += w
+	} else {
+		s.x = s.width - 1
+		s.wrapPending = true
+	}
+}
+
+// lineFeed moves the cursor down a line, scrolling the screen if the cursor is
+// on the last line.
+func (s *Screen) lineFeed() {
+	s.wrapPending = false
+	if s.y+1 < s.height {
+		s.y++
+		return
+	}
+	first := s.cells[0]
+	copy(s.cells, s.cells[1:])
+	for i := range first {
+		first[i] = 0
+	}
+	s.cells[s.height-1] = first
+}
+
+func (s *Screen) moveTo(x, y int) {
+	s.x = max(0, min(x, s.width-1))
+	s.y = max(0, min(y, s.height-1))
+	s.wrapPending = false
+}
+
+// fill erases the cells in the columns [x0,x1) of the lines [y0,y1).
+func (s *Screen) fill(x0, y0, x1, y1 int) {
+	for y := y0; y < y1 && y < s.height; y++ {
+		for x := x0; x < x1 && x < s.width; x++ {
+			s.cells[y][x] = 0
+		}
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,134 @@
+package prompt
+
+import "strings"
+
+// The rectangle commands operate on the rectangular region of a multi-line
+// input whose corners are the mark (see set-mark) and the cursor, which is
+// useful for editing aligned lists of values or columns. The columns of the
+// rectangle are counted in characters from the start of each line of the
+// input text.
+
+// lineStart returns the position of the start of the line containing pos.
+func lineStart(text []rune, pos int) int {
+	for pos > 0 && text[pos-1] != '\n' {
+		pos--
+	}
+	return pos
+}
+
+// lineEnd returns the position of the end of the line containing pos.
+func lineEnd(text []rune, pos int) int {
+	for pos < len(text) && text[pos] != '\n' {
+		pos++
+	}
+	return pos
+}
+
+// killRectangle deletes the rectangle with corners at the mark and the cursor,
+// saving it to be inserted by yankRectangle. Lines of the rectangle which are
+// shorter than its width are padded with spaces. The cursor is moved to the
+// top left corner of the rectangle. The terminal bell is rung if the mark is
+// not set.
+func killRectangle(s *state) {
+	if s.mark < 0 {
+		s.screen.outbuf.WriteRune(keyCtrlG) // ctrl-G == bell/beep
+		return
+	}
+	text := s.screen.Text()
+	top, bottom := s.mark, s.screen.Position()
+	if top > bottom {
+		top, bottom = bottom, top
+	}
+	startCol, endCol := top-lineStart(text, top), bottom-lineStart(text, bottom)
+	if startCol > endCol {
+		startCol, endCol = endCol, startCol
+	}
+
+	// Collect the lines spanned by the rectangle.
+	var lines [][2]int
+	for pos := lineStart(text, top); ; pos++ {
+		end := lineEnd(text, pos)
+		lines = append(lines, [2]int{pos, end})
+		pos = end
+		if pos >= bottom {
+			break
+		}
+	}
+
+	// Delete the rectangle from the last line to the first so that the
+	// positions of the preceding lines are unaffected.
+	rect := make([]string, len(lines))
+	for i := len(lines) - 1; i >= 0; i-- {
+		start, end := lines[i][0]+startCol, lines[i][0]+endCol
+		if start > lines[i][1] {
+			start = lines[i][1]
+		}
+		if end > lines[i][1] {
+			end = lines[i][1]
+		}
+		s.screen.MoveTo(start)
+		erased := s.screen.EraseTo(end)
+		rect[i] = erased + strings.Repeat(" ", endCol-startCol-len([]rune(erased)))
+	}
+	s.rectangle = rect
+}
+
+// yankRectangle inserts the rectangle deleted by killRectangle with its top
+// left corner at the cursor. Each line of the rectangle is inserted at the
+// column of the cursor in successive lines of the input text. Lines shorter
+// than the column are padded with spaces, and lines are added to the end of
+// the input text as necessary. The cursor is moved to the end of the last line
+// inserted.
+func yankRectangle(s *state) {
+	if len(s.rectangle) == 0 {
+		return
+	}
+	pos := s.screen.Position()
+	col := pos - lineStart(s.screen.Text(), pos)
+	for i, line := range s.rectangle {
+		if i > 0 {
+			// Move to the column of the next line, adding a line if necessary.
+			text := s.screen.Text()
+			end := lineEnd(text, pos)
+			if end == len(text) {
+				s.screen.MoveTo(end)
+				s.screen.Insert('\n')
+				text = s.screen.Text()
+			}
+			start := end + 1
+			pos = start + col
+			if end := lineEnd(text, start); pos > end {
+				s.screen.MoveTo(end)
+				s.screen.Insert([]rune(strings.Repeat(" ", pos-end))...)
+			}
+		}
+		s.screen.MoveTo(pos)
+		s.screen.Insert([]rune(line)...)
+		pos = s.screen.Position()
+	}
+}
+
+// adjustMark adjusts the position of the mark after the input text was changed
+// from before to after. The mark is moved to the start of the change if the
+// text around it was modified.
+func adjustMark(mark int, before, after []rune) int {
+	limit := len(before)
+	if limit > len(after) {
+		limit = len(after)
+	}
+	var prefix, suffix int
+	for prefix < limit && before[prefix] == after[prefix] {
+		prefix++
+	}
+	for suffix < limit-prefix && before[len(before)-suffix-1] == after[len(after)-suffix-1] {
+		suffix++
+	}
+	switch {
+	case mark <= prefix:
+		return mark
+	case mark >= len(before)-suffix:
+		return mark + len(after) - len(before)
+	default:
+		return prefix
+	}
+}
@@ -0,0 +1,28 @@
+package prompt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdjustMark(t *testing.T) {
+	testCases := []struct {
+		mark          int
+		before, after string
+		expected      int
+	}{
+		{2, "abcd", "xabcd", 3},
+		{2, "abcd", "abxcd", 2},
+		{2, "abcd", "abcxd", 2},
+		{2, "abcd", "bcd", 1},
+		{2, "abcd", "abd", 2},
+		{2, "abcd", "ad", 1},
+		{2, "abcd", "axyd", 1},
+		{2, "abcd", "", 0},
+	}
+	for _, c := range testCases {
+		require.Equal(t, c.expected, adjustMark(c.mark, []rune(c.before), []rune(c.after)),
+			"%d %q -> %q", c.mark, c.before, c.after)
+	}
+}
@@ -0,0 +1,126 @@
+package prompt
+
+import (
+	"time"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// RenderStyled renders text with the styles of spans applied, for display of
+// read-only blocks of styled text such as help screens or the headers of query
+// results. The positions of the spans are rune offsets within text. The text
+// is wrapped at width columns using the same rules as the input text, with
+// the number of columns a rune occupies determined by runeWidth (if nil,
+// runewidth.RuneWidth is used). Newlines are rendered as "\r\n", preceded by
+// a sequence erasing the remainder of the row, so that the text is displayed
+// correctly whether or not the terminal is in raw mode. A width less than 1
+// disables wrapping.
+//
+// See Prompt.RenderStyled for rendering text using the width and rune width
+// configuration of a Prompt.
+func RenderStyled(text string, spans []Span, width int, runeWidth func(r rune) int) string {
+	if runeWidth == nil {
+		runeWidth = runewidth.RuneWidth
+	}
+	if width < 1 {
+		width = maxRenderWidth
+	}
+
+	var s screen
+	s.Init()
+	s.width = width
+	s.runeWidth = runeWidth
+	s.text = []rune(text)
+	for _, span := range spans {
+		if span.Start < 0 {
+			span.Start = 0
+		}
+		if span.End > len(s.text) {
+			span.End = len(s.text)
+		}
+		if span.Start < span.End && span.Style != "" {
+			s.attrs.Add(attrInfo{startPos: span.Start, endPos: span.End, value: string(span.Style)})
+		}
+	}
+	s.renderText(len(s.text))
+	return s.outbuf.String()
+}
+
+// maxRenderWidth is the width used by RenderStyled when wrapping is disabled.
+const maxRenderWidth = 1 << 30
+
+// RenderStyled renders text with the styles of spans applied using the
+// current terminal width and the rune width configured by WithRuneWidth. See
+// the RenderStyled function. The rendered text can be displayed without
+// disturbing the prompt using Exclusive.
+func (p *Prompt) RenderStyled(text string, spans []Span) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := &p.mu.state.screen
+	return RenderStyled(text, spans, s.width, s.runeWidth)
+}
+
+// PreRenderFunc is invoked immediately before the prompt and input text are
+// written to the terminal, with the Document holding the input text, cursor
+// position, and state of completion. It returns a decoration, such as an
+// elapsed time, a character count, or key hints for the displayed completions,
+// which is displayed after the input text as an annotation, replacing the
+// decoration returned by the previous invocation. An empty Annotation displays
+// no decoration. See WithRenderHooks.
+type PreRenderFunc func(lp *LockedPrompt, doc Document) Annotation
+
+// PostRenderFunc is invoked immediately after the prompt and input text are
+// written to the terminal, with the Document holding the input text and cursor
+// position and a description of the render. See WithRenderHooks.
+type PostRenderFunc func(lp *LockedPrompt, doc Document, info RenderInfo)
+
+// RenderInfo describes a write of the prompt and input text to the terminal.
+type RenderInfo struct {
+	// Bytes is the number of bytes written.
+	Bytes int
+	// Latency is the time from the start of processing the input which
+	// triggered the render until the output was written. For renders not
+	// triggered by input, such as those performed by Redraw or SetAnnotation,
+	// it is measured from the start of the render.
+	Latency time.Duration
+}
+
+// flushLocked writes the buffered rendering commands of the active ReadLine to
+// the terminal, invoking the render hooks configured by WithRenderHooks. The
+// hooks are invoked with the mutex held, and may call methods of the
+// LockedPrompt such as SetAnnotation; the flushes performed by such calls do
+// not invoke the hooks again.
+func (p *Prompt) flushLocked() {
+	s := &p.mu.state
+	if !s.active || p.mu.rendering || (p.preRender == nil && p.postRender == nil) {
+		s.screen.Flush(p.out)
+		return
+	}
+
+	start := p.mu.inputStart
+	if start.IsZero() {
+		start = time.Now()
+	}
+	p.mu.rendering = true
+	defer func() {
+		p.mu.rendering = false
+	}()
+
+	p.withLocked(func(lp *LockedPrompt) {
+		if p.preRender != nil {
+			st := undoSnapshot(s)
+			doc := Document{Text: st.text, Cursor: st.pos, Completion: s.completer.State()}
+			s.annotations.Set(&s.screen, annotationRender, p.preRender(lp, doc))
+		}
+		n := s.screen.outbuf.Len()
+		s.screen.Flush(p.out)
+		if p.postRender != nil {
+			st := undoSnapshot(s)
+			doc := Document{Text: st.text, Cursor: st.pos, Completion: s.completer.State()}
+			p.postRender(lp, doc, RenderInfo{
+				Bytes:   n,
+				Latency: time.Since(start),
+			})
+		}
+	})
+}
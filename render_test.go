@@ -0,0 +1,73 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderStyled(t *testing.T) {
+	const (
+		bold = Style(attrBold)
+		red  = Style(fgRed)
+	)
+	testCases := []struct {
+		text     string
+		spans    []Span
+		width    int
+		expected string
+	}{
+		{"hello", nil, 80, "hello"},
+		{"hello world", []Span{{Start: 0, End: 5, Style: bold}}, 80, "\x1b[1mhello\x1b[0m world"},
+		// Overlapping spans are reset and re-applied at the end of the inner span.
+		{"abcdef", []Span{{Start: 0, End: 6, Style: bold}, {Start: 2, End: 4, Style: red}}, 80,
+			"\x1b[1mab\x1b[91mcd\x1b[0m\x1b[1mef\x1b[0m"},
+		// Spans are clamped to the text.
+		{"abc", []Span{{Start: -1, End: 10, Style: bold}}, 80, "\x1b[1mabc\x1b[0m"},
+		{"ab\ncd", nil, 80, "ab\x1b[K\r\ncd"},
+		// Text is wrapped at the width, with styles continuing across rows.
+		{"abcdefg", []Span{{Start: 2, End: 5, Style: bold}}, 3, "ab\x1b[1mc\r\nde\x1b[0mf\r\ng"},
+		// Wide characters are not split across rows.
+		{"ab日本", nil, 3, "ab\x1b[K\r\n日\x1b[K\r\n本"},
+		// A width less than 1 disables wrapping.
+		{"abcdefg", nil, 0, "abcdefg"},
+	}
+	for _, c := range testCases {
+		require.Equal(t, c.expected, RenderStyled(c.text, c.spans, c.width, nil), "%q", c.text)
+	}
+}
+
+func TestRenderHooks(t *testing.T) {
+	var out strings.Builder
+	var p *Prompt
+	var docs []Document
+	var infos []RenderInfo
+	p, err := New(WithOutput(&out), WithInteractive(true), WithRenderHooks(nil,
+		func(lp *LockedPrompt, doc Document, info RenderInfo) {
+			docs = append(docs, doc)
+			infos = append(infos, info)
+			// Flushes performed by the hooks do not invoke the hooks again.
+			lp.SetAnnotation("renders", Annotation{Text: fmt.Sprintf(" (%d)", len(docs))})
+		}))
+	require.NoError(t, err)
+
+	p.mu.state.Reset([]rune("> "))
+	p.mu.state.active = true
+	p.parser.Feed([]byte("ab\x02"))
+	p.mu.Lock()
+	_, err = p.processInputLocked()
+	p.mu.Unlock()
+	require.NoError(t, err)
+	p.Redraw()
+
+	require.Equal(t, []Document{
+		{Text: []rune("ab"), Cursor: 1},
+		{Text: []rune("ab"), Cursor: 1},
+	}, docs)
+	require.Len(t, infos, 2)
+	require.Less(t, 0, infos[0].Bytes)
+	require.Less(t, int64(0), int64(infos[0].Latency))
+	require.Equal(t, " (2)", string(p.mu.state.screen.suffix))
+}
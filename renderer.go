@@ -0,0 +1,117 @@
+package prompt
+
+import (
+	"io"
+	"strconv"
+)
+
+// Renderer generates the control sequences used to update the display of the
+// prompt and input text on the terminal. The screen tracks the contents of the
+// display and the position of the cursor, and uses the Renderer to move the
+// cursor and to erase portions of the display, writing the generated sequences
+// to w, which buffers the output sent to the terminal. The default Renderer
+// generates ANSI escape sequences. See WithRenderer.
+type Renderer interface {
+	// MoveCursor moves the cursor from column fromX of row fromY to column toX
+	// of row toY. Columns and rows are 0-indexed, with rows relative to the
+	// first row of the prompt.
+	MoveCursor(w io.Writer, fromX, fromY, toX, toY int)
+	// EraseLineToRight erases the row from the cursor to the last column.
+	EraseLineToRight(w io.Writer)
+	// EraseBelow erases the screen from the cursor to the end of the screen.
+	EraseBelow(w io.Writer)
+	// EraseScreen moves the cursor to the top left of the screen and erases
+	// the contents of the screen.
+	EraseScreen(w io.Writer)
+	// InsertChars inserts n blank columns at the cursor, shifting the
+	// remainder of the row to the right. Only used if WithInsertDeleteChars is
+	// enabled.
+	InsertChars(w io.Writer, n int)
+	// DeleteChars deletes n columns at the cursor, shifting the remainder of
+	// the row to the left. Only used if WithInsertDeleteChars is enabled.
+	DeleteChars(w io.Writer, n int)
+}
+
+// ansiRenderer is the default Renderer, generating the minimal set of ANSI
+// escape sequences documented on Prompt: relative cursor movement
+// (ESC[<num>{A,B,C,D}), move to column (ESC[<num>G), move to top left corner
+// (ESC[H), erase screen (ESC[2J), erase below (ESC[J), and erase line to right
+// (ESC[K), along with insert and delete characters (ESC[<num>{@,P}).
+type ansiRenderer struct{}
+
+const (
+	csi              = "\x1b[" // csi = Control Sequence Introducer
+	moveUpSuffix     = "A"
+	moveDownSuffix   = "B"
+	moveRightSuffix  = "C"
+	moveLeftSuffix   = "D"
+	moveColumnSuffix = "G"
+	insertSuffix     = "@"
+	deleteSuffix     = "P"
+)
+
+func (ansiRenderer) MoveCursor(w io.Writer, fromX, fromY, toX, toY int) {
+	if toY < fromY {
+		writeCSI(w, fromY-toY, moveUpSuffix)
+	}
+	if toY > fromY {
+		writeCSI(w, toY-fromY, moveDownSuffix)
+	}
+
+	if toX < fromX {
+		left := fromX - toX
+		switch {
+		case toX == 0:
+			// A carriage return is the shortest way to reach the first column.
+			_, _ = io.WriteString(w, "\r")
+		case numDigits(toX+1) < numDigits(left) && left > 1:
+			// Absolute positioning within the row is shorter than moving left.
+			writeCSI(w, toX+1, moveColumnSuffix)
+		default:
+			writeCSI(w, left, moveLeftSuffix)
+		}
+	}
+	if toX > fromX {
+		writeCSI(w, toX-fromX, moveRightSuffix)
+	}
+}
+
+func (ansiRenderer) EraseLineToRight(w io.Writer) {
+	_, _ = io.WriteString(w, "\x1b[K")
+}
+
+func (ansiRenderer) EraseBelow(w io.Writer) {
+	_, _ = io.WriteString(w, "\x1b[J")
+}
+
+func (ansiRenderer) EraseScreen(w io.Writer) {
+	_, _ = io.WriteString(w, "\x1b[H\x1b[2J")
+}
+
+func (ansiRenderer) InsertChars(w io.Writer, n int) {
+	writeCSI(w, n, insertSuffix)
+}
+
+func (ansiRenderer) DeleteChars(w io.Writer, n int) {
+	writeCSI(w, n, deleteSuffix)
+}
+
+// writeCSI generates the control sequence with the specified numeric parameter
+// and final character. The parameter is omitted if it is 1, the default.
+func writeCSI(w io.Writer, n int, final string) {
+	_, _ = io.WriteString(w, csi)
+	if n != 1 {
+		_, _ = io.WriteString(w, strconv.Itoa(n))
+	}
+	_, _ = io.WriteString(w, final)
+}
+
+// numDigits returns the number of decimal digits in n, which must be
+// non-negative.
+func numDigits(n int) int {
+	d := 1
+	for ; n >= 10; n /= 10 {
+		d++
+	}
+	return d
+}
@@ -3,14 +3,14 @@ package prompt
 import (
 	"bytes"
 	"io"
-	"sort"
-	"strconv"
+	"math"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/mattn/go-runewidth"
 )
 
-// TODO(peter): test the text attribute support
 // TODO(peter): scroll input that is taller than the screen
 // TODO(peter): syntax highlighting?
 
@@ -80,12 +80,33 @@ type attrInfo struct {
 	endPos   int
 	// The attribute value to apply to the text.
 	value string
+	// kind identifies the source of the attribute.
+	kind attrKind
+	// token is the kind of token spanned by an attribute specified by a
+	// highlighter.
+	token TokenKind
 }
 
+// attrKind identifies the source of an attribute.
+type attrKind int8
+
+const (
+	// attrKindText is an attribute of inserted text, such as the completion
+	// hint or a history search match.
+	attrKindText attrKind = iota
+	// attrKindHighlight is an attribute specified by a highlighter.
+	attrKindHighlight
+	// attrKindDiagnostic is an attribute marking the range of a diagnostic.
+	attrKindDiagnostic
+	// attrKindCursor is an attribute marking a secondary cursor.
+	attrKindCursor
+	// attrKindStyle is an attribute applied by Prompt.ApplyStyle.
+	attrKindStyle
+)
+
 // screen models a prompt, input text, and the display of the prompt and text on
-// a terminal. Rendering assumes support for a minimal set of ANSI escape
-// sequences: relative cursor movement (ESC[<num>{A,B,C,D}), move to top left
-// corner (ESC[H), erase screen (ESC[2J), and erase line to right (ESC[K),
+// a terminal. The control sequences moving the cursor and erasing the display
+// are generated by a Renderer, which defaults to ansiRenderer.
 type screen struct {
 	// prefix holds text to display before the input text.
 	prefix []rune
@@ -102,10 +123,15 @@ type screen struct {
 	// ('\n').
 	lines []lineInfo
 	// attrs holds attributes to apply to the displayed text. The elements are spans
-	// of text delineated by [startPos,endPos), sorted by startPos.
-	attrs []attrInfo
+	// of text delineated by [startPos,endPos), ordered by startPos.
+	attrs attrTree
 	// insertAttrs holds the attributes to apply to text inserted by Insert().
 	insertAttrs string
+	// dirty is true if the input text has been modified since the last call to
+	// TakeDirty. The modified region of the input text is [dirtyStart,dirtyEnd).
+	dirty      bool
+	dirtyStart int
+	dirtyEnd   int
 	// width is the width in characters of the terminal.
 	width int
 	// height is the height in characters of the terminal.
@@ -121,20 +147,60 @@ type screen struct {
 	cursorY int
 	// maxY is the maximum row that has been rendered.
 	maxY int
+	// runeWidth returns the number of columns occupied by a rune when displayed
+	// on the terminal. Defaults to runewidth.RuneWidth.
+	runeWidth func(r rune) int
+	// wordClassifier returns true if a rune is part of a word for the purposes
+	// of word movement, killing, and completion. Defaults to isWord.
+	wordClassifier func(r rune) bool
+	// wrapIndent is true if soft-wrapped rows of the input text are indented to
+	// align with the start of the input text on the first row.
+	wrapIndent bool
+	// wordWrap is true if long lines of the input text are wrapped before a
+	// word which does not fit on a row, rather than within the word.
+	wordWrap bool
+	// insertDelete is true if the terminal supports inserting and deleting
+	// characters within a row (ICH and DCH). Edits which do not change the
+	// wrapping of the text are then performed in place rather than by
+	// re-rendering the text following the edit.
+	insertDelete bool
+	// semanticMarks is true if OSC 133 marks are emitted around the prompt, so
+	// that terminals can identify the prompts and the input. See
+	// WithSemanticMarks.
+	semanticMarks bool
+	// resizing is true while the suffix is updated during SetSize, in which
+	// case SetSuffix does not render it.
+	resizing bool
+	// renderer generates the control sequences which move the cursor and
+	// erase the display. Defaults to ansiRenderer.
+	renderer Renderer
+	// metrics records the output written to the terminal. May be nil.
+	metrics *metrics
+	// debug logs the output written to the terminal. May be nil.
+	debug *debugLog
 	// outbuf holds the buffered text to send to the terminal.
 	outbuf bytes.Buffer
+	// runbuf is scratch space used to encode a run of runes before writing it
+	// to outbuf.
+	runbuf []byte
+	// attrbuf is scratch space for the attributes overlapping the rendered text.
+	attrbuf []attrInfo
 }
 
 func (s *screen) Init() {
 	// These defaults are usually override by SetSize().
 	s.width = 80
 	s.height = 40
+	s.runeWidth = runewidth.RuneWidth
+	s.wordClassifier = isWord
+	s.renderer = ansiRenderer{}
 }
 
 // Flush writes the buffered drawing commands to the specified writer and clears
 // the buffer.
 func (s *screen) Flush(w io.Writer) {
-	debugPrintf("output: %q\n", s.outbuf.Bytes())
+	s.debug.Printf("output: %q\n", s.outbuf.Bytes())
+	s.metrics.AddRender(s.outbuf.Len())
 	_, _ = io.Copy(w, &s.outbuf)
 	s.outbuf.Reset()
 }
@@ -144,8 +210,9 @@ func (s *screen) Reset(prefix []rune) {
 	s.prefix = prefix
 	s.suffix = nil
 	s.text = append([]rune(nil), s.prefix...)
-	s.attrs = nil
+	s.attrs.Reset()
 	s.insertAttrs = ""
+	s.dirty, s.dirtyStart, s.dirtyEnd = true, 0, 0
 	s.lines = nil
 	s.cursorPos = 0
 	s.cursorX = 0
@@ -155,19 +222,24 @@ func (s *screen) Reset(prefix []rune) {
 	s.MoveTo(0)
 }
 
-// Cancel cancels the current input, leaving it on screen, and resets state to
-// read a new input.
-func (s *screen) Cancel() {
+// Cancel cancels the current input, leaving it on screen followed by echo, and
+// resets state to read a new input.
+func (s *screen) Cancel(echo string) {
 	s.MoveTo(len(s.text))
-	if s.cursorX != 0 {
+	if echo != "" {
+		s.outbuf.WriteString(echo)
+		s.outbuf.WriteString("\r\n")
+	} else if s.cursorX != 0 {
 		s.outbuf.WriteString("\r\n")
 	}
 	s.Reset(s.prefix)
 }
 
 // SetSize sets the width and height of the screen and re-renders the display to
-// account for the new size.
-func (s *screen) SetSize(width, height int) {
+// account for the new size. If relayout is non-nil, it is invoked once the size
+// has been set to update the suffix, such as the transient UI laid out for the
+// width of the screen, before the display is re-rendered.
+func (s *screen) SetSize(width, height int, relayout func()) {
 	if s.width == 0 {
 		s.width, s.height = width, height
 		return
@@ -178,21 +250,35 @@ func (s *screen) SetSize(width, height int) {
 	}
 
 	oldWidth := s.width
+	if width < oldWidth {
+		// Some terminals truncate lines that were too long when horizontally
+		// shrinking the terminal. Others will attempt to wrap them. Rather than
+		// refreshing the entire screen, which would erase the terminal contents
+		// above the prompt, we assume the rows of the prompt and text were
+		// wrapped, and redraw them starting from the row the prompt was wrapped
+		// to. On a terminal which truncates instead, the rows above the prompt
+		// which wrapping would have added are overwritten.
+		x, y := s.cursorX%width, s.wrappedCursorY(width)
+		s.width, s.height = width, height
+		s.layout(relayout)
+		s.cursorX, s.cursorY = x, y
+		s.moveCursor(x, 0)
+		s.Redraw()
+		return
+	}
+	oldHeight := s.height
 	s.width, s.height = width, height
 
 	switch {
 	case width == oldWidth:
-		return
-
-	case width < oldWidth:
-		// Some terminals truncate lines that were too long when horizontally shrinking
-		// the terminal. Others will attempt to wrap them. In the wrapping case, we're
-		// unsure of how many new lines were added and where the cursor ended up, so we
-		// just refresh the entire screen.
-		s.Refresh()
+		if height != oldHeight && relayout != nil {
+			relayout()
+		}
 		return
 
 	case width > oldWidth:
+		s.layout(relayout)
+		s.metrics.AddFullRedraw()
 		lines := s.maxY
 		s.cursorX = width
 		s.invalidateLines()
@@ -209,24 +295,101 @@ func (s *screen) SetSize(width, height int) {
 	}
 }
 
-// SetSuffix sets the suffix to display. The suffix is displayed after the input
-// text and is used to display the search history prompt.
-func (s *screen) SetSuffix(newSuffix []rune) {
+// layout invokes relayout, if non-nil, to update the suffix for the new size of
+// the screen. The suffix is not rendered, as the display is re-rendered after
+// the resize.
+func (s *screen) layout(relayout func()) {
+	if relayout == nil {
+		return
+	}
+	s.resizing = true
+	relayout()
+	s.resizing = false
+}
+
+// wrappedCursorY returns the row of the cursor, relative to the first row of the
+// prompt, once the rows rendered at the current width have been wrapped by the
+// terminal to width.
+func (s *screen) wrappedCursorY(width int) int {
+	s.maybeRecomputeLines()
+	used := make([]int, s.cursorY)
+	for _, l := range s.lines {
+		if l.y < len(used) {
+			_, w, _ := s.fitGraphemes(s.text[l.startPos:l.endPos], s.width-l.x)
+			if end := l.x + w; end > used[l.y] {
+				used[l.y] = end
+			}
+		}
+	}
+	var y int
+	for _, n := range used {
+		if n <= width {
+			y++
+		} else {
+			y += (n + width - 1) / width
+		}
+	}
+	return y + s.cursorX/width
+}
+
+// SetPrefix sets the prefix (prompt) to display before the input text and
+// re-renders the display. The width of the prefix affects the wrapping of the
+// input text, so all of the text is re-rendered.
+func (s *screen) SetPrefix(newPrefix []rune) {
+	oldPrefix := s.prefix
+	s.prefix = newPrefix
+
+	savedPos := s.cursorPos - len(oldPrefix)
+	s.moveCursor(0, 0)
+	s.outbuf.WriteString("\r")
+	s.cursorX = 0
+
+	text := make([]rune, 0, len(newPrefix)+len(s.text)-len(oldPrefix))
+	text = append(text, newPrefix...)
+	s.text = append(text, s.text[len(oldPrefix):]...)
+	delta := len(newPrefix) - len(oldPrefix)
+	s.attrs.ShiftAll(delta)
+
+	s.invalidateLines()
+	s.cursorPos = 0
+	s.renderText(len(s.text))
+	s.eraseLineToRight()
+	for ; s.cursorY < s.maxY; s.cursorY++ {
+		s.outbuf.WriteString("\r\n")
+		s.cursorX = 0
+		s.eraseLineToRight()
+	}
+	s.MoveTo(savedPos)
+}
+
+// SetSuffix sets the suffix to display, styled by attrs whose positions are
+// relative to the start of the suffix. The suffix is displayed after the input
+// text. See annotations.
+func (s *screen) SetSuffix(newSuffix []rune, attrs []attrInfo) {
 	oldSuffix := s.suffix
 	s.suffix = newSuffix
 
-	s.text = s.text[:len(s.text)-len(oldSuffix)]
+	start := len(s.text) - len(oldSuffix)
+	s.attrs.Erase(start, len(s.text))
+	s.text = s.text[:start]
 	if len(s.text)+len(newSuffix) > cap(s.text) {
 		newText := make([]rune, len(s.text), 2*(len(s.text)+len(newSuffix)))
 		copy(newText, s.text)
 		s.text = newText
 	}
-	pos := len(s.text)
 	s.text = s.text[:len(s.text)+len(newSuffix)]
-	copy(s.text[pos:], newSuffix)
+	copy(s.text[start:], newSuffix)
+	for _, attr := range attrs {
+		attr.startPos += start
+		attr.endPos += start
+		s.attrs.Add(attr)
+	}
 
-	savedPos := s.cursorPos - len(s.prefix)
 	s.invalidateLines()
+	if s.resizing {
+		return
+	}
+	savedPos := s.cursorPos - len(s.prefix)
 	s.MoveTo(len(s.text))
 	s.renderText(len(s.text))
 	s.eraseLineToRight()
@@ -240,6 +403,7 @@ func (s *screen) SetSuffix(newSuffix []rune) {
 
 // Refresh clears the screen and redraws the prompt and text.
 func (s *screen) Refresh() {
+	s.metrics.AddFullRedraw()
 	s.eraseScreen()
 	s.invalidateLines()
 	savedPos := s.cursorPos - len(s.prefix)
@@ -249,8 +413,56 @@ func (s *screen) Refresh() {
 	s.MoveTo(savedPos)
 }
 
+// Redraw redraws the prompt and text starting at the beginning of the line
+// containing the terminal cursor. This is used to recover after other output
+// has been written to the terminal, leaving the position of the terminal
+// cursor relative to the rendered text unknown.
+func (s *screen) Redraw() {
+	s.metrics.AddFullRedraw()
+	s.outbuf.WriteString("\r")
+	s.eraseBelow()
+	s.invalidateLines()
+	savedPos := s.cursorPos - len(s.prefix)
+	s.cursorPos = 0
+	s.cursorX, s.cursorY = 0, 0
+	s.maxY = 0
+	s.renderText(len(s.text))
+	s.MoveTo(savedPos)
+}
+
+// Erase erases the prompt and text from the terminal, leaving the cursor at the
+// beginning of the first line of the prompt. The screen state is otherwise
+// retained so that it can be redrawn by Redraw.
+func (s *screen) Erase() {
+	s.moveCursor(0, 0)
+	s.outbuf.WriteString("\r")
+	s.cursorX = 0
+	s.eraseBelow()
+}
+
+// Suspend moves the terminal cursor to the beginning of the line following the
+// rendered prompt and text, leaving them on screen. The screen state is
+// otherwise retained so that it can be redrawn by Redraw.
+func (s *screen) Suspend() {
+	s.maybeRecomputeLines()
+	if n := len(s.lines); n > 0 {
+		l := &s.lines[n-1]
+		_, width, _ := s.fitGraphemes(s.text[l.startPos:l.endPos], s.width-l.x)
+		s.moveCursor(0, l.y+(l.x+width)/s.width)
+	}
+	s.outbuf.WriteString("\r\n")
+	s.cursorX = 0
+}
+
 // MoveTo moves the cursor to the specified position.
 func (s *screen) MoveTo(pos int) {
+	s.moveTo(pos, false)
+}
+
+// moveTo moves the cursor to the specified position. A position at the end of
+// a row which was wrapped early, such as before a word which did not fit, is
+// displayed at the start of the next row unless rowEnd is true.
+func (s *screen) moveTo(pos int, rowEnd bool) {
 	s.maybeRecomputeLines()
 
 	if pos < 0 {
@@ -259,20 +471,31 @@ func (s *screen) MoveTo(pos int) {
 	if pos > len(s.text)-len(s.suffix)-len(s.prefix) {
 		pos = len(s.text) - len(s.suffix) - len(s.prefix)
 	}
+	// Never position the cursor between a base character and the combining
+	// characters which follow it.
+	for text := s.Text(); pos > 0 && pos < len(text) && s.isCombining(text[pos]); {
+		pos++
+	}
 	pos += len(s.prefix)
 
 	var l *lineInfo
 	for i := 0; i < len(s.lines); i++ {
 		if pos <= s.lines[i].endPos {
 			l = &s.lines[i]
+			for ; !rowEnd && i+1 < len(s.lines) && s.lines[i+1].startPos == pos; i++ {
+				l = &s.lines[i+1]
+			}
 			break
 		}
 	}
 
-	_, width, _ := fitGraphemes(s.text[l.startPos:pos], s.width-l.x)
+	_, width, _ := s.fitGraphemes(s.text[l.startPos:pos], s.width-l.x)
 	x := l.x + width
 	y := l.y + x/s.width
 	x = x % s.width
+	if x == 0 && width > 0 && pos >= len(s.prefix) {
+		x = s.indent()
+	}
 
 	s.cursorPos = pos
 	s.moveCursor(x, y)
@@ -285,12 +508,25 @@ func (s *screen) SetAttrs(value string) {
 // Insert inserts text at the current cursor position, moving the cursor
 // forwards.
 func (s *screen) Insert(text ...rune) {
+	// A combining character is only inserted if there is a preceding base
+	// character for it to attach to. Note that the prompt is not considered a
+	// base character.
+	hasBase := s.cursorPos > len(s.prefix) && s.text[s.cursorPos-1] != '\n'
+
 	origText := text
-	text = text[:0]
+	text = make([]rune, 0, len(origText))
 	for _, r := range origText {
-		if isPrintable(r) {
-			text = append(text, r)
+		if !isPrintable(r) {
+			continue
+		}
+		if s.isCombining(r) {
+			if !hasBase {
+				continue
+			}
+		} else {
+			hasBase = r != '\n'
 		}
+		text = append(text, r)
 	}
 
 	if len(text) < len(origText) {
@@ -310,34 +546,241 @@ func (s *screen) Insert(text ...rune) {
 	copy(s.text[s.cursorPos+len(text):], s.text[s.cursorPos:])
 	copy(s.text[s.cursorPos:], text)
 
+	s.markInserted(s.cursorPos-len(s.prefix), len(text))
+
 	// Update any existing attribute spans to account for the newly inserted text.
-	for i := range s.attrs {
-		attr := &s.attrs[i]
-		if attr.endPos <= s.cursorPos {
-			break
-		}
-		if attr.startPos > s.cursorPos {
-			attr.startPos += len(text)
-		}
+	// The spans of the suffix start at the end of the input, and are shifted
+	// rather than extended by text inserted at the end of the input.
+	var suffixAttrs []attrInfo
+	if end := len(s.text) - len(text) - len(s.suffix); s.cursorPos == end && len(s.suffix) > 0 {
+		suffixAttrs = s.attrs.Extract(end, end+len(s.suffix))
+	}
+	s.attrs.Shift(s.cursorPos, len(text))
+	for _, attr := range suffixAttrs {
+		attr.startPos += len(text)
 		attr.endPos += len(text)
+		s.attrs.Add(attr)
 	}
 	// If attributes are active, add a span for the newly inserted text.
 	if s.insertAttrs != "" {
-		s.attrs = append(s.attrs, attrInfo{
+		s.attrs.Add(attrInfo{
 			startPos: s.cursorPos,
 			endPos:   s.cursorPos + len(text),
 			value:    s.insertAttrs,
 		})
-		sort.Slice(s.attrs, func(i, j int) bool {
-			return s.attrs[i].startPos < s.attrs[j].startPos
-		})
 	}
 
 	newPos := s.cursorPos + len(text) - len(s.prefix)
-	s.renderText(len(s.text))
+	if s.wordWrap {
+		s.rewrap()
+	} else if width, ok := s.inPlaceWidth(text); ok && s.tailFits(s.cursorPos+len(text), width) {
+		s.renderer.InsertChars(&s.outbuf, width)
+		s.renderText(s.cursorPos + len(text))
+	} else {
+		s.renderText(len(s.text))
+	}
 	s.MoveTo(newPos)
 }
 
+// SetHighlights replaces the highlighting of the input text in the range
+// [start,end) with the specified attributes and re-renders the range. The
+// positions of the attributes are relative to the input text.
+func (s *screen) SetHighlights(start, end int, highlights []attrInfo) {
+	s.replaceRangeAttrs(attrKindHighlight, start, end, highlights)
+}
+
+// ApplyStyle replaces the styles applied to the input text in the range
+// [start,end) with style, and re-renders the range. An empty style removes the
+// styles from the range. The style is retained by the text as it is edited.
+func (s *screen) ApplyStyle(start, end int, style Style) {
+	var attrs []attrInfo
+	if style != "" {
+		attrs = []attrInfo{{startPos: start, endPos: end, value: string(style)}}
+	}
+	s.replaceRangeAttrs(attrKindStyle, start, end, attrs)
+}
+
+// replaceRangeAttrs replaces the attributes of the specified kind of the input
+// text in the range [start,end) with the specified attributes and re-renders
+// the range. The positions of the attributes are relative to the input text.
+func (s *screen) replaceRangeAttrs(kind attrKind, start, end int, attrs []attrInfo) {
+	start += len(s.prefix)
+	end += len(s.prefix)
+
+	for _, attr := range s.attrs.Extract(start, end) {
+		if attr.kind != kind {
+			s.attrs.Add(attr)
+			continue
+		}
+		// Retain the portions of the attribute outside of [start,end).
+		if attr.startPos < start {
+			before := attr
+			before.endPos = start
+			s.attrs.Add(before)
+		}
+		if attr.endPos > end {
+			after := attr
+			after.startPos = end
+			s.attrs.Add(after)
+		}
+	}
+	for _, attr := range attrs {
+		attr.startPos += len(s.prefix)
+		attr.endPos += len(s.prefix)
+		if attr.startPos < start {
+			attr.startPos = start
+		}
+		if attr.endPos > end {
+			attr.endPos = end
+		}
+		if attr.startPos < attr.endPos {
+			attr.kind = kind
+			s.attrs.Add(attr)
+		}
+	}
+
+	if start < end {
+		savedPos := s.cursorPos - len(s.prefix)
+		s.MoveTo(start - len(s.prefix))
+		s.renderText(end)
+		s.MoveTo(savedPos)
+	}
+}
+
+// TokenAt returns the kind of the token containing the specified position of
+// the input text, as specified by the highlighter.
+func (s *screen) TokenAt(pos int) TokenKind {
+	pos += len(s.prefix)
+	s.attrbuf = s.attrs.Overlapping(s.attrbuf[:0], pos, pos+1)
+	for _, attr := range s.attrbuf {
+		if attr.kind == attrKindHighlight && attr.token != TokenOther {
+			return attr.token
+		}
+	}
+	return TokenOther
+}
+
+// ReplaceAttrs replaces the attributes of the specified kind of the input text
+// with the specified attributes and re-renders the text whose attributes
+// changed. The positions of the attributes are relative to the input text.
+func (s *screen) ReplaceAttrs(kind attrKind, attrs []attrInfo) {
+	textStart, textEnd := len(s.prefix), len(s.text)-len(s.suffix)
+	var old []attrInfo
+	for _, attr := range s.attrs.Extract(textStart, textEnd) {
+		if attr.kind == kind {
+			old = append(old, attr)
+		} else {
+			s.attrs.Add(attr)
+		}
+	}
+
+	start, end := textEnd, textStart
+	update := func(attr attrInfo) {
+		if start > attr.startPos {
+			start = attr.startPos
+		}
+		if end < attr.endPos {
+			end = attr.endPos
+		}
+	}
+	var added []attrInfo
+	for _, attr := range attrs {
+		attr.startPos += len(s.prefix)
+		attr.endPos += len(s.prefix)
+		if attr.startPos < textStart {
+			attr.startPos = textStart
+		}
+		if attr.endPos > textEnd {
+			attr.endPos = textEnd
+		}
+		if attr.startPos < attr.endPos {
+			attr.kind = kind
+			s.attrs.Add(attr)
+			added = append(added, attr)
+		}
+	}
+
+	// Only re-render if the attributes changed.
+	changed := len(old) != len(added)
+	for i := 0; !changed && i < len(old); i++ {
+		changed = old[i] != added[i]
+	}
+	if !changed {
+		return
+	}
+	for _, attr := range old {
+		update(attr)
+	}
+	for _, attr := range added {
+		update(attr)
+	}
+	if start < end {
+		savedPos := s.cursorPos - len(s.prefix)
+		s.MoveTo(start - len(s.prefix))
+		s.renderText(end)
+		s.MoveTo(savedPos)
+	}
+}
+
+// TakeDirty returns the region [start,end) of the input text which has been
+// modified since the last call to TakeDirty, or false if the input text has
+// not been modified. Note that an empty region indicates text was erased at
+// that position.
+func (s *screen) TakeDirty() (start, end int, ok bool) {
+	if !s.dirty {
+		return 0, 0, false
+	}
+	s.dirty = false
+	return s.dirtyStart, s.dirtyEnd, true
+}
+
+// markInserted extends the dirty region to account for n characters inserted
+// at position pos in the input text.
+func (s *screen) markInserted(pos, n int) {
+	if !s.dirty {
+		s.dirty, s.dirtyStart, s.dirtyEnd = true, pos, pos+n
+		return
+	}
+	if s.dirtyStart > pos {
+		s.dirtyStart += n
+	}
+	if s.dirtyEnd >= pos {
+		s.dirtyEnd += n
+	}
+	s.extendDirty(pos, pos+n)
+}
+
+// markErased extends the dirty region to account for the input text in the
+// range [start,end) being erased.
+func (s *screen) markErased(start, end int) {
+	if !s.dirty {
+		s.dirty, s.dirtyStart, s.dirtyEnd = true, start, start
+		return
+	}
+	adjust := func(pos int) int {
+		switch {
+		case pos <= start:
+			return pos
+		case pos <= end:
+			return start
+		default:
+			return pos - (end - start)
+		}
+	}
+	s.dirtyStart = adjust(s.dirtyStart)
+	s.dirtyEnd = adjust(s.dirtyEnd)
+	s.extendDirty(start, start)
+}
+
+func (s *screen) extendDirty(start, end int) {
+	if s.dirtyStart > start {
+		s.dirtyStart = start
+	}
+	if s.dirtyEnd < end {
+		s.dirtyEnd = end
+	}
+}
+
 // EraseTo erase the characters from the current cursor position to the target
 // position, adjusting the cursor position to account for the deleted text.
 func (s *screen) EraseTo(pos int) string {
@@ -350,34 +793,69 @@ func (s *screen) EraseTo(pos int) string {
 	pos += len(s.prefix)
 
 	var erased string
+	var width int
+	var inPlace bool
 	switch {
 	case pos == s.cursorPos:
 		return ""
 	case pos < s.cursorPos:
-		s.eraseAttrs(pos, s.cursorPos)
+		s.markErased(pos-len(s.prefix), s.cursorPos-len(s.prefix))
+		s.attrs.Erase(pos, s.cursorPos)
 		erased = string(s.text[pos:s.cursorPos])
-		copy(s.text[pos:], s.text[s.cursorPos:])
-		s.text = s.text[:len(s.text)-(s.cursorPos-pos)]
+		width, inPlace = s.inPlaceWidth(s.text[pos:s.cursorPos])
+		end, oldY := s.cursorPos, s.cursorY
 		s.MoveTo(pos - len(s.prefix))
+		inPlace = inPlace && s.cursorY == oldY && s.tailFits(end, width)
+		copy(s.text[pos:], s.text[end:])
+		s.text = s.text[:len(s.text)-(end-pos)]
 	case pos > s.cursorPos:
-		s.eraseAttrs(s.cursorPos, pos)
+		s.markErased(s.cursorPos-len(s.prefix), pos-len(s.prefix))
+		s.attrs.Erase(s.cursorPos, pos)
 		erased = string(s.text[s.cursorPos:pos])
+		width, inPlace = s.inPlaceWidth(s.text[s.cursorPos:pos])
+		inPlace = inPlace && s.tailFits(pos, width)
 		copy(s.text[s.cursorPos:], s.text[pos:])
 		s.text = s.text[:len(s.text)-(pos-s.cursorPos)]
 	}
 
 	s.invalidateLines()
 	newPos := s.cursorPos - len(s.prefix)
-	s.renderText(len(s.text))
+	switch {
+	case s.wordWrap:
+		s.rewrap()
+	case inPlace:
+		s.renderer.DeleteChars(&s.outbuf, width)
+	default:
+		s.renderText(len(s.text))
+		s.eraseBelowText()
+	}
+	s.MoveTo(newPos)
+	return erased
+}
 
+// eraseBelowText erases the remainder of the rows following the rendered text
+// which are no longer occupied by text.
+func (s *screen) eraseBelowText() {
 	s.eraseLineToRight()
 	for ; s.cursorY < s.maxY; s.cursorY++ {
 		s.outbuf.WriteString("\r\n")
 		s.cursorX = 0
 		s.eraseLineToRight()
 	}
-	s.MoveTo(newPos)
-	return erased
+}
+
+// rewrap re-renders the text following an edit at the cursor position when
+// word wrapping is enabled. An edit can change the wrapping of the rows
+// preceding the cursor, such as by inserting a space which allows the start of
+// a word to fit on the previous row, so the text is re-rendered from the start
+// of the line containing the cursor.
+func (s *screen) rewrap() {
+	start := s.cursorPos
+	for ; start > len(s.prefix) && s.text[start-1] != '\n'; start-- {
+	}
+	s.moveTo(start-len(s.prefix), true)
+	s.renderText(len(s.text))
+	s.eraseBelowText()
 }
 
 // End returns the position of the end of the input text.
@@ -401,16 +879,13 @@ func (s *screen) Position() int {
 func (s *screen) NextGraphemeEnd() int {
 	text := s.Text()
 	pos := s.cursorPos - len(s.prefix)
-	if pos >= len(s.text) {
+	if pos >= len(text) {
 		return pos
 	}
 
-	for n := 0; n < 1 && pos < len(text); pos++ {
-		if text[pos] == '\n' || runewidth.RuneWidth(text[pos]) != 0 {
-			n++
-		}
-	}
-	for pos < len(text) && text[pos] != '\n' && runewidth.RuneWidth(text[pos]) == 0 {
+	// Advance past the base character and any combining characters which
+	// follow it.
+	for pos++; pos < len(text) && s.isCombining(text[pos]); {
 		pos++
 	}
 	return pos
@@ -425,10 +900,10 @@ func (s *screen) PrevGraphemeStart() int {
 
 	text := s.Text()[:s.cursorPos-len(s.prefix)]
 	pos := len(text)
-	for n := 0; n < 1 && pos > 0; pos-- {
-		if text[pos-1] == '\n' || runewidth.RuneWidth(text[pos-1]) != 0 {
-			n++
-		}
+	// Retreat past any combining characters and the base character which
+	// precedes them.
+	for pos--; pos > 0 && s.isCombining(text[pos]); {
+		pos--
 	}
 	return pos
 }
@@ -439,14 +914,14 @@ func (s *screen) NextWordEnd(pos int) int {
 	text := s.Text()
 	// Advance to the start of the next word.
 	for pos < len(text) {
-		if isWord(text[pos]) {
+		if s.isWord(text[pos]) {
 			break
 		}
 		pos++
 	}
 	// Advance to the end of the next word.
 	for pos < len(text) {
-		if !isWord(text[pos]) {
+		if !s.isWord(text[pos]) {
 			break
 		}
 		pos++
@@ -461,14 +936,14 @@ func (s *screen) PrevWordStart(pos int) int {
 	pos--
 	// Advance to the end of the previous word.
 	for pos > 0 {
-		if isWord(text[pos]) {
+		if s.isWord(text[pos]) {
 			break
 		}
 		pos--
 	}
 	// Advance to the start of the previous word.
 	for pos > 0 {
-		if !isWord(text[pos-1]) {
+		if !s.isWord(text[pos-1]) {
 			break
 		}
 		pos--
@@ -487,6 +962,7 @@ func (s *screen) maybeRecomputeLines() {
 	var pos int
 	var x, y int
 	s.lines = nil
+	indent := s.indent()
 
 	for text := s.text; len(text) >= 0; {
 		s.lines = append(s.lines, lineInfo{
@@ -499,7 +975,7 @@ func (s *screen) maybeRecomputeLines() {
 			break
 		}
 
-		consumed, width, newline := fitGraphemes(text, s.width-x)
+		consumed, width, newline := s.fitRow(pos, len(s.text), s.width-x)
 		x += width
 		y += x / s.width
 		x = x % s.width
@@ -518,6 +994,10 @@ func (s *screen) maybeRecomputeLines() {
 				text = text[1:]
 			}
 		}
+		if !newline && (consumed == 0 || (width > 0 && x == 0)) && pos >= len(s.prefix) {
+			// The text was soft-wrapped.
+			x = indent
+		}
 	}
 
 	if s.maxY < y {
@@ -525,6 +1005,23 @@ func (s *screen) maybeRecomputeLines() {
 	}
 }
 
+// InputRows returns the number of terminal rows occupied by the prompt and the
+// input text, excluding the suffix.
+func (s *screen) InputRows() int {
+	if s.width <= 0 {
+		return 1
+	}
+	s.maybeRecomputeLines()
+	pos := len(s.text) - len(s.suffix)
+	for i := range s.lines {
+		if l := &s.lines[i]; pos <= l.endPos {
+			_, width, _ := s.fitGraphemes(s.text[l.startPos:pos], s.width-l.x)
+			return l.y + (l.x+width)/s.width + 1
+		}
+	}
+	return 1
+}
+
 func (s *screen) invalidateLines() {
 	s.lines = nil
 }
@@ -536,16 +1033,12 @@ func (s *screen) renderText(end int) {
 	// an attribute becomes active, we emit its escape sequence. When an attribute
 	// becomes inactive (because we stepped past its span), we emit a reset sequence
 	// and then re-emit the escape sequence for the remaining active attributes.
+	// Text between attribute boundaries is written as a single run.
 	var activeAttrs []attrInfo
-	attrs := s.attrs
-	for len(attrs) > 0 {
-		if attrs[0].endPos >= s.cursorPos {
-			break
-		}
-		attrs = attrs[1:]
-	}
+	s.attrbuf = s.attrs.Overlapping(s.attrbuf[:0], s.cursorPos, end)
+	attrs := s.attrbuf
 
-	startAttrs := func(pos int) {
+	startAttrs := func() {
 		for len(attrs) > 0 {
 			if s.cursorPos < attrs[0].startPos {
 				break
@@ -558,12 +1051,12 @@ func (s *screen) renderText(end int) {
 		}
 	}
 
-	endAttrs := func(pos int) {
+	endAttrs := func() {
 		old := activeAttrs
 		activeAttrs = activeAttrs[:0]
 		for i := range old {
 			attr := &old[i]
-			if pos+1 == attr.endPos {
+			if s.cursorPos >= attr.endPos {
 				continue
 			}
 			activeAttrs = append(activeAttrs, *attr)
@@ -576,13 +1069,77 @@ func (s *screen) renderText(end int) {
 		}
 	}
 
+	// nextBoundary returns the position of the next attribute boundary after the
+	// cursor position, limited to limit.
+	nextBoundary := func(limit int) int {
+		if len(attrs) > 0 && attrs[0].startPos < limit {
+			limit = attrs[0].startPos
+		}
+		for i := range activeAttrs {
+			if activeAttrs[i].endPos < limit {
+				limit = activeAttrs[i].endPos
+			}
+		}
+		return limit
+	}
+
+	// writeRun writes a run of runes to outbuf.
+	writeRun := func(run []rune) {
+		var tmp [utf8.UTFMax]byte
+		buf := s.runbuf[:0]
+		for _, r := range run {
+			if r < utf8.RuneSelf {
+				buf = append(buf, byte(r))
+				continue
+			}
+			n := utf8.EncodeRune(tmp[:], r)
+			buf = append(buf, tmp[:n]...)
+		}
+		s.outbuf.Write(buf)
+		s.runbuf = buf
+	}
+
+	// indentRow indents a soft-wrapped row of the input text. The active
+	// attributes are not applied to the indentation.
+	indent := s.indent()
+	indentRow := func() {
+		if indent == 0 || s.cursorPos < len(s.prefix) {
+			return
+		}
+		if len(activeAttrs) != 0 {
+			s.outbuf.WriteString(attrReset)
+		}
+		s.outbuf.WriteString(strings.Repeat(" ", indent))
+		for i := range activeAttrs {
+			s.outbuf.WriteString(activeAttrs[i].value)
+		}
+		s.cursorX = indent
+	}
+
+	// The OSC 133 marks for the start of the prompt and the start of the input
+	// are emitted when the prompt is rendered.
+	if s.semanticMarks && s.cursorPos == 0 && (end > 0 || len(s.text) == 0) {
+		s.outbuf.WriteString(oscPromptStart)
+		if len(s.prefix) == 0 {
+			s.outbuf.WriteString(oscInputStart)
+		}
+	}
+
 	for text := s.text[s.cursorPos:end]; len(text) > 0; {
-		consumed, width, newline := fitGraphemes(text, s.width-s.cursorX)
-		for _, r := range text[:consumed] {
-			startAttrs(s.cursorPos)
-			s.outbuf.WriteRune(r)
-			endAttrs(s.cursorPos)
-			s.cursorPos++
+		consumed, width, newline := s.fitRow(s.cursorPos, end, s.width-s.cursorX)
+		for runEnd := s.cursorPos + consumed; s.cursorPos < runEnd; {
+			startAttrs()
+			next := nextBoundary(runEnd)
+			if s.semanticMarks && s.cursorPos < len(s.prefix) && next > len(s.prefix) {
+				next = len(s.prefix)
+			}
+			markInput := s.semanticMarks && s.cursorPos < len(s.prefix) && next == len(s.prefix)
+			writeRun(s.text[s.cursorPos:next])
+			if markInput {
+				s.outbuf.WriteString(oscInputStart)
+			}
+			s.cursorPos = next
+			endAttrs()
 		}
 		text = text[consumed:]
 
@@ -599,6 +1156,9 @@ func (s *screen) renderText(end int) {
 				// So, if we are stopping at the end of a line, we need to write a newline so
 				// that our cursor can be advanced to the next line.
 				s.outbuf.WriteString("\r\n")
+				if !newline {
+					indentRow()
+				}
 			}
 		}
 
@@ -608,9 +1168,11 @@ func (s *screen) renderText(end int) {
 			s.cursorX = 0
 			s.cursorY++
 			if newline {
-				endAttrs(s.cursorPos)
 				s.cursorPos++
+				endAttrs()
 				text = text[1:]
+			} else {
+				indentRow()
 			}
 		}
 	}
@@ -620,119 +1182,78 @@ func (s *screen) renderText(end int) {
 	}
 }
 
+// The OSC 133 semantic prompt marks, which delimit the prompt, the input, and
+// the output of the command entered at the prompt. See
+// https://gitlab.freedesktop.org/Per_Bothner/specifications/blob/master/proposals/semantic-prompts.md.
+const (
+	oscPromptStart  = "\x1b]133;A\x07"
+	oscInputStart   = "\x1b]133;B\x07"
+	oscOutputStart  = "\x1b]133;C\x07"
+	oscCommandEnd   = "\x1b]133;D\x07"
+	oscCommandEndFn = "\x1b]133;D;%d\x07"
+)
+
+// moveCursor moves the cursor to column x of row y.
 func (s *screen) moveCursor(x, y int) {
-	const (
-		csi             = "\x1b[" // csi = Control Sequence Introducer
-		moveUpSuffix    = "A"
-		moveDownSuffix  = "B"
-		moveRightSuffix = "C"
-		moveLeftSuffix  = "D"
-	)
-
-	if y < s.cursorY {
-		up := s.cursorY - y
-		if up == 1 {
-			s.outbuf.WriteString(csi)
-			s.outbuf.WriteString(moveUpSuffix)
-		} else if up > 1 {
-			s.outbuf.WriteString(csi)
-			s.outbuf.WriteString(strconv.Itoa(up))
-			s.outbuf.WriteString(moveUpSuffix)
-		}
-	}
-
-	if y > s.cursorY {
-		down := y - s.cursorY
-		if down == 1 {
-			s.outbuf.WriteString(csi)
-			s.outbuf.WriteString(moveDownSuffix)
-		} else if down > 1 {
-			s.outbuf.WriteString(csi)
-			s.outbuf.WriteString(strconv.Itoa(down))
-			s.outbuf.WriteString(moveDownSuffix)
-		}
-	}
-
-	if x < s.cursorX {
-		left := s.cursorX - x
-		if left == 1 {
-			s.outbuf.WriteString(csi)
-			s.outbuf.WriteString(moveLeftSuffix)
-		} else if left > 1 {
-			s.outbuf.WriteString(csi)
-			s.outbuf.WriteString(strconv.Itoa(left))
-			s.outbuf.WriteString(moveLeftSuffix)
-		}
-	}
-
-	if x > s.cursorX {
-		right := x - s.cursorX
-		if right == 1 {
-			s.outbuf.WriteString(csi)
-			s.outbuf.WriteString(moveRightSuffix)
-		} else if right > 1 {
-			s.outbuf.WriteString(csi)
-			s.outbuf.WriteString(strconv.Itoa(right))
-			s.outbuf.WriteString(moveRightSuffix)
-		}
+	if x == s.cursorX && y == s.cursorY {
+		return
 	}
-
+	s.renderer.MoveCursor(&s.outbuf, s.cursorX, s.cursorY, x, y)
 	s.cursorX = x
 	s.cursorY = y
 }
 
-// eraseLineToRight generates the escape sequence to erase the line from the
-// current cursor position to the end of the line.
-func (s *screen) eraseLineToRight() {
-	s.outbuf.WriteString("\x1b[K")
+// inPlaceWidth returns the number of columns occupied by text, and whether
+// text can be inserted or deleted in place. This requires the terminal to
+// support inserting and deleting characters, and text to occupy a single row
+// without starting with a combining character.
+func (s *screen) inPlaceWidth(text []rune) (int, bool) {
+	if !s.insertDelete || len(text) == 0 || s.isCombining(text[0]) {
+		return 0, false
+	}
+	var width int
+	for _, r := range text {
+		if r == '\n' {
+			return 0, false
+		}
+		width += s.runeWidth(r)
+	}
+	return width, width > 0
 }
 
-// eraseScreen generates the escape sequence to move the cursor to the top left
-// of the screen and to erase the contents of the screen.
-func (s *screen) eraseScreen() {
-	s.outbuf.WriteString("\x1b[H\x1b[2J")
-}
-
-func (s *screen) eraseAttrs(start, end int) {
-	attrs := s.attrs
-	s.attrs = s.attrs[:0]
-	for i := range attrs {
-		attr := &attrs[i]
-		if start >= attr.endPos {
-			// Attribute info is fully before erased span.
-			//     attr: +-------+
-			//     span:         +-------+
-			//           0 1 2 3 4 5 6 7 8
-			s.attrs = append(s.attrs, *attr)
-			continue
-		}
-		if end <= attr.startPos {
-			// Attribute info is fully after erased span.
-			//     attr:         +-------+
-			//     span: +-------+
-			//           0 1 2 3 4 5 6 7 8
-			attr.startPos -= end - start
-			attr.endPos -= end - start
-			s.attrs = append(s.attrs, *attr)
-			continue
-		}
-		overlapStart := attr.startPos
-		if overlapStart < start {
-			overlapStart = start
-		}
-		overlapEnd := attr.endPos
-		if overlapEnd > end {
-			overlapEnd = end
+// tailFits returns true if the text from pos to the end of its line, along
+// with extra columns, fits on the current row after the cursor without
+// reaching the last column. Inserting or deleting extra columns at the cursor
+// then does not change the wrapping of the text.
+func (s *screen) tailFits(pos, extra int) bool {
+	avail := s.width - s.cursorX - extra
+	for _, r := range s.text[pos:] {
+		if r == '\n' {
+			break
 		}
-		attr.endPos -= overlapEnd - overlapStart
-		if attr.startPos < attr.endPos {
-			if start < attr.startPos {
-				attr.endPos -= attr.startPos - start
-				attr.startPos = start
-			}
-			s.attrs = append(s.attrs, *attr)
+		if avail -= s.runeWidth(r); avail <= 0 {
+			return false
 		}
 	}
+	return avail > 0
+}
+
+// eraseLineToRight erases the line from the current cursor position to the end
+// of the line.
+func (s *screen) eraseLineToRight() {
+	s.renderer.EraseLineToRight(&s.outbuf)
+}
+
+// eraseBelow erases the screen from the current cursor position to the end of
+// the screen.
+func (s *screen) eraseBelow() {
+	s.renderer.EraseBelow(&s.outbuf)
+}
+
+// eraseScreen moves the cursor to the top left of the screen and erases the
+// contents of the screen.
+func (s *screen) eraseScreen() {
+	s.renderer.EraseScreen(&s.outbuf)
 }
 
 const zeroWidthJoiner = '\u200d'
@@ -753,12 +1274,109 @@ func isPrintable(key rune) bool {
 	return key == '\n' || key >= 32 && !isInSurrogateArea
 }
 
+// isCombining returns true if r is a zero-width character which combines with
+// the preceding base character to form a single grapheme.
+func (s *screen) isCombining(r rune) bool {
+	return r != '\n' && r >= 127 && s.runeWidth(r) <= 0
+}
+
+// isWord returns true if r is part of a word.
+func (s *screen) isWord(r rune) bool {
+	return s.wordClassifier(r)
+}
+
+// isWord is the default word classifier: words consist of letters and digits.
 func isWord(r rune) bool {
 	return unicode.IsLetter(r) || unicode.IsDigit(r)
 }
 
-func fitGraphemes(s []rune, avail int) (consumed, width int, newline bool) {
-	for i, r := range s {
+// indent returns the column at which soft-wrapped rows of the input text
+// start. If wrap indentation is enabled, this is the width of the last line of
+// the prompt, unless that leaves too little room for the input text.
+func (s *screen) indent() int {
+	if !s.wrapIndent {
+		return 0
+	}
+	prefix := s.prefix
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] == '\n' {
+			prefix = prefix[i+1:]
+			break
+		}
+	}
+	_, width, _ := s.fitGraphemes(prefix, math.MaxInt32)
+	width %= s.width
+	if s.width-width < 3 {
+		// Leave room for at least one wide character on each row.
+		return 0
+	}
+	return width
+}
+
+// fitRow returns the number of runes of the text starting at pos which are
+// displayed on a row with avail columns remaining, along with their width,
+// considering the text up to end. Fitting stops at a newline, in which case
+// newline is returned as true. If word wrapping is enabled and a word of the
+// input text does not fit on the row, the row ends before the word provided it
+// fits on the next row. The remainder of such a row is left empty.
+func (s *screen) fitRow(pos, end, avail int) (consumed, width int, newline bool) {
+	consumed, width, newline = s.fitGraphemes(s.text[pos:end], avail)
+	if !s.wordWrap || newline {
+		return consumed, width, newline
+	}
+	overflow := pos + consumed
+	if overflow == end {
+		// Determine whether the text following end overflows the row.
+		n, _, nl := s.fitGraphemes(s.text[pos:], avail)
+		if nl || pos+n == len(s.text) {
+			return consumed, width, newline
+		}
+		overflow = pos + n
+	}
+	if b := s.wordBreak(pos, overflow); b >= 0 && b < pos+consumed {
+		consumed = b - pos
+		_, width, _ = s.fitGraphemes(s.text[pos:b], avail)
+	}
+	return consumed, width, newline
+}
+
+// wordBreak returns the position at which to wrap a row of the input text
+// which overflows at position overflow: the start of the word containing
+// overflow, provided the word starts at or after pos and fits on a row by
+// itself, which guarantees that the word does not wrap again on the following
+// row. Returns -1 if the row should be wrapped at overflow.
+func (s *screen) wordBreak(pos, overflow int) int {
+	inputEnd := len(s.text) - len(s.suffix)
+	if overflow >= inputEnd || isBreakSpace(s.text[overflow]) {
+		return -1
+	}
+	start := overflow
+	for ; start > pos && start > len(s.prefix) && !isBreakSpace(s.text[start-1]); start-- {
+	}
+	if start > len(s.prefix) && !isBreakSpace(s.text[start-1]) {
+		// The word starts before pos.
+		return -1
+	}
+	end := overflow
+	for ; end < inputEnd && !isBreakSpace(s.text[end]) && s.text[end] != '\n'; end++ {
+	}
+	if n, _, _ := s.fitGraphemes(s.text[start:end], s.width-s.indent()); start+n < end {
+		return -1
+	}
+	return start
+}
+
+// isBreakSpace returns true if r is whitespace, other than a newline, at which
+// a row of the input text can be wrapped.
+func isBreakSpace(r rune) bool {
+	return r != '\n' && unicode.IsSpace(r)
+}
+
+// fitGraphemes returns the number of runes from text which fit within avail
+// columns, along with their width. Fitting stops at a newline, in which case
+// newline is returned as true.
+func (s *screen) fitGraphemes(text []rune, avail int) (consumed, width int, newline bool) {
+	for i, r := range text {
 		if r == '\n' {
 			return i, width, true
 		}
@@ -769,19 +1387,19 @@ func fitGraphemes(s []rune, avail int) (consumed, width int, newline bool) {
 			width++
 			continue
 		}
-		switch runewidth.RuneWidth(r) {
-		case 0:
-		case 1:
+		switch w := s.runeWidth(r); {
+		case w <= 0:
+		case w == 1:
 			if width >= avail {
 				return i, width, false
 			}
 			width++
-		case 2:
-			if width+2 >= avail {
+		default:
+			if width+w >= avail {
 				return i, width, false
 			}
-			width += 2
+			width += w
 		}
 	}
-	return len(s), width, false
+	return len(text), width, false
 }
@@ -0,0 +1,157 @@
+package prompt
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScreenRenderAttrs(t *testing.T) {
+	var s screen
+	s.Init()
+	s.Reset([]rune("> "))
+	s.Insert([]rune("hello ")...)
+	s.SetAttrs(attrBold)
+	s.Insert([]rune("bold")...)
+	s.SetAttrs("")
+	s.Insert([]rune(" world")...)
+	s.SetHighlights(0, 2, []attrInfo{{startPos: 0, endPos: 2, value: attrUnderline}})
+	s.outbuf.Reset()
+
+	s.Redraw()
+	require.Equal(t,
+		"\r\x1b[J> \x1b[4mhe\x1b[0mllo \x1b[1mbold\x1b[0m world",
+		s.outbuf.String())
+}
+
+func BenchmarkScreenRender(b *testing.B) {
+	var s screen
+	s.Init()
+	s.Reset([]rune("> "))
+	line := strings.Repeat("select * from t where x = 1 ", 4)
+	for i := 0; i < 50; i++ {
+		s.SetAttrs(attrBold)
+		s.Insert([]rune(line[:6])...)
+		s.SetAttrs("")
+		s.Insert([]rune(line[6:])...)
+		s.Insert('\n')
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Redraw()
+		s.outbuf.Reset()
+	}
+}
+
+func TestScreenMoveCursor(t *testing.T) {
+	testCases := []struct {
+		fromX, fromY int
+		toX, toY     int
+		expected     string
+	}{
+		{5, 0, 5, 0, ""},
+		{5, 0, 4, 0, "\x1b[D"},
+		{5, 0, 2, 0, "\x1b[3D"},
+		{5, 0, 0, 0, "\r"},
+		{120, 0, 0, 0, "\r"},
+		{12, 0, 2, 0, "\x1b[3G"},
+		{120, 0, 5, 0, "\x1b[6G"},
+		{120, 0, 100, 0, "\x1b[20D"},
+		{120, 2, 3, 0, "\x1b[2A\x1b[4G"},
+		{0, 0, 12, 1, "\x1b[B\x1b[12C"},
+	}
+	for _, c := range testCases {
+		var s screen
+		s.Init()
+		s.width = 200
+		s.cursorX, s.cursorY = c.fromX, c.fromY
+		s.moveCursor(c.toX, c.toY)
+		require.Equal(t, c.expected, s.outbuf.String(),
+			"(%d,%d) -> (%d,%d)", c.fromX, c.fromY, c.toX, c.toY)
+		require.Equal(t, c.toX, s.cursorX)
+		require.Equal(t, c.toY, s.cursorY)
+	}
+}
+
+func TestScreenInsertDelete(t *testing.T) {
+	var s screen
+	s.Init()
+	s.insertDelete = true
+	s.Reset([]rune("> "))
+	s.Insert([]rune("hello world")...)
+	s.MoveTo(5)
+
+	s.outbuf.Reset()
+	s.Insert(',')
+	require.Equal(t, "\x1b[@,", s.outbuf.String())
+
+	s.outbuf.Reset()
+	s.EraseTo(4)
+	require.Equal(t, "\x1b[2D\x1b[2P", s.outbuf.String())
+
+	s.outbuf.Reset()
+	s.EraseTo(6)
+	require.Equal(t, "\x1b[2P", s.outbuf.String())
+	require.Equal(t, "hellorld", string(s.Text()))
+}
+
+// symbolicRenderer is a Renderer which generates a readable description of
+// each operation in place of a control sequence.
+type symbolicRenderer struct{}
+
+func (symbolicRenderer) MoveCursor(w io.Writer, fromX, fromY, toX, toY int) {
+	fmt.Fprintf(w, "<move %d,%d>", toX-fromX, toY-fromY)
+}
+
+func (symbolicRenderer) EraseLineToRight(w io.Writer) { fmt.Fprint(w, "<erase-line>") }
+func (symbolicRenderer) EraseBelow(w io.Writer)       { fmt.Fprint(w, "<erase-below>") }
+func (symbolicRenderer) EraseScreen(w io.Writer)      { fmt.Fprint(w, "<erase-screen>") }
+func (symbolicRenderer) InsertChars(w io.Writer, n int) {
+	fmt.Fprintf(w, "<insert %d>", n)
+}
+func (symbolicRenderer) DeleteChars(w io.Writer, n int) {
+	fmt.Fprintf(w, "<delete %d>", n)
+}
+
+func TestScreenRenderer(t *testing.T) {
+	var s screen
+	s.Init()
+	s.renderer = symbolicRenderer{}
+	s.insertDelete = true
+	s.Reset([]rune("> "))
+	s.Insert([]rune("hello world")...)
+
+	s.outbuf.Reset()
+	s.MoveTo(5)
+	s.Insert(',')
+	s.EraseTo(4)
+	require.Equal(t, "<move -6,0><insert 1>,<move -2,0><delete 2>", s.outbuf.String())
+
+	s.outbuf.Reset()
+	s.Refresh()
+	require.Equal(t, "<erase-screen>> hell world<move -6,0>", s.outbuf.String())
+}
+
+func TestScreenShrink(t *testing.T) {
+	var s screen
+	s.Init()
+	s.SetSize(10, 5, nil)
+	s.Reset([]rune("> "))
+	s.Insert([]rune("hello world\nfoo")...)
+	s.outbuf.Reset()
+
+	// Shrinking the terminal wraps the first row, "> hello wo", onto two rows,
+	// and the second row, "rld", onto one. The cursor is moved up to the start
+	// of the prompt, and only the rows from there down are redrawn.
+	s.SetSize(5, 5, nil)
+	require.Equal(t,
+		"\x1b[3A\r\x1b[J> hel\r\nlo wo\r\nrld\x1b[K\r\nfoo",
+		s.outbuf.String())
+	require.Equal(t, 3, s.cursorX)
+	require.Equal(t, 3, s.cursorY)
+}
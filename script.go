@@ -0,0 +1,121 @@
+package prompt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/petermattis/prompt/keys"
+)
+
+// ParseKeys translates a script of keystrokes into the input a terminal sends
+// for them. Keys are named within angle brackets using the syntax of key
+// bindings, such as <Enter>, <Control-r>, or <Meta-Left>, along with <Escape>,
+// <Paste-Start>, <Paste-End>, and <lt> for a literal '<'. Other text, including
+// a '<' which is not followed by a '>' before any whitespace, is typed as is,
+// except that line breaks are ignored so that long scripts can be split across
+// lines. An error is returned for an unknown key name.
+func ParseKeys(script string) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		for len(line) > 0 {
+			i := strings.IndexByte(line, '<')
+			if i == -1 {
+				buf.WriteString(line)
+				break
+			}
+			buf.WriteString(line[:i])
+			line = line[i:]
+			j := strings.IndexByte(line, '>')
+			if j <= 1 || strings.ContainsAny(line[1:j], " \t<") {
+				// Not a key name.
+				buf.WriteByte('<')
+				line = line[1:]
+				continue
+			}
+			seq, err := keyNotation(line[1:j])
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString(seq)
+			line = line[j+1:]
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// keyNotation returns the input sequence for the named key.
+func keyNotation(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "lt":
+		return "<", nil
+	case "escape":
+		return "\x1b", nil
+	case "paste-start":
+		seq, _ := keys.Sequence(keyPasteStart)
+		return seq, nil
+	case "paste-end":
+		seq, _ := keys.Sequence(keyPasteEnd)
+		return seq, nil
+	}
+	key, err := parseKey(name)
+	if err != nil {
+		return "", err
+	}
+	var prefix string
+	if _, ok := keys.Sequence(key); !ok && (key&keyAlt) != 0 {
+		// Meta is sent as an escape preceding the key.
+		prefix = "\x1b"
+		key &^= keyAlt
+	}
+	if seq, ok := keys.Sequence(key); ok {
+		return prefix + seq, nil
+	}
+	if (key&(keyAlt|keyCtrl)) != 0 || !utf8.ValidRune(key) {
+		// A modified special key, or a special key such as keyUnknown, which
+		// lies within the surrogate area.
+		return "", fmt.Errorf("key cannot be typed: %q", name)
+	}
+	return prefix + string(key), nil
+}
+
+type scriptOption struct {
+	r io.Reader
+}
+
+func (o scriptOption) apply(p *Prompt) {
+	p.script = o.r
+}
+
+// WithScript configures the Prompt to read scripted keystrokes from r rather
+// than from a terminal, for end-to-end tests of applications without a PTY.
+// The script uses the notation of ParseKeys, e.g. "select 1;<Enter>". Input is
+// read interactively, and the rendering of the prompt is written to the output
+// (see WithOutput) as it would be to a terminal of the size specified by
+// WithSize. ReadLine returns io.EOF once the script is exhausted. New returns an
+// error if the script cannot be read or parsed.
+func WithScript(r io.Reader) Option {
+	return scriptOption{r}
+}
+
+// loadScript reads and parses the script specified by WithScript, setting it
+// as the input.
+func (p *Prompt) loadScript() error {
+	data, err := io.ReadAll(p.script)
+	if err != nil {
+		return err
+	}
+	if !utf8.Valid(data) {
+		return fmt.Errorf("script is not valid UTF-8")
+	}
+	input, err := ParseKeys(string(data))
+	if err != nil {
+		return err
+	}
+	p.in = bytes.NewReader(input)
+	p.interactive = interactiveOn
+	return nil
+}
@@ -0,0 +1,67 @@
+package prompt
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeys(t *testing.T) {
+	testCases := []struct {
+		script   string
+		expected string
+		err      string
+	}{
+		{script: "hello", expected: "hello"},
+		{script: "select 1;<Enter>", expected: "select 1;\r"},
+		{script: "a\nb\r\nc", expected: "abc"},
+		{script: "<Control-a><Control-Space><Control-_>", expected: "\x01\x00\x1f"},
+		{script: "<Meta-b><Meta-Enter><Meta-Control-h>", expected: "\x1bb\x1b\r\x1b\x08"},
+		{script: "<Up><Down><Left><Right>", expected: "\x1b[A\x1b[B\x1b[D\x1b[C"},
+		{script: "<Control-Left><Meta-Left>", expected: "\x1b[1;5D\x1b[1;3D"},
+		{script: "<Home><End><Delete><Page-Up><Tab><Backspace>", expected: "\x1b[H\x1b[F\x1b[3~\x1b[5~\t\x7f"},
+		{script: "<Escape>b<Paste-Start>x<Paste-End>", expected: "\x1bb\x1b[200~x\x1b[201~"},
+		{script: "a < b <= c<>", expected: "a < b <= c<>"},
+		{script: "<lt>b> <<Enter>", expected: "<b> <\r"},
+		{script: "<Bogus>", err: `invalid key: "Bogus"`},
+		{script: "<Control-1>", err: `key cannot be typed: "Control-1"`},
+	}
+	for _, c := range testCases {
+		t.Run(c.script, func(t *testing.T) {
+			input, err := ParseKeys(c.script)
+			if c.err != "" {
+				require.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, string(input))
+		})
+	}
+}
+
+func TestScript(t *testing.T) {
+	var out bytes.Buffer
+	p, err := New(
+		WithScript(strings.NewReader("select 1;<Enter>\nsel<Control-a>x<Enter>\n")),
+		WithOutput(&out), WithSize(40, 5))
+	require.NoError(t, err)
+	require.True(t, p.Interactive())
+
+	var lines []string
+	for {
+		line, err := p.ReadLine("> ")
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		lines = append(lines, line)
+	}
+	require.Equal(t, []string{"select 1;", "xsel"}, lines)
+	require.Contains(t, out.String(), "> select 1;")
+
+	_, err = New(WithScript(strings.NewReader("<Bogus>")))
+	require.EqualError(t, err, `invalid key: "Bogus"`)
+}
@@ -0,0 +1,113 @@
+package prompt
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/petermattis/prompt/keys"
+)
+
+// ReadSecret reads a line of input without displaying it, similar to `read -s`
+// or getpass. Only the prompt is displayed: neither the input nor its length
+// is rendered. Enter accepts the input, Backspace deletes the last character,
+// Control-u deletes all of the input, and Control-c cancels the input,
+// returning io.EOF. Control-d returns io.EOF if there is no input. All other
+// editing commands and key bindings are ignored, and the input is not added to
+// history.
+//
+// In non-interactive mode (see WithInteractive), ReadSecret reads a single line
+// of input. Like ReadLine, ReadSecret must not be invoked from a callback
+// invoked by ReadLine, which may instead call LockedPrompt.ReadSecret.
+func (p *Prompt) ReadSecret(prompt string) (string, error) {
+	if p.nonInteractive {
+		return p.readSecretNonInteractive()
+	}
+	return p.readInteractive(prompt, p.readSecretLocked)
+}
+
+func (p *Prompt) readSecretNonInteractive() (string, error) {
+	if p.lineReader == nil {
+		p.lineReader = bufio.NewReader(p.in)
+	}
+	line, err := p.lineReader.ReadString('\n')
+	if len(line) == 0 && err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (p *Prompt) readSecretLocked(prompt string) (string, error) {
+	p.mu.state.active = true
+	defer func() {
+		p.mu.state.active = false
+	}()
+
+	p.mu.state.screen.Reset([]rune(prompt))
+	p.mu.state.screen.Flush(p.out)
+
+	var secret []rune
+	defer func() {
+		// Don't leave the secret lying around in memory longer than necessary.
+		for i := range secret {
+			secret[i] = 0
+		}
+	}()
+
+	for {
+		for p.mu.hidden {
+			p.mu.shown.Wait()
+		}
+
+		if done, err := p.processSecretInputLocked(&secret); err != nil {
+			return "", err
+		} else if done {
+			return string(secret), nil
+		}
+
+		if err := p.readInputLocked(); err != nil {
+			return "", err
+		}
+	}
+}
+
+// processSecretInputLocked processes the keys in the input, appending
+// characters to secret. Returns true if the input was accepted.
+func (p *Prompt) processSecretInputLocked(secret *[]rune) (done bool, err error) {
+	defer p.mu.state.screen.Flush(p.out)
+	for {
+		key, ok := p.parser.Next()
+		if !ok {
+			return false, nil
+		}
+		p.metrics.AddKey()
+
+		switch key {
+		case keys.Enter:
+			p.mu.state.mirrorLine("", "")
+			p.mu.state.screen.outbuf.WriteString("\r\n")
+			return true, nil
+		case keys.CtrlC:
+			p.mu.state.mirrorLine("", "")
+			p.mu.state.screen.outbuf.WriteString("\r\n")
+			return false, io.EOF
+		case keys.CtrlD:
+			if len(*secret) == 0 {
+				p.mu.state.mirrorLine("", "")
+				p.mu.state.screen.outbuf.WriteString("\r\n")
+				return false, io.EOF
+			}
+		case keys.CtrlU:
+			*secret = (*secret)[:0]
+		case keys.Backspace, keys.CtrlH:
+			if n := len(*secret); n > 0 {
+				*secret = (*secret)[:n-1]
+			}
+		default:
+			if key < keys.Unknown && unicode.IsPrint(key) {
+				*secret = append(*secret, key)
+			}
+		}
+	}
+}
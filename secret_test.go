@@ -0,0 +1,69 @@
+package prompt
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSecret(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+		err      error
+	}{
+		{"hunter2\r", "hunter2", nil},
+		{"abc\x7f\x7fd\r", "ad", nil},
+		{"abc\x15def\r", "def", nil},
+		{"p\x1b[Da\x1bbss\r", "pass", nil},
+		{"abc\x04\r", "abc", nil},
+		{"\x04", "", io.EOF},
+		{"abc\x03", "", io.EOF},
+		{"abc", "", io.EOF},
+	}
+	for _, c := range testCases {
+		t.Run(c.input, func(t *testing.T) {
+			term := newMockTerm(20, 2)
+			p, err := New(WithInput(strings.NewReader(c.input)), WithOutput(term),
+				WithInteractive(true), WithSize(20, 2))
+			require.NoError(t, err)
+
+			secret, err := p.ReadSecret("password: ")
+			require.Equal(t, c.err, err)
+			require.Equal(t, c.expected, secret)
+
+			// Only the prompt is displayed.
+			lines := strings.Split(term.String(), "\n")
+			require.Equal(t, "│password:           │", strings.ReplaceAll(lines[1], "\u0332", ""))
+		})
+	}
+
+	// In non-interactive mode a single line is read.
+	p, err := New(WithInput(strings.NewReader("hunter2\nselect 1;\n")), WithInteractive(false))
+	require.NoError(t, err)
+	secret, err := p.ReadSecret("password: ")
+	require.NoError(t, err)
+	require.Equal(t, "hunter2", secret)
+	line, err := p.ReadLine("> ")
+	require.NoError(t, err)
+	require.Equal(t, "select 1;", line)
+}
+
+func TestReadSecretNested(t *testing.T) {
+	// A callback reads a secret using a nested ReadLine.
+	var secret string
+	p, err := New(WithInput(strings.NewReader("connect\rhunter2\r")), WithOutput(io.Discard),
+		WithInteractive(true), WithSize(20, 4),
+		WithAcceptCheck(func(lp *LockedPrompt, line string) error {
+			var err error
+			secret, err = lp.ReadSecret("password: ")
+			return err
+		}))
+	require.NoError(t, err)
+	line, err := p.ReadLine("> ")
+	require.NoError(t, err)
+	require.Equal(t, "connect", line)
+	require.Equal(t, "hunter2", secret)
+}
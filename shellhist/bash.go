@@ -0,0 +1,91 @@
+// Package shellhist implements the history file formats of bash and zsh,
+// allowing history to be carried over from, and to, those shells and the
+// tools that share their formats.
+package shellhist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a history entry read from or written to a shell history file.
+type Entry struct {
+	// Command is the text of the entry, which may span multiple lines.
+	Command string
+	// Time is the time the command was run, or the zero Time if unknown.
+	Time time.Time
+	// Duration is the time the command took to run, if known. Only recorded
+	// in zsh history files, with a resolution of one second.
+	Duration time.Duration
+}
+
+// ReadBash reads the entries of a bash history file, oldest first. Each line
+// of the file holds an entry, unless the file holds timestamps (written by bash
+// when HISTTIMEFORMAT is set), in which case each entry is preceded by a line
+// holding its timestamp as "#<unix seconds>" and may span the lines up to the
+// next timestamp, as written by bash when the lithist option is set.
+func ReadBash(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var timestamped bool
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		text := s.Text()
+		if t, ok := parseBashTimestamp(text); ok {
+			timestamped = true
+			entries = append(entries, Entry{Time: t})
+			continue
+		}
+		if timestamped && len(entries) > 0 {
+			e := &entries[len(entries)-1]
+			if e.Command == "" {
+				e.Command = text
+			} else {
+				e.Command += "\n" + text
+			}
+			continue
+		}
+		entries = append(entries, Entry{Command: text})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// parseBashTimestamp parses a timestamp line of a bash history file, which
+// holds '#' followed by the seconds since the Unix epoch.
+func parseBashTimestamp(text string) (time.Time, bool) {
+	if len(text) < 2 || text[0] != '#' {
+		return time.Time{}, false
+	}
+	for _, c := range text[1:] {
+		if c < '0' || c > '9' {
+			return time.Time{}, false
+		}
+	}
+	secs, err := strconv.ParseInt(text[1:], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0), true
+}
+
+// WriteBash writes entries, oldest first, in the format of a bash history file.
+// An entry with a non-zero Time is preceded by its timestamp. Note that bash
+// only reads a multi-line entry back as a single entry if it has a timestamp,
+// and the entries are otherwise split at each newline.
+func WriteBash(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		if !e.Time.IsZero() {
+			fmt.Fprintf(bw, "#%d\n", e.Time.Unix())
+		}
+		bw.WriteString(strings.TrimSuffix(e.Command, "\n"))
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,43 @@
+package shellhist
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBashRoundtrip(t *testing.T) {
+	entries := []Entry{
+		{Command: "ls -l", Time: time.Unix(1700000000, 0)},
+		{Command: "for i in 1 2; do\n  echo $i\ndone", Time: time.Unix(1700000005, 0)},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteBash(&buf, entries))
+	require.Equal(t,
+		"#1700000000\nls -l\n#1700000005\nfor i in 1 2; do\n  echo $i\ndone\n",
+		buf.String())
+
+	decoded, err := ReadBash(&buf)
+	require.NoError(t, err)
+	require.Equal(t, entries, decoded)
+}
+
+func TestReadBash(t *testing.T) {
+	entries, err := ReadBash(strings.NewReader(""))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// Without timestamps, each line is an entry, including comments.
+	entries, err = ReadBash(strings.NewReader("ls\n# comment\ncd /tmp\n"))
+	require.NoError(t, err)
+	require.Equal(t, []Entry{{Command: "ls"}, {Command: "# comment"}, {Command: "cd /tmp"}}, entries)
+
+	// Lines preceding the first timestamp are entries of their own.
+	entries, err = ReadBash(strings.NewReader("ls\n#1700000000\npwd\n"))
+	require.NoError(t, err)
+	require.Equal(t, []Entry{{Command: "ls"}, {Command: "pwd", Time: time.Unix(1700000000, 0)}}, entries)
+}
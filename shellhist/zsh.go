@@ -0,0 +1,133 @@
+package shellhist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zshExtendedRE matches the prefix of an entry in the extended history format
+// of zsh: ": <start>:<elapsed>;".
+var zshExtendedRE = regexp.MustCompile(`^: *(\d+):(\d+);`)
+
+// zshMeta is the byte zsh uses to escape the bytes with special meaning to it
+// ("metafication"). The escaped byte follows, XORed with 32.
+const zshMeta = 0x83
+
+// ReadZsh reads the entries of a zsh history file, oldest first, in either the
+// plain format holding the commands alone or the extended format (written by
+// zsh when the EXTENDED_HISTORY option is set) holding each command prefixed
+// by its start time and duration in seconds, as ": <start>:<elapsed>;<command>".
+// A line ending in a backslash continues on the following line, which is how
+// zsh writes multi-line entries.
+func ReadZsh(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var cont bool
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		text := unmetafy(s.Text())
+		var e *Entry
+		if cont {
+			e = &entries[len(entries)-1]
+			e.Command += "\n"
+		} else {
+			entries = append(entries, Entry{})
+			e = &entries[len(entries)-1]
+			if m := zshExtendedRE.FindStringSubmatch(text); m != nil {
+				start, err := strconv.ParseInt(m[1], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("malformed zsh history entry: %q", text)
+				}
+				elapsed, err := strconv.ParseInt(m[2], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("malformed zsh history entry: %q", text)
+				}
+				e.Time = time.Unix(start, 0)
+				e.Duration = time.Duration(elapsed) * time.Second
+				text = text[len(m[0]):]
+			}
+		}
+		text, cont = trimContinuation(text)
+		e.Command += text
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// trimContinuation strips the backslash from a line ending in one, indicating
+// that the entry continues on the following line.
+func trimContinuation(text string) (string, bool) {
+	if strings.HasSuffix(text, `\`) {
+		return text[:len(text)-1], true
+	}
+	return text, false
+}
+
+// WriteZsh writes entries, oldest first, in the extended format of a zsh
+// history file. An entry with a zero Time is written with a start time of 0.
+func WriteZsh(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		var start int64
+		if !e.Time.IsZero() {
+			start = e.Time.Unix()
+		}
+		fmt.Fprintf(bw, ": %d:%d;", start, int64(e.Duration/time.Second))
+		cmd := strings.TrimSuffix(e.Command, "\n")
+		bw.WriteString(metafy(strings.ReplaceAll(cmd, "\n", "\\\n")))
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// isMeta returns true if zsh escapes the byte c when writing history: NUL and
+// the bytes from zshMeta through 0xa2, which zsh uses as tokens.
+func isMeta(c byte) bool {
+	return c == 0 || (c >= zshMeta && c <= 0xa2)
+}
+
+// metafy escapes the bytes of s with special meaning to zsh.
+func metafy(s string) string {
+	var n int
+	for i := 0; i < len(s); i++ {
+		if isMeta(s[i]) {
+			n++
+		}
+	}
+	if n == 0 {
+		return s
+	}
+	buf := make([]byte, 0, len(s)+n)
+	for i := 0; i < len(s); i++ {
+		if isMeta(s[i]) {
+			buf = append(buf, zshMeta, s[i]^32)
+		} else {
+			buf = append(buf, s[i])
+		}
+	}
+	return string(buf)
+}
+
+// unmetafy reverses metafy.
+func unmetafy(s string) string {
+	i := strings.IndexByte(s, zshMeta)
+	if i < 0 {
+		return s
+	}
+	buf := []byte(s[:i])
+	for ; i < len(s); i++ {
+		if s[i] == zshMeta && i+1 < len(s) {
+			i++
+			buf = append(buf, s[i]^32)
+		} else {
+			buf = append(buf, s[i])
+		}
+	}
+	return string(buf)
+}
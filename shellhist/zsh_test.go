@@ -0,0 +1,51 @@
+package shellhist
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestZshRoundtrip(t *testing.T) {
+	entries := []Entry{
+		{Command: "ls -l", Time: time.Unix(1700000000, 0), Duration: 2 * time.Second},
+		{Command: "for i in 1 2; do\n  echo $i\ndone", Time: time.Unix(1700000005, 0)},
+		{Command: "echo Ã", Time: time.Unix(1700000010, 0)},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteZsh(&buf, entries))
+	// The second byte of the UTF-8 encoding of "Ã" (0xc3 0x83) is escaped.
+	require.Equal(t,
+		": 1700000000:2;ls -l\n"+
+			": 1700000005:0;for i in 1 2; do\\\n  echo $i\\\ndone\n"+
+			": 1700000010:0;echo \xc3\x83\xa3\n",
+		buf.String())
+
+	decoded, err := ReadZsh(&buf)
+	require.NoError(t, err)
+	require.Equal(t, entries, decoded)
+}
+
+func TestReadZsh(t *testing.T) {
+	entries, err := ReadZsh(strings.NewReader(""))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// The plain format holds only the commands.
+	entries, err = ReadZsh(strings.NewReader("ls\ncd /tmp\n"))
+	require.NoError(t, err)
+	require.Equal(t, []Entry{{Command: "ls"}, {Command: "cd /tmp"}}, entries)
+}
+
+func TestZshMetafy(t *testing.T) {
+	for _, s := range []string{"", "plain", "\x00", "\x83\x9f\xa2", "a\x83b", "Ã"} {
+		require.Equal(t, s, unmetafy(metafy(s)), "%q", s)
+	}
+	require.Equal(t, "\x83\x20", metafy("\x00"))
+	require.Equal(t, "\x83\xa3", metafy("\x83"))
+	require.Equal(t, "€", metafy("€"))
+}
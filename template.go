@@ -0,0 +1,149 @@
+package prompt
+
+// TemplateSegment is a segment of the initial input text of ReadLineTemplate.
+type TemplateSegment struct {
+	// Text is the text of the segment.
+	Text string
+	// ReadOnly is true if the segment is protected: the cursor can be moved
+	// across it, but editing commands cannot modify or delete it. Text may be
+	// inserted at the boundaries of a read-only segment.
+	ReadOnly bool
+}
+
+// ReadLineTemplate is like ReadLine, but initializes the input text to the
+// concatenation of the text of the segments, allowing the user to be guided in
+// filling in a scaffold such as "UPDATE t SET " followed by an editable
+// segment. The cursor is initially positioned at the start of the first
+// segment which is not read-only. Edits which would modify a read-only segment
+// are rejected. History navigation and search are disabled as they would
+// replace the input text, and the input text is not restored from the draft
+// file. If input is not interactive, ReadLineTemplate behaves like ReadLine.
+// Like ReadLine, ReadLineTemplate must not be invoked from a callback invoked by
+// ReadLine, which may instead call LockedPrompt.ReadLineTemplate.
+func (p *Prompt) ReadLineTemplate(prompt string, segments ...TemplateSegment) (string, error) {
+	if p.nonInteractive {
+		return p.ReadLine(prompt)
+	}
+	return p.readInteractive(prompt, func(prompt string) (string, error) {
+		return p.readTemplateLocked(prompt, segments)
+	})
+}
+
+// template holds the positions of the read-only segments of the input text of
+// ReadLineTemplate.
+type template struct {
+	// readOnly holds the [start,end) positions of the read-only segments within
+	// the input text, in order. The positions exclude the completion hint.
+	readOnly [][2]int
+}
+
+// setTemplate sets the input text to the segments, positioning the cursor at
+// the start of the first segment which is not read-only.
+func (s *state) setTemplate(segments []TemplateSegment) {
+	t := &template{}
+	var text []rune
+	pos := -1
+	for _, seg := range segments {
+		start := len(text)
+		text = append(text, []rune(seg.Text)...)
+		switch {
+		case !seg.ReadOnly:
+			if pos == -1 {
+				pos = start
+			}
+		case start < len(text):
+			t.readOnly = append(t.readOnly, [2]int{start, len(text)})
+		}
+	}
+	if pos == -1 {
+		pos = len(text)
+	}
+	s.template = t
+	s.screen.Insert(text...)
+	s.screen.MoveTo(pos)
+}
+
+// templateDisabled returns true if cmd is disabled while a template is active.
+func templateDisabled(cmd command) bool {
+	switch cmd {
+	case cmdNextHistory, cmdPreviousHistory, cmdReverseSearchHistory, cmdForwardSearchHistory,
+		cmdSubstrSearchBackward, cmdSubstrSearchForward:
+		return true
+	}
+	return false
+}
+
+// Enforce checks the edit performed by a command, which changed the input from
+// the state before. If the edit modified a read-only segment, the edit is
+// reverted and the terminal bell is rung. Otherwise, the positions of the
+// read-only segments are updated to account for the edit.
+func (t *template) Enforce(s *state, before undoState) {
+	after := undoSnapshot(s)
+	if string(after.text) == string(before.text) || t.update(before.text, after.text) {
+		return
+	}
+	s.completer.Cancel(s)
+	s.screen.MoveTo(0)
+	s.screen.EraseTo(s.screen.End())
+	s.screen.Insert(before.text...)
+	s.screen.MoveTo(before.pos)
+	s.screen.outbuf.WriteRune(keyCtrlG) // ctrl-G == bell/beep
+}
+
+// update updates the positions of the read-only segments after the text was
+// changed from before to after, returning false if the change modified a
+// read-only segment.
+func (t *template) update(before, after []rune) bool {
+	limit := len(before)
+	if limit > len(after) {
+		limit = len(after)
+	}
+	var prefix, suffix int
+	for prefix < limit && before[prefix] == after[prefix] {
+		prefix++
+	}
+	for suffix < limit && before[len(before)-suffix-1] == after[len(after)-suffix-1] {
+		suffix++
+	}
+
+	// The change replaced n characters of before with m characters. Its
+	// position is ambiguous if the surrounding text repeats (e.g. inserting "a"
+	// into "aa"), and may be anywhere from the position which maximizes the
+	// common suffix to the position which maximizes the common prefix. The
+	// first position at which the change doesn't modify a read-only segment is
+	// used.
+	n := len(before) - prefix - suffix
+	if suffix > limit-prefix {
+		n = len(before) - limit
+	}
+	m := n + len(after) - len(before)
+	start := len(before) - n - suffix
+	if start < 0 {
+		start = 0
+	}
+	for pos := start; pos <= prefix; pos++ {
+		if t.modifies(pos, n) {
+			continue
+		}
+		for i := range t.readOnly {
+			if r := &t.readOnly[i]; r[0] >= pos+n {
+				r[0] += m - n
+				r[1] += m - n
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// modifies returns true if replacing the n characters at pos modifies a
+// read-only segment. Insertions at the boundaries of a segment do not modify
+// it.
+func (t *template) modifies(pos, n int) bool {
+	for _, r := range t.readOnly {
+		if n > 0 && pos < r[1] && pos+n > r[0] || n == 0 && r[0] < pos && pos < r[1] {
+			return true
+		}
+	}
+	return false
+}
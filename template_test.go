@@ -0,0 +1,57 @@
+package prompt
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateUpdate(t *testing.T) {
+	testCases := []struct {
+		before, after string
+		ok            bool
+		expected      [][2]int
+	}{
+		// The read-only segment is "bb" in "abbc".
+		{"abbc", "abbc", true, [][2]int{{1, 3}}},
+		{"abbc", "xabbc", true, [][2]int{{2, 4}}},
+		{"abbc", "abbxc", true, [][2]int{{1, 3}}},
+		{"abbc", "bbc", true, [][2]int{{0, 2}}},
+		{"abbc", "abb", true, [][2]int{{1, 3}}},
+		{"abbc", "abxbc", false, [][2]int{{1, 3}}},
+		{"abbc", "abc", false, [][2]int{{1, 3}}},
+		{"abbc", "axbc", false, [][2]int{{1, 3}}},
+		{"abbc", "", false, [][2]int{{1, 3}}},
+		// The position of an insertion is ambiguous if the inserted text repeats
+		// the segment. The insertion is considered to be before the segment.
+		{"abbc", "abbbc", true, [][2]int{{2, 4}}},
+		{"abbc", "abbbbc", true, [][2]int{{3, 5}}},
+		{"abbc", "bbbbc", true, [][2]int{{2, 4}}},
+		{"abbc", "ac", false, [][2]int{{1, 3}}},
+	}
+	for _, c := range testCases {
+		tmpl := &template{readOnly: [][2]int{{1, 3}}}
+		ok := tmpl.update([]rune(c.before), []rune(c.after))
+		require.Equal(t, c.ok, ok, "%q -> %q", c.before, c.after)
+		require.Equal(t, c.expected, tmpl.readOnly, "%q -> %q", c.before, c.after)
+	}
+}
+
+func TestReadLineTemplateNested(t *testing.T) {
+	// A callback reads input guided by a template using a nested ReadLine.
+	var nested string
+	p, err := New(WithInput(strings.NewReader("update\ra = 1\r")), WithOutput(io.Discard),
+		WithInteractive(true), WithSize(40, 4),
+		WithAcceptCheck(func(lp *LockedPrompt, line string) error {
+			var err error
+			nested, err = lp.ReadLineTemplate("? ", TemplateSegment{Text: "UPDATE t SET ", ReadOnly: true})
+			return err
+		}))
+	require.NoError(t, err)
+	line, err := p.ReadLine("> ")
+	require.NoError(t, err)
+	require.Equal(t, "update", line)
+	require.Equal(t, "UPDATE t SET a = 1", nested)
+}
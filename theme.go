@@ -0,0 +1,169 @@
+package prompt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Theme specifies the styles used for the text rendered by Prompt itself, as
+// opposed to the styles applied by a HighlightFunc. An empty style selects the
+// default. See WithTheme.
+type Theme struct {
+	// CompletionHint is the style of the completion hint displayed after the
+	// cursor. Defaults to dim.
+	CompletionHint Style
+	// SearchMatch is the style of the text matched by history substring search
+	// and of the current match of incremental history search. Defaults to
+	// reverse video.
+	SearchMatch Style
+	// SearchOccurrence is the style of the other occurrences of the search key
+	// within the entry matched by incremental history search. Defaults to
+	// underline.
+	SearchOccurrence Style
+	// DiagnosticError, DiagnosticWarning, and DiagnosticInfo are the styles of
+	// the ranges of the diagnostics reported by the linter (see WithLinter) with
+	// the corresponding severity. Default to underlined dark-red, brown, and
+	// cyan text.
+	DiagnosticError   Style
+	DiagnosticWarning Style
+	DiagnosticInfo    Style
+	// SecondaryCursor is the style of the character at each secondary cursor
+	// added by the add-cursor-next-match command. Defaults to reverse video.
+	SecondaryCursor Style
+}
+
+// defaultTheme is the theme used for unspecified styles.
+var defaultTheme = Theme{
+	CompletionHint:    attrDim,
+	SearchMatch:       attrReverse,
+	SearchOccurrence:  attrUnderline,
+	DiagnosticError:   "\x1b[4;31m",
+	DiagnosticWarning: "\x1b[4;33m",
+	DiagnosticInfo:    "\x1b[4;36m",
+	SecondaryCursor:   attrReverse,
+}
+
+// merge returns the theme with the empty styles replaced by those of other.
+func (t Theme) merge(other Theme) Theme {
+	if t.CompletionHint == "" {
+		t.CompletionHint = other.CompletionHint
+	}
+	if t.SearchMatch == "" {
+		t.SearchMatch = other.SearchMatch
+	}
+	if t.SearchOccurrence == "" {
+		t.SearchOccurrence = other.SearchOccurrence
+	}
+	if t.DiagnosticError == "" {
+		t.DiagnosticError = other.DiagnosticError
+	}
+	if t.DiagnosticWarning == "" {
+		t.DiagnosticWarning = other.DiagnosticWarning
+	}
+	if t.DiagnosticInfo == "" {
+		t.DiagnosticInfo = other.DiagnosticInfo
+	}
+	if t.SecondaryCursor == "" {
+		t.SecondaryCursor = other.SecondaryCursor
+	}
+	return t
+}
+
+// diagnosticStyle returns the style of diagnostics with the specified severity.
+func (t Theme) diagnosticStyle(severity Severity) Style {
+	switch severity {
+	case SeverityError:
+		return t.DiagnosticError
+	case SeverityWarning:
+		return t.DiagnosticWarning
+	default:
+		return t.DiagnosticInfo
+	}
+}
+
+type themeOption struct {
+	theme Theme
+}
+
+func (o themeOption) apply(p *Prompt) {
+	p.mu.state.theme = o.theme.merge(p.mu.state.theme)
+}
+
+// WithTheme configures the styles used for the completion hint, history search
+// matches, diagnostics, and secondary cursors. Empty styles in the theme leave
+// the existing styles unchanged.
+func WithTheme(theme Theme) Option {
+	return themeOption{theme}
+}
+
+// sgrParams maps the names accepted by ParseStyle to SGR parameters.
+var sgrParams = map[string]string{
+	"bold":      "1",
+	"dim":       "2",
+	"underline": "4",
+	"reverse":   "7",
+
+	"black":      "30",
+	"dark-red":   "31",
+	"dark-green": "32",
+	"brown":      "33",
+	"dark-blue":  "34",
+	"purple":     "35",
+	"cyan":       "36",
+	"light-gray": "37",
+	"default":    "39",
+	"dark-gray":  "90",
+	"red":        "91",
+	"green":      "92",
+	"yellow":     "93",
+	"blue":       "94",
+	"fuchsia":    "95",
+	"turquoise":  "96",
+	"white":      "97",
+}
+
+// ParseStyle parses a textual description of a style, such as "bold red" or
+// "reverse on-blue", into a Style. The description is a whitespace separated
+// list of the attributes bold, dim, underline, and reverse, the foreground
+// colors black, dark-red, dark-green, brown, dark-blue, purple, cyan,
+// light-gray, dark-gray, red, green, yellow, blue, fuchsia, turquoise, white,
+// and default, the background colors named by prefixing a color with "on-",
+// and numeric SGR parameters (e.g. "38;5;208" for a 256-color foreground). The
+// empty string parses to the empty Style.
+func ParseStyle(s string) (Style, error) {
+	var params []string
+	for _, word := range strings.Fields(s) {
+		if p, ok := sgrParams[word]; ok {
+			params = append(params, p)
+			continue
+		}
+		if color := strings.TrimPrefix(word, "on-"); color != word {
+			if p, ok := sgrParams[color]; ok && len(p) == 2 {
+				// Background colors are 10 greater than foreground colors.
+				n, _ := strconv.Atoi(p)
+				params = append(params, strconv.Itoa(n+10))
+				continue
+			}
+		}
+		if isSGRParams(word) {
+			params = append(params, word)
+			continue
+		}
+		return "", fmt.Errorf("invalid style: %q", word)
+	}
+	if len(params) == 0 {
+		return "", nil
+	}
+	return Style("\x1b[" + strings.Join(params, ";") + "m"), nil
+}
+
+// isSGRParams returns true if s is a semicolon separated list of numbers.
+func isSGRParams(s string) bool {
+	for _, p := range strings.Split(s, ";") {
+		if _, err := strconv.ParseUint(p, 10, 8); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package prompt
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// insideTmux returns true if the process is running inside tmux.
+func insideTmux() bool {
+	return os.Getenv("TMUX") != ""
+}
+
+// tmuxBuffer loads text into the tmux paste buffer. Loading is performed in
+// the background, as it requires running the tmux command, and only the most
+// recent text is loaded if text is loaded faster than tmux can keep up (e.g.
+// during consecutive kills).
+type tmuxBuffer struct {
+	// load loads text into the paste buffer. Defaults to loadTmuxBuffer.
+	load  func(text string) error
+	debug *debugLog
+
+	mu      sync.Mutex
+	pending *string
+	running bool
+	// done is signalled when the background loading finishes. Used by tests.
+	done *sync.Cond
+}
+
+func newTmuxBuffer(load func(text string) error, debug *debugLog) *tmuxBuffer {
+	t := &tmuxBuffer{load: load, debug: debug}
+	t.done = sync.NewCond(&t.mu)
+	return t
+}
+
+// Load loads text into the paste buffer in the background.
+func (t *tmuxBuffer) Load(text string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = &text
+	if !t.running {
+		t.running = true
+		go t.run()
+	}
+}
+
+func (t *tmuxBuffer) run() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.pending != nil {
+		text := *t.pending
+		t.pending = nil
+		t.mu.Unlock()
+		err := t.load(text)
+		t.mu.Lock()
+		if err != nil {
+			t.debug.Printf("tmux: %v\n", err)
+		}
+	}
+	t.running = false
+	t.done.Broadcast()
+}
+
+// wait waits for the background loading to finish.
+func (t *tmuxBuffer) wait() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.running {
+		t.done.Wait()
+	}
+}
+
+// loadTmuxBuffer loads text into the tmux paste buffer using "tmux
+// load-buffer".
+func loadTmuxBuffer(text string) error {
+	cmd := exec.Command("tmux", "load-buffer", "-")
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// tmuxPassthrough wraps the escape sequence seq in the DCS passthrough
+// sequence, which tmux forwards to the outer terminal if the allow-passthrough
+// option is enabled. The escape characters within seq are doubled.
+func tmuxPassthrough(seq string) string {
+	return "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+}
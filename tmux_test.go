@@ -0,0 +1,56 @@
+package prompt
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestTmuxPassthrough(t *testing.T) {
+	var buf bytes.Buffer
+	writeClipboard(&buf, "hello", true)
+	const expected = "\x1bPtmux;\x1b\x1b]52;c;aGVsbG8=\x07\x1b\\"
+	if s := buf.String(); s != expected {
+		t.Fatalf("expected %q, but found %q", expected, s)
+	}
+}
+
+func TestTmuxBuffer(t *testing.T) {
+	var mu sync.Mutex
+	var loaded []string
+	b := newTmuxBuffer(func(text string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		loaded = append(loaded, text)
+		return nil
+	}, nil)
+
+	var s state
+	s.screen.Init()
+	s.tmuxBuffer = b
+	s.screen.Reset([]rune("> "))
+	s.screen.Insert([]rune("select 1 from t")...)
+
+	dispatch := func(cmd command) {
+		t.Helper()
+		if _, err := s.killRing.Dispatch(&s, cmd, 0); err != nil {
+			t.Fatal(err)
+		}
+		b.wait()
+	}
+
+	// Consecutive kills load the accumulated text.
+	dispatch(cmdBackwardKillWord)
+	dispatch(cmdBackwardKillWord)
+	// Kills which do not kill any text do not load the buffer.
+	dispatch(cmdKillLine)
+	dispatch(cmdCopyBuffer)
+
+	expected := []string{"t", "from t", "select 1 "}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(expected, loaded) {
+		t.Fatalf("expected %q, but found %q", expected, loaded)
+	}
+}
@@ -0,0 +1,87 @@
+package prompt
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KeyTrace holds the timings of the processing of a key, which help diagnose
+// whether a slow prompt is due to slow callbacks or a slow terminal. See
+// WithTrace.
+type KeyTrace struct {
+	// Start is the time at which processing of the key started.
+	Start time.Time
+	// Key is the name of the key, such as "a" or "Control-r", and Command is
+	// the command the key was bound to.
+	Key     string
+	Command string
+	// Parse is the time spent parsing the key from the input, Dispatch is the
+	// time spent performing the command (including the completion callback),
+	// and Render is the time spent highlighting and linting the input.
+	Parse    time.Duration
+	Dispatch time.Duration
+	Render   time.Duration
+	// Flush is the time spent writing Bytes bytes of rendered output to the
+	// terminal, including the render hooks. Output is flushed once all of the
+	// available input has been processed, so Flush and Bytes are zero for all
+	// but the last key of a burst of input such as a paste. The linting of the
+	// input is similarly included in the Render time of the last key.
+	Flush time.Duration
+	Bytes int
+}
+
+// Total returns the total time spent processing the key.
+func (t KeyTrace) Total() time.Duration {
+	return t.Parse + t.Dispatch + t.Render + t.Flush
+}
+
+func (t KeyTrace) String() string {
+	return fmt.Sprintf("%s (%s): parse=%s dispatch=%s render=%s flush=%s bytes=%d",
+		t.Key, t.Command, t.Parse, t.Dispatch, t.Render, t.Flush, t.Bytes)
+}
+
+// tracer holds the most recent key traces in a ring. The traces are protected
+// by a mutex so that they can be read without waiting for input processing to
+// finish. The methods are no-ops on a nil receiver.
+type tracer struct {
+	mu      sync.Mutex
+	entries []KeyTrace
+	// next is the index of the entry to be overwritten by the next trace once
+	// the ring is full.
+	next  int
+	debug *debugLog
+}
+
+func newTracer(size int, debug *debugLog) *tracer {
+	return &tracer{entries: make([]KeyTrace, 0, size), debug: debug}
+}
+
+// Add records a trace, overwriting the oldest trace if the ring is full. The
+// trace is also written to the debug log.
+func (t *tracer) Add(trace KeyTrace) {
+	if t == nil {
+		return
+	}
+	t.debug.Printf(" trace: %s\n", trace)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.entries) < cap(t.entries) {
+		t.entries = append(t.entries, trace)
+		return
+	}
+	t.entries[t.next] = trace
+	t.next = (t.next + 1) % len(t.entries)
+}
+
+// Snapshot returns a copy of the traces, oldest first.
+func (t *tracer) Snapshot() []KeyTrace {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make([]KeyTrace, 0, len(t.entries))
+	res = append(res, t.entries[t.next:]...)
+	return append(res, t.entries[:t.next]...)
+}
@@ -0,0 +1,51 @@
+package prompt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	var out bytes.Buffer
+	completer := func(text []rune, wordStart, wordEnd int) []string {
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+	p := newTestPrompt(t, WithOutput(&out), WithInteractive(true), WithCompleter(completer),
+		WithTrace(3))
+	p.mu.state.screen.Flush(&out)
+
+	require.Empty(t, p.Trace())
+
+	// Output is flushed once per burst of input, so only the last key of the
+	// burst has a flush time.
+	n := out.Len()
+	feedInput(t, p, "ab")
+	traces := p.Trace()
+	require.Len(t, traces, 2)
+	require.Equal(t, "a", traces[0].Key)
+	require.Equal(t, "insert-char", traces[0].Command)
+	require.GreaterOrEqual(t, int64(traces[0].Dispatch), int64(time.Millisecond))
+	require.Zero(t, traces[0].Bytes)
+	require.Equal(t, "b", traces[1].Key)
+	require.Equal(t, out.Len()-n, traces[1].Bytes)
+	require.False(t, traces[1].Start.Before(traces[0].Start))
+	require.Equal(t, traces[1].Parse+traces[1].Dispatch+traces[1].Render+traces[1].Flush, traces[1].Total())
+
+	// The oldest traces are discarded once the ring is full.
+	feedInput(t, p, "c")
+	feedInput(t, p, "\x01")
+	traces = p.Trace()
+	require.Len(t, traces, 3)
+	require.Equal(t, []string{"b", "c", "Control-a"},
+		[]string{traces[0].Key, traces[1].Key, traces[2].Key})
+	require.Equal(t, "beginning-of-line", traces[2].Command)
+
+	// Tracing is disabled by default.
+	p, err := New(WithOutput(&out), WithInteractive(true))
+	require.NoError(t, err)
+	require.Nil(t, p.Trace())
+}
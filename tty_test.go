@@ -0,0 +1,123 @@
+package prompt
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/term"
+)
+
+// ttyPathOption overrides the path of the controlling terminal.
+type ttyPathOption string
+
+func (o ttyPathOption) apply(p *Prompt) {
+	p.ttyPath = string(o)
+}
+
+func TestTTYFallback(t *testing.T) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		t.Skipf("unable to open pty: %v", err)
+	}
+	defer ptmx.Close()
+	defer tty.Close()
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, ptmx)
+	}()
+
+	ttyPath := ttyPathOption(tty.Name())
+
+	// The fallback is not used if it is disabled, or if the input is a terminal.
+	p, err := New(WithInput(strings.NewReader("")), WithOutput(ioutil.Discard))
+	require.NoError(t, err)
+	require.Nil(t, p.tty)
+	require.Equal(t, -1, p.fd)
+	require.NoError(t, p.Close())
+
+	p, err = New(WithFiles(tty, tty), WithTTYFallback(true), ttyPath)
+	require.NoError(t, err)
+	require.Nil(t, p.tty)
+	require.Equal(t, int(tty.Fd()), p.fd)
+	require.NoError(t, p.Close())
+
+	// Input from a pipe falls back to the terminal for both input and output.
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+	p, err = New(WithFiles(r, w), WithTTYFallback(true), ttyPath)
+	require.NoError(t, err)
+	require.NotNil(t, p.tty)
+	require.Equal(t, p.tty, p.in)
+	require.Equal(t, p.tty, p.out)
+	require.Equal(t, int(p.tty.Fd()), p.fd)
+
+	// Place the terminal in raw mode before writing so that the input is not
+	// processed by the line discipline.
+	_, err = term.MakeRaw(int(tty.Fd()))
+	require.NoError(t, err)
+	_, err = ptmx.WriteString("hello\r")
+	require.NoError(t, err)
+	line, err := p.ReadLine("> ")
+	require.NoError(t, err)
+	require.Equal(t, "hello", line)
+	require.NoError(t, p.Close())
+
+	// The history is closed even if closing the terminal fails.
+	store := &memHistoryStore{}
+	p, err = New(WithFiles(r, w), WithTTYFallback(true), ttyPath, WithHistoryStore(store))
+	require.NoError(t, err)
+	require.NotNil(t, p.tty)
+	require.NoError(t, p.tty.Close())
+	require.Error(t, p.Close())
+	require.True(t, store.closed)
+}
+
+func TestNonInteractive(t *testing.T) {
+	// Input from a pipe is read non-interactively by default.
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	p, err := New(WithFiles(r, w))
+	require.NoError(t, err)
+	require.False(t, p.Interactive())
+	w.Close()
+	_, err = p.ReadLine("> ")
+	require.Equal(t, io.EOF, err)
+
+	// Other readers are read interactively unless configured otherwise.
+	p, err = New(WithInput(strings.NewReader("")))
+	require.NoError(t, err)
+	require.True(t, p.Interactive())
+
+	var out strings.Builder
+	input := "select 1;\r\nselect\n  2;\n\nnot finished"
+	p, err = New(WithInput(strings.NewReader(input)), WithOutput(&out),
+		WithInteractive(false),
+		WithInputFinished(func(text string) bool {
+			return strings.HasSuffix(strings.TrimSpace(text), ";")
+		}))
+	require.NoError(t, err)
+	require.False(t, p.Interactive())
+
+	var lines []string
+	for {
+		line, err := p.ReadLine("> ")
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		lines = append(lines, line)
+	}
+	require.Equal(t, []string{"select 1;", "select\n  2;", "\nnot finished"}, lines)
+	require.Empty(t, out.String())
+
+	p.Redraw()
+	require.NoError(t, p.Exclusive(func() {}))
+	require.Empty(t, out.String())
+}
@@ -0,0 +1,93 @@
+package prompt
+
+// undoState is a snapshot of the input text and cursor position.
+type undoState struct {
+	text []rune
+	pos  int
+}
+
+// undoList records the states of an input buffer prior to each edit so that
+// the edits can be undone. Each history entry recalled during history
+// navigation has its own undoList (see history.undoList), so switching between
+// entries preserves the edits of each. A series of character insertions forms
+// a single edit.
+type undoList struct {
+	states []undoState
+	// coalesce is true if the most recent edit was the insertion of a
+	// character, into which a subsequent insertion is merged.
+	coalesce bool
+}
+
+// Record records the state prior to an edit performed by cmd.
+func (u *undoList) Record(before undoState, cmd command) {
+	if !(u.coalesce && cmd == cmdInsertChar) {
+		u.states = append(u.states, before)
+	}
+	u.coalesce = cmd == cmdInsertChar
+}
+
+// Break ends the current series of character insertions, so that a
+// subsequent insertion is recorded as a separate edit.
+func (u *undoList) Break() {
+	u.coalesce = false
+}
+
+// Pop removes and returns the most recently recorded state. Returns false if
+// there are no edits to undo.
+func (u *undoList) Pop() (undoState, bool) {
+	u.coalesce = false
+	if len(u.states) == 0 {
+		return undoState{}, false
+	}
+	st := u.states[len(u.states)-1]
+	u.states = u.states[:len(u.states)-1]
+	return st, true
+}
+
+// undoSnapshot returns the current input text and cursor position, excluding
+// the completion hint and including the hidden lines of a collapsed history
+// entry.
+func undoSnapshot(s *state) undoState {
+	pos := s.screen.Position()
+	if s.history.collapsed != "" {
+		return undoState{text: []rune(s.history.collapsed), pos: pos}
+	}
+	text := s.screen.Text()
+	if c := &s.completer; c.suffix != nil {
+		return undoState{
+			text: append(append([]rune(nil), text[:c.wordEnd]...), text[c.wordEnd+len(c.suffix):]...),
+			pos:  pos,
+		}
+	}
+	return undoState{text: append([]rune(nil), text...), pos: pos}
+}
+
+// undo restores the input text and cursor position prior to the most recent
+// edit of the current history entry.
+func undo(s *state) {
+	st, ok := s.history.undoList(s.history.index).Pop()
+	if !ok {
+		return
+	}
+	s.screen.MoveTo(0)
+	s.screen.EraseTo(s.screen.End())
+	s.screen.Insert(st.text...)
+	s.screen.MoveTo(st.pos)
+}
+
+// recordUndo records the edit, if any, performed by cmd given the state before
+// the command was dispatched and the history entry that was then current.
+// History navigation and search replace the input text without editing it, so
+// are not recorded.
+func recordUndo(s *state, cmd command, before undoState, index int, searching bool) {
+	u := s.history.undoList(index)
+	if cmd == cmdUndo || searching || s.history.searchDir != 0 || index != s.history.index {
+		u.Break()
+		return
+	}
+	if after := undoSnapshot(s); string(after.text) == string(before.text) {
+		u.Break()
+		return
+	}
+	u.Record(before, cmd)
+}
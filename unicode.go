@@ -0,0 +1,172 @@
+package prompt
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var unicodeCommands = map[command]commandFunc{
+	cmdInsertUnicode: func(s *state, key rune) (bool, error) {
+		s.unicode.Start(s)
+		return true, nil
+	},
+}
+
+// digraphs maps the RFC 1345 mnemonics of commonly used characters to the
+// characters. The mnemonics of accented letters consist of the letter followed
+// by the accent: ! grave, ' acute, > circumflex, ? tilde, : diaeresis, ,
+// cedilla, and / stroke. Greek letters are the Latin letter followed by *.
+var digraphs = func() map[string]rune {
+	const table = `
+A! À A' Á A> Â A? Ã A: Ä AA Å AE Æ C, Ç E! È E' É E> Ê E: Ë I! Ì I' Í I> Î I: Ï
+D- Ð N? Ñ O! Ò O' Ó O> Ô O? Õ O: Ö O/ Ø U! Ù U' Ú U> Û U: Ü Y' Ý TH Þ ss ß
+a! à a' á a> â a? ã a: ä aa å ae æ c, ç e! è e' é e> ê e: ë i! ì i' í i> î i: ï
+d- ð n? ñ o! ò o' ó o> ô o? õ o: ö o/ ø u! ù u' ú u> û u: ü y' ý th þ y: ÿ
+A* Α B* Β G* Γ D* Δ E* Ε Z* Ζ Y* Η H* Θ I* Ι K* Κ L* Λ M* Μ N* Ν C* Ξ O* Ο P* Π
+R* Ρ S* Σ T* Τ U* Υ F* Φ X* Χ Q* Ψ W* Ω
+a* α b* β g* γ d* δ e* ε z* ζ y* η h* θ i* ι k* κ l* λ m* μ n* ν c* ξ o* ο p* π
+r* ρ s* σ *s ς t* τ u* υ f* φ x* χ q* ψ w* ω
+!I ¡ Ct ¢ Pd £ Eu € Ye ¥ SE § Co © -a ª << « NO ¬ Rg ® DG ° +- ± 2S ² 3S ³ My µ
+PI ¶ .M · 1S ¹ -o º >> » 14 ¼ 12 ½ 34 ¾ ?I ¿ *X × -: ÷
+<- ← -> → -! ↑ -v ↓ <> ↔ => ⇒ == ⇔ FA ∀ dP ∂ TE ∃ /0 ∅ (- ∈ *P ∏ +Z ∑ RT √ 00 ∞
+AN ∧ OR ∨ (U ∩ )U ∪ In ∫ ?= ≅ ?2 ≈ != ≠ =3 ≡ =< ≤ >= ≥ (C ⊂ )C ⊃ (_ ⊆ )_ ⊇
+-N – -M — '6 ‘ '9 ’ "6 “ "9 ” /- † /= ‡ .. ‥ ,. … %0 ‰ TM ™
+`
+	m := make(map[string]rune)
+	fields := strings.Fields(table)
+	for i := 0; i+1 < len(fields); i += 2 {
+		r, _ := utf8.DecodeRuneInString(fields[i+1])
+		m[fields[i]] = r
+	}
+	return m
+}()
+
+// unicodeInput implements the insert-unicode command, which reads the code of a
+// character and inserts the character at the cursor. The code is either a
+// two character RFC 1345 digraph such as "a:" for ä or "->" for →, or a
+// hexadecimal code point optionally prefixed with "U+" such as "2603" for ☃. A
+// digraph which cannot be a code point is inserted as soon as it is typed.
+// Otherwise Enter or Space inserts the character, preferring a digraph to a
+// code point. The code being read is displayed below the input. Abort or
+// cancel discards the code, and any other command discards it and is then
+// performed.
+type unicodeInput struct {
+	// active is true while the code is being read.
+	active bool
+	code   []rune
+}
+
+// Reset discards the code being read without updating the screen.
+func (u *unicodeInput) Reset() {
+	u.active = false
+	u.code = nil
+}
+
+// Start starts reading a code.
+func (u *unicodeInput) Start(s *state) {
+	s.completer.Cancel(s)
+	u.active = true
+	u.code = nil
+	u.update(s)
+}
+
+// Dispatch processes the specified command, which reads the code if a code is
+// being read.
+func (u *unicodeInput) Dispatch(s *state, cmd command, key rune) (ok bool, err error) {
+	if !u.active {
+		if fn, ok := unicodeCommands[cmd]; ok {
+			return fn(s, key)
+		}
+		return false, nil
+	}
+	switch cmd {
+	case cmdInsertChar:
+		if key == ' ' {
+			u.finish(s)
+			return true, nil
+		}
+		u.code = append(u.code, key)
+		if r, ok := digraphs[string(u.code)]; ok && !isHexCode(string(u.code)) {
+			u.end(s)
+			s.screen.Insert(r)
+			return true, nil
+		}
+		u.update(s)
+		return true, nil
+	case cmdBackwardDeleteChar:
+		if len(u.code) > 0 {
+			u.code = u.code[:len(u.code)-1]
+		}
+		u.update(s)
+		return true, nil
+	case cmdFinishOrEnter, cmdEnter:
+		u.finish(s)
+		return true, nil
+	case cmdAbort, cmdCancel:
+		u.end(s)
+		return true, nil
+	}
+	u.end(s)
+	return false, nil
+}
+
+// finish inserts the character with the code which has been read. The
+// terminal bell is rung if the code is invalid.
+func (u *unicodeInput) finish(s *state) {
+	r, ok := parseUnicodeCode(string(u.code))
+	u.end(s)
+	if !ok {
+		s.screen.outbuf.WriteRune(keyCtrlG) // ctrl-G == bell/beep
+		return
+	}
+	s.screen.Insert(r)
+}
+
+// end stops reading the code, removing its display.
+func (u *unicodeInput) end(s *state) {
+	u.Reset()
+	s.annotations.Set(&s.screen, annotationUnicode, Annotation{})
+}
+
+func (u *unicodeInput) update(s *state) {
+	s.annotations.Set(&s.screen, annotationUnicode, Annotation{
+		Text:     "\nunicode: " + string(u.code),
+		Priority: AnnotationPrioritySearch,
+	})
+}
+
+// parseUnicodeCode returns the character with the specified digraph or
+// hexadecimal code point.
+func parseUnicodeCode(code string) (rune, bool) {
+	if r, ok := digraphs[code]; ok {
+		return r, true
+	}
+	if !isHexCode(code) {
+		return 0, false
+	}
+	hex := strings.TrimPrefix(strings.TrimPrefix(code, "U+"), "u+")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || v > unicode.MaxRune || !utf8.ValidRune(rune(v)) || unicode.IsControl(rune(v)) {
+		return 0, false
+	}
+	return rune(v), true
+}
+
+// isHexCode returns true if code is, or may be the prefix of, a hexadecimal
+// code point optionally prefixed with "U+".
+func isHexCode(code string) bool {
+	if code == "U" || code == "u" {
+		return true
+	}
+	if len(code) >= 2 && (code[:2] == "U+" || code[:2] == "u+") {
+		code = code[2:]
+	}
+	for _, r := range code {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}